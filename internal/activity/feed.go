@@ -0,0 +1,266 @@
+package activity
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/useragent"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Category string
+
+// Feed categories, each backed by one history collection
+const (
+	CATEGORY_LOGIN    Category = "login"    // login_history
+	CATEGORY_SECURITY Category = "security" // security_history
+	CATEGORY_ACCOUNT  Category = "account"  // account_history
+)
+
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+// Actor values shown instead of the raw ChangedBy reference
+const (
+	ACTOR_SELF   = "self"
+	ACTOR_ADMIN  = "admin"
+	ACTOR_SYSTEM = "system"
+)
+
+var (
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrInvalidCategory = errors.New("invalid category")
+)
+
+var categories = map[Category]string{
+	CATEGORY_LOGIN:    schema.COLLECTION_LOGIN_HISTORY,
+	CATEGORY_SECURITY: schema.COLLECTION_SECURITY_HISTORY,
+	CATEGORY_ACCOUNT:  schema.COLLECTION_ACCOUNT_HISTORY,
+}
+
+// Item is a single user-facing feed entry. Fields that are only meant for
+// administrators (error messages, raw user agents, the ID of whoever made a
+// change) are never copied into it.
+type Item struct {
+	ID        bson.ObjectID  `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Category  Category       `json:"category"`
+	EventType string         `json:"event_type"`
+	Success   *bool          `json:"success,omitempty"`
+	IPAddress string         `json:"ip_address,omitempty"`
+	Country   string         `json:"country,omitempty"`
+	Device    useragent.Info `json:"device"`
+	Summary   string         `json:"device_summary"`
+	Provider  string         `json:"provider,omitempty"`
+	Field     string         `json:"field,omitempty"`
+	OldValue  string         `json:"old_value,omitempty"`
+	NewValue  string         `json:"new_value,omitempty"`
+	Actor     string         `json:"actor,omitempty"`
+}
+
+// Query selects a page of the feed
+type Query struct {
+	Categories []Category
+	Cursor     string
+	Limit      int
+}
+
+// Page is one page of the feed, NextCursor is empty on the last page
+type Page struct {
+	Items      []Item `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ParseCategories parses a comma separated category list, an empty string selects all categories
+func ParseCategories(s string) ([]Category, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var out []Category
+	for _, part := range strings.Split(s, ",") {
+		c := Category(strings.TrimSpace(part))
+		if _, ok := categories[c]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCategory, c)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Feed merges the user's login, security and account history into one page
+// ordered from newest to oldest
+func Feed(ctx context.Context, userID bson.ObjectID, q Query) (*Page, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	if limit > MAX_LIMIT {
+		limit = MAX_LIMIT
+	}
+	cats := q.Categories
+	if len(cats) == 0 {
+		cats = []Category{CATEGORY_LOGIN, CATEGORY_SECURITY, CATEGORY_ACCOUNT}
+	}
+
+	filter := bson.M{"user_id": userID}
+	if q.Cursor != "" {
+		at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{"$lt": at}},
+			bson.M{"created_at": at, "_id": bson.M{"$lt": id}},
+		}
+	}
+
+	// Fetch one extra row per collection so we know whether another page exists
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	var items []Item
+	for _, c := range cats {
+		fetched, err := fetch(ctx, userID, c, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, fetched...)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		}
+		return items[i].ID.Hex() > items[j].ID.Hex()
+	})
+
+	page := &Page{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.Items == nil {
+		page.Items = []Item{}
+	}
+	return page, nil
+}
+
+func fetch(ctx context.Context, userID bson.ObjectID, c Category, filter bson.M, opts *options.FindOptionsBuilder) ([]Item, error) {
+	cursor, err := database.Collection(categories[c]).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	switch c {
+	case CATEGORY_LOGIN:
+		var rows []schema.LoginHistory
+		if err := cursor.All(ctx, &rows); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			items = append(items, withDevice(Item{
+				ID:        r.ID,
+				CreatedAt: r.CreatedAt,
+				Category:  c,
+				EventType: string(r.EventType),
+				Success:   &r.Success,
+				IPAddress: r.IPAddress,
+				Country:   r.Country,
+			}, r.UserAgent))
+		}
+	case CATEGORY_SECURITY:
+		var rows []schema.SecurityHistory
+		if err := cursor.All(ctx, &rows); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			items = append(items, withDevice(Item{
+				ID:        r.ID,
+				CreatedAt: r.CreatedAt,
+				Category:  c,
+				EventType: string(r.EventType),
+				Success:   &r.Success,
+				IPAddress: r.IPAddress,
+				Country:   r.Country,
+				Provider:  r.Provider,
+			}, r.UserAgent))
+		}
+	case CATEGORY_ACCOUNT:
+		var rows []schema.AccountHistory
+		if err := cursor.All(ctx, &rows); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			items = append(items, withDevice(Item{
+				ID:        r.ID,
+				CreatedAt: r.CreatedAt,
+				Category:  c,
+				EventType: string(r.EventType),
+				IPAddress: r.IPAddress,
+				Country:   r.Country,
+				Field:     r.Field,
+				OldValue:  r.OldValue,
+				NewValue:  r.NewValue,
+				Actor:     actor(userID, r.ChangedBy),
+			}, r.UserAgent))
+		}
+	}
+	return items, nil
+}
+
+func withDevice(item Item, ua string) Item {
+	item.Device = useragent.Parse(ua)
+	item.Summary = item.Device.Summary()
+	return item
+}
+
+// actor hides the identity of whoever changed the account unless it was the user themself
+func actor(userID bson.ObjectID, changedBy string) string {
+	switch changedBy {
+	case userID.Hex():
+		return ACTOR_SELF
+	case history.SYSTEM_ACTOR, "":
+		return ACTOR_SYSTEM
+	}
+	return ACTOR_ADMIN
+}
+
+// Cursors are opaque to clients: base64("<unix nanos>:<object id>")
+func encodeCursor(at time.Time, id bson.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(at.UnixNano(), 10) + ":" + id.Hex()))
+}
+
+func decodeCursor(s string) (time.Time, bson.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, bson.NilObjectID, ErrInvalidCursor
+	}
+	nanos, hexID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, bson.NilObjectID, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, bson.NilObjectID, ErrInvalidCursor
+	}
+	id, err := bson.ObjectIDFromHex(hexID)
+	if err != nil {
+		return time.Time{}, bson.NilObjectID, ErrInvalidCursor
+	}
+	return time.Unix(0, n).UTC(), id, nil
+}
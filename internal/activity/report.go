@@ -0,0 +1,69 @@
+package activity
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const REVOKE_REASON_NOT_ME = "activity_flag"
+
+var ErrEventNotFound = errors.New("event not found")
+
+// ReportResult describes what was done after a "this wasn't me" report
+type ReportResult struct {
+	RevokedSessions     int64 `json:"revoked_sessions"`
+	PasswordResetForced bool  `json:"password_reset_forced"`
+}
+
+// ReportNotMe handles a "this wasn't me" report on a login or security event:
+// every session of the user is revoked and a password reset is forced
+func ReportNotMe(ctx context.Context, userID, eventID bson.ObjectID, meta history.Meta) (*ReportResult, error) {
+	if err := ownEvent(ctx, userID, eventID); err != nil {
+		return nil, err
+	}
+
+	revoked, err := session.RevokeAllForUser(ctx, userID, REVOKE_REASON_NOT_ME, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := users.Update(ctx, userID, bson.M{"auth_info.password_reset_forced": true}); err != nil {
+		return nil, err
+	}
+
+	if err := history.RecordSecurity(ctx, schema.SecurityHistory{
+		UserID:    userID,
+		EventType: schema.SECURITY_EVENT_ACTIVITY_FLAG,
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+		Success:   true,
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", userID.Hex()).Msg("Error recording activity flag")
+	}
+
+	return &ReportResult{RevokedSessions: revoked, PasswordResetForced: true}, nil
+}
+
+// ownEvent checks that the reported event is a login or security event of the user
+func ownEvent(ctx context.Context, userID, eventID bson.ObjectID) error {
+	for _, name := range []string{schema.COLLECTION_LOGIN_HISTORY, schema.COLLECTION_SECURITY_HISTORY} {
+		err := database.Collection(name).FindOne(ctx, bson.M{"_id": eventID, "user_id": userID}).Err()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	return ErrEventNotFound
+}
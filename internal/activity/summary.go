@@ -0,0 +1,108 @@
+package activity
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/useragent"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// SUMMARY_WINDOW matches the retention of login_history
+const SUMMARY_WINDOW = schema.TTL_LOGIN_HISTORY * time.Second
+
+// CountryCount is the number of successful sign-ins from one country
+type CountryCount struct {
+	Country    string    `json:"country"`
+	Count      int       `json:"count"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// DeviceCount is the number of successful sign-ins from one kind of device
+type DeviceCount struct {
+	Device     useragent.Info `json:"device"`
+	Summary    string         `json:"device_summary"`
+	Count      int            `json:"count"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+}
+
+// Summary answers "where did I sign in?" for the retained login history
+type Summary struct {
+	Since     time.Time      `json:"since"`
+	Countries []CountryCount `json:"countries"`
+	Devices   []DeviceCount  `json:"devices"`
+}
+
+// Summarize groups the user's successful sign-ins by country and by device
+func Summarize(ctx context.Context, userID bson.ObjectID) (*Summary, error) {
+	since := time.Now().UTC().Add(-SUMMARY_WINDOW)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id":    userID,
+			"event_type": schema.LOGIN_EVENT_SUCCESS,
+			"created_at": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          bson.M{"country": "$country", "user_agent": "$user_agent"},
+			"count":        bson.M{"$sum": 1},
+			"last_seen_at": bson.M{"$max": "$created_at"},
+		}}},
+	}
+	cursor, err := database.Collection(schema.COLLECTION_LOGIN_HISTORY).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Country   string `bson:"country"`
+			UserAgent string `bson:"user_agent"`
+		} `bson:"_id"`
+		Count      int       `bson:"count"`
+		LastSeenAt time.Time `bson:"last_seen_at"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	// Raw user agents are too granular to show, so fold them into parsed summaries
+	countries := map[string]*CountryCount{}
+	devices := map[string]*DeviceCount{}
+	for _, r := range rows {
+		c, ok := countries[r.ID.Country]
+		if !ok {
+			c = &CountryCount{Country: r.ID.Country}
+			countries[r.ID.Country] = c
+		}
+		c.Count += r.Count
+		if r.LastSeenAt.After(c.LastSeenAt) {
+			c.LastSeenAt = r.LastSeenAt
+		}
+
+		info := useragent.Parse(r.ID.UserAgent)
+		key := info.Summary() + "|" + info.Device
+		d, ok := devices[key]
+		if !ok {
+			d = &DeviceCount{Device: info, Summary: info.Summary()}
+			devices[key] = d
+		}
+		d.Count += r.Count
+		if r.LastSeenAt.After(d.LastSeenAt) {
+			d.LastSeenAt = r.LastSeenAt
+		}
+	}
+
+	s := &Summary{Since: since, Countries: []CountryCount{}, Devices: []DeviceCount{}}
+	for _, c := range countries {
+		s.Countries = append(s.Countries, *c)
+	}
+	for _, d := range devices {
+		s.Devices = append(s.Devices, *d)
+	}
+	sort.Slice(s.Countries, func(i, j int) bool { return s.Countries[i].LastSeenAt.After(s.Countries[j].LastSeenAt) })
+	sort.Slice(s.Devices, func(i, j int) bool { return s.Devices[i].LastSeenAt.After(s.Devices[j].LastSeenAt) })
+	return s, nil
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/Auth5/brain/internal/suspension"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	REVOKE_REASON_PASSWORD_RESET = "admin_password_reset"
+	REVOKE_REASON_2FA_DISABLED   = "admin_2fa_disable"
+)
+
+// Suspend suspends the user until the given time, or indefinitely when until
+// is nil. The transition records the AdminHistory event.
+func Suspend(ctx context.Context, userID bson.ObjectID, until *time.Time, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return suspension.Suspend(ctx, suspension.Request{
+		UserID: userID,
+		Actor:  actorUser(actor),
+		Until:  until,
+		Reason: reason,
+		Meta:   actor.Meta,
+	})
+}
+
+// Unsuspend lifts a suspension. The transition records the AdminHistory event.
+func Unsuspend(ctx context.Context, userID bson.ObjectID, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return suspension.Unsuspend(ctx, userID, actorUser(actor), reason, actor.Meta)
+}
+
+// Delete marks the account for deletion on behalf of an admin. The grace
+// period and legal holds apply as for any other deletion.
+func Delete(ctx context.Context, userID bson.ObjectID, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return gdpr.RequestDeletion(ctx, gdpr.DeletionRequest{
+		UserID: userID,
+		Reason: schema.DELETION_REASON_ADMIN_ACTION,
+		Actor:  actorUser(actor),
+		Note:   reason,
+		Meta:   actor.Meta,
+	})
+}
+
+// SetLegalHold places a legal hold until the given time, or lifts it when
+// until is nil. Held accounts are not deleted for inactivity nor anonymized
+// until the hold ends.
+func SetLegalHold(ctx context.Context, userID bson.ObjectID, until *time.Time, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if until != nil && !until.After(time.Now()) {
+		return nil, ErrHoldEnded
+	}
+	if err := gdpr.SetLegalHold(ctx, userID, until, reason, actor); err != nil {
+		return nil, err
+	}
+	return users.FindByID(ctx, userID)
+}
+
+// ForcePasswordReset requires the user to set a new password before the next
+// login and signs them out everywhere
+func ForcePasswordReset(ctx context.Context, userID bson.ObjectID, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if err := users.Update(ctx, userID, bson.M{"auth_info.password_reset_forced": true}); err != nil {
+		return nil, err
+	}
+	revoked, err := session.RevokeAllForUser(ctx, userID, REVOKE_REASON_PASSWORD_RESET, actor.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		UserID:    userID,
+		EventType: schema.ADMIN_EVENT_PASSWORD_RESET,
+		Action:    "password reset forced",
+		Reason:    reason,
+		Details:   "revoked " + strconv.FormatInt(revoked, 10) + " sessions",
+	}); err != nil {
+		return nil, err
+	}
+	return users.FindByID(ctx, userID)
+}
+
+// Disable2FA turns off two-factor authentication, for users who lost their
+// authenticator and backup codes. Sessions are revoked so every device signs
+// in again under the new settings.
+func Disable2FA(ctx context.Context, userID bson.ObjectID, reason string, actor history.AdminActor) (*schema.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if err := users.Update(ctx, userID, bson.M{
+		"auth_info.is_2fa_enabled":   false,
+		"auth_info.totp_secret":      "",
+		"auth_info.otp_backup_codes": []string{},
+	}); err != nil {
+		return nil, err
+	}
+	revoked, err := session.RevokeAllForUser(ctx, userID, REVOKE_REASON_2FA_DISABLED, actor.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := history.RecordSecurity(ctx, schema.SecurityHistory{
+		UserID:    userID,
+		EventType: schema.SECURITY_EVENT_2FA_DISABLE,
+		IPAddress: actor.Meta.IPAddress,
+		Country:   actor.Meta.Country,
+		UserAgent: actor.Meta.UserAgent,
+		Success:   true,
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", userID.Hex()).Msg("Error recording 2FA disable")
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		UserID:    userID,
+		EventType: schema.ADMIN_EVENT_2FA_DISABLE,
+		Action:    "two-factor authentication disabled",
+		Reason:    reason,
+		Details:   "revoked " + strconv.FormatInt(revoked, 10) + " sessions",
+	}); err != nil {
+		return nil, err
+	}
+	return users.FindByID(ctx, userID)
+}
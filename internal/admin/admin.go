@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+var (
+	ErrInvalidQuery   = errors.New("search needs an email, username, phone or provider and provider_id")
+	ErrReasonRequired = errors.New("a reason is required")
+	ErrHoldEnded      = errors.New("a legal hold must end in the future")
+)
+
+// actorUser returns the admin as recorded in status transitions
+func actorUser(actor history.AdminActor) users.Actor {
+	return users.Actor{Kind: users.ACTOR_KIND_ADMIN, ID: actor.ID}
+}
+
+// Query selects users by one exact identifier. Email, Username, Phone and
+// ProviderID are combined with "or"; ProviderID needs Provider.
+type Query struct {
+	Email      string
+	Username   string
+	Phone      string
+	Provider   string // OAuth provider name, e.g. "google"
+	ProviderID string // ID of the account at the provider
+	Limit      int
+}
+
+// Profile is the admin view of a user. Secrets are never included; the
+// security section replaces them with what support needs to know.
+type Profile struct {
+	*schema.User
+	Security Security `json:"security"`
+}
+
+// Security summarizes the credentials of a user without exposing them
+type Security struct {
+	HasPassword          bool       `json:"has_password"`
+	LastPasswordChange   *time.Time `json:"last_password_change,omitempty"`
+	LastPasswordReset    *time.Time `json:"last_password_reset,omitempty"`
+	PasswordResetPending bool       `json:"password_reset_pending"` // A reset link was sent and not used yet
+	EmailVerifyPending   bool       `json:"email_verify_pending"`   // A verification link was sent and not used yet
+	BackupCodesLeft      int        `json:"backup_codes_left"`
+	Last2FAVerified      *time.Time `json:"last_2fa_verified,omitempty"`
+}
+
+// NewProfile builds the admin view of a user
+func NewProfile(u *schema.User) Profile {
+	a := u.AuthInfo
+	p := Profile{User: u, Security: Security{
+		HasPassword:          u.Password != "",
+		LastPasswordReset:    a.LastPasswordReset,
+		PasswordResetPending: a.PasswordResetToken != "",
+		EmailVerifyPending:   a.EmailVerificationToken != "",
+		BackupCodesLeft:      len(a.OTPBackupCodes),
+		Last2FAVerified:      a.Last2FAVerified,
+	}}
+	if !a.LastPasswordChange.IsZero() {
+		p.Security.LastPasswordChange = &a.LastPasswordChange
+	}
+	return p
+}
+
+// Search returns the users matching any identifier of the query, newest first
+func Search(ctx context.Context, q Query) ([]Profile, error) {
+	var or bson.A
+	if q.Email != "" {
+		or = append(or, bson.M{"email": strings.TrimSpace(q.Email)})
+	}
+	if q.Username != "" {
+		or = append(or, bson.M{"username": strings.TrimSpace(q.Username)})
+	}
+	if q.Phone != "" {
+		or = append(or, bson.M{"phone_number": strings.TrimSpace(q.Phone)})
+	}
+	if q.ProviderID != "" {
+		if q.Provider == "" || strings.ContainsAny(q.Provider, ".$") {
+			return nil, ErrInvalidQuery
+		}
+		or = append(or, bson.M{"auth_info.oauth_providers." + q.Provider + ".provider_id": q.ProviderID})
+	}
+	if len(or) == 0 {
+		return nil, ErrInvalidQuery
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit(q.Limit)))
+	cur, err := database.Collection(schema.COLLECTION_USERS).Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []schema.User
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	list := make([]Profile, len(found))
+	for i := range found {
+		list[i] = NewProfile(&found[i])
+	}
+	return list, nil
+}
+
+func limit(n int) int {
+	if n <= 0 {
+		return DEFAULT_LIMIT
+	}
+	return min(n, MAX_LIMIT)
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// History is the recent history of a user as stored, including the details
+// hidden from the user's own activity feed
+type History struct {
+	Login    []schema.LoginHistory    `json:"login"`
+	Security []schema.SecurityHistory `json:"security"`
+	Account  []schema.AccountHistory  `json:"account"`
+	Email    []schema.EmailHistory    `json:"email"`
+	Admin    []schema.AdminHistory    `json:"admin"` // Admin actions taken on the user
+}
+
+// UserHistory returns the latest events of each history collection of a user,
+// at most n per collection
+func UserHistory(ctx context.Context, userID bson.ObjectID, n int) (*History, error) {
+	h := &History{
+		Login:    []schema.LoginHistory{},
+		Security: []schema.SecurityHistory{},
+		Account:  []schema.AccountHistory{},
+		Email:    []schema.EmailHistory{},
+		Admin:    []schema.AdminHistory{},
+	}
+	for name, out := range map[string]any{
+		schema.COLLECTION_LOGIN_HISTORY:    &h.Login,
+		schema.COLLECTION_SECURITY_HISTORY: &h.Security,
+		schema.COLLECTION_ACCOUNT_HISTORY:  &h.Account,
+		schema.COLLECTION_EMAIL_HISTORY:    &h.Email,
+		schema.COLLECTION_ADMIN_HISTORY:    &h.Admin,
+	} {
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit(n)))
+		cur, err := database.Collection(name).Find(ctx, bson.M{"user_id": userID}, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := cur.All(ctx, out); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	DEFAULT_IMPERSONATION_TTL = 15 * time.Minute
+	MAX_IMPERSONATION_TTL     = time.Hour
+)
+
+var (
+	ErrInvalidDuration = errors.New("impersonation lasts between one minute and one hour")
+	ErrImpersonateSelf = errors.New("admins cannot impersonate themselves")
+	ErrNotImpersonable = errors.New("only active, inactive or pending accounts can be impersonated")
+	ErrImpersonating   = errors.New("admin routes are not available while impersonating")
+	ErrUserOnly        = errors.New("only the user can do this, not while impersonating")
+)
+
+// Impersonation is a session issued to an admin to act as a user
+type Impersonation struct {
+	Token   string          `json:"token"`
+	Session *schema.Session `json:"session"`
+}
+
+// Impersonate issues a session for the user marked with the admin's ID. It
+// expires after ttl, DEFAULT_IMPERSONATION_TTL when zero, and is recorded in
+// AdminHistory with its end as expires_at.
+func Impersonate(ctx context.Context, userID bson.ObjectID, ttl time.Duration, reason string, actor history.AdminActor) (*Impersonation, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if ttl == 0 {
+		ttl = DEFAULT_IMPERSONATION_TTL
+	}
+	if ttl < time.Minute || ttl > MAX_IMPERSONATION_TTL {
+		return nil, ErrInvalidDuration
+	}
+	if userID == actor.ID {
+		return nil, ErrImpersonateSelf
+	}
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Status {
+	case schema.USER_STATUS_ACTIVE, schema.USER_STATUS_INACTIVE, schema.USER_STATUS_PENDING:
+	default:
+		return nil, ErrNotImpersonable
+	}
+
+	token, s, err := session.CreateImpersonation(ctx, userID, actor.ID, ttl, actor.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		UserID:    userID,
+		EventType: schema.ADMIN_EVENT_IMPERSONATE,
+		Action:    "impersonation started",
+		Reason:    reason,
+		Details:   "session " + s.ID.Hex(),
+		ExpiresAt: &s.ExpiresAt,
+	}); err != nil {
+		return nil, err
+	}
+	return &Impersonation{Token: token, Session: s}, nil
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/organizations"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// RemoveMember removes a user from an organization, e.g. after a dispute
+// with its owner. The removal is recorded in the organization's membership
+// history and in AdminHistory.
+func RemoveMember(ctx context.Context, orgID, userID bson.ObjectID, reason string, actor history.AdminActor) error {
+	if strings.TrimSpace(reason) == "" {
+		return ErrReasonRequired
+	}
+	m, err := organizations.Expel(ctx, orgID, userID, organizations.Actor{UserID: actor.ID, Meta: actor.Meta})
+	if err != nil {
+		return err
+	}
+	return actor.Record(ctx, schema.AdminHistory{
+		UserID:    userID,
+		EventType: schema.ADMIN_EVENT_ORGANIZATION,
+		Action:    "removed " + string(m.Role) + " from organization",
+		Reason:    reason,
+		Details:   orgID.Hex(),
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Auth5/brain/internal/activity"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// handleActivityFeed returns a page of the user's merged activity feed
+func handleActivityFeed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cats, err := activity.ParseCategories(q.Get("category"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	page, err := activity.Feed(r.Context(), currentUserID(r), activity.Query{
+		Categories: cats,
+		Cursor:     q.Get("cursor"),
+		Limit:      limit,
+	})
+	if errors.Is(err, activity.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading activity feed")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleActivitySummary returns the countries and devices the user signed in from
+func handleActivitySummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := activity.Summarize(r.Context(), currentUserID(r))
+	if err != nil {
+		log.Error().Err(err).Msg("Error summarizing activity")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// handleActivityNotMe revokes all sessions and forces a password reset after
+// the user reports an event they do not recognize
+func handleActivityNotMe(w http.ResponseWriter, r *http.Request) {
+	eventID, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid event id")
+		return
+	}
+
+	res, err := activity.ReportNotMe(r.Context(), currentUserID(r), eventID, requestMeta(r))
+	if errors.Is(err, activity.ErrEventNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error handling activity report")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/admin"
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/suspension"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+)
+
+type adminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminSuspendRequest struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"` // Indefinite when omitted
+}
+
+type legalHoldRequest struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"` // When anonymization may proceed
+}
+
+type impersonateRequest struct {
+	Reason  string `json:"reason"`
+	Minutes int    `json:"minutes"` // 15 when omitted, at most 60
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, admin.ErrInvalidQuery), errors.Is(err, admin.ErrReasonRequired),
+		errors.Is(err, admin.ErrInvalidDuration), errors.Is(err, suspension.ErrUntilInPast),
+		errors.Is(err, admin.ErrHoldEnded):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, admin.ErrImpersonateSelf):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, admin.ErrNotImpersonable), errors.Is(err, suspension.ErrNotSuspended),
+		errors.Is(err, users.ErrInvalidTransition), errors.Is(err, users.ErrGuardFailed),
+		errors.Is(err, users.ErrConcurrentUpdate),
+		errors.Is(err, gdpr.ErrAlreadyAnonymized), errors.Is(err, gdpr.ErrNoLegalHold):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error().Err(err).Msg("Error handling admin request")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// adminActor is the signed in admin, recorded in AdminHistory
+func adminActor(r *http.Request) history.AdminActor {
+	return history.AdminActor{ID: currentUserID(r), Meta: requestMeta(r)}
+}
+
+// queryLimit parses ?limit, zero when absent
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return 0, false
+	}
+	return n, true
+}
+
+// handleSearchUsers finds users by ?email, ?username, ?phone or
+// ?provider and ?provider_id
+func handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	list, err := admin.Search(r.Context(), admin.Query{
+		Email:      q.Get("email"),
+		Username:   q.Get("username"),
+		Phone:      q.Get("phone"),
+		Provider:   q.Get("provider"),
+		ProviderID: q.Get("provider_id"),
+		Limit:      limit,
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetUser returns a user with their credential status
+func handleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := users.FindByID(r.Context(), userID)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleUserHistory returns the latest ?limit events of each history of a user
+func handleUserHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	if _, err := users.FindByID(r.Context(), userID); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	h, err := admin.UserHistory(r.Context(), userID, limit)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// handleAdminSuspend suspends a user, until a given time or indefinitely
+func handleAdminSuspend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req adminSuspendRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := admin.Suspend(r.Context(), userID, req.Until, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleAdminUnsuspend lifts the suspension of a user
+func handleAdminUnsuspend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req adminActionRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := admin.Unsuspend(r.Context(), userID, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleForcePasswordReset requires a new password and signs the user out
+func handleForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req adminActionRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := admin.ForcePasswordReset(r.Context(), userID, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleAdminDisable2FA turns off two-factor authentication of a user
+func handleAdminDisable2FA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req adminActionRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := admin.Disable2FA(r.Context(), userID, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleAdminDelete marks a user's account for deletion. ?reason is recorded
+// in AdminHistory.
+func handleAdminDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := admin.Delete(r.Context(), userID, r.URL.Query().Get("reason"), adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.DeletionInfo)
+}
+
+// handlePlaceLegalHold postpones the anonymization of a user's data until the
+// hold ends
+func handlePlaceLegalHold(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req legalHoldRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := admin.SetLegalHold(r.Context(), userID, &req.Until, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.DeletionInfo)
+}
+
+// handleLiftLegalHold ends a legal hold. ?reason is recorded in AdminHistory.
+func handleLiftLegalHold(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := admin.SetLegalHold(r.Context(), userID, nil, r.URL.Query().Get("reason"), adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.DeletionInfo)
+}
+
+// handleImpersonate issues a short-lived session to act as a user
+func handleImpersonate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req impersonateRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	imp, err := admin.Impersonate(r.Context(), userID, time.Duration(req.Minutes)*time.Minute, req.Reason, adminActor(r))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, imp)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/ledger"
+	"github.com/Auth5/brain/internal/billing/paypal"
+	"github.com/Auth5/brain/internal/billing/stripe"
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+)
+
+// writeBillingError maps billing errors to HTTP responses
+func writeBillingError(w http.ResponseWriter, err error) {
+	var se *stripe.Error
+	var pe *paypal.Error
+	switch {
+	case errors.Is(err, billing.ErrNoSubscription):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, billing.ErrUnknownPlan), errors.Is(err, billing.ErrUnknownPrice),
+		errors.Is(err, billing.ErrInvalidCountry), errors.Is(err, billing.ErrInvalidDetails),
+		errors.Is(err, tax.ErrVATIDFormat), errors.Is(err, tax.ErrVATIDChecksum), errors.Is(err, tax.ErrVATIDCountry),
+		errors.Is(err, billing.ErrInvalidPromotionCode), errors.Is(err, billing.ErrPromotionCodeChange),
+		errors.Is(err, billing.ErrInvalidReferralCode), errors.Is(err, billing.ErrOwnReferralCode),
+		errors.Is(err, billing.ErrTopUpAmount), errors.Is(err, billing.ErrInvalidSeats):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, billing.ErrReferralsDisabled), errors.Is(err, billing.ErrCreditsDisabled),
+		errors.Is(err, ledger.ErrUnknownLot):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, billing.ErrAlreadySubscribed),
+		errors.Is(err, billing.ErrSamePlan),
+		errors.Is(err, billing.ErrNotPendingCancel),
+		errors.Is(err, billing.ErrGatewayMismatch),
+		errors.Is(err, crypto.ErrNotDue),
+		errors.Is(err, crypto.ErrEnded),
+		errors.Is(err, crypto.ErrCreditExceedsPrice),
+		errors.Is(err, billing.ErrPromotionCodeUsedUp),
+		errors.Is(err, billing.ErrCouponNotApplicable),
+		errors.Is(err, billing.ErrFirstPurchaseOnly),
+		errors.Is(err, billing.ErrCouponRedeemed),
+		errors.Is(err, billing.ErrAlreadyReferred),
+		errors.Is(err, billing.ErrNotNewCustomer),
+		errors.Is(err, billing.ErrRefundPeriodOver),
+		errors.Is(err, ledger.ErrNotRefundable),
+		errors.Is(err, ledger.ErrLotExpired),
+		errors.Is(err, ledger.ErrInsufficientCredit):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, gateway.ErrUnsupported):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, billing.ErrPaymentProcessing):
+		writeError(w, http.StatusAccepted, err.Error())
+	case errors.Is(err, billing.ErrPaymentIncomplete):
+		writeError(w, http.StatusPaymentRequired, err.Error())
+	case errors.As(err, &se) && se.Type == "card_error":
+		writeError(w, http.StatusPaymentRequired, se.Message)
+	case errors.As(err, &pe) && pe.Issue() == "INSTRUMENT_DECLINED":
+		writeError(w, http.StatusPaymentRequired, pe.Message)
+	default:
+		log.Error().Err(err).Msg("Billing request failed")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// handleListPlans returns the plans offered to new subscribers
+func handleListPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := catalog.Available(r.Context())
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plans)
+}
+
+// handleGetBillingDetails returns the user's billing address
+func handleGetBillingDetails(w http.ResponseWriter, r *http.Request) {
+	d, err := billing.Details(r.Context(), currentUserID(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	if d == nil {
+		writeError(w, http.StatusNotFound, "no billing details")
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleSaveBillingDetails sets the user's billing address and VAT ID
+func handleSaveBillingDetails(w http.ResponseWriter, r *http.Request) {
+	var req schema.BillingDetails
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	d, err := billing.SaveDetails(r.Context(), currentUserID(r), req)
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleQuote returns the tax and total of a plan price for the user
+func handleQuote(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sel := billing.PlanSelection{
+		AccountType:   q.Get("account_type"),
+		Currency:      q.Get("currency"),
+		Interval:      schema.PLAN_INTERVAL(q.Get("interval")),
+		PromotionCode: q.Get("promotion_code"),
+	}
+	if sel.AccountType == "" {
+		writeError(w, http.StatusBadRequest, "account_type is required")
+		return
+	}
+	res, err := billing.Quote(r.Context(), currentUserID(r), sel, requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleGetSubscription returns the user's current subscription
+func handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	s, err := billing.CurrentSubscription(r.Context(), currentUserID(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handleSubscribe starts a subscription to the plan of an account type
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	var req billing.PlanSelection
+	if err := readJSON(w, r, &req); err != nil || req.AccountType == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	checkout, err := billing.Subscribe(r.Context(), currentUserID(r), req, requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, checkout)
+}
+
+// handleChangePlan upgrades or downgrades the current subscription
+func handleChangePlan(w http.ResponseWriter, r *http.Request) {
+	var req billing.PlanSelection
+	if err := readJSON(w, r, &req); err != nil || req.AccountType == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	checkout, err := billing.ChangePlan(r.Context(), currentUserID(r), req, requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checkout)
+}
+
+// handleCancelSubscription cancels the current subscription, at the end of
+// the period unless ?immediately=true is given
+func handleCancelSubscription(w http.ResponseWriter, r *http.Request) {
+	atPeriodEnd := r.URL.Query().Get("immediately") != "true"
+	s, err := billing.Cancel(r.Context(), currentUserID(r), atPeriodEnd, requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handleResumeSubscription withdraws a scheduled cancellation
+func handleResumeSubscription(w http.ResponseWriter, r *http.Request) {
+	s, err := billing.Resume(r.Context(), currentUserID(r), requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handlePaySubscription returns the invoice to pay for the next period of a
+// crypto subscription, with the processor's checkout link
+func handlePaySubscription(w http.ResponseWriter, r *http.Request) {
+	inv, err := billing.PayCrypto(r.Context(), currentUserID(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inv)
+}
+
+// handleGetReferral returns the user's referral code and how often it was used
+func handleGetReferral(w http.ResponseWriter, r *http.Request) {
+	res, err := billing.Referral(r.Context(), currentUserID(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleClaimReferral records the referral code a new customer was given
+func handleClaimReferral(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := readJSON(w, r, &req); err != nil || req.Code == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	ref, err := billing.ClaimReferral(r.Context(), currentUserID(r), req.Code, requestMeta(r))
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ref)
+}
+
+// handleWebhook receives the events of a payment gateway. Errors other than a
+// bad signature return 500 so that the gateway retries the delivery; an event
+// that is still being processed returns 409 to the same effect.
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	name := r.PathValue("gateway")
+	err = billing.HandleWebhook(r.Context(), name, payload, r.Header)
+	switch {
+	case err == nil, errors.Is(err, billing.ErrDuplicateEvent):
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, billing.ErrEventInProgress):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, billing.ErrUnknownGateway):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, gateway.ErrInvalidSignature):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Error().Err(err).Str("gateway", name).Msg("Error processing webhook")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
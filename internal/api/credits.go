@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/ledger"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// handleGetCredits returns the user's prepaid credits. ?at takes an RFC 3339
+// time to also return the balance at that moment.
+func handleGetCredits(w http.ResponseWriter, r *http.Request) {
+	at := time.Now().UTC()
+	if s := r.URL.Query().Get("at"); s != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid at")
+			return
+		}
+	}
+	res, err := billing.Credits(r.Context(), currentUserID(r), at)
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleCreditStatement returns the user's credit ledger entries, newest
+// first. ?before takes an RFC 3339 time to page back, ?limit caps the page.
+func handleCreditStatement(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	before := time.Now().UTC()
+	var err error
+	if s := q.Get("before"); s != "" {
+		if before, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid before")
+			return
+		}
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	list, err := ledger.Statement(r.Context(), ledger.UserOwner(currentUserID(r)), before, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading credit statement")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleTopUpCredits charges a saved payment method for credits
+func handleTopUpCredits(w http.ResponseWriter, r *http.Request) {
+	var req billing.TopUp
+	if err := readJSON(w, r, &req); err != nil || req.PaymentMethodID == "" || req.IdempotencyKey == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	e, err := billing.TopUpCredits(r.Context(), currentUserID(r), req)
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// handleRefundCredits refunds the unused credits of a top-up
+func handleRefundCredits(w http.ResponseWriter, r *http.Request) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ledger.ErrUnknownLot.Error())
+		return
+	}
+	e, err := billing.RefundCredits(r.Context(), currentUserID(r), id)
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Auth5/brain/internal/entitlements"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type entitlementCheck struct {
+	Allowed bool                `json:"allowed"`
+	Status  entitlements.STATUS `json:"status"`
+	Limit   *int64              `json:"limit,omitempty"` // Quota checked with ?limit
+}
+
+type jwks struct {
+	Keys []entitlements.JWK `json:"keys"`
+}
+
+func writeEntitlementError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrNotFound), errors.Is(err, entitlements.ErrUnknownOverride),
+		errors.Is(err, entitlements.ErrTokensDisabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, entitlements.ErrInvalidOverride):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Error().Err(err).Msg("Error handling entitlement request")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// handleGetEntitlements returns the user's features and limits
+func handleGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	e, err := entitlements.Get(r.Context(), currentUserID(r))
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleEntitlementToken issues a signed token carrying the user's
+// entitlements, for services that check them without calling brain
+func handleEntitlementToken(w http.ResponseWriter, r *http.Request) {
+	t, err := entitlements.IssueToken(r.Context(), currentUserID(r))
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// handleJWKS publishes the keys verifying entitlement tokens
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, jwks{Keys: entitlements.JWKS()})
+}
+
+// serviceUserID parses the {user_id} path value of service endpoints
+func serviceUserID(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("user_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return id, false
+	}
+	return id, true
+}
+
+// handleServiceEntitlements returns a user's entitlements to a service
+func handleServiceEntitlements(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	e, err := entitlements.Get(r.Context(), userID)
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleCheckEntitlement answers whether a user may do something. Every
+// ?feature must be granted and, with ?limit, ?usage must be within the
+// quota.
+func handleCheckEntitlement(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	var usage int64
+	if s := q.Get("usage"); s != "" {
+		var err error
+		if usage, err = strconv.ParseInt(s, 10, 64); err != nil || usage < 0 {
+			writeError(w, http.StatusBadRequest, "invalid usage")
+			return
+		}
+	}
+	if len(q["feature"]) == 0 && q.Get("limit") == "" {
+		writeError(w, http.StatusBadRequest, "feature or limit required")
+		return
+	}
+
+	e, err := entitlements.Get(r.Context(), userID)
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	res := entitlementCheck{Allowed: true, Status: e.Status}
+	for _, f := range q["feature"] {
+		res.Allowed = res.Allowed && e.Has(f)
+	}
+	if name := q.Get("limit"); name != "" {
+		limit := e.Limit(name)
+		res.Limit = &limit
+		res.Allowed = res.Allowed && e.Allows(name, usage)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleListOverrides returns a user's entitlement overrides
+func handleListOverrides(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	list, err := entitlements.Overrides(r.Context(), userID)
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSetOverride creates or replaces one of a user's entitlement overrides
+func handleSetOverride(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req entitlements.Override
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	o, err := entitlements.SetOverride(r.Context(), userID, req, history.SYSTEM_ACTOR, requestMeta(r))
+	if err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleRemoveOverride deletes one of a user's entitlement overrides
+func handleRemoveOverride(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	kind := schema.ENTITLEMENT_KIND(r.PathValue("kind"))
+	if err := entitlements.RemoveOverride(r.Context(), userID, kind, r.PathValue("key"), history.SYSTEM_ACTOR, requestMeta(r)); err != nil {
+		writeEntitlementError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
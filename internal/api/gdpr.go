@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// handleDeleteAccount marks the current user's account for deletion
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	u, err := gdpr.RequestDeletion(r.Context(), gdpr.DeletionRequest{
+		UserID: userID,
+		Reason: schema.DELETION_REASON_USER_REQUEST,
+		Actor:  users.Actor{Kind: users.ACTOR_KIND_USER, ID: userID},
+		Meta:   requestMeta(r),
+	})
+	if errors.Is(err, users.ErrConcurrentUpdate) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error deleting account")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, u.DeletionInfo)
+}
+
+type recoveryRequest struct {
+	Email string `json:"email"`
+}
+
+type recoveryConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	TOTPCode string `json:"totp_code"`
+}
+
+// handleRecoveryRequest emails a recovery link for a deleted account
+func handleRecoveryRequest(w http.ResponseWriter, r *http.Request) {
+	var req recoveryRequest
+	if err := readJSON(w, r, &req); err != nil || req.Email == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := gdpr.RequestRecovery(r.Context(), req.Email); err != nil {
+		log.Error().Err(err).Msg("Error requesting account recovery")
+	}
+	// Always accepted, whether or not a recoverable account exists
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleRecoveryConfirm restores a deleted account after re-verifying the owner
+func handleRecoveryConfirm(w http.ResponseWriter, r *http.Request) {
+	var req recoveryConfirmRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	u, err := gdpr.ConfirmRecovery(r.Context(), gdpr.Confirmation{
+		Token:    req.Token,
+		Password: req.Password,
+		TOTPCode: req.TOTPCode,
+	}, requestMeta(r))
+	switch {
+	case errors.Is(err, gdpr.ErrRecoveryInvalid), errors.Is(err, gdpr.ErrIdentityNotVerified):
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	case errors.Is(err, gdpr.ErrRecoveryUnavailable), errors.Is(err, users.ErrGuardFailed):
+		writeError(w, http.StatusGone, gdpr.ErrRecoveryUnavailable.Error())
+		return
+	case errors.Is(err, users.ErrConcurrentUpdate):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("Error confirming account recovery")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// handleExportRequest queues a download of all data held about the user
+func handleExportRequest(w http.ResponseWriter, r *http.Request) {
+	export, err := gdpr.RequestExport(r.Context(), currentUserID(r), requestMeta(r))
+	if errors.Is(err, gdpr.ErrExportInProgress) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error requesting data export")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, export)
+}
+
+// handleExportDownload serves a decrypted export archive. The key in the
+// emailed link is the only credential.
+func handleExportDownload(w http.ResponseWriter, r *http.Request) {
+	exportID, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, gdpr.ErrExportNotFound.Error())
+		return
+	}
+
+	data, err := gdpr.OpenExport(r.Context(), exportID, r.URL.Query().Get("key"))
+	switch {
+	case errors.Is(err, gdpr.ErrExportNotFound), errors.Is(err, gdpr.ErrExportKey):
+		writeError(w, http.StatusNotFound, gdpr.ErrExportNotFound.Error())
+		return
+	case errors.Is(err, gdpr.ErrExportNotReady):
+		writeError(w, http.StatusGone, err.Error())
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("Error opening data export")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="personal-data-`+exportID.Hex()+`.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
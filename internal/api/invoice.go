@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Auth5/brain/internal/billing/invoice"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func handleListInvoices(w http.ResponseWriter, r *http.Request) {
+	list, err := invoice.ListForUser(r.Context(), currentUserID(r))
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing invoices")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	inv, ok := userInvoice(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, inv)
+}
+
+// handleInvoiceDocument serves the PDF or HTML rendered on finalization, so
+// the user always receives the exact document that was issued
+func handleInvoiceDocument(w http.ResponseWriter, r *http.Request) {
+	format := schema.INVOICE_FORMAT(r.PathValue("format"))
+	if format != schema.INVOICE_FORMAT_PDF && format != schema.INVOICE_FORMAT_HTML {
+		writeError(w, http.StatusNotFound, "unknown format")
+		return
+	}
+	inv, ok := userInvoice(w, r)
+	if !ok {
+		return
+	}
+	doc, err := invoice.Document(r.Context(), inv.ID, format)
+	if err != nil {
+		log.Error().Err(err).Str("invoice_id", inv.ID.Hex()).Msg("Error loading invoice document")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if format == schema.INVOICE_FORMAT_PDF {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+inv.Number+`.pdf"`)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	_, _ = w.Write(doc.Data)
+}
+
+// userInvoice loads the invoice in the path, writing 404 unless it is a
+// finalized invoice of the current user
+func userInvoice(w http.ResponseWriter, r *http.Request) (*schema.Invoice, bool) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, invoice.ErrNotFound.Error())
+		return nil, false
+	}
+	inv, err := invoice.GetForUser(r.Context(), currentUserID(r), id)
+	if errors.Is(err, invoice.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading invoice")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	return inv, true
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Auth5/brain/internal/admin"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/rbac"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type contextKey int
+
+const (
+	sessionKey contextKey = iota
+	impersonatorKey
+)
+
+// requireSession rejects requests without a valid bearer session token
+func requireSession(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, "missing session token")
+			return
+		}
+		s, err := session.Lookup(r.Context(), token)
+		if errors.Is(err, session.ErrInvalidSession) {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error looking up session")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		ctx := context.WithValue(r.Context(), sessionKey, s.UserID)
+		if s.ImpersonatorID != nil {
+			// Marks every response so clients can show an impersonation banner
+			w.Header().Set("X-Impersonated-By", s.ImpersonatorID.Hex())
+			ctx = context.WithValue(ctx, impersonatorKey, *s.ImpersonatorID)
+		}
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// currentUserID returns the ID of the user authenticated by requireSession
+func currentUserID(r *http.Request) bson.ObjectID {
+	id, _ := r.Context().Value(sessionKey).(bson.ObjectID)
+	return id
+}
+
+// impersonatorID returns the admin behind an impersonation session, if any
+func impersonatorID(r *http.Request) (bson.ObjectID, bool) {
+	id, ok := r.Context().Value(impersonatorKey).(bson.ObjectID)
+	return id, ok
+}
+
+// denyImpersonation rejects impersonation sessions on routes that move money
+// or cannot be undone, which only the user may call themselves
+func denyImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := impersonatorID(r); ok {
+			writeError(w, http.StatusForbidden, admin.ErrUserOnly.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requirePermission rejects requests of users without the permission. On
+// routes with an {organization_id} the grants for that organization count as
+// well, elsewhere only global grants do. Impersonation sessions are always
+// rejected, so impersonating an admin grants nothing.
+func requirePermission(p schema.PERMISSION, next http.HandlerFunc) http.Handler {
+	return requireSession(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := impersonatorID(r); ok {
+			writeError(w, http.StatusForbidden, admin.ErrImpersonating.Error())
+			return
+		}
+		var scope *bson.ObjectID
+		if s := r.PathValue("organization_id"); s != "" {
+			id, err := bson.ObjectIDFromHex(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid organization id")
+				return
+			}
+			scope = &id
+		}
+		ok, err := rbac.Can(r.Context(), currentUserID(r), p, scope)
+		if err != nil {
+			log.Error().Err(err).Msg("Error checking permission")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusForbidden, rbac.ErrForbidden.Error())
+			return
+		}
+		next(w, r)
+	})
+}
+
+// withCORS allows the configured origins to call the API from a browser
+func withCORS(next http.Handler) http.Handler {
+	origins := config.GetCORSConfig().Origins
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && slices.Contains(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireServiceToken rejects requests without the given bearer token. It
+// protects endpoints called by backend services rather than users.
+func requireServiceToken(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid service token")
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/migration"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func writeMigrationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, migration.ErrImportNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, migration.ErrUnknownSource), errors.Is(err, migration.ErrInvalidFile),
+		errors.Is(err, migration.ErrFirebaseNotConfig):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, migration.ErrFileTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, migration.ErrNotReviewed), errors.Is(err, migration.ErrImportFinished):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error().Err(err).Msg("Error handling user import request")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// importID parses the {import_id} path value
+func importID(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("import_id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, migration.ErrImportNotFound.Error())
+		return id, false
+	}
+	return id, true
+}
+
+// handleUploadImport stores the export file in the body and queues its
+// import from ?source, as a dry run with ?dry_run=true
+func handleUploadImport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dryRun := false
+	if s := q.Get("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+	}
+	job, err := migration.Upload(r.Context(), schema.IMPORT_SOURCE(q.Get("source")), dryRun, r.Body, adminActor(r))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleListImports returns the latest ?limit imports
+func handleListImports(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	list, err := migration.List(r.Context(), limit)
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetImport returns an import with its report
+func handleGetImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := importID(w, r)
+	if !ok {
+		return
+	}
+	job, err := migration.Get(r.Context(), id)
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleRunImport runs a reviewed dry run for real
+func handleRunImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := importID(w, r)
+	if !ok {
+		return
+	}
+	job, err := migration.Run(r.Context(), id, adminActor(r))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleCancelImport stops an import and removes its file
+func handleCancelImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := importID(w, r)
+	if !ok {
+		return
+	}
+	job, err := migration.Cancel(r.Context(), id, adminActor(r))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleExportUsers streams all users as JSONL, with their password hashes
+// when ?password_hashes=true
+func handleExportUsers(w http.ResponseWriter, r *http.Request) {
+	withHashes := false
+	if s := r.URL.Query().Get("password_hashes"); s != "" {
+		var err error
+		if withHashes, err = strconv.ParseBool(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid password_hashes")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="users-`+time.Now().UTC().Format("20060102")+`.jsonl"`)
+	w.Header().Set("Cache-Control", "no-store")
+	// Once streaming started the status can no longer change, a truncated
+	// file is all the client sees
+	if err := migration.Export(r.Context(), w, withHashes, adminActor(r)); err != nil {
+		log.Error().Err(err).Msg("Error exporting users")
+	}
+}
@@ -0,0 +1,545 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/admin"
+	"github.com/Auth5/brain/internal/organizations"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+type memberRoleRequest struct {
+	Role schema.ORGANIZATION_ROLE `json:"role"`
+}
+
+type transferRequest struct {
+	UserID bson.ObjectID `json:"user_id"`
+}
+
+type invitationRequest struct {
+	Email string                   `json:"email"`
+	Role  schema.ORGANIZATION_ROLE `json:"role"`
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+type domainRequest struct {
+	Domain string `json:"domain"`
+}
+
+type autoJoinRequest struct {
+	AutoJoin *bool `json:"auto_join"`
+}
+
+type seatsRequest struct {
+	Seats int64 `json:"seats"`
+}
+
+// writeOrganizationError maps organization errors to HTTP responses. Errors
+// of the owner's subscription fall through to writeBillingError.
+func writeOrganizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, organizations.ErrNotFound), errors.Is(err, organizations.ErrUnknownMember),
+		errors.Is(err, organizations.ErrUnknownInvitation), errors.Is(err, organizations.ErrUnknownDomain),
+		errors.Is(err, organizations.ErrOrganizationsDisabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, organizations.ErrInvalidName), errors.Is(err, organizations.ErrInvalidRole),
+		errors.Is(err, organizations.ErrInvalidEmail), errors.Is(err, organizations.ErrInvalidDomain),
+		errors.Is(err, organizations.ErrInvalidInvitation):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, organizations.ErrForbidden), errors.Is(err, organizations.ErrPlanWithoutTeams),
+		errors.Is(err, organizations.ErrEmailNotVerified):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, organizations.ErrAlreadyMember), errors.Is(err, organizations.ErrOwnerRole),
+		errors.Is(err, organizations.ErrNoSeats), errors.Is(err, organizations.ErrSeatsInUse),
+		errors.Is(err, organizations.ErrDomainExists), errors.Is(err, organizations.ErrDomainClaimed),
+		errors.Is(err, organizations.ErrDomainNotVerified):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeBillingError(w, err)
+	}
+}
+
+// organizationActor is the signed in user acting on an organization
+func organizationActor(r *http.Request) organizations.Actor {
+	return organizations.Actor{UserID: currentUserID(r), Meta: requestMeta(r)}
+}
+
+// organizationID parses the {id} path value
+func organizationID(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid organization id")
+		return id, false
+	}
+	return id, true
+}
+
+// handleCreateOrganization creates an organization owned by the user
+func handleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req organizationRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	o, err := organizations.Create(r.Context(), req.Name, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, o)
+}
+
+// handleListOrganizations returns the organizations the user is a member of
+func handleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	list, err := organizations.List(r.Context(), currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAutoJoin joins the organization that verified the domain of the
+// user's email address
+func handleAutoJoin(w http.ResponseWriter, r *http.Request) {
+	list, err := organizations.AutoJoin(r.Context(), organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetOrganization returns an organization with the user's role
+func handleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	m, err := organizations.Get(r.Context(), id, currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleRenameOrganization changes the name of an organization
+func handleRenameOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req organizationRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	o, err := organizations.Rename(r.Context(), id, req.Name, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleDeleteOrganization deletes an organization owned by the user
+func handleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	if err := organizations.Delete(r.Context(), id, organizationActor(r)); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListMembers returns the members of an organization
+func handleListMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.Members(r.Context(), id, currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleChangeMemberRole makes a member an admin or an admin a member
+func handleChangeMemberRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req memberRoleRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	m, err := organizations.ChangeRole(r.Context(), id, userID, req.Role, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleRemoveMember removes a member, or lets the user leave when the
+// {user_id} is their own
+func handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := organizations.RemoveMember(r.Context(), id, userID, organizationActor(r)); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTransferOwnership makes another member the owner
+func handleTransferOwnership(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req transferRequest
+	if err := readJSON(w, r, &req); err != nil || req.UserID.IsZero() {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	o, err := organizations.TransferOwnership(r.Context(), id, req.UserID, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleListInvitations returns the pending invitations of an organization
+func handleListInvitations(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.Invitations(r.Context(), id, currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleInvite emails an invitation to join an organization
+func handleInvite(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req invitationRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = schema.ORGANIZATION_ROLE_MEMBER
+	}
+	inv, err := organizations.Invite(r.Context(), id, req.Email, req.Role, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, inv)
+}
+
+// handleRevokeInvitation withdraws a pending invitation
+func handleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	invitationID, err := bson.ObjectIDFromHex(r.PathValue("invitation_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid invitation id")
+		return
+	}
+	if err := organizations.RevokeInvitation(r.Context(), id, invitationID, organizationActor(r)); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAcceptInvitation joins the organization of an emailed invitation
+func handleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req acceptInvitationRequest
+	if err := readJSON(w, r, &req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	m, err := organizations.AcceptInvitation(r.Context(), req.Token, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleListDomains returns the email domains of an organization
+func handleListDomains(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.Domains(r.Context(), id, currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAddDomain claims an email domain and returns its verification token
+func handleAddDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req domainRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	d, err := organizations.AddDomain(r.Context(), id, req.Domain, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, d)
+}
+
+// handleVerifyDomain checks the DNS TXT record of a domain
+func handleVerifyDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	d, err := organizations.VerifyDomain(r.Context(), id, r.PathValue("domain"), organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleUpdateDomain turns auto-join on or off for a verified domain
+func handleUpdateDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req autoJoinRequest
+	if err := readJSON(w, r, &req); err != nil || req.AutoJoin == nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	d, err := organizations.SetAutoJoin(r.Context(), id, r.PathValue("domain"), *req.AutoJoin, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleRemoveDomain releases an email domain
+func handleRemoveDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	if err := organizations.RemoveDomain(r.Context(), id, r.PathValue("domain"), organizationActor(r)); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetSeats returns the seat usage of an organization
+func handleGetSeats(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	s, err := organizations.Seats(r.Context(), id, currentUserID(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handleSetSeats changes the seats billed on the owner's subscription
+func handleSetSeats(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	var req seatsRequest
+	if err := readJSON(w, r, &req); err != nil || req.Seats < 1 {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	s, err := organizations.SetSeats(r.Context(), id, req.Seats, organizationActor(r))
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// handleMembershipHistory returns the membership events of an organization,
+// newest first. ?before takes an RFC 3339 time to page back, ?limit caps the
+// page.
+func handleMembershipHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := organizationID(w, r)
+	if !ok {
+		return
+	}
+	before, limit, ok := historyPage(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.History(r.Context(), id, currentUserID(r), before, limit)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// historyPage parses ?before and ?limit of the membership history
+func historyPage(w http.ResponseWriter, r *http.Request) (time.Time, int, bool) {
+	q := r.URL.Query()
+	before := time.Now().UTC()
+	var err error
+	if s := q.Get("before"); s != "" {
+		if before, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid before")
+			return before, 0, false
+		}
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return before, 0, false
+		}
+	}
+	return before, limit, true
+}
+
+// adminOrganizationID parses the {organization_id} path value of the admin
+// routes, which requirePermission also reads to apply organization grants
+func adminOrganizationID(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	id, err := bson.ObjectIDFromHex(r.PathValue("organization_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid organization id")
+		return id, false
+	}
+	return id, true
+}
+
+// handleAdminGetOrganization returns any organization to an admin
+func handleAdminGetOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminOrganizationID(w, r)
+	if !ok {
+		return
+	}
+	o, err := organizations.Inspect(r.Context(), id)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// handleAdminListMembers returns the members of any organization to an admin
+func handleAdminListMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminOrganizationID(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.InspectMembers(r.Context(), id)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAdminMembershipHistory returns the membership events of any
+// organization to an admin
+func handleAdminMembershipHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminOrganizationID(w, r)
+	if !ok {
+		return
+	}
+	before, limit, ok := historyPage(w, r)
+	if !ok {
+		return
+	}
+	list, err := organizations.InspectHistory(r.Context(), id, before, limit)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleAdminRemoveMember removes a member from an organization on behalf of
+// an admin, ?reason is required
+func handleAdminRemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminOrganizationID(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	err := admin.RemoveMember(r.Context(), id, userID, r.URL.Query().Get("reason"), adminActor(r))
+	if errors.Is(err, admin.ErrReasonRequired) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Auth5/brain/internal/admin"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/provisioning"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+)
+
+// MAX_CSV_BYTES limits the size of a bulk creation upload
+const MAX_CSV_BYTES = 4 << 20
+
+type setupRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func writeProvisioningError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, provisioning.ErrInvalidEmail), errors.Is(err, provisioning.ErrUnknownAccountType),
+		errors.Is(err, provisioning.ErrInvalidCSV), errors.Is(err, provisioning.ErrTooManyRows),
+		errors.Is(err, password.ErrInvalidLength):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, provisioning.ErrInvalidSetup):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, users.ErrAlreadyExists), errors.Is(err, provisioning.ErrNotPending),
+		errors.Is(err, provisioning.ErrNotAdminCreated), errors.Is(err, users.ErrGuardFailed),
+		errors.Is(err, users.ErrConcurrentUpdate):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error().Err(err).Msg("Error handling account provisioning request")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// handleCreateUser creates a pending account and emails a set-password link
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req provisioning.NewUser
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := provisioning.Create(r.Context(), req, adminActor(r))
+	if err != nil {
+		writeProvisioningError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, admin.NewProfile(u))
+}
+
+// handleCreateUsersCSV creates a pending account for every row of a CSV body
+func handleCreateUsersCSV(w http.ResponseWriter, r *http.Request) {
+	res, err := provisioning.CreateFromCSV(r.Context(), http.MaxBytesReader(w, r.Body, MAX_CSV_BYTES), adminActor(r))
+	if err != nil {
+		writeProvisioningError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleResendSetup emails a fresh set-password link to a pending account
+func handleResendSetup(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := provisioning.Resend(r.Context(), userID, adminActor(r))
+	if err != nil {
+		writeProvisioningError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, admin.NewProfile(u))
+}
+
+// handleCompleteSetup sets the password of an admin-created account from the
+// emailed link and activates it
+func handleCompleteSetup(w http.ResponseWriter, r *http.Request) {
+	var req setupRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := provisioning.CompleteSetup(r.Context(), req.Token, req.Password, requestMeta(r))
+	if err != nil {
+		writeProvisioningError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
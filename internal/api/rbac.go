@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Auth5/brain/internal/rbac"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type roleRequest struct {
+	Description string              `json:"description"`
+	Permissions []schema.PERMISSION `json:"permissions"`
+}
+
+type grantRequest struct {
+	Role           string         `json:"role"`
+	OrganizationID *bson.ObjectID `json:"organization_id"` // Global when omitted
+	Reason         string         `json:"reason"`
+}
+
+func writeRBACError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrNotFound), errors.Is(err, rbac.ErrUnknownRole),
+		errors.Is(err, rbac.ErrUnknownGrant), errors.Is(err, rbac.ErrUnknownOrganization):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, rbac.ErrInvalidRole):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, rbac.ErrForbidden), errors.Is(err, rbac.ErrBuiltInRole):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, rbac.ErrAlreadyGranted), errors.Is(err, rbac.ErrRoleInUse),
+		errors.Is(err, rbac.ErrLastSuperAdmin):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error().Err(err).Msg("Error handling role request")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// handleMyPermissions returns the user's grants and permissions, globally or
+// within ?organization_id
+func handleMyPermissions(w http.ResponseWriter, r *http.Request) {
+	var scope *bson.ObjectID
+	if s := r.URL.Query().Get("organization_id"); s != "" {
+		id, err := bson.ObjectIDFromHex(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid organization id")
+			return
+		}
+		scope = &id
+	}
+	a, err := rbac.Resolve(r.Context(), currentUserID(r), scope)
+	if err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// handleListRoles returns the built-in and custom roles
+func handleListRoles(w http.ResponseWriter, r *http.Request) {
+	list, err := rbac.Roles(r.Context())
+	if err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSaveRole creates or replaces a custom role
+func handleSaveRole(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	role, err := rbac.SaveRole(r.Context(), schema.Role{
+		Name:        r.PathValue("name"),
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}, adminActor(r))
+	if err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, role)
+}
+
+// handleDeleteRole removes a custom role that is no longer granted
+func handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := rbac.DeleteRole(r.Context(), r.PathValue("name"), adminActor(r)); err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListGrants returns the role grants of a user
+func handleListGrants(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	list, err := rbac.Grants(r.Context(), userID)
+	if err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGrantRole gives a role to a user
+func handleGrantRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	var req grantRequest
+	if err := readJSON(w, r, &req); err != nil || req.Role == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	g, err := rbac.Grant(r.Context(), schema.RoleGrant{
+		UserID:         userID,
+		Role:           req.Role,
+		OrganizationID: req.OrganizationID,
+		Reason:         req.Reason,
+	}, adminActor(r))
+	if err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, g)
+}
+
+// handleRevokeRole removes a role grant. ?reason is recorded in AdminHistory.
+func handleRevokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := serviceUserID(w, r)
+	if !ok {
+		return
+	}
+	grantID, err := bson.ObjectIDFromHex(r.PathValue("grant_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid grant id")
+		return
+	}
+	if err := rbac.Revoke(r.Context(), userID, grantID, r.URL.Query().Get("reason"), adminActor(r)); err != nil {
+		writeRBACError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
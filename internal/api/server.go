@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+)
+
+// Start registers all routes and serves the API until the server fails
+func Start() {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	cfg := config.GetServerConfig()
+	srv := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler:           withCORS(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Info().Str("addr", srv.Addr).Msg("Starting API server")
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal().Err(err).Msg("API server failed")
+	}
+}
+
+func registerRoutes(mux *http.ServeMux) {
+	// Self-service activity feed
+	mux.Handle("GET /me/activity", requireSession(handleActivityFeed))
+	mux.Handle("GET /me/activity/summary", requireSession(handleActivitySummary))
+	mux.Handle("POST /me/activity/{id}/not-me", requireSession(handleActivityNotMe))
+
+	// GDPR
+	mux.Handle("DELETE /me", requireSession(denyImpersonation(handleDeleteAccount)))
+	mux.HandleFunc("POST /account/recovery", handleRecoveryRequest)
+	mux.HandleFunc("POST /account/recovery/confirm", handleRecoveryConfirm)
+	mux.Handle("POST /me/export", requireSession(handleExportRequest))
+	mux.HandleFunc("GET /gdpr/exports/{id}", handleExportDownload)
+
+	// Billing
+	mux.HandleFunc("GET /billing/plans", handleListPlans)
+	mux.Handle("GET /me/billing/details", requireSession(handleGetBillingDetails))
+	mux.Handle("PUT /me/billing/details", requireSession(handleSaveBillingDetails))
+	mux.Handle("GET /me/billing/quote", requireSession(handleQuote))
+	mux.Handle("GET /me/billing/subscription", requireSession(handleGetSubscription))
+	mux.Handle("POST /me/billing/subscription", requireSession(denyImpersonation(handleSubscribe)))
+	mux.Handle("PUT /me/billing/subscription", requireSession(denyImpersonation(handleChangePlan)))
+	mux.Handle("DELETE /me/billing/subscription", requireSession(denyImpersonation(handleCancelSubscription)))
+	mux.Handle("POST /me/billing/subscription/resume", requireSession(denyImpersonation(handleResumeSubscription)))
+	mux.Handle("POST /me/billing/subscription/pay", requireSession(denyImpersonation(handlePaySubscription)))
+	mux.Handle("GET /me/billing/referral", requireSession(handleGetReferral))
+	mux.Handle("POST /me/billing/referral", requireSession(denyImpersonation(handleClaimReferral)))
+	mux.Handle("GET /me/billing/credits", requireSession(handleGetCredits))
+	mux.Handle("GET /me/billing/credits/entries", requireSession(handleCreditStatement))
+	mux.Handle("POST /me/billing/credits/top-up", requireSession(denyImpersonation(handleTopUpCredits)))
+	mux.Handle("POST /me/billing/credits/{id}/refund", requireSession(denyImpersonation(handleRefundCredits)))
+	mux.HandleFunc("POST /webhooks/{gateway}", handleWebhook)
+	mux.Handle("GET /me/invoices", requireSession(handleListInvoices))
+	mux.Handle("GET /me/invoices/{id}", requireSession(handleGetInvoice))
+	mux.Handle("GET /me/invoices/{id}/{format}", requireSession(handleInvoiceDocument))
+
+	// Usage metering
+	mux.Handle("POST /usage/events", requireServiceToken(config.GetBillingConfig().Metering.IngestToken, handleIngestUsage))
+	mux.Handle("GET /me/usage", requireSession(handleGetUsage))
+
+	// Entitlements
+	serviceToken := config.GetEntitlementsConfig().ServiceToken
+	mux.Handle("GET /me/entitlements", requireSession(handleGetEntitlements))
+	mux.Handle("POST /me/entitlements/token", requireSession(handleEntitlementToken))
+	mux.HandleFunc("GET /.well-known/jwks.json", handleJWKS)
+	mux.Handle("GET /entitlements/{user_id}", requireServiceToken(serviceToken, handleServiceEntitlements))
+	mux.Handle("GET /entitlements/{user_id}/check", requireServiceToken(serviceToken, handleCheckEntitlement))
+	mux.Handle("GET /entitlements/{user_id}/overrides", requireServiceToken(serviceToken, handleListOverrides))
+	mux.Handle("PUT /entitlements/{user_id}/overrides", requireServiceToken(serviceToken, handleSetOverride))
+	mux.Handle("DELETE /entitlements/{user_id}/overrides/{kind}/{key}", requireServiceToken(serviceToken, handleRemoveOverride))
+
+	// Organizations
+	mux.Handle("POST /organizations", requireSession(handleCreateOrganization))
+	mux.Handle("GET /me/organizations", requireSession(handleListOrganizations))
+	mux.Handle("POST /me/organizations/auto-join", requireSession(handleAutoJoin))
+	mux.Handle("POST /organizations/invitations/accept", requireSession(handleAcceptInvitation))
+	mux.Handle("GET /organizations/{id}", requireSession(handleGetOrganization))
+	mux.Handle("PATCH /organizations/{id}", requireSession(handleRenameOrganization))
+	mux.Handle("DELETE /organizations/{id}", requireSession(denyImpersonation(handleDeleteOrganization)))
+	mux.Handle("GET /organizations/{id}/members", requireSession(handleListMembers))
+	mux.Handle("PUT /organizations/{id}/members/{user_id}", requireSession(handleChangeMemberRole))
+	mux.Handle("DELETE /organizations/{id}/members/{user_id}", requireSession(denyImpersonation(handleRemoveMember)))
+	mux.Handle("POST /organizations/{id}/transfer", requireSession(denyImpersonation(handleTransferOwnership)))
+	mux.Handle("GET /organizations/{id}/invitations", requireSession(handleListInvitations))
+	mux.Handle("POST /organizations/{id}/invitations", requireSession(handleInvite))
+	mux.Handle("DELETE /organizations/{id}/invitations/{invitation_id}", requireSession(handleRevokeInvitation))
+	mux.Handle("GET /organizations/{id}/domains", requireSession(handleListDomains))
+	mux.Handle("POST /organizations/{id}/domains", requireSession(handleAddDomain))
+	mux.Handle("POST /organizations/{id}/domains/{domain}/verify", requireSession(handleVerifyDomain))
+	mux.Handle("PATCH /organizations/{id}/domains/{domain}", requireSession(handleUpdateDomain))
+	mux.Handle("DELETE /organizations/{id}/domains/{domain}", requireSession(handleRemoveDomain))
+	mux.Handle("GET /organizations/{id}/seats", requireSession(handleGetSeats))
+	mux.Handle("PUT /organizations/{id}/seats", requireSession(denyImpersonation(handleSetSeats)))
+	mux.Handle("GET /organizations/{id}/history", requireSession(handleMembershipHistory))
+
+	// Roles and permissions
+	mux.Handle("GET /me/permissions", requireSession(handleMyPermissions))
+	mux.Handle("GET /admin/roles", requirePermission(schema.PERMISSION_ROLES_READ, handleListRoles))
+	mux.Handle("PUT /admin/roles/{name}", requirePermission(schema.PERMISSION_ROLES_WRITE, handleSaveRole))
+	mux.Handle("DELETE /admin/roles/{name}", requirePermission(schema.PERMISSION_ROLES_WRITE, handleDeleteRole))
+	mux.Handle("GET /admin/users/{user_id}/roles", requirePermission(schema.PERMISSION_ROLES_READ, handleListGrants))
+	mux.Handle("POST /admin/users/{user_id}/roles", requirePermission(schema.PERMISSION_ROLES_WRITE, handleGrantRole))
+	mux.Handle("DELETE /admin/users/{user_id}/roles/{grant_id}", requirePermission(schema.PERMISSION_ROLES_WRITE, handleRevokeRole))
+
+	// Admin console
+	mux.Handle("GET /admin/users", requirePermission(schema.PERMISSION_USERS_READ, handleSearchUsers))
+	mux.Handle("GET /admin/users/{user_id}", requirePermission(schema.PERMISSION_USERS_READ, handleGetUser))
+	mux.Handle("GET /admin/users/{user_id}/history", requirePermission(schema.PERMISSION_USERS_READ, handleUserHistory))
+	mux.Handle("POST /admin/users/{user_id}/suspend", requirePermission(schema.PERMISSION_USERS_SUSPEND, handleAdminSuspend))
+	mux.Handle("POST /admin/users/{user_id}/unsuspend", requirePermission(schema.PERMISSION_USERS_SUSPEND, handleAdminUnsuspend))
+	mux.Handle("POST /admin/users/{user_id}/password-reset", requirePermission(schema.PERMISSION_USERS_CREDENTIALS, handleForcePasswordReset))
+	mux.Handle("POST /admin/users/{user_id}/2fa/disable", requirePermission(schema.PERMISSION_USERS_CREDENTIALS, handleAdminDisable2FA))
+	mux.Handle("DELETE /admin/users/{user_id}", requirePermission(schema.PERMISSION_USERS_DELETE, handleAdminDelete))
+	mux.Handle("PUT /admin/users/{user_id}/legal-hold", requirePermission(schema.PERMISSION_USERS_DELETE, handlePlaceLegalHold))
+	mux.Handle("DELETE /admin/users/{user_id}/legal-hold", requirePermission(schema.PERMISSION_USERS_DELETE, handleLiftLegalHold))
+	mux.Handle("POST /admin/users/{user_id}/impersonate", requirePermission(schema.PERMISSION_USERS_IMPERSONATE, handleImpersonate))
+	mux.Handle("GET /admin/organizations/{organization_id}", requirePermission(schema.PERMISSION_ORGANIZATIONS_READ, handleAdminGetOrganization))
+	mux.Handle("GET /admin/organizations/{organization_id}/members", requirePermission(schema.PERMISSION_ORGANIZATIONS_READ, handleAdminListMembers))
+	mux.Handle("GET /admin/organizations/{organization_id}/history", requirePermission(schema.PERMISSION_ORGANIZATIONS_READ, handleAdminMembershipHistory))
+	mux.Handle("DELETE /admin/organizations/{organization_id}/members/{user_id}", requirePermission(schema.PERMISSION_ORGANIZATIONS_WRITE, handleAdminRemoveMember))
+
+	// Admin-created accounts
+	mux.Handle("POST /admin/users", requirePermission(schema.PERMISSION_USERS_WRITE, handleCreateUser))
+	mux.Handle("POST /admin/users/bulk", requirePermission(schema.PERMISSION_USERS_WRITE, handleCreateUsersCSV))
+	mux.Handle("POST /admin/users/{user_id}/setup-link", requirePermission(schema.PERMISSION_USERS_WRITE, handleResendSetup))
+	mux.HandleFunc("POST /account/setup", handleCompleteSetup)
+
+	// Migration from other identity providers
+	mux.Handle("POST /admin/imports", requirePermission(schema.PERMISSION_USERS_WRITE, handleUploadImport))
+	mux.Handle("GET /admin/imports", requirePermission(schema.PERMISSION_USERS_WRITE, handleListImports))
+	mux.Handle("GET /admin/imports/{import_id}", requirePermission(schema.PERMISSION_USERS_WRITE, handleGetImport))
+	mux.Handle("POST /admin/imports/{import_id}/run", requirePermission(schema.PERMISSION_USERS_WRITE, handleRunImport))
+	mux.Handle("DELETE /admin/imports/{import_id}", requirePermission(schema.PERMISSION_USERS_WRITE, handleCancelImport))
+	mux.Handle("GET /admin/users/export", requirePermission(schema.PERMISSION_USERS_WRITE, handleExportUsers))
+}
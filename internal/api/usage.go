@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/metering"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+)
+
+// MAX_USAGE_BATCH is the maximum number of events per ingestion request
+const MAX_USAGE_BATCH = 1000
+
+type usageBatch struct {
+	Events []metering.Event `json:"events"`
+}
+
+type usageEventError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+type usageBatchResult struct {
+	Accepted   int               `json:"accepted"`
+	Duplicates int               `json:"duplicates"`
+	Errors     []usageEventError `json:"errors,omitempty"`
+}
+
+// handleIngestUsage buffers a batch of usage events reported by a service.
+// Events are handled one by one; rejected events are listed by index and the
+// rest are still recorded.
+func handleIngestUsage(w http.ResponseWriter, r *http.Request) {
+	var req usageBatch
+	if err := readJSON(w, r, &req); err != nil || len(req.Events) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Events) > MAX_USAGE_BATCH {
+		writeError(w, http.StatusRequestEntityTooLarge, "too many events")
+		return
+	}
+
+	var res usageBatchResult
+	for i, e := range req.Events {
+		err := metering.Record(e)
+		switch {
+		case err == nil:
+			res.Accepted++
+		case errors.Is(err, metering.ErrDuplicateEvent):
+			res.Duplicates++
+		case errors.Is(err, metering.ErrUnknownMeter), errors.Is(err, metering.ErrInvalidEvent):
+			res.Errors = append(res.Errors, usageEventError{Index: i, Error: err.Error()})
+		default:
+			log.Error().Err(err).Msg("Error recording usage event")
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, res)
+}
+
+// handleGetUsage returns the user's usage buckets, the current month's by
+// default. ?granularity=hour|month, ?from and ?to take RFC 3339 times.
+func handleGetUsage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	g := schema.USAGE_GRANULARITY(q.Get("granularity"))
+	if g == "" {
+		g = schema.USAGE_GRANULARITY_MONTH
+	}
+	if g != schema.USAGE_GRANULARITY_HOUR && g != schema.USAGE_GRANULARITY_MONTH {
+		writeError(w, http.StatusBadRequest, "invalid granularity")
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	var err error
+	if s := q.Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid from")
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to")
+			return
+		}
+	}
+
+	buckets, err := metering.Usage(r.Context(), currentUserID(r), g, from, to)
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading usage")
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, buckets)
+}
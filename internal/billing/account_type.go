@@ -0,0 +1,76 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PlanListener is told that a user's subscription or account type may have
+// changed, e.g. to drop cached entitlements
+type PlanListener func(userID bson.ObjectID)
+
+var (
+	listenersMu   sync.RWMutex
+	planListeners []PlanListener
+)
+
+// RegisterPlanListener adds a function called after every SyncAccountType
+func RegisterPlanListener(l PlanListener) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	planListeners = append(planListeners, l)
+}
+
+func notifyPlanListeners(userID bson.ObjectID) {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, l := range planListeners {
+		l(userID)
+	}
+}
+
+// SyncAccountType sets User.AccountType to the plan of the user's entitled
+// subscription, or to the default account type without one, and records the
+// change in AccountHistory. It runs whenever a subscription changes, so
+// plan listeners are notified even when the account type stays the same.
+func SyncAccountType(ctx context.Context, userID bson.ObjectID, meta history.Meta) error {
+	defer notifyPlanListeners(userID)
+
+	want := config.GetBillingConfig().DefaultAccountType
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNoSubscription) {
+		return err
+	}
+	if s != nil && s.Entitled() && s.AccountType != "" {
+		want = s.AccountType
+	}
+
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u.AccountType == want {
+		return nil
+	}
+	if err := users.Update(ctx, userID, bson.M{"account_type": want}); err != nil {
+		return err
+	}
+	return history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    userID,
+		EventType: schema.ACCOUNT_EVENT_ACCOUNT_TYPE,
+		Field:     "account_type",
+		OldValue:  u.AccountType,
+		NewValue:  want,
+		ChangedBy: history.SYSTEM_ACTOR,
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	})
+}
@@ -0,0 +1,119 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Auth5/brain/internal/billing/btcpay"
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/paypal"
+	"github.com/Auth5/brain/internal/billing/stripe"
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrUnknownPlan       = catalog.ErrUnknownPlan
+	ErrUnknownPrice      = catalog.ErrUnknownPrice
+	ErrUnknownGateway    = errors.New("payment gateway not configured")
+	ErrGatewayMismatch   = errors.New("plan uses a different payment gateway than the subscription")
+	ErrAlreadySubscribed = errors.New("user already has a subscription")
+	ErrNoSubscription    = errors.New("user has no subscription")
+	ErrSamePlan          = errors.New("subscription is already on this plan")
+	ErrNotPendingCancel  = errors.New("subscription is not scheduled for cancellation")
+	ErrInvalidSeats      = errors.New("a subscription needs at least one seat")
+)
+
+// gateways holds the configured payment gateways by name
+var gateways = map[string]gateway.PaymentGateway{}
+
+// InitBilling creates the configured payment gateways, seeds the plan
+// catalog and registers the billing hooks of the GDPR workflows
+func InitBilling() {
+	stripeCfg := config.GetStripeConfig()
+	gateways[gateway.STRIPE] = stripe.NewGateway(stripe.NewClient(stripeCfg.APIBase, stripeCfg.SecretKey), stripeCfg.Webhook.Secret)
+	if cfg := config.GetPayPalConfig(); cfg != nil {
+		gateways[gateway.PAYPAL] = paypal.NewGateway(paypal.NewClient(cfg.APIBase, cfg.ClientID, cfg.ClientSecret), cfg.WebhookID)
+	}
+	if cfg := config.GetCryptoConfig(); cfg != nil {
+		processor := btcpay.NewProcessor(btcpay.NewClient(cfg.APIBase, cfg.APIKey, cfg.StoreID), cfg.WebhookSecret, cfg.SpeedPolicy, cfg.ExpirationMinutes)
+		gateways[gateway.CRYPTO] = crypto.NewGateway(processor, cryptoPrice, cfg.RenewalLeadDays, cfg.GraceDays)
+	}
+
+	cfg := config.GetBillingConfig()
+	defaultPlan := false
+	for _, p := range cfg.Plans {
+		defaultPlan = defaultPlan || p.AccountType == cfg.DefaultAccountType
+		for _, pr := range p.Prices {
+			if _, ok := gateways[pr.Gateway]; !ok {
+				log.Fatal().Str("account_type", p.AccountType).Str("gateway", pr.Gateway).Msg("Plan uses a payment gateway that is not configured")
+			}
+		}
+	}
+	if !defaultPlan {
+		log.Fatal().Str("account_type", cfg.DefaultAccountType).Msg("No plan for the default account type")
+	}
+	if _, err := config.GetLegalEntity(cfg.Invoicing.DefaultEntity); err != nil {
+		log.Fatal().Err(err).Msg("Default invoicing entity is not configured")
+	}
+	if _, err := config.GetSMTPConfig(mailer.PROFILE_BILLING); err != nil {
+		log.Fatal().Err(err).Msg("Invoices need an email profile nicknamed billing")
+	}
+
+	if err := validateDunning(cfg.Dunning); err != nil {
+		log.Fatal().Err(err).Msg("Invalid dunning schedule")
+	}
+	if err := validateCoupons(cfg); err != nil {
+		log.Fatal().Err(err).Msg("Invalid coupons")
+	}
+	if err := validateCredits(cfg); err != nil {
+		log.Fatal().Err(err).Msg("Invalid prepaid credits")
+	}
+
+	tax.InitTax()
+
+	ctx := context.Background()
+	if err := catalog.Seed(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Error seeding plan catalog")
+	}
+	unknown, err := catalog.ValidateAccountTypes(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error validating account types")
+	}
+	for _, u := range unknown {
+		log.Error().Str("account_type", u.AccountType).Int64("users", u.Users).Msg("Account type in use has no plan")
+	}
+	if err := seedCoupons(ctx, gateways[gateway.STRIPE].(*stripe.Gateway).Client()); err != nil {
+		log.Fatal().Err(err).Msg("Error seeding coupons")
+	}
+
+	for _, g := range gateways {
+		gdpr.RegisterProcessor(gatewayProcessor{g})
+	}
+	gdpr.RegisterHold(HOLD_SUBSCRIPTION, hasActiveSubscription)
+}
+
+// Gateway returns a configured payment gateway by name
+func Gateway(name string) (gateway.PaymentGateway, error) {
+	g, ok := gateways[name]
+	if !ok {
+		return nil, ErrUnknownGateway
+	}
+	return g, nil
+}
+
+func customers() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_BILLING_CUSTOMERS)
+}
+
+func subscriptions() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_SUBSCRIPTIONS)
+}
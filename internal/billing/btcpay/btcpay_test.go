@@ -0,0 +1,214 @@
+package btcpay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAPIKey        = "key_test"
+	testStoreID       = "store_1"
+	testWebhookSecret = "whsec_test"
+)
+
+// fakeBTCPay is a stand-in for the Greenfield API of one store, keeping
+// invoices and refunds in memory
+type fakeBTCPay struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	seq      int
+	invoices map[string]*fakeInvoice
+	refunds  map[string]int // Pull payments created per invoice
+	requests []*recorded
+}
+
+type fakeInvoice struct {
+	Invoice
+	methods []PaymentMethod
+}
+
+// recorded is a request with its decoded JSON body
+type recorded struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]any
+}
+
+func newFakeBTCPay(t *testing.T) (*fakeBTCPay, *Client) {
+	f := &fakeBTCPay{
+		t:        t,
+		invoices: map[string]*fakeInvoice{},
+		refunds:  map[string]int{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/stores/{store}/invoices", f.createInvoice)
+	mux.HandleFunc("GET /api/v1/stores/{store}/invoices/{id}", f.getInvoice)
+	mux.HandleFunc("GET /api/v1/stores/{store}/invoices/{id}/payment-methods", f.getPaymentMethods)
+	mux.HandleFunc("POST /api/v1/stores/{store}/invoices/{id}/status", f.markStatus)
+	mux.HandleFunc("POST /api/v1/stores/{store}/invoices/{id}/refund", f.refund)
+	f.server = httptest.NewServer(f.authenticate(mux))
+	t.Cleanup(f.server.Close)
+	return f, NewClient(f.server.URL, testAPIKey, testStoreID)
+}
+
+func (f *fakeBTCPay) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+testAPIKey {
+			f.fail(w, http.StatusUnauthorized, "unauthenticated", "Authentication is required for accessing this endpoint")
+			return
+		}
+		rec := &recorded{method: r.Method, path: r.URL.Path, header: r.Header.Clone()}
+		if r.Method == http.MethodPost {
+			raw, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(raw, &rec.body); err != nil {
+				f.fail(w, http.StatusBadRequest, "invalid-json", err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(raw))
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, rec)
+		f.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *fakeBTCPay) reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeBTCPay) fail(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"code":%q,"message":%q}`, code, message)
+}
+
+// invoice returns the invoice of the request, answering 404 for unknown
+// stores and invoices
+func (f *fakeBTCPay) invoice(w http.ResponseWriter, r *http.Request) *fakeInvoice {
+	if r.PathValue("store") != testStoreID {
+		f.fail(w, http.StatusNotFound, "store-not-found", "The store was not found")
+		return nil
+	}
+	inv, ok := f.invoices[r.PathValue("id")]
+	if !ok {
+		f.fail(w, http.StatusNotFound, "invoice-not-found", "The invoice was not found")
+	}
+	return inv
+}
+
+func (f *fakeBTCPay) createInvoice(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var p InvoiceParams
+	json.NewDecoder(r.Body).Decode(&p)
+	if amount, err := strconv.ParseFloat(p.Amount, 64); err != nil || amount <= 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"path":"amount","message":"Amount should be more than 0"}]`))
+		return
+	}
+	f.seq++
+	inv := f.add(Invoice{
+		ID:             "inv_" + strconv.Itoa(f.seq),
+		Amount:         p.Amount,
+		Currency:       p.Currency,
+		Status:         STATUS_NEW,
+		ExpirationTime: time.Now().Add(15 * time.Minute).Unix(),
+	})
+	f.reply(w, inv.Invoice)
+}
+
+// add stores an invoice as the processor would have it
+func (f *fakeBTCPay) add(inv Invoice, methods ...PaymentMethod) *fakeInvoice {
+	if inv.CheckoutLink == "" {
+		inv.CheckoutLink = f.server.URL + "/i/" + inv.ID
+	}
+	if methods == nil {
+		methods = []PaymentMethod{{PaymentMethodID: "BTC-CHAIN", Rate: "50000.00", PaymentMethodPaid: "0"}}
+	}
+	fi := &fakeInvoice{Invoice: inv, methods: methods}
+	f.invoices[inv.ID] = fi
+	return fi
+}
+
+func (f *fakeBTCPay) getInvoice(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inv := f.invoice(w, r); inv != nil {
+		f.reply(w, inv.Invoice)
+	}
+}
+
+func (f *fakeBTCPay) getPaymentMethods(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inv := f.invoice(w, r); inv != nil {
+		f.reply(w, inv.methods)
+	}
+}
+
+func (f *fakeBTCPay) markStatus(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inv := f.invoice(w, r)
+	if inv == nil {
+		return
+	}
+	if inv.Status != STATUS_NEW && inv.Status != STATUS_EXPIRED {
+		f.fail(w, http.StatusBadRequest, "invoice-status-change-failed", "Invoice status cannot be changed")
+		return
+	}
+	var in struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	inv.Status = in.Status
+	f.reply(w, inv.Invoice)
+}
+
+func (f *fakeBTCPay) refund(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inv := f.invoice(w, r)
+	if inv == nil {
+		return
+	}
+	if inv.Status != STATUS_SETTLED {
+		f.fail(w, http.StatusBadRequest, "non-refundable", "Cannot refund this invoice")
+		return
+	}
+	f.refunds[inv.ID]++
+	id := fmt.Sprintf("pp_%s_%d", inv.ID, f.refunds[inv.ID])
+	f.reply(w, PullPayment{ID: id, Currency: "BTC", ViewLink: f.server.URL + "/pull-payments/" + id})
+}
+
+// refundCount returns the number of pull payments created for an invoice
+func (f *fakeBTCPay) refundCount(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refunds[id]
+}
+
+// lastRequest returns the latest request to the given method and path
+func (f *fakeBTCPay) lastRequest(method, path string) *recorded {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if r := f.requests[i]; r.method == method && r.path == path {
+			return r
+		}
+	}
+	f.t.Fatalf("no %s %s request", method, path)
+	return nil
+}
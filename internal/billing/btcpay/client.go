@@ -0,0 +1,99 @@
+package btcpay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a minimal BTCPay Server Greenfield API client for one store,
+// authenticating with an API key
+type Client struct {
+	apiBase string
+	apiKey  string
+	storeID string
+	http    *http.Client
+}
+
+// Error is an error response returned by the Greenfield API
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("btcpay: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// NewClient creates a client for a store of the BTCPay Server at apiBase
+func NewClient(apiBase, apiKey, storeID string) *Client {
+	return &Client{
+		apiBase: strings.TrimRight(apiBase, "/"),
+		apiKey:  apiKey,
+		storeID: storeID,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// storePath returns the API path of a store resource
+func (c *Client) storePath(format string, args ...any) string {
+	return "/api/v1/stores/" + url.PathEscape(c.storeID) + fmt.Sprintf(format, args...)
+}
+
+// call performs a JSON API request
+func (c *Client) call(ctx context.Context, method, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		e := Error{}
+		if json.Unmarshal(data, &e) != nil || e.Message == "" {
+			// Validation errors are returned as a list
+			var list []struct {
+				Path    string `json:"path"`
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(data, &list) == nil && len(list) > 0 {
+				e.Code, e.Message = "validation-error", list[0].Path+": "+list[0].Message
+			}
+		}
+		e.StatusCode = res.StatusCode
+		if e.Message == "" {
+			e.Message = http.StatusText(res.StatusCode)
+		}
+		return &e
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
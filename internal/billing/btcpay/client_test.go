@@ -0,0 +1,170 @@
+package btcpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+func TestCreateInvoice(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	p := NewProcessor(c, testWebhookSecret, "MediumSpeed", 30)
+
+	inv, err := p.CreateInvoice(context.Background(), crypto.InvoiceParams{
+		Amount:      1999,
+		Currency:    "eur",
+		OrderID:     "sub-1-1",
+		Description: "Pro monthly",
+		Email:       "ada@example.com",
+		RedirectURL: "https://example.com/billing/complete",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != schema.CRYPTO_INVOICE_STATUS_NEW || inv.Amount != 1999 || inv.Currency != "eur" || inv.CheckoutLink == "" {
+		t.Fatalf("created %+v", inv)
+	}
+
+	req := f.lastRequest(http.MethodPost, "/api/v1/stores/"+testStoreID+"/invoices")
+	if req.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Content-Type = %q", req.header.Get("Content-Type"))
+	}
+	if req.body["amount"] != "19.99" || req.body["currency"] != "EUR" {
+		t.Fatalf("amount %v %v", req.body["amount"], req.body["currency"])
+	}
+	metadata := req.body["metadata"].(map[string]any)
+	if metadata["orderId"] != "sub-1-1" || metadata["itemDesc"] != "Pro monthly" || metadata["buyerEmail"] != "ada@example.com" {
+		t.Fatalf("metadata %v", metadata)
+	}
+	checkout := req.body["checkout"].(map[string]any)
+	if checkout["speedPolicy"] != "MediumSpeed" || checkout["expirationMinutes"] != float64(30) || checkout["redirectURL"] != "https://example.com/billing/complete" {
+		t.Fatalf("checkout %v", checkout)
+	}
+}
+
+func TestCreateInvoiceZeroDecimal(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	if _, err := NewProcessor(c, testWebhookSecret, "", 0).CreateInvoice(context.Background(), crypto.InvoiceParams{Amount: 1500, Currency: "jpy"}); err != nil {
+		t.Fatal(err)
+	}
+	req := f.lastRequest(http.MethodPost, "/api/v1/stores/"+testStoreID+"/invoices")
+	if req.body["amount"] != "1500" {
+		t.Fatalf("amount %v", req.body["amount"])
+	}
+	if _, ok := req.body["checkout"].(map[string]any)["speedPolicy"]; ok {
+		t.Fatal("empty speed policy sent instead of the store's")
+	}
+}
+
+func TestGetInvoiceConvertsPayments(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	f.add(Invoice{ID: "inv_paid", Amount: "30.00", Currency: "EUR", Status: STATUS_PROCESSING, AdditionalStatus: ADDITIONAL_PAID_OVER},
+		PaymentMethod{PaymentMethodID: "BTC-CHAIN", Rate: "50000.00", PaymentMethodPaid: "0.0005"},
+		PaymentMethod{PaymentMethod: "BTC-LightningNetwork", Rate: "50000.00", PaymentMethodPaid: "0.0002"},
+	)
+
+	inv, err := NewProcessor(c, testWebhookSecret, "", 0).GetInvoice(context.Background(), "inv_paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != schema.CRYPTO_INVOICE_STATUS_PROCESSING || inv.Paid != 3500 || inv.Amount != 3000 || !inv.Overpaid {
+		t.Fatalf("invoice %+v", inv)
+	}
+}
+
+func TestInvalidateInvoice(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	f.add(Invoice{ID: "inv_new", Amount: "10.00", Currency: "EUR", Status: STATUS_NEW})
+	p := NewProcessor(c, testWebhookSecret, "", 0)
+
+	if err := p.InvalidateInvoice(context.Background(), "inv_new"); err != nil {
+		t.Fatal(err)
+	}
+	if f.invoices["inv_new"].Status != STATUS_INVALID {
+		t.Fatalf("status %s", f.invoices["inv_new"].Status)
+	}
+}
+
+func TestRefundOverpayment(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	f.add(Invoice{ID: "inv_over", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED, AdditionalStatus: ADDITIONAL_PAID_OVER},
+		PaymentMethod{PaymentMethodID: "LTC-CHAIN", Rate: "80.00", PaymentMethodPaid: "0"},
+		PaymentMethod{PaymentMethodID: "BTC-CHAIN", Rate: "50000.00", PaymentMethodPaid: "0.0005"},
+	)
+
+	link, err := NewProcessor(c, testWebhookSecret, "", 0).RefundOverpayment(context.Background(), "inv_over")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != f.server.URL+"/pull-payments/pp_inv_over_1" {
+		t.Fatalf("link %q", link)
+	}
+	req := f.lastRequest(http.MethodPost, "/api/v1/stores/"+testStoreID+"/invoices/inv_over/refund")
+	if req.body["paymentMethod"] != "BTC-CHAIN" || req.body["refundVariant"] != REFUND_OVERPAID {
+		t.Fatalf("refund params %v", req.body)
+	}
+}
+
+func TestRefundWithoutPayments(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	f.add(Invoice{ID: "inv_unpaid", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED, AdditionalStatus: ADDITIONAL_MARKED})
+
+	_, err := NewProcessor(c, testWebhookSecret, "", 0).RefundOverpayment(context.Background(), "inv_unpaid")
+	if !errors.Is(err, ErrNothingPaid) {
+		t.Fatalf("err = %v", err)
+	}
+	if f.refundCount("inv_unpaid") != 0 {
+		t.Fatal("pull payment created for an invoice without payments")
+	}
+}
+
+func TestCallDecodesErrors(t *testing.T) {
+	f, c := newFakeBTCPay(t)
+	ctx := context.Background()
+
+	_, err := c.GetInvoice(ctx, "inv_unknown")
+	var be *Error
+	if !errors.As(err, &be) || be.StatusCode != http.StatusNotFound || be.Code != "invoice-not-found" || be.Message != "The invoice was not found" {
+		t.Fatalf("err = %v", err)
+	}
+
+	_, err = NewClient(f.server.URL, "key_wrong", testStoreID).GetInvoice(ctx, "inv_unknown")
+	if !errors.As(err, &be) || be.StatusCode != http.StatusUnauthorized || be.Code != "unauthenticated" {
+		t.Fatalf("err = %v", err)
+	}
+
+	_, err = NewClient(f.server.URL, testAPIKey, "store_other").GetInvoice(ctx, "inv_unknown")
+	if !errors.As(err, &be) || be.Code != "store-not-found" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCallDecodesValidationErrors(t *testing.T) {
+	_, c := newFakeBTCPay(t)
+	_, err := c.CreateInvoice(context.Background(), InvoiceParams{Amount: "0", Currency: "EUR"})
+	var be *Error
+	if !errors.As(err, &be) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if be.StatusCode != http.StatusUnprocessableEntity || be.Code != "validation-error" || be.Message != "amount: Amount should be more than 0" {
+		t.Fatalf("decoded %+v", be)
+	}
+}
+
+func TestCallErrorWithoutBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, testAPIKey, testStoreID).GetInvoice(context.Background(), "inv_1")
+	var be *Error
+	if !errors.As(err, &be) || be.StatusCode != http.StatusServiceUnavailable || be.Message != http.StatusText(http.StatusServiceUnavailable) {
+		t.Fatalf("err = %v", err)
+	}
+}
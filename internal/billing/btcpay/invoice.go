@@ -0,0 +1,156 @@
+package btcpay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Invoice statuses
+const (
+	STATUS_NEW        = "New"        // Waiting for payment
+	STATUS_PROCESSING = "Processing" // Paid, waiting for confirmations
+	STATUS_SETTLED    = "Settled"    // Paid and confirmed
+	STATUS_EXPIRED    = "Expired"    // Not (fully) paid in time
+	STATUS_INVALID    = "Invalid"    // Payment failed to confirm, or marked invalid
+)
+
+// Additional invoice statuses
+const (
+	ADDITIONAL_PAID_PARTIAL = "PaidPartial"
+	ADDITIONAL_PAID_OVER    = "PaidOver"
+	ADDITIONAL_PAID_LATE    = "PaidLate"
+	ADDITIONAL_MARKED       = "Marked"
+)
+
+// Invoice is a Greenfield invoice
+type Invoice struct {
+	ID               string          `json:"id"`
+	Amount           string          `json:"amount"`
+	Currency         string          `json:"currency"`
+	Status           string          `json:"status"`
+	AdditionalStatus string          `json:"additionalStatus"`
+	CheckoutLink     string          `json:"checkoutLink"`
+	CreatedTime      int64           `json:"createdTime"`
+	ExpirationTime   int64           `json:"expirationTime"`
+	Metadata         json.RawMessage `json:"metadata"`
+}
+
+// InvoiceParams describe a new invoice
+type InvoiceParams struct {
+	Amount   string          `json:"amount"`
+	Currency string          `json:"currency"`
+	Metadata map[string]any  `json:"metadata,omitempty"`
+	Checkout InvoiceCheckout `json:"checkout"`
+}
+
+// InvoiceCheckout configures the checkout of an invoice
+type InvoiceCheckout struct {
+	SpeedPolicy       string `json:"speedPolicy,omitempty"`       // Confirmations needed: HighSpeed (0), MediumSpeed (1), LowMediumSpeed (2), LowSpeed (6)
+	ExpirationMinutes int    `json:"expirationMinutes,omitempty"` // How long the exchange rate quote is valid
+	MonitoringMinutes int    `json:"monitoringMinutes,omitempty"` // How long late payments are still detected
+	RedirectURL       string `json:"redirectURL,omitempty"`
+	DefaultLanguage   string `json:"defaultLanguage,omitempty"`
+}
+
+// PaymentMethod is the state of one payment method of an invoice. Amounts
+// are decimal strings in the method's cryptocurrency, Rate converts them to
+// the invoice currency.
+type PaymentMethod struct {
+	PaymentMethod     string    `json:"paymentMethod"`   // Greenfield 1.x
+	PaymentMethodID   string    `json:"paymentMethodId"` // Greenfield 2.x
+	Destination       string    `json:"destination"`
+	Rate              string    `json:"rate"`
+	PaymentMethodPaid string    `json:"paymentMethodPaid"`
+	TotalPaid         string    `json:"totalPaid"`
+	Due               string    `json:"due"`
+	Amount            string    `json:"amount"`
+	Payments          []Payment `json:"payments"`
+}
+
+// ID returns the payment method ID in either API version
+func (m *PaymentMethod) ID() string {
+	if m.PaymentMethodID != "" {
+		return m.PaymentMethodID
+	}
+	return m.PaymentMethod
+}
+
+// Payment is a single on-chain or lightning payment to an invoice
+type Payment struct {
+	ID           string `json:"id"`
+	ReceivedDate int64  `json:"receivedDate"`
+	Value        string `json:"value"`
+	Status       string `json:"status"` // Invalid, Processing or Settled
+}
+
+// PullPayment is a refund the customer claims by entering their address
+type PullPayment struct {
+	ID       string `json:"id"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+	ViewLink string `json:"viewLink"`
+}
+
+// Refund variants
+const (
+	REFUND_OVERPAID = "OverpaidAmount" // The amount paid above the invoice amount
+	REFUND_CUSTOM   = "Custom"         // CustomAmount in CustomCurrency
+)
+
+// RefundParams describe a refund of an invoice
+type RefundParams struct {
+	Name           string `json:"name,omitempty"`
+	Description    string `json:"description,omitempty"`
+	PaymentMethod  string `json:"paymentMethod"`
+	RefundVariant  string `json:"refundVariant"`
+	CustomAmount   string `json:"customAmount,omitempty"`
+	CustomCurrency string `json:"customCurrency,omitempty"`
+}
+
+// CreateInvoice creates an invoice
+func (c *Client) CreateInvoice(ctx context.Context, p InvoiceParams) (*Invoice, error) {
+	var out Invoice
+	if err := c.call(ctx, http.MethodPost, c.storePath("/invoices"), p, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetInvoice retrieves an invoice
+func (c *Client) GetInvoice(ctx context.Context, id string) (*Invoice, error) {
+	var out Invoice
+	if err := c.call(ctx, http.MethodGet, c.storePath("/invoices/%s", url.PathEscape(id)), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPaymentMethods retrieves the payment methods and payments of an invoice
+func (c *Client) GetPaymentMethods(ctx context.Context, invoiceID string) ([]PaymentMethod, error) {
+	var out []PaymentMethod
+	if err := c.call(ctx, http.MethodGet, c.storePath("/invoices/%s/payment-methods", url.PathEscape(invoiceID)), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MarkInvoiceStatus marks an invoice Settled or Invalid by hand
+func (c *Client) MarkInvoiceStatus(ctx context.Context, id, status string) (*Invoice, error) {
+	var out Invoice
+	in := map[string]string{"status": status}
+	if err := c.call(ctx, http.MethodPost, c.storePath("/invoices/%s/status", url.PathEscape(id)), in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RefundInvoice creates a pull payment refunding an invoice
+func (c *Client) RefundInvoice(ctx context.Context, id string, p RefundParams) (*PullPayment, error) {
+	var out PullPayment
+	if err := c.call(ctx, http.MethodPost, c.storePath("/invoices/%s/refund", url.PathEscape(id)), p, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
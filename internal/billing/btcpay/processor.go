@@ -0,0 +1,160 @@
+package btcpay
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// ErrNothingPaid is returned when refunding an invoice without payments
+var ErrNothingPaid = errors.New("btcpay: invoice has no payments to refund")
+
+// Processor implements crypto.Processor on top of the Greenfield API
+type Processor struct {
+	client            *Client
+	webhookSecret     string
+	speedPolicy       string
+	expirationMinutes int
+}
+
+// NewProcessor creates the BTCPay payment processor. speedPolicy sets the
+// confirmations after which a payment is settled, expirationMinutes how long
+// an invoice's exchange rate is guaranteed; empty values use the store's
+// settings.
+func NewProcessor(client *Client, webhookSecret, speedPolicy string, expirationMinutes int) *Processor {
+	return &Processor{
+		client:            client,
+		webhookSecret:     webhookSecret,
+		speedPolicy:       speedPolicy,
+		expirationMinutes: expirationMinutes,
+	}
+}
+
+func (p *Processor) CreateInvoice(ctx context.Context, params crypto.InvoiceParams) (*crypto.Invoice, error) {
+	metadata := map[string]any{"orderId": params.OrderID}
+	if params.Description != "" {
+		metadata["itemDesc"] = params.Description
+	}
+	if params.Email != "" {
+		metadata["buyerEmail"] = params.Email
+	}
+	inv, err := p.client.CreateInvoice(ctx, InvoiceParams{
+		Amount:   crypto.FormatAmount(params.Amount, params.Currency),
+		Currency: strings.ToUpper(params.Currency),
+		Metadata: metadata,
+		Checkout: InvoiceCheckout{
+			SpeedPolicy:       p.speedPolicy,
+			ExpirationMinutes: p.expirationMinutes,
+			RedirectURL:       params.RedirectURL,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return neutral(inv, nil), nil
+}
+
+// GetInvoice retrieves an invoice and converts what was paid with each
+// payment method to the invoice currency
+func (p *Processor) GetInvoice(ctx context.Context, id string) (*crypto.Invoice, error) {
+	inv, err := p.client.GetInvoice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := p.client.GetPaymentMethods(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return neutral(inv, methods), nil
+}
+
+func (p *Processor) InvalidateInvoice(ctx context.Context, id string) error {
+	_, err := p.client.MarkInvoiceStatus(ctx, id, STATUS_INVALID)
+	return err
+}
+
+// RefundOverpayment creates a pull payment for the overpaid amount in the
+// cryptocurrency the customer paid with
+func (p *Processor) RefundOverpayment(ctx context.Context, id string) (string, error) {
+	methods, err := p.client.GetPaymentMethods(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	method := ""
+	for _, m := range methods {
+		if paid, ok := new(big.Rat).SetString(m.PaymentMethodPaid); ok && paid.Sign() > 0 {
+			method = m.ID()
+			break
+		}
+	}
+	if method == "" {
+		return "", ErrNothingPaid
+	}
+	pp, err := p.client.RefundInvoice(ctx, id, RefundParams{
+		Name:          "Overpayment refund",
+		PaymentMethod: method,
+		RefundVariant: REFUND_OVERPAID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return pp.ViewLink, nil
+}
+
+func (p *Processor) ParseWebhook(payload []byte, header http.Header) (*crypto.Notification, error) {
+	e, err := ParseWebhook(payload, header.Get(SIGNATURE_HEADER), p.webhookSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &crypto.Notification{
+		ID:        e.EventID(),
+		Type:      e.Type,
+		InvoiceID: e.InvoiceID,
+		CreatedAt: time.Unix(e.Timestamp, 0).UTC(),
+	}, nil
+}
+
+// neutral maps a Greenfield invoice onto the neutral model. Payments are
+// valued at each method's rate, which is fixed for the lifetime of the
+// invoice.
+func neutral(inv *Invoice, methods []PaymentMethod) *crypto.Invoice {
+	out := &crypto.Invoice{
+		ID:           inv.ID,
+		Amount:       crypto.ParseAmount(inv.Amount, inv.Currency),
+		Currency:     strings.ToLower(inv.Currency),
+		Overpaid:     inv.AdditionalStatus == ADDITIONAL_PAID_OVER,
+		CheckoutLink: inv.CheckoutLink,
+		ExpiresAt:    time.Unix(inv.ExpirationTime, 0).UTC(),
+	}
+	switch inv.Status {
+	case STATUS_NEW:
+		out.Status = schema.CRYPTO_INVOICE_STATUS_NEW
+	case STATUS_PROCESSING:
+		out.Status = schema.CRYPTO_INVOICE_STATUS_PROCESSING
+	case STATUS_SETTLED:
+		out.Status = schema.CRYPTO_INVOICE_STATUS_SETTLED
+	case STATUS_EXPIRED:
+		out.Status = schema.CRYPTO_INVOICE_STATUS_EXPIRED
+	default:
+		out.Status = schema.CRYPTO_INVOICE_STATUS_INVALID
+	}
+
+	paid := new(big.Rat)
+	for _, m := range methods {
+		amount, ok1 := new(big.Rat).SetString(m.PaymentMethodPaid)
+		rate, ok2 := new(big.Rat).SetString(m.Rate)
+		if ok1 && ok2 {
+			paid.Add(paid, amount.Mul(amount, rate))
+		}
+	}
+	out.Paid = crypto.Minor(paid, inv.Currency)
+	return out
+}
+
+var _ crypto.Processor = (*Processor)(nil)
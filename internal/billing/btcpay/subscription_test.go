@@ -0,0 +1,258 @@
+package btcpay
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// The refresh tests keep crypto subscriptions in MongoDB. They run against
+// a throwaway database on the server at BRAIN_TEST_MONGODB_URI and are
+// skipped without one.
+func testDatabase(t *testing.T) {
+	uri := os.Getenv("BRAIN_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("BRAIN_TEST_MONGODB_URI not set")
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	database.Client = client
+	database.DB = client.Database("brain_test_" + bson.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.DB.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+}
+
+// refreshFixture is an active subscription whose first period is paid, with
+// an invoice for the second period at the fake BTCPay
+type refreshFixture struct {
+	f       *fakeBTCPay
+	gateway *crypto.Gateway
+	user    bson.ObjectID
+	sub     *schema.CryptoSubscription
+}
+
+func newRefreshFixture(t *testing.T, period int, invoices ...schema.CryptoInvoice) *refreshFixture {
+	testDatabase(t)
+	f, c := newFakeBTCPay(t)
+	ctx := context.Background()
+
+	price := func(ctx context.Context, userID bson.ObjectID, priceID, country string) (*crypto.Price, error) {
+		return &crypto.Price{Amount: 2000, Currency: "eur", Interval: schema.PLAN_INTERVAL_MONTH, Description: "Pro"}, nil
+	}
+	r := &refreshFixture{
+		f:       f,
+		gateway: crypto.NewGateway(NewProcessor(c, testWebhookSecret, "", 0), price, 3, 7),
+		user:    bson.NewObjectID(),
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := database.Collection(schema.COLLECTION_USERS).InsertOne(ctx, schema.User{
+		ID:          r.user,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Email:       "ada@example.com",
+		DisplayName: "Ada",
+		Status:      schema.USER_STATUS_ACTIVE,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	start, end := now.AddDate(0, -1, 2), now.AddDate(0, 0, 2)
+	r.sub = &schema.CryptoSubscription{
+		ID:                 bson.NewObjectID(),
+		CreatedAt:          start,
+		UpdatedAt:          start,
+		UserID:             r.user,
+		CustomerID:         "crypto-" + r.user.Hex(),
+		PriceID:            "price_pro",
+		Status:             schema.SUBSCRIPTION_STATUS_ACTIVE,
+		Period:             period,
+		CurrentPeriodStart: &start,
+		CurrentPeriodEnd:   &end,
+		Invoices:           invoices,
+	}
+	if _, err := database.Collection(schema.COLLECTION_CRYPTO_SUBSCRIPTIONS).InsertOne(ctx, r.sub); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// invoice returns a local invoice as created by the gateway
+func invoice(id string, period int, status schema.CRYPTO_INVOICE_STATUS, expiresIn time.Duration) schema.CryptoInvoice {
+	return schema.CryptoInvoice{
+		ExternalID: id,
+		Period:     period,
+		PriceID:    "price_pro",
+		Interval:   schema.PLAN_INTERVAL_MONTH,
+		Amount:     2000,
+		Currency:   "eur",
+		Status:     status,
+		ExpiresAt:  time.Now().Add(expiresIn).UTC(),
+	}
+}
+
+// paidWith is the BTC payment method of an invoice that received amount
+func paidWith(amount string) PaymentMethod {
+	return PaymentMethod{PaymentMethodID: "BTC-CHAIN", Rate: "50000.00", PaymentMethodPaid: amount}
+}
+
+func (r *refreshFixture) refresh(t *testing.T) *schema.CryptoSubscription {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := r.gateway.GetSubscription(ctx, r.sub.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	var s schema.CryptoSubscription
+	if err := database.Collection(schema.COLLECTION_CRYPTO_SUBSCRIPTIONS).FindOne(ctx, bson.M{"_id": r.sub.ID}).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+// emails counts the emails of a type recorded for the user, sent or not
+func (r *refreshFixture) emails(t *testing.T, emailType schema.EmailEventType) int64 {
+	t.Helper()
+	n, err := database.Collection(schema.COLLECTION_EMAIL_HISTORY).CountDocuments(context.Background(), bson.M{"user_id": r.user, "email_type": emailType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRefreshExpiredPartialPayment(t *testing.T) {
+	r := newRefreshFixture(t, 1, invoice("inv_partial", 2, schema.CRYPTO_INVOICE_STATUS_NEW, -time.Hour))
+	r.f.add(Invoice{ID: "inv_partial", Amount: "20.00", Currency: "EUR", Status: STATUS_EXPIRED, AdditionalStatus: ADDITIONAL_PAID_PARTIAL},
+		paidWith("0.00015"))
+
+	s := r.refresh(t)
+	inv := s.Invoices[0]
+	if inv.Status != schema.CRYPTO_INVOICE_STATUS_EXPIRED || inv.Paid != 750 || !inv.Notified {
+		t.Fatalf("invoice %+v", inv)
+	}
+	if s.Period != 1 || s.Status != schema.SUBSCRIPTION_STATUS_ACTIVE {
+		t.Fatalf("partial payment changed the subscription: %+v", s)
+	}
+	if n := r.emails(t, schema.EMAIL_EVENT_CRYPTO_PAYMENT); n != 1 {
+		t.Fatalf("%d partial payment notices, want 1", n)
+	}
+
+	// The notice is sent once
+	r.refresh(t)
+	if n := r.emails(t, schema.EMAIL_EVENT_CRYPTO_PAYMENT); n != 1 {
+		t.Fatalf("%d partial payment notices after another refresh, want 1", n)
+	}
+}
+
+func TestRefreshOverpaidSettledInvoice(t *testing.T) {
+	r := newRefreshFixture(t, 1, invoice("inv_over", 2, schema.CRYPTO_INVOICE_STATUS_NEW, 10*time.Minute))
+	r.f.add(Invoice{ID: "inv_over", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED, AdditionalStatus: ADDITIONAL_PAID_OVER},
+		paidWith("0.0005"))
+	periodEnd := *r.sub.CurrentPeriodEnd
+
+	s := r.refresh(t)
+	if s.Period != 2 || !s.CurrentPeriodStart.Equal(periodEnd) {
+		t.Fatalf("renewal not applied: %+v", s)
+	}
+	inv := s.Invoices[0]
+	if inv.Status != schema.CRYPTO_INVOICE_STATUS_SETTLED || !inv.Overpaid || inv.Paid != 2500 || inv.Unapplied {
+		t.Fatalf("invoice %+v", inv)
+	}
+	if inv.Refund != schema.CRYPTO_REFUND_CREATED || inv.RefundLink != r.f.server.URL+"/pull-payments/pp_inv_over_1" {
+		t.Fatalf("refund %q %q", inv.Refund, inv.RefundLink)
+	}
+
+	// Further refreshes, as from the renewal job and webhooks, do not refund again
+	r.refresh(t)
+	r.refresh(t)
+	if n := r.f.refundCount("inv_over"); n != 1 {
+		t.Fatalf("%d pull payments, want 1", n)
+	}
+	if n := r.emails(t, schema.EMAIL_EVENT_CRYPTO_REFUND); n != 1 {
+		t.Fatalf("%d refund emails, want 1", n)
+	}
+}
+
+func TestRefreshRetriesFailedRefund(t *testing.T) {
+	r := newRefreshFixture(t, 1, invoice("inv_over", 2, schema.CRYPTO_INVOICE_STATUS_NEW, 10*time.Minute))
+	// BTCPay reports the overpayment before the payment methods show it
+	r.f.add(Invoice{ID: "inv_over", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED, AdditionalStatus: ADDITIONAL_PAID_OVER},
+		paidWith("0"))
+
+	if inv := r.refresh(t).Invoices[0]; inv.Refund != "" {
+		t.Fatalf("failed refund kept as %q", inv.Refund)
+	}
+	r.f.invoices["inv_over"].methods = []PaymentMethod{paidWith("0.0005")}
+	if inv := r.refresh(t).Invoices[0]; inv.Refund != schema.CRYPTO_REFUND_CREATED {
+		t.Fatalf("refund %q after retry", inv.Refund)
+	}
+	if n := r.f.refundCount("inv_over"); n != 1 {
+		t.Fatalf("%d pull payments, want 1", n)
+	}
+}
+
+func TestRefreshPaymentForPaidPeriod(t *testing.T) {
+	paidAt := time.Now().Add(-time.Hour).UTC()
+	first := invoice("inv_first", 2, schema.CRYPTO_INVOICE_STATUS_SETTLED, -30*time.Minute)
+	first.Paid, first.SettledAt = 2000, &paidAt
+	// The customer paid a second checkout for the same period
+	second := invoice("inv_second", 2, schema.CRYPTO_INVOICE_STATUS_PROCESSING, -20*time.Minute)
+	r := newRefreshFixture(t, 2, first, second)
+	r.f.add(Invoice{ID: "inv_second", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED}, paidWith("0.0004"))
+
+	// The webhook is not reported to billing as a payment
+	payload := []byte(`{"deliveryId":"d1","type":"InvoiceSettled","timestamp":1718000000,"invoiceId":"inv_second"}`)
+	header := http.Header{}
+	header.Set(SIGNATURE_HEADER, sign(payload, testWebhookSecret))
+	e, err := r.gateway.ParseWebhook(context.Background(), payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SubscriptionID != r.sub.ID.Hex() || e.Paid {
+		t.Fatalf("event %+v", e)
+	}
+
+	s := r.refresh(t)
+	inv := s.Invoices[1]
+	if inv.Status != schema.CRYPTO_INVOICE_STATUS_SETTLED || !inv.Unapplied || inv.Paid != 2000 {
+		t.Fatalf("invoice %+v", inv)
+	}
+	if s.Period != 2 || !s.CurrentPeriodEnd.Equal(*r.sub.CurrentPeriodEnd) {
+		t.Fatalf("second payment extended the subscription: %+v", s)
+	}
+	if r.f.refundCount("inv_second") != 0 {
+		t.Fatal("payment for a paid period refunded automatically")
+	}
+}
+
+func TestParseWebhookReportsPayment(t *testing.T) {
+	r := newRefreshFixture(t, 1, invoice("inv_renewal", 2, schema.CRYPTO_INVOICE_STATUS_NEW, 10*time.Minute))
+	r.f.add(Invoice{ID: "inv_renewal", Amount: "20.00", Currency: "EUR", Status: STATUS_SETTLED}, paidWith("0.0004"))
+
+	payload := []byte(`{"deliveryId":"d1","type":"InvoiceSettled","timestamp":1718000000,"invoiceId":"inv_renewal"}`)
+	header := http.Header{}
+	header.Set(SIGNATURE_HEADER, sign(payload, testWebhookSecret))
+	e, err := r.gateway.ParseWebhook(context.Background(), payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Paid || e.PaymentID != "inv_renewal" || e.Amount != 2000 || e.Currency != "eur" {
+		t.Fatalf("event %+v", e)
+	}
+}
@@ -0,0 +1,74 @@
+package btcpay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+)
+
+// SIGNATURE_HEADER carries the HMAC-SHA256 of the body, "sha256=<hex>"
+const SIGNATURE_HEADER = "BTCPay-Sig"
+
+// Webhook event types
+const (
+	EVENT_INVOICE_CREATED          = "InvoiceCreated"
+	EVENT_INVOICE_RECEIVED_PAYMENT = "InvoiceReceivedPayment"
+	EVENT_INVOICE_PAYMENT_SETTLED  = "InvoicePaymentSettled"
+	EVENT_INVOICE_PROCESSING       = "InvoiceProcessing"
+	EVENT_INVOICE_EXPIRED          = "InvoiceExpired"
+	EVENT_INVOICE_SETTLED          = "InvoiceSettled"
+	EVENT_INVOICE_INVALID          = "InvoiceInvalid"
+)
+
+var ErrInvalidSignature = fmt.Errorf("btcpay: %w", gateway.ErrInvalidSignature)
+
+// WebhookEvent is a webhook delivery
+type WebhookEvent struct {
+	DeliveryID         string `json:"deliveryId"`
+	WebhookID          string `json:"webhookId"`
+	OriginalDeliveryID string `json:"originalDeliveryId"`
+	IsRedelivery       bool   `json:"isRedelivery"`
+	Type               string `json:"type"`
+	Timestamp          int64  `json:"timestamp"`
+	StoreID            string `json:"storeId"`
+	InvoiceID          string `json:"invoiceId"`
+	PartiallyPaid      bool   `json:"partiallyPaid"` // InvoiceExpired
+	OverPaid           bool   `json:"overPaid"`      // InvoiceProcessing, InvoiceSettled
+	AfterExpiration    bool   `json:"afterExpiration"`
+}
+
+// EventID identifies the event across redeliveries
+func (e *WebhookEvent) EventID() string {
+	if e.OriginalDeliveryID != "" {
+		return e.OriginalDeliveryID
+	}
+	return e.DeliveryID
+}
+
+// ParseWebhook verifies the signature of a delivery and decodes it
+func ParseWebhook(payload []byte, signature, secret string) (*WebhookEvent, error) {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var e WebhookEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
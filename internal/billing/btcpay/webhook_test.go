@@ -0,0 +1,88 @@
+package btcpay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+)
+
+// sign builds the BTCPay-Sig header of a payload
+func sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhook(t *testing.T) {
+	payload := []byte(`{"deliveryId":"d2","originalDeliveryId":"d1","isRedelivery":true,"type":"InvoiceExpired","timestamp":1718000000,"storeId":"store_1","invoiceId":"inv_1","partiallyPaid":true}`)
+	valid := sign(payload, testWebhookSecret)
+
+	tests := []struct {
+		name      string
+		signature string
+		ok        bool
+	}{
+		{"valid", valid, true},
+		{"wrong secret", sign(payload, "whsec_other"), false},
+		{"missing prefix", valid[len("sha256="):], false},
+		{"other algorithm", "sha1=" + valid[len("sha256="):], false},
+		{"not hex", "sha256=zz", false},
+		{"truncated", valid[:len(valid)-2], false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseWebhook(payload, tt.signature, testWebhookSecret)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidSignature) || !errors.Is(err, gateway.ErrInvalidSignature) {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Type != EVENT_INVOICE_EXPIRED || e.InvoiceID != "inv_1" || !e.PartiallyPaid {
+				t.Fatalf("event %+v", e)
+			}
+			// Redeliveries keep the ID of the original delivery
+			if e.EventID() != "d1" {
+				t.Fatalf("event ID %q", e.EventID())
+			}
+		})
+	}
+}
+
+func TestParseWebhookTamperedPayload(t *testing.T) {
+	signature := sign([]byte(`{"deliveryId":"d1","type":"InvoiceSettled","invoiceId":"inv_1"}`), testWebhookSecret)
+	_, err := ParseWebhook([]byte(`{"deliveryId":"d1","type":"InvoiceSettled","invoiceId":"inv_2"}`), signature, testWebhookSecret)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestProcessorParseWebhook(t *testing.T) {
+	_, c := newFakeBTCPay(t)
+	p := NewProcessor(c, testWebhookSecret, "", 0)
+	payload := []byte(`{"deliveryId":"d1","type":"InvoiceSettled","timestamp":1718000000,"invoiceId":"inv_1","overPaid":true}`)
+
+	header := http.Header{}
+	header.Set(SIGNATURE_HEADER, sign(payload, testWebhookSecret))
+	n, err := p.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != "d1" || n.Type != EVENT_INVOICE_SETTLED || n.InvoiceID != "inv_1" || !n.CreatedAt.Equal(time.Unix(1718000000, 0)) {
+		t.Fatalf("notification %+v", n)
+	}
+
+	if _, err := p.ParseWebhook(payload, http.Header{}); !errors.Is(err, gateway.ErrInvalidSignature) {
+		t.Fatalf("unsigned delivery: %v", err)
+	}
+}
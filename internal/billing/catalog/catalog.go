@@ -0,0 +1,221 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrUnknownPlan  = errors.New("no plan for account type")
+	ErrUnknownPrice = errors.New("plan has no price for this currency and interval")
+)
+
+// UnknownAccountType is an AccountType held by users without a catalog plan
+type UnknownAccountType struct {
+	AccountType string `bson:"_id" json:"account_type"`
+	Users       int64  `bson:"users" json:"users"`
+}
+
+func plans() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_PLANS)
+}
+
+// Seed stores the plans of the config. A plan whose definition changed gets
+// a new version, older versions stay so existing subscribers keep their
+// prices. Plans removed from the config stop being available.
+func Seed(ctx context.Context) error {
+	cfg := config.GetBillingConfig()
+	seeded := make([]string, 0, len(cfg.Plans))
+	for _, pc := range cfg.Plans {
+		if err := seedPlan(ctx, pc); err != nil {
+			return err
+		}
+		seeded = append(seeded, pc.AccountType)
+	}
+
+	_, err := plans().UpdateMany(ctx,
+		bson.M{"current": true, "available": true, "account_type": bson.M{"$nin": seeded}},
+		bson.M{"$set": bson.M{"available": false, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func seedPlan(ctx context.Context, pc config.PlanConfig) error {
+	p := fromConfig(pc)
+	latest, err := Current(ctx, pc.AccountType)
+	if err != nil && !errors.Is(err, ErrUnknownPlan) {
+		return err
+	}
+	now := time.Now().UTC()
+
+	if latest != nil && latest.Fingerprint == p.Fingerprint {
+		if latest.Available {
+			return nil
+		}
+		_, err := plans().UpdateByID(ctx, latest.ID, bson.M{"$set": bson.M{"available": true, "updated_at": now}})
+		return err
+	}
+
+	p.ID = bson.NewObjectID()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	p.Version = 1
+	if latest != nil {
+		p.Version = latest.Version + 1
+	}
+	if _, err := plans().InsertOne(ctx, p); err != nil {
+		// Another instance seeded the same version concurrently
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+	if latest != nil {
+		if _, err := plans().UpdateByID(ctx, latest.ID, bson.M{"$set": bson.M{
+			"current":    false,
+			"available":  false,
+			"retired_at": now,
+			"updated_at": now,
+		}}); err != nil {
+			return err
+		}
+	}
+	log.Info().Str("account_type", p.AccountType).Int("version", p.Version).Msg("Seeded plan version")
+	return nil
+}
+
+// fromConfig builds a plan from its config. The fingerprint covers every
+// field that affects subscribers.
+func fromConfig(pc config.PlanConfig) *schema.Plan {
+	p := &schema.Plan{
+		AccountType: pc.AccountType,
+		Current:     true,
+		Available:   true,
+		Name:        pc.Name,
+		TrialDays:   pc.TrialDays,
+		Prices:      []schema.PlanPrice{},
+		Features:    append([]string{}, pc.Features...),
+		Quotas:      map[string]int64{},
+	}
+	for _, pr := range pc.Prices {
+		p.Prices = append(p.Prices, schema.PlanPrice{
+			Currency: pr.Currency,
+			Interval: schema.PLAN_INTERVAL(pr.Interval),
+			Amount:   pr.Amount,
+			Gateway:  pr.Gateway,
+			PriceID:  pr.PriceID,
+		})
+	}
+	for k, v := range pc.Quotas {
+		p.Quotas[k] = v
+	}
+
+	// encoding/json sorts map keys, so equal definitions hash equally
+	data, _ := json.Marshal(struct {
+		Name      string
+		TrialDays int
+		Prices    []schema.PlanPrice
+		Features  []string
+		Quotas    map[string]int64
+	}{p.Name, p.TrialDays, p.Prices, p.Features, p.Quotas})
+	sum := sha256.Sum256(data)
+	p.Fingerprint = hex.EncodeToString(sum[:])
+	return p
+}
+
+// Current returns the latest version of the plan granting an account type
+func Current(ctx context.Context, accountType string) (*schema.Plan, error) {
+	var p schema.Plan
+	err := plans().FindOne(ctx,
+		bson.M{"account_type": accountType},
+		options.FindOne().SetSort(bson.M{"version": -1}),
+	).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Version returns a specific version of a plan
+func Version(ctx context.Context, accountType string, version int) (*schema.Plan, error) {
+	var p schema.Plan
+	err := plans().FindOne(ctx, bson.M{"account_type": accountType, "version": version}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Available returns the current version of every plan offered to new subscribers
+func Available(ctx context.Context) ([]schema.Plan, error) {
+	cur, err := plans().Find(ctx,
+		bson.M{"current": true, "available": true},
+		options.Find().SetSort(bson.M{"account_type": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := []schema.Plan{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FindByPrice returns the newest plan version charging a gateway price, and
+// that price
+func FindByPrice(ctx context.Context, gatewayName, priceID string) (*schema.Plan, *schema.PlanPrice, error) {
+	var p schema.Plan
+	err := plans().FindOne(ctx,
+		bson.M{"prices": bson.M{"$elemMatch": bson.M{"gateway": gatewayName, "price_id": priceID}}},
+		options.FindOne().SetSort(bson.M{"version": -1}),
+	).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, ErrUnknownPlan
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &p, p.Price(gatewayName, priceID), nil
+}
+
+// ValidateAccountTypes returns the AccountType values held by users that no
+// plan version grants
+func ValidateAccountTypes(ctx context.Context) ([]UnknownAccountType, error) {
+	var knownTypes []string
+	if err := plans().Distinct(ctx, "account_type", bson.M{}).Decode(&knownTypes); err != nil {
+		return nil, err
+	}
+
+	cur, err := database.Collection(schema.COLLECTION_USERS).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"account_type": bson.M{"$nin": knownTypes}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$account_type", "users": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := []UnknownAccountType{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,400 @@
+package billing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/stripe"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrInvalidPromotionCode = errors.New("promotion code is invalid or expired")
+	ErrPromotionCodeUsedUp  = errors.New("promotion code has reached its redemption limit")
+	ErrCouponNotApplicable  = errors.New("promotion code does not apply to this price")
+	ErrFirstPurchaseOnly    = errors.New("promotion code is only valid for a first subscription")
+	ErrCouponRedeemed       = errors.New("coupon was already redeemed")
+	ErrPromotionCodeChange  = errors.New("promotion codes can only be redeemed when subscribing")
+)
+
+func coupons() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_COUPONS)
+}
+
+func promotionCodes() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_PROMOTION_CODES)
+}
+
+func redemptions() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_COUPON_REDEMPTIONS)
+}
+
+// validateCoupons checks what the config validator cannot: promotion codes
+// are unique across coupons and restrictions name existing plans
+func validateCoupons(cfg *config.BillingConfig) error {
+	codes := map[string]string{}
+	for _, c := range cfg.Coupons {
+		for _, at := range c.AccountTypes {
+			if !slices.ContainsFunc(cfg.Plans, func(p config.PlanConfig) bool { return p.AccountType == at }) {
+				return fmt.Errorf("coupon %s applies to account type %s, which has no plan", c.ID, at)
+			}
+		}
+		for _, pc := range c.PromotionCodes {
+			if other, ok := codes[pc.Code]; ok {
+				return fmt.Errorf("promotion code %s is used by coupons %s and %s", pc.Code, other, c.ID)
+			}
+			codes[pc.Code] = c.ID
+		}
+	}
+	return nil
+}
+
+// seedCoupons stores the coupons and promotion codes of the config and syncs
+// their terms to Stripe. Coupons and codes removed from the config can no
+// longer be redeemed, but discounts already applied stay.
+func seedCoupons(ctx context.Context, sc *stripe.Client) error {
+	cfg := config.GetBillingConfig()
+	keys := make([]string, 0, len(cfg.Coupons))
+	codes := []string{}
+	for _, cc := range cfg.Coupons {
+		if err := seedCoupon(ctx, sc, cc); err != nil {
+			return err
+		}
+		keys = append(keys, cc.ID)
+		for _, pc := range cc.PromotionCodes {
+			codes = append(codes, pc.Code)
+		}
+	}
+
+	now := time.Now().UTC()
+	if _, err := coupons().UpdateMany(ctx,
+		bson.M{"active": true, "key": bson.M{"$nin": keys}},
+		bson.M{"$set": bson.M{"active": false, "updated_at": now}},
+	); err != nil {
+		return err
+	}
+	_, err := promotionCodes().UpdateMany(ctx,
+		bson.M{"active": true, "code": bson.M{"$nin": codes}},
+		bson.M{"$set": bson.M{"active": false, "updated_at": now}},
+	)
+	return err
+}
+
+func seedCoupon(ctx context.Context, sc *stripe.Client, cc config.CouponConfig) error {
+	now := time.Now().UTC()
+	c := couponFromConfig(cc)
+	var stored schema.Coupon
+	err := coupons().FindOneAndUpdate(ctx,
+		bson.M{"key": cc.ID},
+		bson.M{
+			"$set": bson.M{
+				"name":                c.Name,
+				"percent_off":         c.PercentOff,
+				"amount_off":          c.AmountOff,
+				"currency":            c.Currency,
+				"duration":            c.Duration,
+				"duration_months":     c.DurationMonths,
+				"max_redemptions":     c.MaxRedemptions,
+				"expires_at":          c.ExpiresAt,
+				"account_types":       c.AccountTypes,
+				"first_purchase_only": c.FirstPurchaseOnly,
+				"active":              true,
+				"fingerprint":         c.Fingerprint,
+				"updated_at":          now,
+			},
+			"$setOnInsert": bson.M{"created_at": now, "redemptions": 0},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	// Another instance inserted the coupon concurrently, update that one
+	if mongo.IsDuplicateKeyError(err) {
+		return seedCoupon(ctx, sc, cc)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Stripe coupons are immutable, so every version of the terms gets its own
+	stripeID := cc.ID + "-" + c.Fingerprint[:8]
+	if stored.StripeCouponID != stripeID {
+		_, err := sc.CreateCoupon(ctx, stripe.CouponParams{
+			ID:               stripeID,
+			Name:             c.Name,
+			PercentOff:       c.PercentOff,
+			AmountOff:        c.AmountOff,
+			Currency:         c.Currency,
+			Duration:         string(c.Duration),
+			DurationInMonths: c.DurationMonths,
+		}, "coupon-"+stripeID)
+		var se *stripe.Error
+		if errors.As(err, &se) && se.StatusCode == http.StatusBadRequest && se.Code == "resource_already_exists" {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("syncing coupon %s to Stripe: %w", cc.ID, err)
+		}
+		if _, err := coupons().UpdateByID(ctx, stored.ID, bson.M{"$set": bson.M{"stripe_coupon_id": stripeID}}); err != nil {
+			return err
+		}
+		log.Info().Str("coupon", cc.ID).Str("stripe_coupon", stripeID).Msg("Synced coupon to Stripe")
+	}
+
+	for _, pc := range cc.PromotionCodes {
+		if err := seedPromotionCode(ctx, cc.ID, pc, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedPromotionCode(ctx context.Context, couponKey string, pc config.PromotionCodeConfig, now time.Time) error {
+	_, err := promotionCodes().UpdateOne(ctx,
+		bson.M{"code": pc.Code},
+		bson.M{
+			"$set": bson.M{
+				"coupon_key":          couponKey,
+				"max_redemptions":     pc.MaxRedemptions,
+				"expires_at":          optionalTime(pc.ExpiresAt),
+				"first_purchase_only": pc.FirstPurchaseOnly,
+				"active":              true,
+				"updated_at":          now,
+			},
+			"$setOnInsert": bson.M{"created_at": now, "redemptions": 0},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return seedPromotionCode(ctx, couponKey, pc, now)
+	}
+	return err
+}
+
+// couponFromConfig builds a coupon from its config. The fingerprint covers
+// the discount terms, which a gateway cannot change on an existing coupon.
+func couponFromConfig(cc config.CouponConfig) *schema.Coupon {
+	c := &schema.Coupon{
+		Key:               cc.ID,
+		Name:              cc.Name,
+		PercentOff:        cc.PercentOff,
+		AmountOff:         cc.AmountOff,
+		Currency:          cc.Currency,
+		Duration:          schema.COUPON_DURATION(cc.Duration),
+		DurationMonths:    cc.DurationMonths,
+		MaxRedemptions:    cc.MaxRedemptions,
+		ExpiresAt:         optionalTime(cc.ExpiresAt),
+		AccountTypes:      append([]string{}, cc.AccountTypes...),
+		FirstPurchaseOnly: cc.FirstPurchaseOnly,
+		Active:            true,
+	}
+	data, _ := json.Marshal(struct {
+		PercentOff     int
+		AmountOff      int64
+		Currency       string
+		Duration       schema.COUPON_DURATION
+		DurationMonths int
+	}{c.PercentOff, c.AmountOff, c.Currency, c.Duration, c.DurationMonths})
+	sum := sha256.Sum256(data)
+	c.Fingerprint = hex.EncodeToString(sum[:])
+	return c
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// promotion is a promotion code that can be redeemed, with its coupon
+type promotion struct {
+	code   *schema.PromotionCode
+	coupon *schema.Coupon
+}
+
+// discount returns an amount after the coupon's discount. Percentages are
+// rounded to the nearest minor unit.
+func (p *promotion) discount(amount int64) int64 {
+	if p.coupon.PercentOff > 0 {
+		return amount - (amount*int64(p.coupon.PercentOff)+50)/100
+	}
+	return max(amount-p.coupon.AmountOff, 0)
+}
+
+// gatewayCoupon returns the coupon ID at a gateway. Only Stripe has coupons,
+// the other gateways reject subscriptions with one.
+func (p *promotion) gatewayCoupon(gatewayName string) string {
+	if gatewayName == gateway.STRIPE {
+		return p.coupon.StripeCouponID
+	}
+	return p.coupon.Key
+}
+
+// findPromotion looks up a promotion code and checks that the user can
+// redeem it for a plan price. Redemption limits are checked when the code
+// is reserved.
+func findPromotion(ctx context.Context, userID bson.ObjectID, code string, plan *schema.Plan, price *schema.PlanPrice) (*promotion, error) {
+	now := time.Now().UTC()
+	p := promotion{code: &schema.PromotionCode{}, coupon: &schema.Coupon{}}
+	err := promotionCodes().FindOne(ctx, bson.M{"code": strings.ToUpper(strings.TrimSpace(code)), "active": true}).Decode(p.code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidPromotionCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = coupons().FindOne(ctx, bson.M{"key": p.code.CouponKey, "active": true}).Decode(p.coupon)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidPromotionCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if (p.code.ExpiresAt != nil && now.After(*p.code.ExpiresAt)) || (p.coupon.ExpiresAt != nil && now.After(*p.coupon.ExpiresAt)) {
+		return nil, ErrInvalidPromotionCode
+	}
+
+	if len(p.coupon.AccountTypes) > 0 && !slices.Contains(p.coupon.AccountTypes, plan.AccountType) {
+		return nil, ErrCouponNotApplicable
+	}
+	if p.coupon.AmountOff > 0 && p.coupon.Currency != price.Currency {
+		return nil, ErrCouponNotApplicable
+	}
+	if p.coupon.FirstPurchaseOnly || p.code.FirstPurchaseOnly {
+		n, err := subscriptions().CountDocuments(ctx, bson.M{
+			"user_id": userID,
+			"status":  bson.M{"$ne": schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, ErrFirstPurchaseOnly
+		}
+	}
+	return &p, nil
+}
+
+// underLimit matches coupons and promotion codes with redemptions left
+var underLimit = bson.M{"$expr": bson.M{"$or": bson.A{
+	bson.M{"$eq": bson.A{"$max_redemptions", 0}},
+	bson.M{"$lt": bson.A{"$redemptions", "$max_redemptions"}},
+}}}
+
+// reserveRedemption counts a redemption against the limits of the coupon
+// and the code before the subscription is created. A reservation left by
+// an interrupted checkout of the same user is reused.
+func reserveRedemption(ctx context.Context, userID bson.ObjectID, p *promotion, gatewayName string, meta history.Meta) (*schema.CouponRedemption, error) {
+	now := time.Now().UTC()
+	r := &schema.CouponRedemption{
+		ID:        bson.NewObjectID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    userID,
+		CouponKey: p.coupon.Key,
+		Code:      p.code.Code,
+		Gateway:   gatewayName,
+		Status:    schema.REDEMPTION_STATUS_RESERVED,
+		IPAddress: meta.IPAddress,
+	}
+	if _, err := redemptions().InsertOne(ctx, r); mongo.IsDuplicateKeyError(err) {
+		var existing schema.CouponRedemption
+		if err := redemptions().FindOne(ctx, bson.M{"user_id": userID, "coupon_key": p.coupon.Key}).Decode(&existing); err != nil {
+			return nil, err
+		}
+		if existing.Status != schema.REDEMPTION_STATUS_RESERVED || existing.Code != p.code.Code {
+			return nil, ErrCouponRedeemed
+		}
+		return &existing, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	inc := bson.M{"$inc": bson.M{"redemptions": 1}, "$set": bson.M{"updated_at": now}}
+	res, err := coupons().UpdateOne(ctx, bson.M{"_id": p.coupon.ID, "$and": bson.A{underLimit}}, inc)
+	if err != nil || res.ModifiedCount == 0 {
+		_, _ = redemptions().DeleteOne(ctx, bson.M{"_id": r.ID})
+		if err == nil {
+			err = ErrPromotionCodeUsedUp
+		}
+		return nil, err
+	}
+	res, err = promotionCodes().UpdateOne(ctx, bson.M{"_id": p.code.ID, "$and": bson.A{underLimit}}, inc)
+	if err != nil || res.ModifiedCount == 0 {
+		_, _ = coupons().UpdateOne(ctx, bson.M{"_id": p.coupon.ID}, bson.M{"$inc": bson.M{"redemptions": -1}})
+		_, _ = redemptions().DeleteOne(ctx, bson.M{"_id": r.ID})
+		if err == nil {
+			err = ErrPromotionCodeUsedUp
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// releaseRedemption gives back a reservation whose subscription could not
+// be created
+func releaseRedemption(ctx context.Context, r *schema.CouponRedemption) {
+	res, err := redemptions().DeleteOne(ctx, bson.M{"_id": r.ID, "status": schema.REDEMPTION_STATUS_RESERVED})
+	if err == nil && res.DeletedCount == 1 {
+		dec := bson.M{"$inc": bson.M{"redemptions": -1}}
+		if _, err = coupons().UpdateOne(ctx, bson.M{"key": r.CouponKey}, dec); err == nil {
+			_, err = promotionCodes().UpdateOne(ctx, bson.M{"code": r.Code}, dec)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("redemption", r.ID.Hex()).Msg("Error releasing coupon redemption")
+	}
+}
+
+// applyRedemption links a reservation to the subscription it discounts and
+// records it in AccountHistory
+func applyRedemption(ctx context.Context, r *schema.CouponRedemption, subscriptionID bson.ObjectID, meta history.Meta) error {
+	if _, err := redemptions().UpdateByID(ctx, r.ID, bson.M{"$set": bson.M{
+		"status":          schema.REDEMPTION_STATUS_APPLIED,
+		"subscription_id": subscriptionID,
+		"updated_at":      time.Now().UTC(),
+	}}); err != nil {
+		return err
+	}
+	return history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    r.UserID,
+		EventType: schema.ACCOUNT_EVENT_COUPON,
+		Field:     "coupon",
+		NewValue:  r.CouponKey + ":" + r.Code,
+		ChangedBy: r.UserID.Hex(),
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	})
+}
+
+// subscriptionDiscount describes the redeemed coupon on the subscription
+func (p *promotion) subscriptionDiscount(start time.Time) *schema.SubscriptionDiscount {
+	return &schema.SubscriptionDiscount{
+		CouponKey:      p.coupon.Key,
+		Code:           p.code.Code,
+		Name:           p.coupon.Name,
+		PercentOff:     p.coupon.PercentOff,
+		AmountOff:      p.coupon.AmountOff,
+		Currency:       p.coupon.Currency,
+		Duration:       p.coupon.Duration,
+		DurationMonths: p.coupon.DurationMonths,
+		Start:          start,
+	}
+}
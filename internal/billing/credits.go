@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/ledger"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// TOPUP_REFERENCE_PREFIX starts the charge reference of a top-up, followed
+// by the user ID
+const TOPUP_REFERENCE_PREFIX = "topup:"
+
+var (
+	ErrCreditsDisabled   = errors.New("prepaid credits are not enabled")
+	ErrTopUpAmount       = errors.New("top-up amount is out of range")
+	ErrPaymentIncomplete = errors.New("payment was not completed")
+	ErrPaymentProcessing = errors.New("payment is processing, the credits are added once it succeeds")
+	ErrRefundPeriodOver  = errors.New("credits can no longer be refunded")
+)
+
+// validateCredits checks that credit-priced meters have a credit currency
+func validateCredits(cfg *config.BillingConfig) error {
+	for _, m := range cfg.Metering.Meters {
+		if m.CreditPrice > 0 && cfg.Credits.Currency == "" {
+			return errors.New("meter " + m.Name + " burns credits, but no credit currency is configured")
+		}
+	}
+	return nil
+}
+
+// CreditSummary is an owner's credit balance and lots
+type CreditSummary struct {
+	Currency  string                 `json:"currency"`
+	Available int64                  `json:"available"` // Credits that can be spent now
+	Balance   int64                  `json:"balance"`   // Book balance at At, including expired lots until their expiration is posted
+	At        time.Time              `json:"at"`
+	Lots      []schema.LedgerAccount `json:"lots"`
+}
+
+// Credits returns the user's credits, with the balance at a point in time
+func Credits(ctx context.Context, userID bson.ObjectID, at time.Time) (*CreditSummary, error) {
+	currency := config.GetBillingConfig().Credits.Currency
+	if currency == "" {
+		return nil, ErrCreditsDisabled
+	}
+	o := ledger.UserOwner(userID)
+	available, err := ledger.Available(ctx, o, currency)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := ledger.BalanceAt(ctx, o, currency, at)
+	if err != nil {
+		return nil, err
+	}
+	lots, err := ledger.Lots(ctx, o, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &CreditSummary{Currency: currency, Available: available, Balance: balance, At: at, Lots: lots}, nil
+}
+
+// TopUp is a request to buy credits with a saved payment method
+type TopUp struct {
+	Amount          int64  `json:"amount"`            // In minor units of the credit currency
+	Gateway         string `json:"gateway"`           // Stripe when empty
+	PaymentMethodID string `json:"payment_method_id"` // Saved payment method charged off-session
+	IdempotencyKey  string `json:"idempotency_key"`   // Chosen by the client, makes a retried top-up charge once
+}
+
+// TopUpCredits charges the user for credits and adds them to their balance
+// once the payment succeeded. A payment that is still processing, e.g. a
+// bank debit, returns ErrPaymentProcessing; its credits are added by the
+// gateway's webhook event when it succeeds.
+func TopUpCredits(ctx context.Context, userID bson.ObjectID, req TopUp) (*schema.LedgerEntry, error) {
+	cfg := config.GetBillingConfig().Credits
+	if cfg.Currency == "" {
+		return nil, ErrCreditsDisabled
+	}
+	if req.Amount < cfg.MinTopUp || req.Amount > cfg.MaxTopUp {
+		return nil, ErrTopUpAmount
+	}
+	if req.Gateway == "" {
+		req.Gateway = gateway.STRIPE
+	}
+	g, err := Gateway(req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := EnsureCustomer(ctx, u, g)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.AttachPaymentMethod(ctx, c.ExternalID, req.PaymentMethodID); err != nil {
+		return nil, err
+	}
+	charge, err := g.Charge(ctx, gateway.ChargeParams{
+		CustomerID:      c.ExternalID,
+		PaymentMethodID: req.PaymentMethodID,
+		Amount:          req.Amount,
+		Currency:        cfg.Currency,
+		Description:     "Prepaid credits",
+		IdempotencyKey:  "topup-" + userID.Hex() + "-" + req.IdempotencyKey,
+		Reference:       TOPUP_REFERENCE_PREFIX + userID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch charge.Status {
+	case "succeeded", "completed":
+		return creditTopUp(ctx, userID, g.Name(), charge)
+	case "processing", "pending":
+		log.Info().Str("user_id", userID.Hex()).Str("charge", charge.ID).Msg("Top-up payment processing")
+		return nil, ErrPaymentProcessing
+	}
+	log.Warn().Str("user_id", userID.Hex()).Str("charge", charge.ID).Str("status", charge.Status).Msg("Top-up payment not completed")
+	return nil, ErrPaymentIncomplete
+}
+
+// creditTopUp adds the credits of a succeeded top-up charge. It is keyed on
+// the charge, so the response to the top-up and the gateway's webhook event
+// add them once.
+func creditTopUp(ctx context.Context, userID bson.ObjectID, gatewayName string, charge *gateway.Charge) (*schema.LedgerEntry, error) {
+	cfg := config.GetBillingConfig().Credits
+	now := time.Now().UTC()
+	p := ledger.LotParams{
+		Amount:      charge.Amount,
+		Currency:    cfg.Currency,
+		Gateway:     gatewayName,
+		ChargeID:    charge.ID,
+		Description: "Top-up",
+		ChangedBy:   userID.Hex(),
+		Key:         "topup:" + gatewayName + ":" + charge.ID,
+	}
+	if cfg.ExpiryDays > 0 {
+		t := now.AddDate(0, 0, cfg.ExpiryDays)
+		p.ExpiresAt = &t
+	}
+	if cfg.RefundDays > 0 {
+		t := now.AddDate(0, 0, cfg.RefundDays)
+		p.RefundableUntil = &t
+	}
+	return ledger.TopUp(ctx, ledger.UserOwner(userID), p)
+}
+
+// creditSucceededCharge credits a top-up whose payment succeeded after
+// TopUpCredits returned. Charges of other purposes are ignored.
+func creditSucceededCharge(ctx context.Context, g gateway.PaymentGateway, event *gateway.Event) error {
+	hex, ok := strings.CutPrefix(event.ChargeReference, TOPUP_REFERENCE_PREFIX)
+	if !ok {
+		return nil
+	}
+	userID, err := bson.ObjectIDFromHex(hex)
+	if err != nil {
+		log.Warn().Str("gateway", g.Name()).Str("charge", event.Charge.ID).Str("reference", event.ChargeReference).Msg("Top-up with an invalid reference, not credited")
+		return nil
+	}
+	if currency := config.GetBillingConfig().Credits.Currency; !strings.EqualFold(event.Charge.Currency, currency) {
+		log.Error().Str("gateway", g.Name()).Str("charge", event.Charge.ID).Str("currency", event.Charge.Currency).Msg("Top-up in another currency than the credits, not credited")
+		return nil
+	}
+	e, err := creditTopUp(ctx, userID, g.Name(), event.Charge)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("user_id", userID.Hex()).Str("charge", event.Charge.ID).Str("entry", e.ID.Hex()).Msg("Credited processed top-up")
+	return nil
+}
+
+// RefundCredits pays the unused credits of one of the user's top-ups back
+// through the gateway that collected them. The credits leave the balance
+// first, so they cannot be spent while the refund is made, and are restored
+// when the gateway fails.
+func RefundCredits(ctx context.Context, userID, lotID bson.ObjectID) (*schema.LedgerEntry, error) {
+	o := ledger.UserOwner(userID)
+	lots, err := ledger.Lots(ctx, o, config.GetBillingConfig().Credits.Currency)
+	if err != nil {
+		return nil, err
+	}
+	var lot *schema.LedgerAccount
+	for i := range lots {
+		if lots[i].ID == lotID {
+			lot = &lots[i]
+		}
+	}
+	if lot == nil {
+		return nil, ledger.ErrUnknownLot
+	}
+	if lot.RefundableUntil == nil || time.Now().After(*lot.RefundableUntil) {
+		return nil, ErrRefundPeriodOver
+	}
+	g, err := Gateway(lot.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keyed on the lot's sequence, so a retried request refunds once but a
+	// refund after a failed one is a new refund
+	e, err := ledger.Refund(ctx, o, lot.ID, ledger.RefundParams{
+		Description: "Refund of unused credits",
+		ChangedBy:   userID.Hex(),
+		Key:         fmt.Sprintf("refund:%s:%d", lot.ID.Hex(), lot.Seq),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := g.Refund(ctx, gateway.RefundParams{
+		ChargeID:       lot.ChargeID,
+		Amount:         e.Amount,
+		Currency:       e.Currency,
+		Reason:         "requested_by_customer",
+		IdempotencyKey: e.Key,
+	})
+	if err == nil && (r.Status == "failed" || r.Status == "canceled") {
+		err = errors.New("refund " + r.ID + " " + r.Status)
+	}
+	if err != nil {
+		if _, rerr := ledger.ReverseRefund(ctx, e, "Refund failed"); rerr != nil {
+			log.Error().Err(rerr).Str("entry", e.ID.Hex()).Msg("Error reversing failed credit refund")
+		}
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,80 @@
+package billing
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/billing/crypto"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	CRYPTO_RENEWAL_JOB      = "crypto_renewal"
+	CRYPTO_RENEWAL_INTERVAL = 10 * time.Minute
+)
+
+// cryptoPrice prices one period of a crypto subscription. Customers pay
+// from their wallet, so the amount asked for includes tax.
+func cryptoPrice(ctx context.Context, userID bson.ObjectID, priceID, country string) (*crypto.Price, error) {
+	plan, price, err := catalog.FindByPrice(ctx, gateway.CRYPTO, priceID)
+	if err != nil {
+		return nil, err
+	}
+	if price == nil {
+		return nil, ErrUnknownPrice
+	}
+	totals, err := quote(ctx, userID, country, price.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return &crypto.Price{
+		Amount:      totals.Total,
+		Currency:    price.Currency,
+		Interval:    price.Interval,
+		Description: plan.Name,
+	}, nil
+}
+
+// cryptoGateway returns the crypto gateway, or nil when it is not configured
+func cryptoGateway() *crypto.Gateway {
+	g, _ := gateways[gateway.CRYPTO].(*crypto.Gateway)
+	return g
+}
+
+// PayCrypto returns the invoice to pay for the next period of the user's
+// crypto subscription
+func PayCrypto(ctx context.Context, userID bson.ObjectID) (*schema.CryptoInvoice, error) {
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	g := cryptoGateway()
+	if s.Gateway != gateway.CRYPTO || g == nil {
+		return nil, gateway.ErrUnsupported
+	}
+	return g.Pay(ctx, s.ExternalID)
+}
+
+// RenewCryptoSubscriptions applies payments, expiries and renewals of crypto
+// subscriptions, in case their webhooks were missed, and reminds customers
+// to pay
+func RenewCryptoSubscriptions(ctx context.Context) error {
+	g := cryptoGateway()
+	if g == nil {
+		return nil
+	}
+	events, err := g.Renew(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if err := dispatch(ctx, g, e); err != nil {
+			log.Error().Err(err).Str("subscription", e.SubscriptionID).Msg("Error applying crypto subscription change")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrUnknownSubscription = errors.New("crypto: unknown subscription")
+	ErrNotDue              = errors.New("crypto: the next period cannot be paid yet")
+	ErrEnded               = errors.New("crypto: subscription has ended")
+	ErrCreditExceedsPrice  = errors.New("crypto: payments received for the period exceed its price")
+)
+
+// customerPrefix prefixes the customer IDs of crypto customers. Processors
+// have no customer objects, so the ID only links billing records.
+const customerPrefix = "crypto-"
+
+// Price is what one period of a catalog price costs a customer
+type Price struct {
+	Amount      int64 // Including tax
+	Currency    string
+	Interval    schema.PLAN_INTERVAL
+	Description string
+}
+
+// PriceFunc prices a catalog price for a user in the tax country of their
+// subscription
+type PriceFunc func(ctx context.Context, userID bson.ObjectID, priceID, country string) (*Price, error)
+
+// Gateway implements gateway.PaymentGateway for cryptocurrency payments.
+// Processors cannot pull money from a customer, so brain keeps the
+// subscription itself and asks the customer to pay an invoice per period.
+type Gateway struct {
+	processor   Processor
+	price       PriceFunc
+	renewalLead time.Duration
+	grace       time.Duration
+}
+
+// NewGateway creates the crypto payment gateway. Customers are reminded to
+// pay renewalLeadDays before their period ends, and subscriptions turn unpaid
+// graceDays after it ended.
+func NewGateway(processor Processor, price PriceFunc, renewalLeadDays, graceDays int) *Gateway {
+	return &Gateway{
+		processor:   processor,
+		price:       price,
+		renewalLead: time.Duration(renewalLeadDays) * 24 * time.Hour,
+		grace:       time.Duration(graceDays) * 24 * time.Hour,
+	}
+}
+
+func cryptoSubscriptions() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_CRYPTO_SUBSCRIPTIONS)
+}
+
+func (g *Gateway) Name() string {
+	return gateway.CRYPTO
+}
+
+func (g *Gateway) CreateCustomer(ctx context.Context, p gateway.CustomerParams) (string, error) {
+	return customerPrefix + p.UserID, nil
+}
+
+// DeleteCustomer removes the customer's subscriptions. Invoices stay at the
+// processor, which keeps no personal data beyond the order reference.
+func (g *Gateway) DeleteCustomer(ctx context.Context, customerID string) error {
+	_, err := cryptoSubscriptions().DeleteMany(ctx, bson.M{"customer_id": customerID})
+	return err
+}
+
+// AttachPaymentMethod is not supported: every payment is made by the
+// customer from their own wallet
+func (g *Gateway) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	return gateway.ErrUnsupported
+}
+
+func (g *Gateway) Charge(ctx context.Context, p gateway.ChargeParams) (*gateway.Charge, error) {
+	return nil, gateway.ErrUnsupported
+}
+
+// Refund is not supported: refunds need an address from the customer.
+// Overpayments are refunded automatically.
+func (g *Gateway) Refund(ctx context.Context, p gateway.RefundParams) (*gateway.Refund, error) {
+	return nil, gateway.ErrUnsupported
+}
+
+// CreateSubscription starts a subscription. Without a trial it waits for the
+// first invoice to be paid at the returned ApprovalURL; with a trial the
+// customer is reminded to pay before it ends. Coupons are not supported.
+func (g *Gateway) CreateSubscription(ctx context.Context, p gateway.SubscriptionParams) (*gateway.Subscription, error) {
+	if p.CouponID != "" {
+		return nil, gateway.ErrUnsupported
+	}
+	if p.IdempotencyKey != "" {
+		var existing schema.CryptoSubscription
+		err := cryptoSubscriptions().FindOne(ctx, bson.M{"idempotency_key": p.IdempotencyKey}).Decode(&existing)
+		if err == nil {
+			return g.neutral(&existing), nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+	}
+	userID, err := bson.ObjectIDFromHex(p.UserID)
+	if err != nil {
+		return nil, err
+	}
+	price, err := g.price(ctx, userID, p.PriceID, p.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	s := &schema.CryptoSubscription{
+		ID:             bson.NewObjectID(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		UserID:         userID,
+		CustomerID:     p.CustomerID,
+		IdempotencyKey: p.IdempotencyKey,
+		PriceID:        p.PriceID,
+		TaxCountry:     p.Country,
+		Status:         schema.SUBSCRIPTION_STATUS_INCOMPLETE,
+		Invoices:       []schema.CryptoInvoice{},
+	}
+	if p.TrialDays > 0 {
+		end := now.AddDate(0, 0, p.TrialDays)
+		s.Status = schema.SUBSCRIPTION_STATUS_TRIALING
+		s.CurrentPeriodStart = &now
+		s.CurrentPeriodEnd = &end
+		s.TrialEnd = &end
+	} else {
+		inv, err := g.createInvoice(ctx, s, p.PriceID, price, 0, p.Email, p.ReturnURL)
+		if err != nil {
+			return nil, err
+		}
+		s.Invoices = append(s.Invoices, *inv)
+	}
+
+	if _, err := cryptoSubscriptions().InsertOne(ctx, s); err != nil {
+		if mongo.IsDuplicateKeyError(err) && p.IdempotencyKey != "" {
+			return g.CreateSubscription(ctx, p)
+		}
+		return nil, err
+	}
+	return g.neutral(s), nil
+}
+
+// GetSubscription brings the subscription up to date with its invoices at
+// the processor
+func (g *Gateway) GetSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	s, _, err := g.refresh(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return g.neutral(s), nil
+}
+
+// ChangeSubscriptionPrice charges the new price from the next period on. A
+// subscription waiting for its first payment switches immediately and gets
+// a new invoice at ApprovalURL. The change is local, so no idempotency key is
+// needed.
+func (g *Gateway) ChangeSubscriptionPrice(ctx context.Context, id, priceID, idempotencyKey string) (*gateway.Subscription, error) {
+	var withdrawn []string
+	s, err := update(ctx, id, func(s *schema.CryptoSubscription) error {
+		withdrawn = withdrawn[:0]
+		if s.Period == 0 && s.Status == schema.SUBSCRIPTION_STATUS_INCOMPLETE {
+			s.PriceID = priceID
+		}
+		s.NextPriceID = priceID
+		if s.NextPriceID == s.PriceID {
+			s.NextPriceID = ""
+		}
+		for i := range s.Invoices {
+			if s.Invoices[i].Period > s.Period && s.Invoices[i].Status == schema.CRYPTO_INVOICE_STATUS_NEW {
+				withdrawn = append(withdrawn, s.Invoices[i].ExternalID)
+				s.Invoices[i].Status = schema.CRYPTO_INVOICE_STATUS_INVALID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.invalidate(ctx, withdrawn)
+
+	if s.Status == schema.SUBSCRIPTION_STATUS_INCOMPLETE {
+		if _, err := g.Pay(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return g.GetSubscription(ctx, id)
+}
+
+// CancelSubscription ends the subscription now, withdrawing unpaid invoices,
+// or at the end of the paid period
+func (g *Gateway) CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*gateway.Subscription, error) {
+	var withdrawn []string
+	s, err := update(ctx, id, func(s *schema.CryptoSubscription) error {
+		withdrawn = withdrawn[:0]
+		if atPeriodEnd && s.CurrentPeriodEnd != nil {
+			s.CancelAtPeriodEnd = true
+			return nil
+		}
+		now := time.Now().UTC()
+		s.Status = schema.SUBSCRIPTION_STATUS_CANCELED
+		s.CanceledAt = &now
+		for i := range s.Invoices {
+			if s.Invoices[i].Status == schema.CRYPTO_INVOICE_STATUS_NEW {
+				withdrawn = append(withdrawn, s.Invoices[i].ExternalID)
+				s.Invoices[i].Status = schema.CRYPTO_INVOICE_STATUS_INVALID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.invalidate(ctx, withdrawn)
+	return g.neutral(s), nil
+}
+
+// ResumeSubscription withdraws a cancellation scheduled for the period end
+func (g *Gateway) ResumeSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	s, err := update(ctx, id, func(s *schema.CryptoSubscription) error {
+		if s.Status == schema.SUBSCRIPTION_STATUS_CANCELED {
+			return ErrEnded
+		}
+		s.CancelAtPeriodEnd = false
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g.neutral(s), nil
+}
+
+// ChangeSubscriptionQuantity is not supported: seat-based plans are billed
+// through Stripe
+func (g *Gateway) ChangeSubscriptionQuantity(ctx context.Context, id string, quantity int64, idempotencyKey string) (*gateway.Subscription, error) {
+	return nil, gateway.ErrUnsupported
+}
+
+// RetryPayment is not supported: the customer pays each period from their
+// wallet, and unpaid periods follow the crypto grace period instead
+func (g *Gateway) RetryPayment(ctx context.Context, subscriptionID, invoiceID, idempotencyKey string) error {
+	return gateway.ErrUnsupported
+}
+
+// CreditBalance is not supported: crypto customers have no balance, only
+// the credit of partial payments towards a period
+func (g *Gateway) CreditBalance(ctx context.Context, p gateway.CreditParams) error {
+	return gateway.ErrUnsupported
+}
+
+// ParseWebhook verifies a processor notification and maps it to the
+// subscription of the invoice. The invoice is fetched from the processor
+// rather than trusting the payload; a settled invoice is reported as a
+// collected payment of the period's full price, unless its period was
+// already paid.
+func (g *Gateway) ParseWebhook(ctx context.Context, payload []byte, header http.Header) (*gateway.Event, error) {
+	n, err := g.processor.ParseWebhook(payload, header)
+	if err != nil {
+		return nil, err
+	}
+	event := &gateway.Event{
+		ID:        n.ID,
+		Type:      n.Type,
+		Kind:      gateway.EVENT_KIND_OTHER,
+		CreatedAt: n.CreatedAt,
+		InvoiceID: n.InvoiceID,
+	}
+	if n.InvoiceID == "" {
+		return event, nil
+	}
+
+	var s schema.CryptoSubscription
+	err = cryptoSubscriptions().FindOne(ctx, bson.M{"invoices.external_id": n.InvoiceID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Invoices created outside brain, e.g. by the processor's own apps
+		return event, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	event.Kind = gateway.EVENT_KIND_PAYMENT
+	event.SubscriptionID = s.ID.Hex()
+	event.CustomerID = s.CustomerID
+
+	inv, err := g.processor.GetInvoice(ctx, n.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+	// Payments for a period that is already paid are refunded by hand
+	local := findInvoice(&s, n.InvoiceID)
+	if local != nil && inv.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED && !local.Unapplied && (local.SettledAt != nil || !unapplicable(&s, local)) {
+		paid(event, local)
+	}
+	return event, nil
+}
+
+// paid marks an event as the collected payment of an invoice's period
+func paid(event *gateway.Event, inv *schema.CryptoInvoice) {
+	event.Paid = true
+	event.PaymentID = inv.ExternalID
+	event.Amount = inv.Amount + inv.Credit
+	event.Currency = inv.Currency
+}
+
+// neutral maps a subscription onto the neutral model. ApprovalURL is the
+// checkout page of the invoice waiting for payment, if any.
+func (g *Gateway) neutral(s *schema.CryptoSubscription) *gateway.Subscription {
+	out := &gateway.Subscription{
+		ID:                 s.ID.Hex(),
+		CustomerID:         s.CustomerID,
+		PriceID:            s.PriceID,
+		Status:             s.Status,
+		CurrentPeriodStart: s.CurrentPeriodStart,
+		CurrentPeriodEnd:   s.CurrentPeriodEnd,
+		TrialEnd:           s.TrialEnd,
+		CancelAtPeriodEnd:  s.CancelAtPeriodEnd,
+		CanceledAt:         s.CanceledAt,
+	}
+	if inv := openInvoice(s); inv != nil && inv.Status == schema.CRYPTO_INVOICE_STATUS_NEW {
+		out.ApprovalURL = inv.CheckoutLink
+	}
+	return out
+}
+
+// createInvoice creates the invoice for the next period of a subscription,
+// less what was already received for it
+func (g *Gateway) createInvoice(ctx context.Context, s *schema.CryptoSubscription, priceID string, price *Price, credit int64, email, redirectURL string) (*schema.CryptoInvoice, error) {
+	amount := price.Amount - credit
+	if amount <= 0 {
+		return nil, ErrCreditExceedsPrice
+	}
+	period := s.Period + 1
+	inv, err := g.processor.CreateInvoice(ctx, InvoiceParams{
+		Amount:      amount,
+		Currency:    price.Currency,
+		OrderID:     fmt.Sprintf("%s-%d-%d", s.ID.Hex(), period, len(s.Invoices)+1),
+		Description: price.Description,
+		Email:       email,
+		RedirectURL: redirectURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &schema.CryptoInvoice{
+		ExternalID:   inv.ID,
+		Period:       period,
+		PriceID:      priceID,
+		Interval:     price.Interval,
+		Amount:       amount,
+		Credit:       credit,
+		Currency:     price.Currency,
+		Status:       inv.Status,
+		CheckoutLink: inv.CheckoutLink,
+		ExpiresAt:    inv.ExpiresAt,
+	}, nil
+}
+
+// invalidate withdraws unpaid invoices at the processor. Failures are only
+// logged: an expired quote cannot be paid anyway.
+func (g *Gateway) invalidate(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		if err := g.processor.InvalidateInvoice(ctx, id); err != nil {
+			log.Warn().Err(err).Str("invoice", id).Msg("Error withdrawing crypto invoice")
+		}
+	}
+}
+
+func findInvoice(s *schema.CryptoSubscription, externalID string) *schema.CryptoInvoice {
+	for i := range s.Invoices {
+		if s.Invoices[i].ExternalID == externalID {
+			return &s.Invoices[i]
+		}
+	}
+	return nil
+}
+
+// openInvoice returns the newest invoice for the next period that can still
+// be paid or is being confirmed
+func openInvoice(s *schema.CryptoSubscription) *schema.CryptoInvoice {
+	for i := len(s.Invoices) - 1; i >= 0; i-- {
+		if s.Invoices[i].Period > s.Period && s.Invoices[i].Open() {
+			return &s.Invoices[i]
+		}
+	}
+	return nil
+}
+
+var _ gateway.PaymentGateway = (*Gateway)(nil)
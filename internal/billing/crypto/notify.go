@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+)
+
+// payLink is the page where the frontend requests an invoice with
+// POST /me/billing/subscription/pay and sends the customer to its checkout
+func payLink() string {
+	return config.GetSiteConfig().URL + "/billing/pay"
+}
+
+func money(minor int64, currency string) string {
+	return FormatAmount(minor, currency) + " " + strings.ToUpper(currency)
+}
+
+// sendRenewal reminds the customer to pay the next period
+func sendRenewal(ctx context.Context, s *schema.CryptoSubscription, price *Price) error {
+	return send(ctx, s, schema.EMAIL_EVENT_CRYPTO_PAYMENT, "crypto_payment", map[string]any{
+		"Partial": false,
+		"Amount":  money(price.Amount, price.Currency),
+		"Due":     s.CurrentPeriodEnd,
+		"Link":    payLink(),
+	})
+}
+
+// sendPartialPayment asks the customer to pay the rest of an expired invoice
+func sendPartialPayment(ctx context.Context, s *schema.CryptoSubscription, inv *schema.CryptoInvoice) error {
+	return send(ctx, s, schema.EMAIL_EVENT_CRYPTO_PAYMENT, "crypto_payment", map[string]any{
+		"Partial":  true,
+		"Received": money(inv.Credit+inv.Paid, inv.Currency),
+		"Amount":   money(inv.Amount-inv.Paid, inv.Currency),
+		"Due":      s.CurrentPeriodEnd,
+		"Link":     payLink(),
+	})
+}
+
+// sendRefund sends the link where the customer claims an overpayment
+func sendRefund(ctx context.Context, s *schema.CryptoSubscription, inv *schema.CryptoInvoice, link string) error {
+	return send(ctx, s, schema.EMAIL_EVENT_CRYPTO_REFUND, "crypto_refund", map[string]any{
+		"Amount": money(inv.Amount, inv.Currency),
+		"Paid":   money(inv.Paid, inv.Currency),
+		"Link":   link,
+	})
+}
+
+func send(ctx context.Context, s *schema.CryptoSubscription, emailType schema.EmailEventType, template string, data map[string]any) error {
+	u, err := users.FindByID(ctx, s.UserID)
+	if err != nil {
+		return err
+	}
+	data["DisplayName"] = u.DisplayName
+	return mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_BILLING,
+		To:       u.Email,
+		UserID:   s.UserID,
+		Type:     emailType,
+		Template: template,
+		Data:     data,
+	})
+}
@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// Processor is a cryptocurrency payment processor such as BTCPay Server. It
+// quotes invoices in fiat currency, watches the chain for payments and
+// reports them with signed webhooks. Amounts are in the fiat currency's
+// minor unit.
+type Processor interface {
+	CreateInvoice(ctx context.Context, p InvoiceParams) (*Invoice, error)
+	GetInvoice(ctx context.Context, id string) (*Invoice, error)
+	// InvalidateInvoice withdraws an unpaid invoice
+	InvalidateInvoice(ctx context.Context, id string) error
+	// RefundOverpayment lets the customer claim the amount paid above the
+	// invoice amount and returns the link where they enter their address
+	RefundOverpayment(ctx context.Context, id string) (string, error)
+	// ParseWebhook verifies a webhook delivery and decodes it
+	ParseWebhook(payload []byte, header http.Header) (*Notification, error)
+}
+
+// InvoiceParams describe a new invoice
+type InvoiceParams struct {
+	Amount      int64
+	Currency    string
+	OrderID     string // Merchant reference, shown in the processor's dashboard
+	Description string
+	Email       string
+	RedirectURL string // Where the checkout page sends the customer after payment
+}
+
+// Invoice is the processor-neutral state of an invoice
+type Invoice struct {
+	ID           string
+	Status       schema.CRYPTO_INVOICE_STATUS
+	Amount       int64
+	Currency     string
+	Paid         int64 // Received so far, converted at the invoice's rate
+	Overpaid     bool
+	CheckoutLink string
+	ExpiresAt    time.Time
+}
+
+// Notification is a verified webhook delivery about an invoice
+type Notification struct {
+	ID        string // Unique across redeliveries
+	Type      string // Processor-specific event type
+	InvoiceID string
+	CreatedAt time.Time
+}
+
+// zeroDecimal lists currencies without a minor unit
+var zeroDecimal = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+// scale returns the number of minor units in one unit of a currency
+func scale(currency string) int64 {
+	if zeroDecimal[strings.ToLower(currency)] {
+		return 1
+	}
+	return 100
+}
+
+// FormatAmount converts an amount in minor units to a decimal string
+func FormatAmount(minor int64, currency string) string {
+	if scale(currency) == 1 {
+		return strconv.FormatInt(minor, 10)
+	}
+	return big.NewRat(minor, 100).FloatString(2)
+}
+
+// ParseAmount converts a decimal string to minor units, rounding half up
+func ParseAmount(s, currency string) int64 {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0
+	}
+	return Minor(r, currency)
+}
+
+// Minor converts an exact amount to minor units, rounding half up
+func Minor(r *big.Rat, currency string) int64 {
+	r = new(big.Rat).Mul(r, big.NewRat(scale(currency), 1))
+	r.Add(r, big.NewRat(1, 2))
+	return new(big.Int).Div(r.Num(), r.Denom()).Int64()
+}
@@ -0,0 +1,415 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// A subscription whose first invoice was not paid ends after this long
+	INCOMPLETE_EXPIRY = 23 * time.Hour
+	// Invoices are checked for late payments this long after their quote expired
+	LATE_PAYMENT_WINDOW = 24 * time.Hour
+	// An open invoice is handed out again while its quote is valid for at least this long
+	QUOTE_MIN_REMAINING = 5 * time.Minute
+
+	updateRetries = 5
+)
+
+var errConflict = errors.New("crypto: subscription changed concurrently")
+
+// update applies fn to the current state of a subscription and saves it,
+// starting over when another write came first. fn may run more than once.
+func update(ctx context.Context, id string, fn func(s *schema.CryptoSubscription) error) (*schema.CryptoSubscription, error) {
+	oid, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrUnknownSubscription
+	}
+	for range updateRetries {
+		s, err := load(ctx, oid)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(s); err != nil {
+			return nil, err
+		}
+		revision := s.Revision
+		s.Revision++
+		s.UpdatedAt = time.Now().UTC()
+		res, err := cryptoSubscriptions().ReplaceOne(ctx, bson.M{"_id": oid, "revision": revision}, s)
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 1 {
+			return s, nil
+		}
+	}
+	return nil, errConflict
+}
+
+func load(ctx context.Context, id bson.ObjectID) (*schema.CryptoSubscription, error) {
+	var s schema.CryptoSubscription
+	err := cryptoSubscriptions().FindOne(ctx, bson.M{"_id": id}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// refresh fetches the recent invoices of a subscription from the processor,
+// applies payments and the passing of time, and follows up on partial and
+// over-payments. It returns the invoices that were settled by this call.
+func (g *Gateway) refresh(ctx context.Context, id string) (*schema.CryptoSubscription, []schema.CryptoInvoice, error) {
+	oid, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, ErrUnknownSubscription
+	}
+	s, err := load(ctx, oid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Withdrawn and expired invoices can still receive payments that were
+	// already on their way
+	fetched := map[string]*Invoice{}
+	since := time.Now().Add(-LATE_PAYMENT_WINDOW)
+	for _, inv := range s.Invoices {
+		if inv.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED || inv.ExpiresAt.Before(since) {
+			continue
+		}
+		f, err := g.processor.GetInvoice(ctx, inv.ExternalID)
+		if err != nil {
+			return nil, nil, err
+		}
+		fetched[inv.ExternalID] = f
+	}
+
+	var settled []schema.CryptoInvoice
+	var withdrawn []string
+	s, err = update(ctx, id, func(s *schema.CryptoSubscription) error {
+		settled, withdrawn = settled[:0], withdrawn[:0]
+		now := time.Now().UTC()
+		for i := range s.Invoices {
+			inv := &s.Invoices[i]
+			f, ok := fetched[inv.ExternalID]
+			if !ok || inv.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED {
+				continue
+			}
+			inv.Paid, inv.Overpaid, inv.ExpiresAt = f.Paid, f.Overpaid, f.ExpiresAt
+			// A withdrawn invoice stays invalid unless it was paid regardless
+			if inv.Status != schema.CRYPTO_INVOICE_STATUS_INVALID || f.Status == schema.CRYPTO_INVOICE_STATUS_PROCESSING || f.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED {
+				inv.Status = f.Status
+			}
+			if inv.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED {
+				inv.SettledAt = &now
+				if g.settle(s, inv, now) {
+					settled = append(settled, *inv)
+				}
+			}
+		}
+		g.advance(s, now)
+		for i := range s.Invoices {
+			if s.Invoices[i].Status == schema.CRYPTO_INVOICE_STATUS_NEW && s.Invoices[i].Period <= s.Period {
+				withdrawn = append(withdrawn, s.Invoices[i].ExternalID)
+				s.Invoices[i].Status = schema.CRYPTO_INVOICE_STATUS_INVALID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	g.invalidate(ctx, withdrawn)
+	g.followUp(ctx, s)
+	return s, settled, nil
+}
+
+// settle starts the period paid by a settled invoice. Renewals continue
+// where the previous period ended; the first payment and payments of unpaid
+// subscriptions start a period now. It reports false for an invoice paying a
+// period that is already paid or canceled, which is flagged Unapplied.
+func (g *Gateway) settle(s *schema.CryptoSubscription, inv *schema.CryptoInvoice, now time.Time) bool {
+	if unapplicable(s, inv) {
+		inv.Unapplied = true
+		log.Warn().
+			Str("subscription", s.ID.Hex()).
+			Str("invoice", inv.ExternalID).
+			Int64("amount", inv.Paid).
+			Msg("Crypto invoice paid for a period that is already paid or canceled, refund it manually")
+		return false
+	}
+
+	start := now
+	switch s.Status {
+	case schema.SUBSCRIPTION_STATUS_TRIALING, schema.SUBSCRIPTION_STATUS_ACTIVE, schema.SUBSCRIPTION_STATUS_PAST_DUE:
+		if s.CurrentPeriodEnd != nil {
+			start = *s.CurrentPeriodEnd
+		}
+	}
+	end := start.AddDate(0, 1, 0)
+	if inv.Interval == schema.PLAN_INTERVAL_YEAR {
+		end = start.AddDate(1, 0, 0)
+	}
+
+	s.Period = inv.Period
+	s.PriceID = inv.PriceID
+	if s.NextPriceID == s.PriceID {
+		s.NextPriceID = ""
+	}
+	s.CurrentPeriodStart = &start
+	s.CurrentPeriodEnd = &end
+	s.Status = schema.SUBSCRIPTION_STATUS_ACTIVE
+	s.CanceledAt = nil
+	return true
+}
+
+// unapplicable reports whether an invoice pays a period that is already
+// paid, or a subscription that was canceled
+func unapplicable(s *schema.CryptoSubscription, inv *schema.CryptoInvoice) bool {
+	return inv.Period <= s.Period || s.Status == schema.SUBSCRIPTION_STATUS_CANCELED
+}
+
+// advance applies the passing of time: unpaid first invoices expire, and a
+// period that ended without payment makes the subscription past_due, then
+// unpaid after the grace period
+func (g *Gateway) advance(s *schema.CryptoSubscription, now time.Time) {
+	switch s.Status {
+	case schema.SUBSCRIPTION_STATUS_INCOMPLETE:
+		if openInvoice(s) == nil && now.Sub(s.CreatedAt) > INCOMPLETE_EXPIRY {
+			s.Status = schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED
+		}
+
+	case schema.SUBSCRIPTION_STATUS_TRIALING, schema.SUBSCRIPTION_STATUS_ACTIVE, schema.SUBSCRIPTION_STATUS_PAST_DUE:
+		if s.CurrentPeriodEnd == nil || now.Before(*s.CurrentPeriodEnd) {
+			return
+		}
+		if s.CancelAtPeriodEnd {
+			s.Status = schema.SUBSCRIPTION_STATUS_CANCELED
+			s.CanceledAt = s.CurrentPeriodEnd
+			return
+		}
+		// A payment waiting for confirmations keeps the subscription as it is
+		if inv := openInvoice(s); inv != nil && inv.Status == schema.CRYPTO_INVOICE_STATUS_PROCESSING {
+			return
+		}
+		if now.After(s.CurrentPeriodEnd.Add(g.grace)) {
+			s.Status = schema.SUBSCRIPTION_STATUS_UNPAID
+		} else {
+			s.Status = schema.SUBSCRIPTION_STATUS_PAST_DUE
+		}
+	}
+}
+
+// followUp tells the customer about partial payments and refunds
+// overpayments. Each is claimed on the invoice first so that it happens once.
+func (g *Gateway) followUp(ctx context.Context, s *schema.CryptoSubscription) {
+	for _, inv := range s.Invoices {
+		switch {
+		case inv.Status == schema.CRYPTO_INVOICE_STATUS_EXPIRED && inv.Paid > 0 && !inv.Notified && inv.Period > s.Period:
+			if !claim(ctx, s.ID, inv.ExternalID, "notified", false, true) {
+				continue
+			}
+			if err := sendPartialPayment(ctx, s, &inv); err != nil {
+				log.Error().Err(err).Str("invoice", inv.ExternalID).Msg("Error sending crypto partial payment notice")
+			}
+
+		case inv.Status == schema.CRYPTO_INVOICE_STATUS_SETTLED && inv.Overpaid && inv.Refund == "":
+			if !claim(ctx, s.ID, inv.ExternalID, "refund", bson.M{"$exists": false}, schema.CRYPTO_REFUND_PENDING) {
+				continue
+			}
+			link, err := g.processor.RefundOverpayment(ctx, inv.ExternalID)
+			if err != nil {
+				// Released so that the next refresh tries again
+				log.Error().Err(err).Str("invoice", inv.ExternalID).Msg("Error refunding crypto overpayment")
+				unset(ctx, s.ID, inv.ExternalID, "refund")
+				continue
+			}
+			if _, err := cryptoSubscriptions().UpdateOne(ctx,
+				bson.M{"_id": s.ID, "invoices.external_id": inv.ExternalID},
+				bson.M{"$set": bson.M{"invoices.$.refund": schema.CRYPTO_REFUND_CREATED, "invoices.$.refund_link": link}, "$inc": bson.M{"revision": 1}},
+			); err != nil {
+				log.Error().Err(err).Str("invoice", inv.ExternalID).Str("link", link).Msg("Error storing crypto refund")
+			}
+			if err := sendRefund(ctx, s, &inv, link); err != nil {
+				log.Error().Err(err).Str("invoice", inv.ExternalID).Msg("Error sending crypto refund link")
+			}
+		}
+	}
+}
+
+// claim sets a field of an invoice if it still has the expected value
+func claim(ctx context.Context, id bson.ObjectID, externalID, field string, from, to any) bool {
+	res, err := cryptoSubscriptions().UpdateOne(ctx,
+		bson.M{"_id": id, "invoices": bson.M{"$elemMatch": bson.M{"external_id": externalID, field: from}}},
+		bson.M{"$set": bson.M{"invoices.$." + field: to}, "$inc": bson.M{"revision": 1}},
+	)
+	if err != nil {
+		log.Error().Err(err).Str("invoice", externalID).Str("field", field).Msg("Error claiming crypto invoice")
+		return false
+	}
+	return res.ModifiedCount == 1
+}
+
+func unset(ctx context.Context, id bson.ObjectID, externalID, field string) {
+	if _, err := cryptoSubscriptions().UpdateOne(ctx,
+		bson.M{"_id": id, "invoices.external_id": externalID},
+		bson.M{"$unset": bson.M{"invoices.$." + field: ""}, "$inc": bson.M{"revision": 1}},
+	); err != nil {
+		log.Error().Err(err).Str("invoice", externalID).Str("field", field).Msg("Error releasing crypto invoice")
+	}
+}
+
+// Pay returns an invoice for the next period of a subscription: the open one
+// while its quote is still valid, otherwise a new one at the current price,
+// less what was received on expired invoices for the period
+func (g *Gateway) Pay(ctx context.Context, id string) (*schema.CryptoInvoice, error) {
+	s, _, err := g.refresh(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch s.Status {
+	case schema.SUBSCRIPTION_STATUS_CANCELED, schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED:
+		return nil, ErrEnded
+	}
+	if s.CancelAtPeriodEnd {
+		return nil, ErrEnded
+	}
+	if inv := openInvoice(s); inv != nil && (inv.Status == schema.CRYPTO_INVOICE_STATUS_PROCESSING || time.Until(inv.ExpiresAt) > QUOTE_MIN_REMAINING) {
+		return inv, nil
+	}
+	if s.Status != schema.SUBSCRIPTION_STATUS_INCOMPLETE && s.CurrentPeriodEnd != nil && time.Until(*s.CurrentPeriodEnd) > g.renewalLead {
+		return nil, ErrNotDue
+	}
+
+	priceID := s.PriceID
+	if s.NextPriceID != "" {
+		priceID = s.NextPriceID
+	}
+	price, err := g.price(ctx, s.UserID, priceID, s.TaxCountry)
+	if err != nil {
+		return nil, err
+	}
+	var credit int64
+	for _, inv := range s.Invoices {
+		if inv.Period == s.Period+1 && inv.Status == schema.CRYPTO_INVOICE_STATUS_EXPIRED {
+			credit += inv.Paid
+		}
+	}
+	u, err := users.FindByID(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := g.createInvoice(ctx, s, priceID, price, credit, u.Email, config.GetSiteConfig().URL+"/billing/complete")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := update(ctx, id, func(s *schema.CryptoSubscription) error {
+		s.Invoices = append(s.Invoices, *inv)
+		return nil
+	}); err != nil {
+		g.invalidate(ctx, []string{inv.ExternalID})
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Renew brings every running subscription up to date and reminds customers
+// to pay before their period ends. It returns an event for each subscription
+// that changed, with the payments collected, for billing to apply.
+func (g *Gateway) Renew(ctx context.Context) ([]*gateway.Event, error) {
+	cur, err := cryptoSubscriptions().Find(ctx, bson.M{"status": bson.M{"$in": bson.A{
+		schema.SUBSCRIPTION_STATUS_INCOMPLETE,
+		schema.SUBSCRIPTION_STATUS_TRIALING,
+		schema.SUBSCRIPTION_STATUS_ACTIVE,
+		schema.SUBSCRIPTION_STATUS_PAST_DUE,
+		schema.SUBSCRIPTION_STATUS_UNPAID,
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	var running []schema.CryptoSubscription
+	if err := cur.All(ctx, &running); err != nil {
+		return nil, err
+	}
+
+	var events []*gateway.Event
+	for _, before := range running {
+		s, settled, err := g.refresh(ctx, before.ID.Hex())
+		if err != nil {
+			log.Error().Err(err).Str("subscription", before.ID.Hex()).Msg("Error refreshing crypto subscription")
+			continue
+		}
+		now := time.Now().UTC()
+		event := func() *gateway.Event {
+			return &gateway.Event{
+				ID:             fmt.Sprintf("renewal-%s-%d", s.ID.Hex(), s.Revision),
+				Type:           "renewal",
+				Kind:           gateway.EVENT_KIND_SUBSCRIPTION,
+				CreatedAt:      now,
+				SubscriptionID: s.ID.Hex(),
+				CustomerID:     s.CustomerID,
+			}
+		}
+		for i := range settled {
+			e := event()
+			e.Kind = gateway.EVENT_KIND_PAYMENT
+			e.InvoiceID = settled[i].ExternalID
+			paid(e, &settled[i])
+			events = append(events, e)
+		}
+		if len(settled) == 0 && (s.Status != before.Status || s.Period != before.Period) {
+			events = append(events, event())
+		}
+		g.remind(ctx, s)
+	}
+	return events, nil
+}
+
+// remind emails the customer once per period when the renewal is due
+func (g *Gateway) remind(ctx context.Context, s *schema.CryptoSubscription) {
+	switch s.Status {
+	case schema.SUBSCRIPTION_STATUS_TRIALING, schema.SUBSCRIPTION_STATUS_ACTIVE, schema.SUBSCRIPTION_STATUS_PAST_DUE:
+	default:
+		return
+	}
+	next := s.Period + 1
+	if s.CancelAtPeriodEnd || s.CurrentPeriodEnd == nil || s.RenewalNoticeFor >= next || time.Until(*s.CurrentPeriodEnd) > g.renewalLead {
+		return
+	}
+	if inv := openInvoice(s); inv != nil && inv.Status == schema.CRYPTO_INVOICE_STATUS_PROCESSING {
+		return
+	}
+
+	priceID := s.PriceID
+	if s.NextPriceID != "" {
+		priceID = s.NextPriceID
+	}
+	price, err := g.price(ctx, s.UserID, priceID, s.TaxCountry)
+	if err != nil {
+		log.Error().Err(err).Str("subscription", s.ID.Hex()).Msg("Error pricing crypto renewal")
+		return
+	}
+	res, err := cryptoSubscriptions().UpdateOne(ctx,
+		bson.M{"_id": s.ID, "renewal_notice_for": bson.M{"$lt": next}},
+		bson.M{"$set": bson.M{"renewal_notice_for": next}, "$inc": bson.M{"revision": 1}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		return
+	}
+	if err := sendRenewal(ctx, s, price); err != nil {
+		log.Error().Err(err).Str("subscription", s.ID.Hex()).Msg("Error sending crypto renewal reminder")
+	}
+}
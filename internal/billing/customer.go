@@ -0,0 +1,68 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// FindCustomer returns the billing customer of a user at a gateway
+func FindCustomer(ctx context.Context, userID bson.ObjectID, gatewayName string) (*schema.BillingCustomer, error) {
+	var c schema.BillingCustomer
+	err := customers().FindOne(ctx, bson.M{"user_id": userID, "gateway": gatewayName}).Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// EnsureCustomer returns the user's billing customer at a gateway, creating
+// it on first use. The idempotency key makes concurrent first calls create a
+// single customer at the gateway.
+func EnsureCustomer(ctx context.Context, u *schema.User, g gateway.PaymentGateway) (*schema.BillingCustomer, error) {
+	c, err := FindCustomer(ctx, u.ID, g.Name())
+	if err == nil {
+		return c, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	externalID, err := g.CreateCustomer(ctx, gateway.CustomerParams{
+		UserID:         u.ID.Hex(),
+		Email:          u.Email,
+		Name:           u.DisplayName,
+		IdempotencyKey: "customer-" + u.ID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	c = &schema.BillingCustomer{
+		ID:         bson.NewObjectID(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		UserID:     u.ID,
+		Gateway:    g.Name(),
+		ExternalID: externalID,
+	}
+	if _, err := customers().InsertOne(ctx, c); err != nil {
+		// Lost the race against a concurrent call, use the stored customer
+		if mongo.IsDuplicateKeyError(err) {
+			return FindCustomer(ctx, u.ID, g.Name())
+		}
+		return nil, err
+	}
+	// Referral credits wait for a customer that has a balance
+	if err := creditPendingReferrals(ctx, u.ID); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error applying referral credits")
+	}
+	return c, nil
+}
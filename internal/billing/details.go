@@ -0,0 +1,88 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrInvalidCountry = errors.New("invalid country code")
+	ErrInvalidDetails = errors.New("billing name and address are required")
+)
+
+func billingDetails() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_BILLING_DETAILS)
+}
+
+// Details returns a user's billing details, or nil if they have none
+func Details(ctx context.Context, userID bson.ObjectID) (*schema.BillingDetails, error) {
+	var d schema.BillingDetails
+	err := billingDetails().FindOne(ctx, bson.M{"user_id": userID}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SaveDetails validates and stores a user's billing details. A VAT ID must
+// pass the offline format and checksum validation and belong to the country.
+func SaveDetails(ctx context.Context, userID bson.ObjectID, d schema.BillingDetails) (*schema.BillingDetails, error) {
+	d.Name = strings.TrimSpace(d.Name)
+	d.Country = strings.ToUpper(strings.TrimSpace(d.Country))
+	address := []string{}
+	for _, l := range d.Address {
+		if l = strings.TrimSpace(l); l != "" {
+			address = append(address, l)
+		}
+	}
+	d.Address = address
+	if d.Name == "" || len(d.Address) == 0 {
+		return nil, ErrInvalidDetails
+	}
+	if len(d.Country) != 2 || d.Country[0] < 'A' || d.Country[0] > 'Z' || d.Country[1] < 'A' || d.Country[1] > 'Z' {
+		return nil, ErrInvalidCountry
+	}
+	if d.VATID != "" {
+		id, country, err := tax.ValidateVATID(d.VATID)
+		if err != nil {
+			return nil, err
+		}
+		if country != d.Country {
+			return nil, tax.ErrVATIDCountry
+		}
+		d.VATID = id
+	}
+
+	now := time.Now().UTC()
+	var out schema.BillingDetails
+	err := billingDetails().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"updated_at": now,
+				"name":       d.Name,
+				"address":    d.Address,
+				"country":    d.Country,
+				"vat_id":     d.VATID,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,326 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/suspension"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	DUNNING_JOB      = "dunning"
+	DUNNING_INTERVAL = 15 * time.Minute
+
+	// Final actions when grace ends without payment
+	DUNNING_ACTION_DOWNGRADE = "downgrade" // Cancel the subscription, the user falls back to the default account type
+	DUNNING_ACTION_SUSPEND   = "suspend"   // Cancel the subscription and suspend the account
+
+	REASON_PAYMENT_FAILED = "subscription payment failed"
+)
+
+func dunningCases() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_DUNNING_CASES)
+}
+
+// validateDunning checks that the retry schedule is ascending and fits into
+// the grace period
+func validateDunning(cfg config.DunningConfig) error {
+	for i, d := range cfg.RetryDays {
+		if i > 0 && d <= cfg.RetryDays[i-1] {
+			return errors.New("dunning retry days must be ascending")
+		}
+	}
+	if last := cfg.RetryDays[len(cfg.RetryDays)-1]; last >= cfg.GraceDays {
+		return fmt.Errorf("dunning grace days (%d) must be after the last retry (day %d)", cfg.GraceDays, last)
+	}
+	return nil
+}
+
+// startDunning opens a dunning case for the failed renewal payment of an
+// entitled subscription. Failures while a case is open, such as those of
+// the gateway's own retries, only update the failed invoice.
+func startDunning(ctx context.Context, g gateway.PaymentGateway, event *gateway.Event) error {
+	var s schema.Subscription
+	err := subscriptions().FindOne(ctx, bson.M{"gateway": g.Name(), "external_id": event.SubscriptionID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Warn().Str("gateway", g.Name()).Str("subscription", event.SubscriptionID).Msg("Payment failed for unknown subscription")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// First payments of incomplete subscriptions are retried by the user
+	if !s.Entitled() {
+		return nil
+	}
+
+	cfg := config.GetBillingConfig().Dunning
+	now := time.Now().UTC()
+	failedAt := event.CreatedAt
+	if failedAt.IsZero() {
+		failedAt = now
+	}
+	next := failedAt.AddDate(0, 0, cfg.RetryDays[0])
+	c := schema.DunningCase{
+		ID:             bson.NewObjectID(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		UserID:         s.UserID,
+		SubscriptionID: s.ID,
+		Gateway:        s.Gateway,
+		ExternalID:     s.ExternalID,
+		InvoiceID:      event.InvoiceID,
+		Status:         schema.DUNNING_STATUS_OPEN,
+		Step:           schema.DUNNING_STEP_PAYMENT_FAILED,
+		FailedAt:       failedAt,
+		NextRetryAt:    &next,
+		GraceEndsAt:    failedAt.AddDate(0, 0, cfg.GraceDays),
+	}
+	if _, err := dunningCases().InsertOne(ctx, c); mongo.IsDuplicateKeyError(err) {
+		if event.InvoiceID == "" {
+			return nil
+		}
+		_, err := dunningCases().UpdateOne(ctx,
+			bson.M{"subscription_id": s.ID, "status": schema.DUNNING_STATUS_OPEN},
+			bson.M{"$set": bson.M{"invoice_id": event.InvoiceID, "updated_at": now}},
+		)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := subscriptions().UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": bson.M{"grace_ends_at": c.GraceEndsAt}}); err != nil {
+		return err
+	}
+	notifyPlanListeners(s.UserID)
+	if err := recordDunning(ctx, &c, "", c.Step); err != nil {
+		return err
+	}
+	sendDunning(ctx, &c)
+	return nil
+}
+
+// resolveDunning closes the open dunning case of a subscription, if any
+func resolveDunning(ctx context.Context, gatewayName, externalID string, status schema.DUNNING_STATUS, step schema.DUNNING_STEP) error {
+	var c schema.DunningCase
+	err := dunningCases().FindOne(ctx, bson.M{"gateway": gatewayName, "external_id": externalID, "status": schema.DUNNING_STATUS_OPEN}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ok, err := closeDunning(ctx, &c, status, step)
+	if err != nil || !ok {
+		return err
+	}
+	return recordDunning(ctx, &c, c.Step, step)
+}
+
+// closeDunning moves an open case to its final status and ends the grace of
+// its subscription. It reports false when the case was already closed.
+func closeDunning(ctx context.Context, c *schema.DunningCase, status schema.DUNNING_STATUS, step schema.DUNNING_STEP) (bool, error) {
+	now := time.Now().UTC()
+	res, err := dunningCases().UpdateOne(ctx,
+		bson.M{"_id": c.ID, "status": schema.DUNNING_STATUS_OPEN},
+		bson.M{
+			"$set":   bson.M{"status": status, "step": step, "resolved_at": now, "updated_at": now},
+			"$unset": bson.M{"next_retry_at": ""},
+		},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		return false, err
+	}
+	_, err = subscriptions().UpdateOne(ctx, bson.M{"_id": c.SubscriptionID}, bson.M{"$unset": bson.M{"grace_ends_at": ""}})
+	notifyPlanListeners(c.UserID)
+	return true, err
+}
+
+// RunDunning advances every open dunning case: cases whose payment was
+// collected meanwhile are resolved, due retries are made and cases whose
+// grace ended get the final action
+func RunDunning(ctx context.Context) error {
+	cur, err := dunningCases().Find(ctx, bson.M{"status": schema.DUNNING_STATUS_OPEN})
+	if err != nil {
+		return err
+	}
+	var open []schema.DunningCase
+	if err := cur.All(ctx, &open); err != nil {
+		return err
+	}
+	for i := range open {
+		if err := advanceDunning(ctx, &open[i]); err != nil {
+			log.Error().Err(err).Str("case", open[i].ID.Hex()).Str("user_id", open[i].UserID.Hex()).Msg("Error advancing dunning case")
+		}
+	}
+	return nil
+}
+
+func advanceDunning(ctx context.Context, c *schema.DunningCase) error {
+	g, err := Gateway(c.Gateway)
+	if err != nil {
+		return err
+	}
+	// The gateway may have collected the payment on its own
+	if err := SyncSubscription(ctx, g, c.ExternalID); err != nil {
+		return err
+	}
+	var s schema.Subscription
+	err = subscriptions().FindOne(ctx, bson.M{"_id": c.SubscriptionID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return resolveDunning(ctx, c.Gateway, c.ExternalID, schema.DUNNING_STATUS_CLOSED, schema.DUNNING_STEP_CLOSED)
+	}
+	if err != nil {
+		return err
+	}
+	switch s.Status {
+	case schema.SUBSCRIPTION_STATUS_ACTIVE, schema.SUBSCRIPTION_STATUS_TRIALING:
+		return resolveDunning(ctx, c.Gateway, c.ExternalID, schema.DUNNING_STATUS_RECOVERED, schema.DUNNING_STEP_RECOVERED)
+	case schema.SUBSCRIPTION_STATUS_CANCELED, schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED:
+		return resolveDunning(ctx, c.Gateway, c.ExternalID, schema.DUNNING_STATUS_CLOSED, schema.DUNNING_STEP_CLOSED)
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(c.GraceEndsAt) {
+		return finishDunning(ctx, g, c, &s)
+	}
+	if c.NextRetryAt != nil && !now.Before(*c.NextRetryAt) {
+		return retryDunning(ctx, g, c)
+	}
+	return nil
+}
+
+// retryDunning makes the next scheduled payment retry. The attempt is
+// claimed first so that it is made once even if the job overlaps itself.
+func retryDunning(ctx context.Context, g gateway.PaymentGateway, c *schema.DunningCase) error {
+	cfg := config.GetBillingConfig().Dunning
+	attempt := c.Attempts + 1
+	set := bson.M{"attempts": attempt, "updated_at": time.Now().UTC()}
+	update := bson.M{"$set": set}
+	if attempt < len(cfg.RetryDays) {
+		next := c.FailedAt.AddDate(0, 0, cfg.RetryDays[attempt])
+		set["next_retry_at"] = next
+		c.NextRetryAt = &next
+	} else {
+		update["$unset"] = bson.M{"next_retry_at": ""}
+		c.NextRetryAt = nil
+	}
+	res, err := dunningCases().UpdateOne(ctx, bson.M{"_id": c.ID, "status": schema.DUNNING_STATUS_OPEN, "attempts": c.Attempts}, update)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+	c.Attempts = attempt
+
+	err = g.RetryPayment(ctx, c.ExternalID, c.InvoiceID, fmt.Sprintf("dunning-%s-%d", c.ID.Hex(), attempt))
+	if err == nil {
+		// Resolved by the paid webhook, or by the next run once the gateway
+		// reports the subscription active
+		log.Info().Str("case", c.ID.Hex()).Int("attempt", attempt).Msg("Dunning retry succeeded")
+		return SyncSubscription(ctx, g, c.ExternalID)
+	}
+
+	log.Info().Err(err).Str("case", c.ID.Hex()).Int("attempt", attempt).Msg("Dunning retry failed")
+	if _, err := dunningCases().UpdateOne(ctx,
+		bson.M{"_id": c.ID},
+		bson.M{"$set": bson.M{"last_error": err.Error(), "step": schema.DUNNING_STEP_RETRY_FAILED}},
+	); err != nil {
+		return err
+	}
+	from := c.Step
+	c.Step, c.LastError = schema.DUNNING_STEP_RETRY_FAILED, err.Error()
+	if err := recordDunning(ctx, c, from, c.Step); err != nil {
+		return err
+	}
+	sendDunning(ctx, c)
+	return nil
+}
+
+// finishDunning takes the final action when grace ended without payment.
+// The subscription is canceled at the gateway, so the user falls back to
+// the default account type and is not charged again.
+func finishDunning(ctx context.Context, g gateway.PaymentGateway, c *schema.DunningCase, s *schema.Subscription) error {
+	action := config.GetBillingConfig().Dunning.FinalAction
+	gs, err := g.CancelSubscription(ctx, c.ExternalID, false)
+	if err != nil {
+		return err
+	}
+	s.GraceEndsAt = nil
+	if _, err := save(ctx, s, gs, history.Meta{}); err != nil {
+		return err
+	}
+
+	step := schema.DUNNING_STEP_DOWNGRADED
+	if action == DUNNING_ACTION_SUSPEND {
+		step = schema.DUNNING_STEP_SUSPENDED
+		if _, err := suspension.Suspend(ctx, suspension.Request{
+			UserID: c.UserID,
+			Actor:  users.SystemActor(),
+			Reason: REASON_PAYMENT_FAILED,
+		}); err != nil {
+			return err
+		}
+	}
+	ok, err := closeDunning(ctx, c, schema.DUNNING_STATUS_EXHAUSTED, step)
+	if err != nil || !ok {
+		return err
+	}
+	from := c.Step
+	c.Step = step
+	if err := recordDunning(ctx, c, from, step); err != nil {
+		return err
+	}
+	sendDunning(ctx, c)
+	return nil
+}
+
+// recordDunning records a dunning step in AccountHistory
+func recordDunning(ctx context.Context, c *schema.DunningCase, from, to schema.DUNNING_STEP) error {
+	return history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    c.UserID,
+		EventType: schema.ACCOUNT_EVENT_DUNNING,
+		Field:     "dunning",
+		OldValue:  string(from),
+		NewValue:  string(to),
+		ChangedBy: history.SYSTEM_ACTOR,
+	})
+}
+
+// sendDunning emails the user about the current step of a case. Failures
+// are only logged: the schedule goes on regardless.
+func sendDunning(ctx context.Context, c *schema.DunningCase) {
+	u, err := users.FindByID(ctx, c.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", c.UserID.Hex()).Msg("Error loading user for dunning email")
+		return
+	}
+	err = mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_BILLING,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_DUNNING,
+		Template: "dunning",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Step":        string(c.Step),
+			"Attempts":    c.Attempts,
+			"NextRetryAt": c.NextRetryAt,
+			"GraceEndsAt": c.GraceEndsAt,
+			"Link":        config.GetSiteConfig().URL + "/billing",
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", c.UserID.Hex()).Msg("Error sending dunning email")
+	}
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// Gateway names used in configuration and stored on billing records
+const (
+	STRIPE = "stripe"
+	PAYPAL = "paypal"
+	CRYPTO = "crypto"
+)
+
+var (
+	ErrUnsupported      = errors.New("operation not supported by this payment gateway")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// PaymentGateway is implemented by every payment processor. Billing logic only
+// talks to this interface, so a plan can move between processors without
+// changes elsewhere. All amounts are in the currency's minor unit.
+type PaymentGateway interface {
+	// Name returns the gateway name stored on billing records
+	Name() string
+
+	CreateCustomer(ctx context.Context, p CustomerParams) (string, error)
+	DeleteCustomer(ctx context.Context, customerID string) error
+	AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error
+
+	Charge(ctx context.Context, p ChargeParams) (*Charge, error)
+	Refund(ctx context.Context, p RefundParams) (*Refund, error)
+
+	CreateSubscription(ctx context.Context, p SubscriptionParams) (*Subscription, error)
+	GetSubscription(ctx context.Context, id string) (*Subscription, error)
+	// ChangeSubscriptionPrice moves the subscription to another price
+	ChangeSubscriptionPrice(ctx context.Context, id, priceID, idempotencyKey string) (*Subscription, error)
+	// ChangeSubscriptionQuantity sets the number of seats billed, prorating the difference
+	ChangeSubscriptionQuantity(ctx context.Context, id string, quantity int64, idempotencyKey string) (*Subscription, error)
+	CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*Subscription, error)
+	ResumeSubscription(ctx context.Context, id string) (*Subscription, error)
+	// RetryPayment collects the outstanding amount of a subscription again,
+	// from the invoice whose payment failed where the gateway has invoices
+	RetryPayment(ctx context.Context, subscriptionID, invoiceID, idempotencyKey string) error
+	// CreditBalance credits a customer's balance, which pays future invoices first
+	CreditBalance(ctx context.Context, p CreditParams) error
+
+	// ParseWebhook verifies a webhook delivery and decodes it into an event
+	ParseWebhook(ctx context.Context, payload []byte, header http.Header) (*Event, error)
+}
+
+// CustomerParams describe the customer created for a user
+type CustomerParams struct {
+	UserID string
+	Email  string
+	Name   string
+	// IdempotencyKey makes retried creation return the same customer
+	IdempotencyKey string
+}
+
+// ChargeParams describe a one-off payment with a saved payment method
+type ChargeParams struct {
+	CustomerID      string
+	PaymentMethodID string
+	Amount          int64
+	Currency        string
+	Description     string
+	IdempotencyKey  string
+	Reference       string // Returned in the webhook event of the charge, e.g. "topup:<user id>"
+}
+
+// Charge is the outcome of a one-off payment
+type Charge struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// RefundParams describe a full (Amount 0) or partial refund of a charge
+type RefundParams struct {
+	ChargeID       string
+	Amount         int64
+	Currency       string
+	Reason         string
+	IdempotencyKey string
+}
+
+// Refund is the outcome of a refund
+type Refund struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount int64  `json:"amount"`
+}
+
+// SubscriptionParams describe a new subscription
+type SubscriptionParams struct {
+	CustomerID     string
+	PriceID        string
+	TrialDays      int
+	UserID         string
+	Email          string
+	Name           string
+	ReturnURL      string // Where redirect-based gateways send the user after approval
+	CancelURL      string // Where redirect-based gateways send the user on abort
+	Country        string // Tax country of the customer, for gateways that price locally
+	CouponID       string // Gateway coupon discounting the subscription
+	IdempotencyKey string
+}
+
+// CreditParams describe a credit to a customer's balance
+type CreditParams struct {
+	CustomerID     string
+	Amount         int64
+	Currency       string
+	Description    string
+	IdempotencyKey string
+}
+
+// Subscription is the gateway-neutral state of a subscription
+type Subscription struct {
+	ID                 string
+	CustomerID         string
+	PriceID            string
+	Quantity           int64 // Seats billed, 0 when the gateway has no quantities
+	Status             schema.SUBSCRIPTION_STATUS
+	CurrentPeriodStart *time.Time
+	CurrentPeriodEnd   *time.Time
+	TrialEnd           *time.Time
+	CancelAtPeriodEnd  bool
+	CanceledAt         *time.Time
+
+	// Set on creation when the user still has to act
+	ClientSecret string // Confirm the first payment in the browser (Stripe)
+	ApprovalURL  string // Approve or pay the subscription on the gateway's site (PayPal, crypto)
+}
+
+type EVENT_KIND string
+
+const (
+	EVENT_KIND_SUBSCRIPTION EVENT_KIND = "subscription" // Subscription created, changed or ended
+	EVENT_KIND_INVOICE      EVENT_KIND = "invoice"      // Invoice created, paid or failed
+	EVENT_KIND_PAYMENT      EVENT_KIND = "payment"      // Payment succeeded or failed
+	EVENT_KIND_OTHER        EVENT_KIND = "other"        // Not handled by brain
+)
+
+// Event is a verified webhook event
+type Event struct {
+	ID             string     // Unique event ID at the gateway
+	Type           string     // Gateway-specific event type
+	Kind           EVENT_KIND // Gateway-neutral category
+	CreatedAt      time.Time
+	SubscriptionID string // Subscription affected by the event, if any
+	InvoiceID      string // Invoice affected by the event, if any
+	CustomerID     string // Customer affected by the event, if any
+
+	// Set when a subscription payment failed
+	PaymentFailed bool
+
+	// Set when the event reports a collected subscription payment
+	Paid      bool
+	PaymentID string // Gateway invoice or sale ID, unique per payment
+	Amount    int64  // Amount collected in minor units
+	Currency  string // ISO 4217 code, lowercase
+
+	// Set when a one-off charge succeeded after Charge returned, e.g. a
+	// bank debit that was still processing
+	Charge          *Charge
+	ChargeReference string // ChargeParams.Reference of the charge
+}
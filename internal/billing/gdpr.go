@@ -0,0 +1,74 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// HOLD_SUBSCRIPTION prevents system deletion of accounts with an entitled subscription
+const HOLD_SUBSCRIPTION = "active_subscription"
+
+func hasActiveSubscription(ctx context.Context, u *schema.User) (bool, error) {
+	s, err := CurrentSubscription(ctx, u.ID)
+	if errors.Is(err, ErrNoSubscription) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.Entitled(), nil
+}
+
+// gatewayProcessor cancels the remaining subscriptions of an erased user at
+// one payment gateway and deletes the customer with its payment methods
+type gatewayProcessor struct {
+	gateway gateway.PaymentGateway
+}
+
+func (p gatewayProcessor) Name() string {
+	return p.gateway.Name()
+}
+
+func (p gatewayProcessor) Erase(ctx context.Context, u *schema.User, dryRun bool) (string, error) {
+	c, err := FindCustomer(ctx, u.ID, p.gateway.Name())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "no " + p.gateway.Name() + " customer", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if dryRun {
+		return "would delete " + p.gateway.Name() + " customer", nil
+	}
+
+	cur, err := subscriptions().Find(ctx, bson.M{
+		"user_id": u.ID,
+		"gateway": p.gateway.Name(),
+		"status": bson.M{"$nin": bson.A{
+			schema.SUBSCRIPTION_STATUS_CANCELED,
+			schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	var open []schema.Subscription
+	if err := cur.All(ctx, &open); err != nil {
+		return "", err
+	}
+	for _, s := range open {
+		if _, err := p.gateway.CancelSubscription(ctx, s.ExternalID, false); err != nil {
+			return "", err
+		}
+	}
+
+	if err := p.gateway.DeleteCustomer(ctx, c.ExternalID); err != nil {
+		return "", err
+	}
+	return "deleted " + p.gateway.Name() + " customer", nil
+}
@@ -0,0 +1,100 @@
+package invoice
+
+import (
+	"sort"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// computeTotals derives line amounts, discounts, taxes and totals. Invoice
+// discounts are spread over the lines in proportion to their net amount
+// before tax, and tax is computed once per rate on the summed base.
+func computeTotals(inv *schema.Invoice) {
+	net := make([]int64, len(inv.Lines))
+	var subtotal, lineDiscounts, sumNet int64
+	for i := range inv.Lines {
+		l := &inv.Lines[i]
+		l.Amount = l.Quantity * l.UnitAmount
+		subtotal += l.Amount
+		lineDiscounts += l.Discount
+		net[i] = l.Amount - l.Discount
+		sumNet += net[i]
+	}
+
+	remaining := sumNet
+	var invoiceDiscounts int64
+	for i := range inv.Discounts {
+		d := &inv.Discounts[i]
+		d.Applied = d.Amount
+		if d.Percent > 0 {
+			d.Applied = roundDiv(sumNet*d.Percent, 10000)
+		}
+		d.Applied = min(d.Applied, remaining)
+		remaining -= d.Applied
+		invoiceDiscounts += d.Applied
+	}
+
+	taxable := allocate(net, invoiceDiscounts)
+	bases := map[int64]int64{}
+	for i, l := range inv.Lines {
+		bases[l.TaxRate] += taxable[i]
+	}
+	rates := make([]int64, 0, len(bases))
+	for r := range bases {
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i] < rates[j] })
+
+	inv.Taxes = []schema.InvoiceTax{}
+	var taxTotal int64
+	for _, r := range rates {
+		t := schema.InvoiceTax{Rate: r, Base: bases[r], Amount: roundDiv(bases[r]*r, 10000)}
+		taxTotal += t.Amount
+		inv.Taxes = append(inv.Taxes, t)
+	}
+
+	inv.Subtotal = subtotal
+	inv.DiscountTotal = lineDiscounts + invoiceDiscounts
+	inv.TaxTotal = taxTotal
+	inv.Total = subtotal - inv.DiscountTotal + taxTotal
+}
+
+// allocate subtracts discount from the amounts in proportion to their size,
+// handing out the rounding remainder by largest fraction so the parts add up
+func allocate(amounts []int64, discount int64) []int64 {
+	out := append([]int64(nil), amounts...)
+	var total int64
+	for _, a := range amounts {
+		total += a
+	}
+	if discount == 0 || total == 0 {
+		return out
+	}
+
+	type share struct {
+		i   int
+		rem int64
+	}
+	shares := make([]share, len(amounts))
+	var given int64
+	for i, a := range amounts {
+		part := a * discount / total
+		out[i] -= part
+		given += part
+		shares[i] = share{i, a * discount % total}
+	}
+	sort.SliceStable(shares, func(a, b int) bool { return shares[a].rem > shares[b].rem })
+	for k := 0; given < discount; k++ {
+		out[shares[k%len(shares)].i]--
+		given++
+	}
+	return out
+}
+
+// roundDiv divides rounding half away from zero
+func roundDiv(n, d int64) int64 {
+	if n < 0 {
+		return -((-n + d/2) / d)
+	}
+	return (n + d/2) / d
+}
@@ -0,0 +1,95 @@
+package invoice
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CreateCreditNote creates a draft credit note for a finalized invoice. With
+// no lines it credits the whole invoice, otherwise the given lines; further
+// lines can be added while it is a draft.
+func CreateCreditNote(ctx context.Context, invoiceID bson.ObjectID, lines []schema.InvoiceLine, note string) (*schema.Invoice, error) {
+	orig, err := Get(ctx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if orig.Kind != schema.INVOICE_KIND_INVOICE || !finalized(orig) {
+		return nil, ErrNotCreditable
+	}
+
+	now := time.Now().UTC()
+	cn := &schema.Invoice{
+		ID:                bson.NewObjectID(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		UserID:            orig.UserID,
+		Entity:            orig.Entity,
+		Kind:              schema.INVOICE_KIND_CREDIT_NOTE,
+		Status:            schema.INVOICE_STATUS_DRAFT,
+		Currency:          orig.Currency,
+		Note:              note,
+		Customer:          orig.Customer,
+		Lines:             lines,
+		Discounts:         []schema.InvoiceDiscount{},
+		CreditedInvoiceID: &orig.ID,
+		SubscriptionID:    orig.SubscriptionID,
+	}
+	if len(lines) == 0 {
+		cn.Lines = append([]schema.InvoiceLine{}, orig.Lines...)
+		cn.Discounts = append(cn.Discounts, orig.Discounts...)
+	}
+	for _, l := range cn.Lines {
+		if l.Description == "" || l.Quantity <= 0 || l.UnitAmount < 0 || l.Discount < 0 || l.TaxRate < 0 {
+			return nil, ErrInvalidLine
+		}
+	}
+	computeTotals(cn)
+	if cn.Total > orig.Total-orig.CreditedTotal {
+		return nil, ErrCreditExceedsInvoice
+	}
+	if _, err := invoices().InsertOne(ctx, cn); err != nil {
+		return nil, err
+	}
+	return cn, nil
+}
+
+// applyCredit adds a finalizing credit note to its invoice. It matches only
+// while the invoice has enough left to credit and does not list the credit
+// note yet, so repeating it has no effect.
+func applyCredit(ctx context.Context, cn *schema.Invoice) error {
+	if cn.CreditedInvoiceID == nil {
+		return ErrNotCreditable
+	}
+	res, err := invoices().UpdateOne(ctx,
+		bson.M{
+			"_id":          *cn.CreditedInvoiceID,
+			"credit_notes": bson.M{"$ne": cn.ID},
+			"$expr":        bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$total", "$credited_total"}}, cn.Total}},
+		},
+		bson.M{
+			"$inc":  bson.M{"credited_total": cn.Total},
+			"$push": bson.M{"credit_notes": cn.ID},
+			"$set":  bson.M{"updated_at": time.Now().UTC()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	orig, err := Get(ctx, *cn.CreditedInvoiceID)
+	if err != nil {
+		return err
+	}
+	for _, id := range orig.CreditNotes {
+		if id == cn.ID {
+			return nil
+		}
+	}
+	return ErrCreditExceedsInvoice
+}
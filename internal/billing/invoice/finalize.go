@@ -0,0 +1,291 @@
+package invoice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	RESUME_JOB      = "invoice_finalization"
+	RESUME_INTERVAL = time.Minute
+	// Finalizations idle for longer than this are considered interrupted
+	RESUME_AFTER = 2 * time.Minute
+
+	maxNumberAttempts = 20
+)
+
+// Finalize numbers and renders a draft, after which it can no longer change.
+// Finalization runs in steps that can all be repeated: an invoice left in
+// the finalizing status by a crash is completed with the number it had
+// already reserved, either by calling Finalize again or by ResumeFinalizations.
+func Finalize(ctx context.Context, id bson.ObjectID) (*schema.Invoice, error) {
+	inv, err := Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch inv.Status {
+	case schema.INVOICE_STATUS_DRAFT:
+		if inv, err = begin(ctx, inv); err != nil {
+			return nil, err
+		}
+	case schema.INVOICE_STATUS_FINALIZING:
+	default:
+		return nil, ErrNotDraft
+	}
+	return complete(ctx, inv)
+}
+
+// begin freezes the draft: it takes the seller and customer snapshots,
+// computes the totals and applies a credit note to its invoice
+func begin(ctx context.Context, inv *schema.Invoice) (*schema.Invoice, error) {
+	if len(inv.Lines) == 0 {
+		return nil, ErrEmptyInvoice
+	}
+	entity, err := config.GetLegalEntity(inv.Entity)
+	if err != nil {
+		return nil, err
+	}
+	u, err := users.FindByID(ctx, inv.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	inv.Seller = schema.InvoiceParty{
+		Name:    entity.Name,
+		Email:   entity.Email,
+		Address: entity.Address,
+		Country: entity.Country,
+		VATID:   entity.VATID,
+	}
+	if inv.Customer.Name == "" {
+		inv.Customer.Name = u.DisplayName
+	}
+	if inv.Customer.Email == "" {
+		inv.Customer.Email = u.Email
+	}
+	inv.Locale = u.Locale
+	computeTotals(inv)
+	inv.IssuedAt = &now
+	inv.Year = now.Year()
+	if inv.Kind == schema.INVOICE_KIND_INVOICE {
+		due := now.AddDate(0, 0, config.GetBillingConfig().Invoicing.PaymentTermDays)
+		inv.DueAt = &due
+	}
+	inv.Status = schema.INVOICE_STATUS_FINALIZING
+	inv.UpdatedAt = now
+
+	res, err := invoices().ReplaceOne(ctx, bson.M{"_id": inv.ID, "status": schema.INVOICE_STATUS_DRAFT}, inv)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		// Finalized concurrently, continue with the stored state
+		if inv, err = Get(ctx, inv.ID); err != nil {
+			return nil, err
+		}
+		if inv.Status != schema.INVOICE_STATUS_FINALIZING {
+			return nil, ErrNotDraft
+		}
+		return inv, nil
+	}
+
+	if inv.Kind == schema.INVOICE_KIND_CREDIT_NOTE {
+		if err := applyCredit(ctx, inv); err != nil {
+			// Nothing was numbered yet, so the credit note can go back to draft
+			if _, rerr := invoices().UpdateOne(ctx,
+				bson.M{"_id": inv.ID, "status": schema.INVOICE_STATUS_FINALIZING},
+				bson.M{"$set": bson.M{"status": schema.INVOICE_STATUS_DRAFT}},
+			); rerr != nil {
+				log.Error().Err(rerr).Str("invoice_id", inv.ID.Hex()).Msg("Error reverting credit note to draft")
+			}
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// complete numbers, renders and stores a finalizing invoice
+func complete(ctx context.Context, inv *schema.Invoice) (*schema.Invoice, error) {
+	if inv.Kind == schema.INVOICE_KIND_CREDIT_NOTE {
+		// Repeats safely when resuming an interrupted finalization
+		if err := applyCredit(ctx, inv); err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := reserveNumber(ctx, inv)
+	if err != nil {
+		return nil, err
+	}
+	entity, err := config.GetLegalEntity(inv.Entity)
+	if err != nil {
+		return nil, err
+	}
+	prefix := entity.InvoicePrefix
+	if inv.Kind == schema.INVOICE_KIND_CREDIT_NOTE {
+		prefix = entity.CreditNotePrefix
+	}
+	inv.Number = fmt.Sprintf("%s%d-%06d", prefix, n.Year, n.Sequence)
+	inv.Year = n.Year
+	inv.Sequence = n.Sequence
+
+	var creditedNumber string
+	if inv.CreditedInvoiceID != nil {
+		orig, err := Get(ctx, *inv.CreditedInvoiceID)
+		if err != nil {
+			return nil, err
+		}
+		creditedNumber = orig.Number
+	}
+	pdf, html, err := Render(inv, creditedNumber)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(pdf)
+	inv.DocumentHash = hex.EncodeToString(sum[:])
+	if err := storeDocument(ctx, inv.ID, schema.INVOICE_FORMAT_PDF, pdf); err != nil {
+		return nil, err
+	}
+	if err := storeDocument(ctx, inv.ID, schema.INVOICE_FORMAT_HTML, html); err != nil {
+		return nil, err
+	}
+
+	inv.Status = schema.INVOICE_STATUS_OPEN
+	if inv.Kind == schema.INVOICE_KIND_CREDIT_NOTE {
+		inv.Status = schema.INVOICE_STATUS_ISSUED
+	}
+	inv.UpdatedAt = time.Now().UTC()
+	_, err = invoices().UpdateOne(ctx,
+		bson.M{"_id": inv.ID, "status": schema.INVOICE_STATUS_FINALIZING},
+		bson.M{"$set": bson.M{
+			"number":        inv.Number,
+			"year":          inv.Year,
+			"sequence":      inv.Sequence,
+			"document_hash": inv.DocumentHash,
+			"status":        inv.Status,
+			"updated_at":    inv.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("invoice_id", inv.ID.Hex()).Str("number", inv.Number).Int64("total", inv.Total).Msg("Finalized invoice")
+	return inv, nil
+}
+
+// reserveNumber returns the number reserved for an invoice, reserving the
+// next number of its sequence on first call. Sequences are per legal entity,
+// kind and year, and the unique index on the sequence makes concurrent
+// finalizations retry with the following number instead of sharing one.
+func reserveNumber(ctx context.Context, inv *schema.Invoice) (*schema.InvoiceNumber, error) {
+	numbers := database.Collection(schema.COLLECTION_INVOICE_NUMBERS)
+	var n schema.InvoiceNumber
+	err := numbers.FindOne(ctx, bson.M{"invoice_id": inv.ID}).Decode(&n)
+	if err == nil {
+		return &n, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	for range maxNumberAttempts {
+		var last schema.InvoiceNumber
+		err := numbers.FindOne(ctx,
+			bson.M{"entity": inv.Entity, "kind": inv.Kind, "year": inv.Year},
+			options.FindOne().SetSort(bson.M{"sequence": -1}),
+		).Decode(&last)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		n = schema.InvoiceNumber{
+			ID:        bson.NewObjectID(),
+			CreatedAt: time.Now().UTC(),
+			Entity:    inv.Entity,
+			Kind:      inv.Kind,
+			Year:      inv.Year,
+			Sequence:  last.Sequence + 1,
+			InvoiceID: inv.ID,
+		}
+		_, err = numbers.InsertOne(ctx, n)
+		if err == nil {
+			return &n, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		// Either the sequence was taken or this invoice was numbered concurrently
+		if err := numbers.FindOne(ctx, bson.M{"invoice_id": inv.ID}).Decode(&n); err == nil {
+			return &n, nil
+		}
+	}
+	return nil, fmt.Errorf("could not reserve an invoice number after %d attempts", maxNumberAttempts)
+}
+
+func storeDocument(ctx context.Context, invoiceID bson.ObjectID, format schema.INVOICE_FORMAT, data []byte) error {
+	sum := sha256.Sum256(data)
+	_, err := database.Collection(schema.COLLECTION_INVOICE_DOCUMENTS).ReplaceOne(ctx,
+		bson.M{"invoice_id": invoiceID, "format": format},
+		schema.InvoiceDocument{
+			ID:        bson.NewObjectID(),
+			CreatedAt: time.Now().UTC(),
+			InvoiceID: invoiceID,
+			Format:    format,
+			Data:      data,
+			SHA256:    hex.EncodeToString(sum[:]),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Document returns a rendered document of a finalized invoice
+func Document(ctx context.Context, invoiceID bson.ObjectID, format schema.INVOICE_FORMAT) (*schema.InvoiceDocument, error) {
+	var d schema.InvoiceDocument
+	err := database.Collection(schema.COLLECTION_INVOICE_DOCUMENTS).FindOne(ctx,
+		bson.M{"invoice_id": invoiceID, "format": format},
+	).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ResumeFinalizations completes finalizations interrupted by a crash. It is
+// run by the scheduler under a lease.
+func ResumeFinalizations(ctx context.Context) error {
+	cur, err := invoices().Find(ctx, bson.M{
+		"status":     schema.INVOICE_STATUS_FINALIZING,
+		"updated_at": bson.M{"$lte": time.Now().UTC().Add(-RESUME_AFTER)},
+	})
+	if err != nil {
+		return err
+	}
+	var stuck []schema.Invoice
+	if err := cur.All(ctx, &stuck); err != nil {
+		return err
+	}
+	for i := range stuck {
+		if _, err := complete(ctx, &stuck[i]); err != nil {
+			log.Error().Err(err).Str("invoice_id", stuck[i].ID.Hex()).Msg("Error resuming invoice finalization")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrNotFound             = errors.New("invoice not found")
+	ErrNotDraft             = errors.New("invoice is finalized and cannot be changed")
+	ErrNotOpen              = errors.New("invoice is not open")
+	ErrEmptyInvoice         = errors.New("invoice has no lines")
+	ErrInvalidLine          = errors.New("invalid invoice line")
+	ErrInvalidDiscount      = errors.New("invalid invoice discount")
+	ErrCreditExceedsInvoice = errors.New("credit note exceeds the remaining invoice amount")
+	ErrNotCreditable        = errors.New("only finalized invoices can be credited")
+)
+
+func invoices() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_INVOICES)
+}
+
+// Draft describes a new invoice
+type Draft struct {
+	UserID         bson.ObjectID
+	Entity         string // Issuing legal entity, the configured default when empty
+	Currency       string
+	Note           string
+	SubscriptionID *bson.ObjectID
+	Gateway        string
+	PaymentID      string
+
+	TaxCountry      string // Country whose VAT rules applied to the lines
+	TaxTreatment    string // Applied VAT rule, printed on reverse charge and exports
+	TaxRatesVersion string
+}
+
+// Create stores a new draft invoice
+func Create(ctx context.Context, d Draft) (*schema.Invoice, error) {
+	if d.Entity == "" {
+		d.Entity = config.GetBillingConfig().Invoicing.DefaultEntity
+	}
+	if _, err := config.GetLegalEntity(d.Entity); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	inv := &schema.Invoice{
+		ID:             bson.NewObjectID(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		UserID:         d.UserID,
+		Entity:         d.Entity,
+		Kind:           schema.INVOICE_KIND_INVOICE,
+		Status:         schema.INVOICE_STATUS_DRAFT,
+		Currency:       d.Currency,
+		Note:           d.Note,
+		Lines:          []schema.InvoiceLine{},
+		Discounts:      []schema.InvoiceDiscount{},
+		Taxes:          []schema.InvoiceTax{},
+		SubscriptionID: d.SubscriptionID,
+		Gateway:        d.Gateway,
+		PaymentID:      d.PaymentID,
+
+		TaxCountry:      d.TaxCountry,
+		TaxTreatment:    d.TaxTreatment,
+		TaxRatesVersion: d.TaxRatesVersion,
+	}
+	if _, err := invoices().InsertOne(ctx, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Get returns an invoice
+func Get(ctx context.Context, id bson.ObjectID) (*schema.Invoice, error) {
+	var inv schema.Invoice
+	err := invoices().FindOne(ctx, bson.M{"_id": id}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// FindByPayment returns the invoice of a gateway payment
+func FindByPayment(ctx context.Context, gatewayName, paymentID string) (*schema.Invoice, error) {
+	var inv schema.Invoice
+	err := invoices().FindOne(ctx, bson.M{"gateway": gatewayName, "payment_id": paymentID}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// GetForUser returns a finalized invoice of a user
+func GetForUser(ctx context.Context, userID, id bson.ObjectID) (*schema.Invoice, error) {
+	inv, err := Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.UserID != userID || !finalized(inv) {
+		return nil, ErrNotFound
+	}
+	return inv, nil
+}
+
+// ListForUser returns a user's finalized invoices and credit notes, newest first
+func ListForUser(ctx context.Context, userID bson.ObjectID) ([]schema.Invoice, error) {
+	cur, err := invoices().Find(ctx,
+		bson.M{"user_id": userID, "status": bson.M{"$in": bson.A{
+			schema.INVOICE_STATUS_OPEN, schema.INVOICE_STATUS_PAID, schema.INVOICE_STATUS_ISSUED,
+		}}},
+		options.Find().SetSort(bson.D{{Key: "issued_at", Value: -1}, {Key: "_id", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := []schema.Invoice{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func finalized(inv *schema.Invoice) bool {
+	switch inv.Status {
+	case schema.INVOICE_STATUS_OPEN, schema.INVOICE_STATUS_PAID, schema.INVOICE_STATUS_ISSUED:
+		return true
+	}
+	return false
+}
+
+// AddLine appends a line to a draft
+func AddLine(ctx context.Context, id bson.ObjectID, line schema.InvoiceLine) (*schema.Invoice, error) {
+	if line.Description == "" || line.Quantity <= 0 || line.UnitAmount < 0 || line.Discount < 0 ||
+		line.Discount > line.Quantity*line.UnitAmount || line.TaxRate < 0 {
+		return nil, ErrInvalidLine
+	}
+	return updateDraft(ctx, id, func(inv *schema.Invoice) error {
+		inv.Lines = append(inv.Lines, line)
+		return nil
+	})
+}
+
+// AddDiscount adds an invoice-level discount to a draft
+func AddDiscount(ctx context.Context, id bson.ObjectID, d schema.InvoiceDiscount) (*schema.Invoice, error) {
+	if d.Description == "" || d.Amount < 0 || d.Percent < 0 || d.Percent > 10000 || (d.Amount > 0) == (d.Percent > 0) {
+		return nil, ErrInvalidDiscount
+	}
+	return updateDraft(ctx, id, func(inv *schema.Invoice) error {
+		inv.Discounts = append(inv.Discounts, d)
+		return nil
+	})
+}
+
+// SetCustomer overrides the customer details printed on a draft, which
+// otherwise come from the user on finalization
+func SetCustomer(ctx context.Context, id bson.ObjectID, customer schema.InvoiceParty) (*schema.Invoice, error) {
+	return updateDraft(ctx, id, func(inv *schema.Invoice) error {
+		inv.Customer = customer
+		return nil
+	})
+}
+
+// updateDraft applies a change to a draft and recomputes its totals. The
+// write only matches while the invoice is still a draft, which is what keeps
+// finalized invoices immutable.
+func updateDraft(ctx context.Context, id bson.ObjectID, change func(*schema.Invoice) error) (*schema.Invoice, error) {
+	inv, err := Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != schema.INVOICE_STATUS_DRAFT {
+		return nil, ErrNotDraft
+	}
+	if err := change(inv); err != nil {
+		return nil, err
+	}
+	computeTotals(inv)
+	inv.UpdatedAt = time.Now().UTC()
+
+	res, err := invoices().ReplaceOne(ctx, bson.M{"_id": id, "status": schema.INVOICE_STATUS_DRAFT}, inv)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrNotDraft
+	}
+	return inv, nil
+}
+
+// MarkPaid records the payment of an open invoice
+func MarkPaid(ctx context.Context, id bson.ObjectID, paidAt time.Time) (*schema.Invoice, error) {
+	var inv schema.Invoice
+	err := invoices().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": schema.INVOICE_STATUS_OPEN},
+		bson.M{"$set": bson.M{
+			"status":     schema.INVOICE_STATUS_PAID,
+			"paid_at":    paidAt.UTC(),
+			"updated_at": time.Now().UTC(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotOpen
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
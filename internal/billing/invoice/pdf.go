@@ -0,0 +1,186 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A4 in points
+const (
+	pageWidth  = 595
+	pageHeight = 842
+)
+
+// Fonts available to the PDF templates, both standard Type 1 fonts every
+// reader provides, so nothing has to be embedded
+const (
+	fontRegular = "R"
+	fontBold    = "B"
+)
+
+var pdfFonts = map[string]string{fontRegular: "Helvetica", fontBold: "Helvetica-Bold"}
+
+// buildPDF assembles content streams, one per page, into a PDF document.
+// The output only depends on its input, so re-rendering an invoice yields
+// the same bytes and the same hash.
+func buildPDF(title string, created time.Time, pages [][]byte) []byte {
+	var objects [][]byte
+	add := func(format string, args ...any) int {
+		objects = append(objects, fmt.Appendf(nil, format, args...))
+		return len(objects)
+	}
+	reserve := func() int {
+		objects = append(objects, nil)
+		return len(objects)
+	}
+
+	catalog := reserve()
+	pagesObj := reserve()
+	regular := add("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", pdfFonts[fontRegular])
+	bold := add("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", pdfFonts[fontBold])
+
+	var kids []string
+	for _, content := range pages {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		_, _ = w.Write(content)
+		_ = w.Close()
+		stream := add("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", z.Len(), z.Bytes())
+		page := add("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /%s %d 0 R /%s %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pageWidth, pageHeight, fontRegular, regular, fontBold, bold, stream)
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	objects[catalog-1] = fmt.Appendf(nil, "<< /Type /Catalog /Pages %d 0 R >>", pagesObj)
+	objects[pagesObj-1] = fmt.Appendf(nil, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+	info := add("<< /Title (%s) /Producer (brain) /CreationDate (D:%s) >>", pdfEscape(title), created.UTC().Format("20060102150405Z"))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(obj)
+		buf.WriteString("\nendobj\n")
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, catalog, info, xref)
+	return buf.Bytes()
+}
+
+// pdfText draws s with its baseline starting at x, y
+func pdfText(x, y int, size float64, font, s string) string {
+	return drawText(float64(x), float64(y), size, font, s)
+}
+
+// pdfRightText draws s so that it ends at x
+func pdfRightText(x, y int, size float64, font, s string) string {
+	return drawText(float64(x)-textWidth(s, size, font), float64(y), size, font, s)
+}
+
+func drawText(x, y, size float64, font, s string) string {
+	return fmt.Sprintf("BT /%s %g Tf %.2f %g Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+// pdfFit shortens s with an ellipsis until it fits into width
+func pdfFit(width int, size float64, font, s string) string {
+	if textWidth(s, size, font) <= float64(width) {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && textWidth(string(r)+"…", size, font) > float64(width) {
+		r = r[:len(r)-1]
+	}
+	return strings.TrimSpace(string(r)) + "…"
+}
+
+// pdfLine draws a horizontal rule between x1 and x2
+func pdfLine(x1, x2, y int) string {
+	return fmt.Sprintf("0.5 w 0.6 G %d %d m %d %d l S 0 G\n", x1, y, x2, y)
+}
+
+// pdfEscape encodes s as a WinAnsi string literal body. Characters outside
+// the encoding are replaced with a question mark.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := winAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// winAnsiExtra maps the characters WinAnsiEncoding places in 0x80-0x9F
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+	'\u202f': 0xa0, // Narrow no-break space, used as thousands separator
+}
+
+func winAnsi(r rune) (byte, bool) {
+	if r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff {
+		return byte(r), true
+	}
+	c, ok := winAnsiExtra[r]
+	return c, ok
+}
+
+// Glyph widths of Helvetica and Helvetica-Bold for 0x20-0x7E in 1/1000 em
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// textWidth measures s in points. Characters beyond ASCII are counted with
+// the width of a digit, which is close enough for accented letters and €.
+func textWidth(s string, size float64, font string) float64 {
+	widths := &helveticaWidths
+	if font == fontBold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+		switch {
+		case r == '\u00a0' || r == '\u202f':
+			total += widths[0]
+		case r >= 0x20 && r < 0x7f:
+			total += widths[r-0x20]
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,332 @@
+package invoice
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	htmltemplate "html/template"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+const DEFAULT_LOCALE = "en"
+
+// PDF layout in points, rows are described in the PDF template
+const (
+	pdfTableTopFirst = 560 // Baseline of the table header on the first page
+	pdfTableTop      = 790 // Baseline of the table header on following pages
+	pdfRowHeight     = 26
+	pdfBottom        = 70 // Lowest baseline above the footer
+	pdfTotalsRow     = 16
+)
+
+//go:embed templates/* locales/*.json
+var assets embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+		"neg": func(n int64) int64 { return -n },
+	}).ParseFS(assets, "templates/*.html"))
+	pdfTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+		"text":  pdfText,
+		"rtext": pdfRightText,
+		"fit":   pdfFit,
+		"line":  pdfLine,
+		"add":   func(a, b int) int { return a + b },
+		"sub":   func(a, b int) int { return a - b },
+		"mul":   func(a, b int) int { return a * b },
+	}).ParseFS(assets, "templates/*.tmpl"))
+	locales = loadLocales()
+)
+
+// locale holds the number and date conventions and the labels of a language
+type locale struct {
+	Decimal     string            `json:"decimal"`
+	Thousands   string            `json:"thousands"`
+	SymbolFirst bool              `json:"symbol_first"`
+	SymbolSpace bool              `json:"symbol_space"`
+	DateFormat  string            `json:"date_format"`
+	Labels      map[string]string `json:"labels"`
+}
+
+func loadLocales() map[string]*locale {
+	files, err := assets.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	out := map[string]*locale{}
+	for _, f := range files {
+		data, err := assets.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var l locale
+		if err := json.Unmarshal(data, &l); err != nil {
+			panic("invoice locale " + f.Name() + ": " + err.Error())
+		}
+		out[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = &l
+	}
+	if out[DEFAULT_LOCALE] == nil {
+		panic("invoice locale " + DEFAULT_LOCALE + " is missing")
+	}
+	return out
+}
+
+// resolveLocale picks the best available locale for a tag such as "de-AT",
+// falling back to its language and then to English
+func resolveLocale(tag string) (string, *locale) {
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	if l, ok := locales[tag]; ok {
+		return tag, l
+	}
+	if lang, _, _ := strings.Cut(tag, "-"); locales[lang] != nil {
+		return lang, locales[lang]
+	}
+	return DEFAULT_LOCALE, locales[DEFAULT_LOCALE]
+}
+
+// zeroDecimal lists currencies without a minor unit
+var zeroDecimal = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+var currencySymbols = map[string]string{"eur": "€", "usd": "$", "gbp": "£", "jpy": "¥"}
+
+// view is the data passed to the invoice templates
+type view struct {
+	Invoice *schema.Invoice
+	Locale  string
+	locale  *locale
+
+	creditedNumber string
+}
+
+func newView(inv *schema.Invoice) *view {
+	tag, l := resolveLocale(inv.Locale)
+	return &view{Invoice: inv, Locale: tag, locale: l}
+}
+
+// L returns a label in the invoice's language
+func (v *view) L(key string) string {
+	if s, ok := v.locale.Labels[key]; ok {
+		return s
+	}
+	return locales[DEFAULT_LOCALE].Labels[key]
+}
+
+// Title is the document title, "Invoice" or "Credit note"
+func (v *view) Title() string {
+	return v.L(string(v.Invoice.Kind))
+}
+
+// Money formats an amount in minor units of the invoice currency
+func (v *view) Money(amount int64) string {
+	neg := amount < 0
+	if neg {
+		amount = -amount
+	}
+	digits := 2
+	if zeroDecimal[v.Invoice.Currency] {
+		digits = 0
+	}
+	s := strconv.FormatInt(amount, 10)
+	for len(s) <= digits {
+		s = "0" + s
+	}
+	whole, frac := s[:len(s)-digits], s[len(s)-digits:]
+	var b strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(v.locale.Thousands)
+		}
+		b.WriteRune(c)
+	}
+	if digits > 0 {
+		b.WriteString(v.locale.Decimal)
+		b.WriteString(frac)
+	}
+
+	symbol, ok := currencySymbols[v.Invoice.Currency]
+	if !ok {
+		symbol = strings.ToUpper(v.Invoice.Currency)
+	}
+	sep := ""
+	if v.locale.SymbolSpace || !ok {
+		sep = "\u00a0"
+	}
+	out := b.String() + sep + symbol
+	if v.locale.SymbolFirst {
+		out = symbol + sep + b.String()
+	}
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+// Date formats a date in the invoice's language
+func (v *view) Date(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(v.locale.DateFormat)
+}
+
+// Period formats the service period of a line
+func (v *view) Period(l schema.InvoiceLine) string {
+	if l.PeriodStart == nil || l.PeriodEnd == nil {
+		return ""
+	}
+	return v.Date(l.PeriodStart) + " – " + v.Date(l.PeriodEnd)
+}
+
+// Rate formats a tax rate given in basis points
+func (v *view) Rate(bp int64) string {
+	s := strconv.FormatInt(bp/100, 10)
+	if frac := bp % 100; frac != 0 {
+		s += v.locale.Decimal + strings.TrimRight(strconv.FormatInt(100+frac, 10)[1:], "0")
+	}
+	return s + " %"
+}
+
+// TaxNote explains why an invoice charges no VAT
+func (v *view) TaxNote() string {
+	switch t := tax.TREATMENT(v.Invoice.TaxTreatment); t {
+	case tax.TREATMENT_REVERSE_CHARGE, tax.TREATMENT_OUTSIDE_SCOPE:
+		return v.L(string(t))
+	}
+	return ""
+}
+
+// CreditedNumber is the number of the invoice a credit note refers to
+func (v *view) CreditedNumber() string {
+	return v.creditedNumber
+}
+
+// LineDiscounts is the sum of the discounts given on single lines
+func (v *view) LineDiscounts() int64 {
+	var total int64
+	for _, l := range v.Invoice.Lines {
+		total += l.Discount
+	}
+	return total
+}
+
+// pdfPage is the data of one PDF page
+type pdfPage struct {
+	*view
+	Rows   []pdfRow
+	Number int
+	Count  int
+	First  bool
+	Last   bool
+	TableY int        // Baseline of the table header
+	Totals []pdfTotal // Totals block, on the last page only
+	NoteY  int        // Baseline of the note below the totals
+}
+
+type pdfTotal struct {
+	Label  string
+	Amount string
+	Y      int
+	Bold   bool
+}
+
+type pdfRow struct {
+	schema.InvoiceLine
+	Y int
+}
+
+// Render renders the PDF and HTML documents of an invoice
+func Render(inv *schema.Invoice, creditedNumber string) ([]byte, []byte, error) {
+	v := newView(inv)
+	v.creditedNumber = creditedNumber
+
+	var html bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, "invoice.html", v); err != nil {
+		return nil, nil, err
+	}
+
+	pages := paginate(v)
+	streams := make([][]byte, len(pages))
+	for i, p := range pages {
+		var buf bytes.Buffer
+		if err := pdfTemplates.ExecuteTemplate(&buf, "invoice_pdf.tmpl", p); err != nil {
+			return nil, nil, err
+		}
+		streams[i] = buf.Bytes()
+	}
+	created := inv.CreatedAt
+	if inv.IssuedAt != nil {
+		created = *inv.IssuedAt
+	}
+	return buildPDF(v.Title()+" "+inv.Number, created, streams), html.Bytes(), nil
+}
+
+// paginate spreads the lines over pages, keeping room for the totals on the
+// last one
+func paginate(v *view) []*pdfPage {
+	totalsHeight := (3+len(v.Invoice.Discounts)+len(v.Invoice.Taxes))*pdfTotalsRow + 20
+	if v.Invoice.Note != "" || v.TaxNote() != "" {
+		totalsHeight += 3 * pdfTotalsRow
+	}
+
+	var pages []*pdfPage
+	page := &pdfPage{view: v, First: true}
+	y := pdfTableTopFirst - pdfRowHeight
+	for _, l := range v.Invoice.Lines {
+		if y < pdfBottom {
+			pages = append(pages, page)
+			page = &pdfPage{view: v}
+			y = pdfTableTop - pdfRowHeight
+		}
+		page.Rows = append(page.Rows, pdfRow{InvoiceLine: l, Y: y})
+		y -= pdfRowHeight
+	}
+	if y+pdfRowHeight-totalsHeight < pdfBottom {
+		pages = append(pages, page)
+		page = &pdfPage{view: v}
+		y = pdfTableTop
+	}
+	page.Last = true
+	page.Totals = totals(v, y)
+	page.NoteY = page.Totals[len(page.Totals)-1].Y - 2*pdfTotalsRow
+	pages = append(pages, page)
+
+	for i, p := range pages {
+		p.TableY = pdfTableTop
+		if p.First {
+			p.TableY = pdfTableTopFirst
+		}
+		p.Number = i + 1
+		p.Count = len(pages)
+	}
+	return pages
+}
+
+func totals(v *view, y int) []pdfTotal {
+	inv := v.Invoice
+	rows := []pdfTotal{{Label: v.L("subtotal"), Amount: v.Money(inv.Subtotal)}}
+	if lineDiscounts := v.LineDiscounts(); lineDiscounts > 0 {
+		rows = append(rows, pdfTotal{Label: v.L("discount"), Amount: v.Money(-lineDiscounts)})
+	}
+	for _, d := range inv.Discounts {
+		rows = append(rows, pdfTotal{Label: d.Description, Amount: v.Money(-d.Applied)})
+	}
+	for _, t := range inv.Taxes {
+		rows = append(rows, pdfTotal{Label: v.L("tax") + " " + v.Rate(t.Rate), Amount: v.Money(t.Amount)})
+	}
+	rows = append(rows, pdfTotal{Label: v.L("total"), Amount: v.Money(inv.Total), Bold: true})
+	for i := range rows {
+		rows[i].Y = y - i*pdfTotalsRow
+	}
+	rows[len(rows)-1].Y -= 4
+	return rows
+}
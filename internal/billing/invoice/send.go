@@ -0,0 +1,69 @@
+package invoice
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Send emails a finalized invoice with its PDF attached, in the invoice's
+// language, through the billing email profile
+func Send(ctx context.Context, id bson.ObjectID) error {
+	inv, err := Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !finalized(inv) {
+		return ErrNotDraft
+	}
+	doc, err := Document(ctx, id, schema.INVOICE_FORMAT_PDF)
+	if err != nil {
+		return err
+	}
+	u, err := users.FindByID(ctx, inv.UserID)
+	if err != nil {
+		return err
+	}
+
+	v := newView(inv)
+	subject := v.L("email_subject")
+	if inv.Kind == schema.INVOICE_KIND_CREDIT_NOTE {
+		subject = v.L("email_credit_note_subject")
+	}
+	to := inv.Customer.Email
+	if to == "" {
+		to = u.Email
+	}
+	err = mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_BILLING,
+		To:       to,
+		UserID:   inv.UserID,
+		Type:     schema.EMAIL_EVENT_INVOICE,
+		Template: "invoice",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Subject":     subject,
+			"Greeting":    v.L("email_greeting"),
+			"Body":        v.L("email_body"),
+			"Title":       v.Title(),
+			"Number":      inv.Number,
+			"AmountLabel": v.L("email_amount"),
+			"Amount":      v.Money(inv.Total),
+		},
+		Attachments: []mailer.Attachment{{
+			Filename:    inv.Number + ".pdf",
+			ContentType: "application/pdf",
+			Data:        doc.Data,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = invoices().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"sent_at": time.Now().UTC()}})
+	return err
+}
@@ -0,0 +1,107 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/invoice"
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// invoicePayment issues the invoice of a collected subscription payment.
+// Every step picks up where a previous delivery of the event stopped, and
+// the unique gateway payment ID keeps it to one invoice per payment.
+func invoicePayment(ctx context.Context, g gateway.PaymentGateway, event *gateway.Event) error {
+	var s schema.Subscription
+	err := subscriptions().FindOne(ctx, bson.M{"gateway": g.Name(), "external_id": event.SubscriptionID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Warn().Str("gateway", g.Name()).Str("subscription", event.SubscriptionID).Msg("Payment for unknown subscription, not invoiced")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The collected amount is what the customer paid. Prices include tax, so
+	// tax is taken out of it the same way the checkout quoted it.
+	details, err := Details(ctx, s.UserID)
+	if err != nil {
+		return err
+	}
+	c, err := taxCustomer(ctx, s.UserID, s.TaxCountry)
+	if err != nil {
+		return err
+	}
+	totals := tax.Calculate(sellerCountry(), c, event.Amount, config.GetBillingConfig().Tax.PricesIncludeTax)
+
+	inv, err := invoice.FindByPayment(ctx, g.Name(), event.PaymentID)
+	if errors.Is(err, invoice.ErrNotFound) {
+		inv, err = invoice.Create(ctx, invoice.Draft{
+			UserID:          s.UserID,
+			Currency:        event.Currency,
+			SubscriptionID:  &s.ID,
+			Gateway:         g.Name(),
+			PaymentID:       event.PaymentID,
+			TaxCountry:      totals.Country,
+			TaxTreatment:    string(totals.Treatment),
+			TaxRatesVersion: totals.RatesVersion,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			inv, err = invoice.FindByPayment(ctx, g.Name(), event.PaymentID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if inv.Status == schema.INVOICE_STATUS_DRAFT && len(inv.Lines) == 0 {
+		if details != nil {
+			if inv, err = invoice.SetCustomer(ctx, inv.ID, schema.InvoiceParty{
+				Name:    details.Name,
+				Address: details.Address,
+				Country: details.Country,
+				VATID:   details.VATID,
+			}); err != nil {
+				return err
+			}
+		}
+		description := s.AccountType
+		if plan, err := catalog.Version(ctx, s.AccountType, s.PlanVersion); err == nil {
+			description = plan.Name
+		}
+		if inv, err = invoice.AddLine(ctx, inv.ID, schema.InvoiceLine{
+			Description: description,
+			Quantity:    1,
+			UnitAmount:  totals.Net,
+			TaxRate:     totals.Rate,
+			PeriodStart: s.CurrentPeriodStart,
+			PeriodEnd:   s.CurrentPeriodEnd,
+		}); err != nil {
+			return err
+		}
+	}
+	if inv.Status == schema.INVOICE_STATUS_DRAFT || inv.Status == schema.INVOICE_STATUS_FINALIZING {
+		if inv, err = invoice.Finalize(ctx, inv.ID); err != nil {
+			return err
+		}
+	}
+	if inv.Status == schema.INVOICE_STATUS_OPEN {
+		if inv, err = invoice.MarkPaid(ctx, inv.ID, event.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if inv.SentAt == nil {
+		// A failed email must not make the gateway redeliver the event
+		if err := invoice.Send(ctx, inv.ID); err != nil {
+			log.Error().Err(err).Str("invoice_id", inv.ID.Hex()).Msg("Error sending invoice")
+		}
+	}
+	return nil
+}
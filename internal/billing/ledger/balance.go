@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MAX_STATEMENT_ENTRIES is the most entries returned by Statement
+const MAX_STATEMENT_ENTRIES = 100
+
+// BalanceAt returns an owner's credits in a currency at a point in time. It
+// sums the postings made until then, so past balances are exact; lots are
+// counted until their expiration is posted.
+func BalanceAt(ctx context.Context, o Owner, currency string, at time.Time) (int64, error) {
+	match := o.filter()
+	match["currency"] = currency
+	match["created_at"] = bson.M{"$lte": at}
+	cur, err := entries().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$postings"}},
+		{{Key: "$match", Value: bson.M{"postings.kind": schema.LEDGER_ACCOUNT_CREDIT}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "sum": bson.M{"$sum": "$postings.amount"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var res []struct {
+		Sum int64 `bson:"sum"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return normal(schema.LEDGER_ACCOUNT_CREDIT, res[0].Sum), nil
+}
+
+// Available returns the credits an owner can spend now
+func Available(ctx context.Context, o Owner, currency string) (int64, error) {
+	lots, err := spendable(ctx, o, currency, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, l := range lots {
+		total += l.Balance
+	}
+	return total, nil
+}
+
+// Lots returns the owner's credit lots in a currency, newest first
+func Lots(ctx context.Context, o Owner, currency string) ([]schema.LedgerAccount, error) {
+	filter := o.filter()
+	filter["kind"] = schema.LEDGER_ACCOUNT_CREDIT
+	filter["currency"] = currency
+	filter["seq"] = bson.M{"$gt": 0} // Opened by a posted entry
+	cur, err := accounts().Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	lots := []schema.LedgerAccount{}
+	if err := cur.All(ctx, &lots); err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+// Statement returns the owner's entries, newest first, posted before a time
+func Statement(ctx context.Context, o Owner, before time.Time, limit int) ([]schema.LedgerEntry, error) {
+	if limit <= 0 || limit > MAX_STATEMENT_ENTRIES {
+		limit = MAX_STATEMENT_ENTRIES
+	}
+	filter := o.filter()
+	filter["created_at"] = bson.M{"$lt": before}
+	cur, err := entries().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.LedgerEntry{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FundedUsers returns the users with credits to spend in a currency
+func FundedUsers(ctx context.Context, currency string) ([]bson.ObjectID, error) {
+	var ids []bson.ObjectID
+	err := accounts().Distinct(ctx, "owner_id", bson.M{
+		"kind":       schema.LEDGER_ACCOUNT_CREDIT,
+		"owner_type": schema.LEDGER_OWNER_USER,
+		"currency":   currency,
+		"balance":    bson.M{"$gt": 0},
+		"$or":        bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": time.Now().UTC()}}},
+	}).Decode(&ids)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return ids, nil
+}
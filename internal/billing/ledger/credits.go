@@ -0,0 +1,372 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	EXPIRY_JOB      = "credit_expiry"
+	EXPIRY_INTERVAL = time.Hour
+	EXPIRY_BATCH    = 500
+)
+
+var (
+	ErrUnknownLot    = errors.New("credit lot not found")
+	ErrNotRefundable = errors.New("credits of this lot cannot be refunded")
+	ErrLotExpired    = errors.New("credits of this lot expired")
+)
+
+// LotParams describe credits added to an owner's balance as a new lot
+type LotParams struct {
+	Amount          int64 // In minor units
+	Currency        string
+	ExpiresAt       *time.Time // Nil for credits that never expire
+	RefundableUntil *time.Time // Top-ups only, nil when the owner cannot refund them
+	Gateway         string     // Top-ups only
+	ChargeID        string     // Top-ups only
+	Description     string
+	ChangedBy       string
+	Key             string // Idempotency key
+}
+
+// TopUp adds credits the owner paid for, against the cash account
+func TopUp(ctx context.Context, o Owner, p LotParams) (*schema.LedgerEntry, error) {
+	return openLot(ctx, o, schema.LEDGER_ENTRY_TOP_UP, schema.LEDGER_ACCOUNT_CASH, p)
+}
+
+// Grant adds free credits, against the promotions expense account
+func Grant(ctx context.Context, o Owner, p LotParams) (*schema.LedgerEntry, error) {
+	return openLot(ctx, o, schema.LEDGER_ENTRY_GRANT, schema.LEDGER_ACCOUNT_PROMOTIONS, p)
+}
+
+func openLot(ctx context.Context, o Owner, t schema.LEDGER_ENTRY_TYPE, counter schema.LEDGER_ACCOUNT_KIND, p LotParams) (*schema.LedgerEntry, error) {
+	if p.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	return post(ctx, p.Key, func() (*draft, error) {
+		lot, err := lotAccount(ctx, o, t, p)
+		if err != nil {
+			return nil, err
+		}
+		sys, err := systemAccount(ctx, counter, p.Currency)
+		if err != nil {
+			return nil, err
+		}
+		return &draft{
+			entry: &schema.LedgerEntry{
+				Type:        t,
+				Currency:    p.Currency,
+				Amount:      p.Amount,
+				OwnerType:   o.Type,
+				OwnerID:     o.ID,
+				Description: p.Description,
+				Reference:   p.ChargeID,
+				ChangedBy:   p.ChangedBy,
+				Postings:    []schema.LedgerPosting{{AccountID: sys.ID, Kind: sys.Kind, Amount: p.Amount}},
+			},
+			lots: []lotPosting{{lot, -p.Amount}},
+		}, nil
+	})
+}
+
+// lotAccount returns the credit account opened by an entry. It is keyed by
+// the entry's idempotency key, so a retried entry reuses it.
+func lotAccount(ctx context.Context, o Owner, t schema.LEDGER_ENTRY_TYPE, p LotParams) (*schema.LedgerAccount, error) {
+	key := "lot:" + p.Key
+	var a schema.LedgerAccount
+	err := accounts().FindOne(ctx, bson.M{"key": key}).Decode(&a)
+	if err == nil {
+		return &a, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	a = schema.LedgerAccount{
+		ID:              bson.NewObjectID(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Key:             key,
+		Kind:            schema.LEDGER_ACCOUNT_CREDIT,
+		Type:            accountTypes[schema.LEDGER_ACCOUNT_CREDIT],
+		Currency:        p.Currency,
+		OwnerType:       o.Type,
+		OwnerID:         &o.ID,
+		Source:          t,
+		Amount:          p.Amount,
+		ExpiresAt:       p.ExpiresAt,
+		Gateway:         p.Gateway,
+		ChargeID:        p.ChargeID,
+		Description:     p.Description,
+		RefundableUntil: p.RefundableUntil,
+	}
+	if _, err := accounts().InsertOne(ctx, a); mongo.IsDuplicateKeyError(err) {
+		return lotAccount(ctx, o, t, p)
+	} else if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UsageParams describe credits burned by metered usage
+type UsageParams struct {
+	Amount      int64 // Most to burn, in minor units
+	Currency    string
+	Description string
+	Reference   string
+	Key         string // Idempotency key
+}
+
+// Burn spends up to p.Amount of an owner's credits, from the lots expiring
+// soonest first, against the usage revenue account. The entry's Amount is
+// what was burned, which is less than asked when the credits run out. It
+// returns nil when the owner has no credits left.
+func Burn(ctx context.Context, o Owner, p UsageParams) (*schema.LedgerEntry, error) {
+	if p.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	return post(ctx, p.Key, func() (*draft, error) {
+		lots, err := spendable(ctx, o, p.Currency, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		d := &draft{}
+		var burned int64
+		for i := range lots {
+			if burned == p.Amount {
+				break
+			}
+			n := min(lots[i].Balance, p.Amount-burned)
+			d.lots = append(d.lots, lotPosting{&lots[i], n})
+			burned += n
+		}
+		if burned == 0 {
+			return nil, nil
+		}
+		revenue, err := systemAccount(ctx, schema.LEDGER_ACCOUNT_USAGE_REVENUE, p.Currency)
+		if err != nil {
+			return nil, err
+		}
+		d.entry = &schema.LedgerEntry{
+			Type:        schema.LEDGER_ENTRY_USAGE,
+			Currency:    p.Currency,
+			Amount:      burned,
+			OwnerType:   o.Type,
+			OwnerID:     o.ID,
+			Description: p.Description,
+			Reference:   p.Reference,
+			ChangedBy:   history.SYSTEM_ACTOR,
+			Postings:    []schema.LedgerPosting{{AccountID: revenue.ID, Kind: revenue.Kind, Amount: -burned}},
+		}
+		return d, nil
+	})
+}
+
+// spendable returns the owner's lots with credits left that did not expire,
+// in the order they are burned: expiring soonest first, then oldest first
+func spendable(ctx context.Context, o Owner, currency string, now time.Time) ([]schema.LedgerAccount, error) {
+	filter := o.filter()
+	filter["kind"] = schema.LEDGER_ACCOUNT_CREDIT
+	filter["currency"] = currency
+	filter["balance"] = bson.M{"$gt": 0}
+	filter["$or"] = bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}}
+	cur, err := accounts().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var lots []schema.LedgerAccount
+	if err := cur.All(ctx, &lots); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i].ExpiresAt, lots[j].ExpiresAt
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.Before(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return lots[i].CreatedAt.Before(lots[j].CreatedAt)
+	})
+	return lots, nil
+}
+
+// RefundParams describe unused credits of a top-up paid back to the owner
+type RefundParams struct {
+	Amount      int64 // In minor units, 0 for the whole balance of the lot
+	Description string
+	ChangedBy   string
+	Key         string // Idempotency key
+}
+
+// Refund takes unused credits of a top-up lot out of the owner's balance,
+// against the cash account. The caller pays them back through the gateway
+// and reverses the refund with ReverseRefund when that fails.
+func Refund(ctx context.Context, o Owner, lotID bson.ObjectID, p RefundParams) (*schema.LedgerEntry, error) {
+	if p.Amount < 0 {
+		return nil, ErrInvalidAmount
+	}
+	return post(ctx, p.Key, func() (*draft, error) {
+		lot, err := ownerLot(ctx, o, lotID)
+		if err != nil {
+			return nil, err
+		}
+		if lot.Source != schema.LEDGER_ENTRY_TOP_UP || lot.ChargeID == "" {
+			return nil, ErrNotRefundable
+		}
+		if lot.ExpiresAt != nil && !lot.ExpiresAt.After(time.Now()) {
+			return nil, ErrLotExpired
+		}
+		amount := p.Amount
+		if amount == 0 {
+			amount = lot.Balance
+		}
+		if amount == 0 || amount > lot.Balance {
+			return nil, ErrInsufficientCredit
+		}
+		cash, err := systemAccount(ctx, schema.LEDGER_ACCOUNT_CASH, lot.Currency)
+		if err != nil {
+			return nil, err
+		}
+		return &draft{
+			entry: &schema.LedgerEntry{
+				Type:        schema.LEDGER_ENTRY_REFUND,
+				Currency:    lot.Currency,
+				Amount:      amount,
+				OwnerType:   o.Type,
+				OwnerID:     o.ID,
+				Description: p.Description,
+				Reference:   lot.ChargeID,
+				ChangedBy:   p.ChangedBy,
+				Postings:    []schema.LedgerPosting{{AccountID: cash.ID, Kind: cash.Kind, Amount: -amount}},
+			},
+			lots: []lotPosting{{lot, amount}},
+		}, nil
+	})
+}
+
+// ReverseRefund restores the credits of a refund that the gateway did not
+// pay out
+func ReverseRefund(ctx context.Context, refund *schema.LedgerEntry, reason string) (*schema.LedgerEntry, error) {
+	if refund.Type != schema.LEDGER_ENTRY_REFUND {
+		return nil, ErrNotRefundable
+	}
+	return post(ctx, "reversal:"+refund.Key, func() (*draft, error) {
+		d := &draft{entry: &schema.LedgerEntry{
+			Type:        schema.LEDGER_ENTRY_REFUND_REVERSAL,
+			Currency:    refund.Currency,
+			Amount:      refund.Amount,
+			OwnerType:   refund.OwnerType,
+			OwnerID:     refund.OwnerID,
+			Description: reason,
+			Reference:   refund.ID.Hex(),
+			ChangedBy:   history.SYSTEM_ACTOR,
+		}}
+		for _, p := range refund.Postings {
+			if p.Kind != schema.LEDGER_ACCOUNT_CREDIT {
+				d.entry.Postings = append(d.entry.Postings, schema.LedgerPosting{AccountID: p.AccountID, Kind: p.Kind, Amount: -p.Amount})
+				continue
+			}
+			var lot schema.LedgerAccount
+			if err := accounts().FindOne(ctx, bson.M{"_id": p.AccountID}).Decode(&lot); err != nil {
+				return nil, err
+			}
+			d.lots = append(d.lots, lotPosting{&lot, -p.Amount})
+		}
+		return d, nil
+	})
+}
+
+// ownerLot returns a credit account of the owner
+func ownerLot(ctx context.Context, o Owner, lotID bson.ObjectID) (*schema.LedgerAccount, error) {
+	filter := o.filter()
+	filter["_id"] = lotID
+	filter["kind"] = schema.LEDGER_ACCOUNT_CREDIT
+	var lot schema.LedgerAccount
+	err := accounts().FindOne(ctx, filter).Decode(&lot)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownLot
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+// ExpireCredits posts the expiration of the unused credits of lots past
+// their expiry: paid credits to breakage revenue, granted credits back
+// against the promotions expense. It is run by the scheduler under a lease.
+func ExpireCredits(ctx context.Context) error {
+	cur, err := accounts().Find(ctx,
+		bson.M{
+			"kind":       schema.LEDGER_ACCOUNT_CREDIT,
+			"balance":    bson.M{"$gt": 0},
+			"expires_at": bson.M{"$lte": time.Now().UTC()},
+		},
+		options.Find().SetSort(bson.M{"expires_at": 1}).SetLimit(EXPIRY_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	var due []schema.LedgerAccount
+	if err := cur.All(ctx, &due); err != nil {
+		return err
+	}
+	for _, lot := range due {
+		if _, err := expireLot(ctx, &lot); err != nil {
+			return err
+		}
+	}
+	if len(due) > 0 {
+		log.Info().Int("lots", len(due)).Msg("Expired unused credits")
+	}
+	return nil
+}
+
+// expireLot empties an expired lot. The key includes the sequence, so credits
+// restored to the lot afterwards expire again.
+func expireLot(ctx context.Context, lot *schema.LedgerAccount) (*schema.LedgerEntry, error) {
+	key := fmt.Sprintf("expire:%s:%d", lot.ID.Hex(), lot.Seq)
+	return post(ctx, key, func() (*draft, error) {
+		var fresh schema.LedgerAccount
+		if err := accounts().FindOne(ctx, bson.M{"_id": lot.ID}).Decode(&fresh); err != nil {
+			return nil, err
+		}
+		if fresh.Balance == 0 {
+			return nil, nil
+		}
+		counter := schema.LEDGER_ACCOUNT_BREAKAGE
+		if fresh.Source == schema.LEDGER_ENTRY_GRANT {
+			counter = schema.LEDGER_ACCOUNT_PROMOTIONS
+		}
+		sys, err := systemAccount(ctx, counter, fresh.Currency)
+		if err != nil {
+			return nil, err
+		}
+		return &draft{
+			entry: &schema.LedgerEntry{
+				Type:        schema.LEDGER_ENTRY_EXPIRATION,
+				Currency:    fresh.Currency,
+				Amount:      fresh.Balance,
+				OwnerType:   fresh.OwnerType,
+				OwnerID:     *fresh.OwnerID,
+				Description: fresh.Description,
+				Reference:   fresh.ID.Hex(),
+				ChangedBy:   history.SYSTEM_ACTOR,
+				Postings:    []schema.LedgerPosting{{AccountID: sys.ID, Kind: sys.Kind, Amount: -fresh.Balance}},
+			},
+			lots: []lotPosting{{&fresh, fresh.Balance}},
+		}, nil
+	})
+}
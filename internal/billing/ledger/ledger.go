@@ -0,0 +1,260 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MAX_POST_ATTEMPTS bounds the retries of an entry whose credit accounts were
+// posted to concurrently
+const MAX_POST_ATTEMPTS = 10
+
+var (
+	ErrInsufficientCredit = errors.New("not enough credit")
+	ErrUnbalancedEntry    = errors.New("postings of a ledger entry must sum to zero")
+	ErrInvalidAmount      = errors.New("amount must be positive")
+	ErrConcurrentPosting  = errors.New("credit account is posted to concurrently")
+)
+
+// accountTypes maps each account kind to its normal balance
+var accountTypes = map[schema.LEDGER_ACCOUNT_KIND]schema.LEDGER_ACCOUNT_TYPE{
+	schema.LEDGER_ACCOUNT_CREDIT:        schema.LEDGER_ACCOUNT_TYPE_LIABILITY,
+	schema.LEDGER_ACCOUNT_CASH:          schema.LEDGER_ACCOUNT_TYPE_ASSET,
+	schema.LEDGER_ACCOUNT_USAGE_REVENUE: schema.LEDGER_ACCOUNT_TYPE_REVENUE,
+	schema.LEDGER_ACCOUNT_BREAKAGE:      schema.LEDGER_ACCOUNT_TYPE_REVENUE,
+	schema.LEDGER_ACCOUNT_PROMOTIONS:    schema.LEDGER_ACCOUNT_TYPE_EXPENSE,
+}
+
+// Owner is the user or organization holding credits
+type Owner struct {
+	Type schema.LEDGER_OWNER
+	ID   bson.ObjectID
+}
+
+// UserOwner returns the owner of a user's credits
+func UserOwner(userID bson.ObjectID) Owner {
+	return Owner{Type: schema.LEDGER_OWNER_USER, ID: userID}
+}
+
+// OrganizationOwner returns the owner of an organization's credits
+func OrganizationOwner(orgID bson.ObjectID) Owner {
+	return Owner{Type: schema.LEDGER_OWNER_ORGANIZATION, ID: orgID}
+}
+
+func (o Owner) filter() bson.M {
+	return bson.M{"owner_type": o.Type, "owner_id": o.ID}
+}
+
+func accounts() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_LEDGER_ACCOUNTS)
+}
+
+func entries() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_LEDGER_ENTRIES)
+}
+
+// normal converts a signed posting amount (debits positive) to the change of
+// the balance in the normal direction of an account kind
+func normal(kind schema.LEDGER_ACCOUNT_KIND, amount int64) int64 {
+	switch accountTypes[kind] {
+	case schema.LEDGER_ACCOUNT_TYPE_LIABILITY, schema.LEDGER_ACCOUNT_TYPE_REVENUE:
+		return -amount
+	default:
+		return amount
+	}
+}
+
+// systemAccount returns the system account of a kind and currency, creating
+// it on first use
+func systemAccount(ctx context.Context, kind schema.LEDGER_ACCOUNT_KIND, currency string) (*schema.LedgerAccount, error) {
+	key := string(kind) + ":" + currency
+	for {
+		var a schema.LedgerAccount
+		err := accounts().FindOne(ctx, bson.M{"key": key}).Decode(&a)
+		if err == nil {
+			return &a, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		a = schema.LedgerAccount{
+			ID:        bson.NewObjectID(),
+			CreatedAt: now,
+			UpdatedAt: now,
+			Key:       key,
+			Kind:      kind,
+			Type:      accountTypes[kind],
+			Currency:  currency,
+		}
+		if _, err := accounts().InsertOne(ctx, a); err == nil {
+			return &a, nil
+		} else if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+	}
+}
+
+// lotPosting is a change of a credit account to include in an entry
+type lotPosting struct {
+	account *schema.LedgerAccount
+	amount  int64 // Signed, debits positive
+}
+
+// draft is an entry before its credit account postings are sequenced
+type draft struct {
+	entry *schema.LedgerEntry
+	lots  []lotPosting
+}
+
+// post writes the entry built by build, once per idempotency key. Credit
+// accounts are never overdrawn: each posting to a credit account claims the
+// next sequence number of the account through a unique index, so of two
+// concurrent entries only one is written and the other is rebuilt from the
+// new balance. build returns nil when there is nothing to post.
+//
+// The cached balance of a credit account is updated after the entry is
+// written. When that update is lost, the next posting finds the sequence
+// taken and rolls the cache forward from the entry holding it.
+func post(ctx context.Context, key string, build func() (*draft, error)) (*schema.LedgerEntry, error) {
+	for attempt := 0; attempt < MAX_POST_ATTEMPTS; attempt++ {
+		if e, err := findEntry(ctx, key); err != nil || e != nil {
+			return e, err
+		}
+		d, err := build()
+		if err != nil || d == nil {
+			return nil, err
+		}
+		e := d.entry
+		e.ID = bson.NewObjectID()
+		e.CreatedAt = time.Now().UTC()
+		e.Key = key
+		e.Locks = nil
+		for _, l := range d.lots {
+			a := l.account
+			balance := a.Balance + normal(a.Kind, l.amount)
+			if balance < 0 {
+				return nil, ErrInsufficientCredit
+			}
+			seq := a.Seq + 1
+			e.Postings = append(e.Postings, schema.LedgerPosting{
+				AccountID:    a.ID,
+				Kind:         a.Kind,
+				Amount:       l.amount,
+				Seq:          seq,
+				BalanceAfter: &balance,
+			})
+			e.Locks = append(e.Locks, lock(a.ID, seq))
+		}
+		if err := balanced(e); err != nil {
+			return nil, err
+		}
+
+		_, err = entries().InsertOne(ctx, e)
+		if mongo.IsDuplicateKeyError(err) {
+			// Either the key was posted concurrently, found on the next
+			// attempt, or a sequence is taken and the cache is behind
+			for _, l := range d.lots {
+				if err := syncAccount(ctx, l.account.ID); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range e.Postings {
+			if p.BalanceAfter == nil {
+				continue
+			}
+			if err := advance(ctx, p.AccountID, p.Seq, *p.BalanceAfter); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+	return nil, ErrConcurrentPosting
+}
+
+// balanced checks that an entry has postings in one currency summing to zero
+func balanced(e *schema.LedgerEntry) error {
+	if len(e.Postings) < 2 || e.Amount <= 0 {
+		return ErrUnbalancedEntry
+	}
+	var sum int64
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return ErrUnbalancedEntry
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return ErrUnbalancedEntry
+	}
+	return nil
+}
+
+func lock(accountID bson.ObjectID, seq int64) string {
+	return fmt.Sprintf("%s:%d", accountID.Hex(), seq)
+}
+
+func findEntry(ctx context.Context, key string) (*schema.LedgerEntry, error) {
+	var e schema.LedgerEntry
+	err := entries().FindOne(ctx, bson.M{"key": key}).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// advance moves the cached balance of a credit account to a posting, unless
+// a later posting already did
+func advance(ctx context.Context, accountID bson.ObjectID, seq, balance int64) error {
+	_, err := accounts().UpdateOne(ctx,
+		bson.M{"_id": accountID, "seq": bson.M{"$lt": seq}},
+		bson.M{"$set": bson.M{"seq": seq, "balance": balance, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// syncAccount rolls the cached balance of a credit account forward over
+// postings whose cache update was lost
+func syncAccount(ctx context.Context, accountID bson.ObjectID) error {
+	for {
+		var a schema.LedgerAccount
+		if err := accounts().FindOne(ctx, bson.M{"_id": accountID}).Decode(&a); err != nil {
+			return err
+		}
+		var e schema.LedgerEntry
+		err := entries().FindOne(ctx, bson.M{"locks": lock(accountID, a.Seq+1)}).Decode(&e)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		advanced := false
+		for _, p := range e.Postings {
+			if p.AccountID == accountID && p.Seq == a.Seq+1 && p.BalanceAfter != nil {
+				if err := advance(ctx, accountID, p.Seq, *p.BalanceAfter); err != nil {
+					return err
+				}
+				advanced = true
+			}
+		}
+		if !advanced {
+			return fmt.Errorf("ledger entry %s holds the lock of account %s without a posting", e.ID.Hex(), accountID.Hex())
+		}
+	}
+}
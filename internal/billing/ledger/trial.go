@@ -0,0 +1,193 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	TRIAL_BALANCE_JOB      = "ledger_trial_balance"
+	TRIAL_BALANCE_INTERVAL = 24 * time.Hour
+	// Unbalanced entries listed in a report at most
+	MAX_UNBALANCED_ENTRIES = 100
+)
+
+// CheckTrialBalance runs TrialBalance and logs an error when the ledger is
+// inconsistent. It is run by the scheduler under a lease.
+func CheckTrialBalance(ctx context.Context) error {
+	tb, err := TrialBalance(ctx)
+	if err != nil {
+		return err
+	}
+	if !tb.Balanced {
+		log.Error().Str("report", tb.ID.Hex()).Int("unbalanced_entries", len(tb.UnbalancedEntries)).Int("mismatches", len(tb.Mismatches)).Msg("Credit ledger is out of balance")
+	}
+	return nil
+}
+
+// TrialBalance checks the consistency of the whole ledger and stores the
+// report: debits equal credits in every currency, every entry sums to zero
+// and the cached balance of every credit account matches its postings.
+// Cached balances that are behind because an update was lost are rolled
+// forward and reported as repaired.
+func TrialBalance(ctx context.Context) (*schema.LedgerTrialBalance, error) {
+	tb := &schema.LedgerTrialBalance{ID: bson.NewObjectID(), CreatedAt: time.Now().UTC(), Balanced: true}
+
+	totals, err := currencyTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tb.Currencies = totals
+	for _, t := range totals {
+		if t.Debits != t.Credits {
+			tb.Balanced = false
+		}
+	}
+
+	if tb.UnbalancedEntries, err = unbalancedEntries(ctx); err != nil {
+		return nil, err
+	}
+	if len(tb.UnbalancedEntries) > 0 {
+		tb.Balanced = false
+	}
+
+	if tb.Mismatches, err = mismatches(ctx); err != nil {
+		return nil, err
+	}
+	for i, m := range tb.Mismatches {
+		if m.Seq > m.Postings {
+			tb.Balanced = false
+			continue
+		}
+		if err := syncAccount(ctx, m.AccountID); err != nil {
+			return nil, err
+		}
+		var a schema.LedgerAccount
+		if err := accounts().FindOne(ctx, bson.M{"_id": m.AccountID}).Decode(&a); err != nil {
+			return nil, err
+		}
+		tb.Mismatches[i].Repaired = a.Seq == m.Postings && a.Balance == m.Computed
+		tb.Balanced = tb.Balanced && tb.Mismatches[i].Repaired
+	}
+
+	if _, err := database.Collection(schema.COLLECTION_LEDGER_TRIAL_BALANCES).InsertOne(ctx, tb); err != nil {
+		return nil, err
+	}
+	return tb, nil
+}
+
+// currencyTotals sums the debits and credits of every account kind per currency
+func currencyTotals(ctx context.Context) ([]schema.LedgerCurrencyTotals, error) {
+	cur, err := entries().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$postings"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"currency": "$currency", "kind": "$postings.kind"},
+			"debits":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$postings.amount", 0}}, "$postings.amount", 0}}},
+			"credits": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$lt": bson.A{"$postings.amount", 0}}, bson.M{"$subtract": bson.A{0, "$postings.amount"}}, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.currency", Value: 1}, {Key: "_id.kind", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Currency string                     `bson:"currency"`
+			Kind     schema.LEDGER_ACCOUNT_KIND `bson:"kind"`
+		} `bson:"_id"`
+		Debits  int64 `bson:"debits"`
+		Credits int64 `bson:"credits"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	totals := []schema.LedgerCurrencyTotals{}
+	for _, r := range rows {
+		if len(totals) == 0 || totals[len(totals)-1].Currency != r.ID.Currency {
+			totals = append(totals, schema.LedgerCurrencyTotals{Currency: r.ID.Currency, Balances: map[schema.LEDGER_ACCOUNT_KIND]int64{}})
+		}
+		t := &totals[len(totals)-1]
+		t.Debits += r.Debits
+		t.Credits += r.Credits
+		t.Balances[r.ID.Kind] = normal(r.ID.Kind, r.Debits-r.Credits)
+	}
+	return totals, nil
+}
+
+// unbalancedEntries returns entries whose postings do not sum to zero
+func unbalancedEntries(ctx context.Context) ([]bson.ObjectID, error) {
+	cur, err := entries().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$ne": bson.A{bson.M{"$sum": "$postings.amount"}, 0}}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: MAX_UNBALANCED_ENTRIES}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID bson.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	var ids []bson.ObjectID
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+	}
+	return ids, nil
+}
+
+// mismatches returns the credit accounts whose cached balance or sequence
+// differs from their postings
+func mismatches(ctx context.Context) ([]schema.LedgerMismatch, error) {
+	cur, err := entries().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$postings"}},
+		{{Key: "$match", Value: bson.M{"postings.kind": schema.LEDGER_ACCOUNT_CREDIT}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$postings.account_id",
+			"sum":      bson.M{"$sum": "$postings.amount"},
+			"postings": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         schema.COLLECTION_LEDGER_ACCOUNTS,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "account",
+		}}},
+		{{Key: "$unwind", Value: "$account"}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$or": bson.A{
+			bson.M{"$ne": bson.A{"$account.seq", "$postings"}},
+			bson.M{"$ne": bson.A{"$account.balance", bson.M{"$subtract": bson.A{0, "$sum"}}}},
+		}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID       bson.ObjectID        `bson:"_id"`
+		Sum      int64                `bson:"sum"`
+		Postings int64                `bson:"postings"`
+		Account  schema.LedgerAccount `bson:"account"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	var list []schema.LedgerMismatch
+	for _, r := range rows {
+		list = append(list, schema.LedgerMismatch{
+			AccountID: r.ID,
+			Balance:   r.Account.Balance,
+			Seq:       r.Account.Seq,
+			Postings:  r.Postings,
+			Computed:  normal(schema.LEDGER_ACCOUNT_CREDIT, r.Sum),
+		})
+	}
+	return list, nil
+}
@@ -0,0 +1,178 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	AGGREGATE_JOB      = "usage_aggregation"
+	AGGREGATE_INTERVAL = time.Minute
+	AGGREGATE_BATCH    = 10000
+)
+
+// bucketKey identifies one bucket
+type bucketKey struct {
+	UserID      bson.ObjectID
+	Meter       string
+	Granularity schema.USAGE_GRANULARITY
+	Start       time.Time
+}
+
+// periodOf returns the bounds of the bucket containing t
+func periodOf(g schema.USAGE_GRANULARITY, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if g == schema.USAGE_GRANULARITY_HOUR {
+		start := t.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Aggregate recomputes the hourly and monthly buckets touched by events that
+// are not aggregated yet. Buckets are always recomputed from the stored
+// events, so running it again after a failure never counts an event twice.
+// It is run by the scheduler under a lease.
+func Aggregate(ctx context.Context) error {
+	events := database.Collection(schema.COLLECTION_USAGE_EVENTS)
+	cur, err := events.Find(ctx,
+		bson.M{"aggregated": false},
+		options.Find().
+			SetProjection(bson.M{"_id": 1, "user_id": 1, "meter": 1, "timestamp": 1}).
+			SetSort(bson.M{"timestamp": 1}).
+			SetLimit(AGGREGATE_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	var pending []schema.UsageEvent
+	if err := cur.All(ctx, &pending); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	touched := map[bucketKey]bool{}
+	ids := make([]string, 0, len(pending))
+	for _, e := range pending {
+		ids = append(ids, e.ID)
+		for _, g := range []schema.USAGE_GRANULARITY{schema.USAGE_GRANULARITY_HOUR, schema.USAGE_GRANULARITY_MONTH} {
+			start, _ := periodOf(g, e.Timestamp)
+			touched[bucketKey{e.UserID, e.Meter, g, start}] = true
+		}
+	}
+
+	for k := range touched {
+		if err := recompute(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	_, err = events.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"aggregated": true}})
+	if err != nil {
+		return err
+	}
+	log.Debug().Int("events", len(ids)).Int("buckets", len(touched)).Msg("Aggregated usage events")
+	return nil
+}
+
+// recompute rebuilds one bucket from its events
+func recompute(ctx context.Context, k bucketKey) error {
+	m, err := Meter(k.Meter)
+	if errors.Is(err, ErrUnknownMeter) {
+		log.Warn().Str("meter", k.Meter).Msg("Skipping usage of a meter that is no longer configured")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	aggregation := schema.USAGE_AGGREGATION(m.Aggregation)
+	start, end := periodOf(k.Granularity, k.Start)
+
+	match := bson.D{{Key: "$match", Value: bson.M{
+		"user_id":   k.UserID,
+		"meter":     k.Meter,
+		"timestamp": bson.M{"$gte": start, "$lt": end},
+	}}}
+	var pipeline mongo.Pipeline
+	if aggregation == schema.USAGE_AGGREGATION_UNIQUE {
+		// Grouping by value first keeps the distinct set out of a single document
+		pipeline = mongo.Pipeline{
+			match,
+			{{Key: "$group", Value: bson.M{"_id": "$value", "events": bson.M{"$sum": 1}}}},
+			{{Key: "$group", Value: bson.M{"_id": nil, "value": bson.M{"$sum": 1}, "events": bson.M{"$sum": "$events"}}}},
+		}
+	} else {
+		op := "$sum"
+		if aggregation == schema.USAGE_AGGREGATION_MAX {
+			op = "$max"
+		}
+		pipeline = mongo.Pipeline{
+			match,
+			{{Key: "$group", Value: bson.M{"_id": nil, "value": bson.M{op: "$quantity"}, "events": bson.M{"$sum": 1}}}},
+		}
+	}
+
+	cur, err := database.Collection(schema.COLLECTION_USAGE_EVENTS).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		Value  int64 `bson:"value"`
+		Events int64 `bson:"events"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return err
+	}
+	var value, count int64
+	if len(rows) > 0 {
+		value, count = rows[0].Value, rows[0].Events
+	}
+
+	_, err = database.Collection(schema.COLLECTION_USAGE_BUCKETS).UpdateOne(ctx,
+		bson.M{"user_id": k.UserID, "meter": k.Meter, "granularity": k.Granularity, "period_start": start},
+		bson.M{
+			"$set": bson.M{
+				"updated_at":  time.Now().UTC(),
+				"aggregation": aggregation,
+				"period_end":  end,
+				"value":       value,
+				"events":      count,
+			},
+			"$setOnInsert": bson.M{"reported_value": int64(0), "credit_burned": int64(0)},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// Usage returns a user's buckets of one granularity that overlap [from, to)
+func Usage(ctx context.Context, userID bson.ObjectID, g schema.USAGE_GRANULARITY, from, to time.Time) ([]schema.UsageBucket, error) {
+	cur, err := database.Collection(schema.COLLECTION_USAGE_BUCKETS).Find(ctx,
+		bson.M{
+			"user_id":      userID,
+			"granularity":  g,
+			"period_start": bson.M{"$lt": to},
+			"period_end":   bson.M{"$gt": from},
+		},
+		options.Find().SetSort(bson.D{{Key: "period_start", Value: 1}, {Key: "meter", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := []schema.UsageBucket{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/ledger"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	BURN_JOB      = "credit_burn"
+	BURN_INTERVAL = 5 * time.Minute
+	// Users whose buckets are loaded per query
+	BURN_BATCH = 500
+)
+
+// Cost returns the credits in minor units burned by a usage value of a
+// meter, rounded down. The product is computed exactly, so large values
+// cannot overflow into a wrong price.
+func Cost(m *config.MeterConfig, value int64) int64 {
+	if m.CreditPrice == 0 || value <= 0 {
+		return 0
+	}
+	c := new(big.Int).Mul(big.NewInt(value), big.NewInt(m.CreditPrice))
+	c.Quo(c, big.NewInt(m.CreditUnits))
+	if !c.IsInt64() {
+		return math.MaxInt64
+	}
+	return c.Int64()
+}
+
+// BurnCredits burns the prepaid credits of users for the usage of meters
+// priced in credits. Buckets are burned as they grow, like they are reported
+// to Stripe: hourly for sum meters, monthly for the others. Usage beyond a
+// user's credits stays due on the bucket and is burned after the next
+// top-up. It is run by the scheduler under a lease.
+func BurnCredits(ctx context.Context) error {
+	currency := config.GetBillingConfig().Credits.Currency
+	if currency == "" {
+		return nil
+	}
+	funded, err := ledger.FundedUsers(ctx, currency)
+	if err != nil {
+		return err
+	}
+	for _, m := range config.GetBillingConfig().Metering.Meters {
+		if m.CreditPrice == 0 {
+			continue
+		}
+		for i := 0; i < len(funded); i += BURN_BATCH {
+			if err := burnMeter(ctx, &m, currency, funded[i:min(i+BURN_BATCH, len(funded))]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func burnMeter(ctx context.Context, m *config.MeterConfig, currency string, userIDs []bson.ObjectID) error {
+	cur, err := database.Collection(schema.COLLECTION_USAGE_BUCKETS).Find(ctx,
+		bson.M{
+			"meter":       m.Name,
+			"granularity": reportGranularity(m),
+			"user_id":     bson.M{"$in": userIDs},
+			// Cost(value) > credit_burned, without rounding: value*price >= (credit_burned+1)*units
+			"$expr": bson.M{"$gte": bson.A{
+				bson.M{"$multiply": bson.A{"$value", m.CreditPrice}},
+				bson.M{"$multiply": bson.A{bson.M{"$add": bson.A{"$credit_burned", 1}}, m.CreditUnits}},
+			}},
+		},
+		options.Find().SetSort(bson.M{"period_start": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var due []schema.UsageBucket
+	if err := cur.All(ctx, &due); err != nil {
+		return err
+	}
+	for _, b := range due {
+		if err := burnBucket(ctx, m, currency, &b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// burnBucket burns the credits a bucket costs beyond what was burned for it.
+// The entry is keyed on the amount burned before, so a run that stops between
+// the entry and the bucket update finds the entry again and records it.
+func burnBucket(ctx context.Context, m *config.MeterConfig, currency string, b *schema.UsageBucket) error {
+	amount := Cost(m, b.Value) - b.CreditBurned
+	if amount <= 0 {
+		return nil
+	}
+	e, err := ledger.Burn(ctx, ledger.UserOwner(b.UserID), ledger.UsageParams{
+		Amount:      amount,
+		Currency:    currency,
+		Description: fmt.Sprintf("%s usage %s", m.Name, b.PeriodStart.Format(time.RFC3339)),
+		Reference:   b.ID.Hex(),
+		Key:         fmt.Sprintf("usage:%s:%d", b.ID.Hex(), b.CreditBurned),
+	})
+	if err != nil || e == nil {
+		return err
+	}
+	_, err = database.Collection(schema.COLLECTION_USAGE_BUCKETS).UpdateOne(ctx,
+		bson.M{"_id": b.ID, "credit_burned": b.CreditBurned},
+		bson.M{"$inc": bson.M{"credit_burned": e.Amount}},
+	)
+	if err != nil {
+		return err
+	}
+	if e.Amount < amount {
+		log.Debug().Str("user_id", b.UserID.Hex()).Str("meter", m.Name).Int64("due", amount-e.Amount).Msg("Credits ran out, usage stays due")
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	FLUSH_INTERVAL = 10 * time.Second
+	FLUSH_BATCH    = 1000
+
+	// Idempotency keys are remembered locally for this long, Mongo deduplicates after that
+	SEEN_TTL = 48 * time.Hour
+	// Events older than this are rejected, their month may already be reconciled
+	MAX_EVENT_AGE = 7 * 24 * time.Hour
+	// Events may be timestamped slightly ahead to tolerate clock skew
+	MAX_CLOCK_SKEW = 5 * time.Minute
+
+	bufferPrefix = "usage:buf:"
+	seenPrefix   = "usage:seen:"
+)
+
+var (
+	ErrUnknownMeter   = errors.New("unknown meter")
+	ErrDuplicateEvent = errors.New("usage event already recorded")
+	ErrInvalidEvent   = errors.New("invalid usage event")
+)
+
+// Event is a usage report from a service
+type Event struct {
+	IdempotencyKey string        `json:"idempotency_key"`
+	UserID         bson.ObjectID `json:"user_id"`
+	Meter          string        `json:"meter"`
+	Quantity       int64         `json:"quantity"`
+	Value          string        `json:"value,omitempty"`
+	Timestamp      time.Time     `json:"timestamp"`
+}
+
+// Meter returns the configuration of a meter
+func Meter(name string) (*config.MeterConfig, error) {
+	for i, m := range config.GetBillingConfig().Metering.Meters {
+		if m.Name == name {
+			return &config.GetBillingConfig().Metering.Meters[i], nil
+		}
+	}
+	return nil, ErrUnknownMeter
+}
+
+// Record buffers a usage event in Badger. It returns ErrDuplicateEvent when
+// the idempotency key was already recorded for the user.
+func Record(e Event) error {
+	m, err := Meter(e.Meter)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if e.Timestamp.IsZero() {
+		e.Timestamp = now
+	}
+	e.Timestamp = e.Timestamp.UTC()
+	if e.IdempotencyKey == "" || e.UserID.IsZero() || e.Quantity < 0 ||
+		e.Timestamp.Before(now.Add(-MAX_EVENT_AGE)) || e.Timestamp.After(now.Add(MAX_CLOCK_SKEW)) {
+		return ErrInvalidEvent
+	}
+	if schema.USAGE_AGGREGATION(m.Aggregation) == schema.USAGE_AGGREGATION_UNIQUE && e.Value == "" {
+		return ErrInvalidEvent
+	}
+
+	id := eventID(e.UserID, e.IdempotencyKey)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return database.KV.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(seenPrefix + id))
+		if err == nil {
+			return ErrDuplicateEvent
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		if err := txn.SetEntry(badger.NewEntry([]byte(seenPrefix+id), nil).WithTTL(SEEN_TTL)); err != nil {
+			return err
+		}
+		return txn.Set([]byte(bufferPrefix+id), data)
+	})
+}
+
+func eventID(userID bson.ObjectID, key string) string {
+	return userID.Hex() + ":" + key
+}
+
+// StartFlusher moves buffered events to Mongo until ctx is done. Badger is
+// local to each instance, so every instance runs its own flusher.
+func StartFlusher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(FLUSH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for {
+				n, err := Flush(ctx)
+				if err != nil {
+					log.Error().Err(err).Msg("Error flushing usage events")
+					break
+				}
+				if n < FLUSH_BATCH {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Flush inserts up to FLUSH_BATCH buffered events into usage_events and drops
+// them from the buffer. Events are keyed by user and idempotency key, so an
+// event inserted before a crash is not stored twice on the next flush.
+func Flush(ctx context.Context) (int, error) {
+	var keys [][]byte
+	var docs []any
+	now := time.Now().UTC()
+	err := database.KV.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(bufferPrefix), PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid() && len(keys) < FLUSH_BATCH; it.Next() {
+			item := it.Item()
+			var e Event
+			if err := item.Value(func(v []byte) error { return json.Unmarshal(v, &e) }); err != nil {
+				return err
+			}
+			keys = append(keys, item.KeyCopy(nil))
+			docs = append(docs, schema.UsageEvent{
+				ID:        eventID(e.UserID, e.IdempotencyKey),
+				CreatedAt: now,
+				UserID:    e.UserID,
+				Meter:     e.Meter,
+				Quantity:  e.Quantity,
+				Value:     e.Value,
+				Timestamp: e.Timestamp,
+			})
+		}
+		return nil
+	})
+	if err != nil || len(docs) == 0 {
+		return 0, err
+	}
+
+	_, err = database.Collection(schema.COLLECTION_USAGE_EVENTS).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicates(err) {
+		return 0, err
+	}
+
+	wb := database.KV.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), wb.Flush()
+}
+
+// onlyDuplicates reports whether every failed write of a bulk insert was a duplicate key
+func onlyDuplicates(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if !mongo.IsDuplicateKeyError(we) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/stripe"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	RECONCILE_JOB      = "usage_reconciliation"
+	RECONCILE_INTERVAL = 6 * time.Hour
+	// A month is reconciled once late events and reports had time to settle
+	RECONCILE_DELAY = 24 * time.Hour
+)
+
+// ReconcileUsage compares the previous month's usage with what Stripe
+// aggregated, once per meter and month, and stores the result in
+// usage_reconciliations. It is run by the scheduler under a lease.
+func ReconcileUsage(ctx context.Context) error {
+	end, _ := periodOf(schema.USAGE_GRANULARITY_MONTH, time.Now())
+	start := end.AddDate(0, -1, 0)
+	if time.Now().UTC().Before(end.Add(RECONCILE_DELAY)) {
+		return nil
+	}
+	client, err := stripeClient()
+	if err != nil {
+		return err
+	}
+
+	reports := database.Collection(schema.COLLECTION_USAGE_RECONCILIATIONS)
+	for _, m := range config.GetBillingConfig().Metering.Meters {
+		if m.StripeMeterID == "" {
+			continue
+		}
+		n, err := reports.CountDocuments(ctx, bson.M{"meter": m.Name, "period_start": start})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		report, err := reconcileMeter(ctx, client, &m, start, end)
+		if err != nil {
+			return err
+		}
+		if _, err := reports.InsertOne(ctx, report); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		event := log.Info()
+		if report.Mismatches > 0 {
+			event = log.Warn()
+		}
+		event.Str("meter", m.Name).Time("period_start", start).Int("entries", len(report.Entries)).Int("mismatches", report.Mismatches).Msg("Reconciled usage")
+	}
+	return nil
+}
+
+func reconcileMeter(ctx context.Context, client *stripe.Client, m *config.MeterConfig, start, end time.Time) (*schema.UsageReconciliation, error) {
+	report := &schema.UsageReconciliation{
+		ID:          bson.NewObjectID(),
+		CreatedAt:   time.Now().UTC(),
+		Meter:       m.Name,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Entries:     []schema.UsageReconciliationEntry{},
+	}
+
+	buckets := database.Collection(schema.COLLECTION_USAGE_BUCKETS)
+	cur, err := buckets.Find(ctx, bson.M{
+		"meter":        m.Name,
+		"granularity":  schema.USAGE_GRANULARITY_MONTH,
+		"period_start": start,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var monthly []schema.UsageBucket
+	if err := cur.All(ctx, &monthly); err != nil {
+		return nil, err
+	}
+
+	for _, b := range monthly {
+		entry := schema.UsageReconciliationEntry{UserID: b.UserID, Local: b.Value, Reported: b.ReportedValue}
+		if reportGranularity(m) == schema.USAGE_GRANULARITY_HOUR {
+			entry.Reported, err = reportedHours(ctx, b.UserID, m.Name, start, end)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		c, err := billing.FindCustomer(ctx, b.UserID, gateway.STRIPE)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			entry.Error = errNoStripeCustomer.Error()
+		case err != nil:
+			return nil, err
+		default:
+			remote, err := client.MeterUsage(ctx, m.StripeMeterID, c.ExternalID, start, end)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			entry.Remote = int64(math.Round(remote))
+		}
+
+		entry.Match = entry.Error == "" && entry.Local == entry.Reported && entry.Reported == entry.Remote
+		if !entry.Match {
+			report.Mismatches++
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+	return report, nil
+}
+
+// reportedHours sums what was reported for the hourly buckets of a month
+func reportedHours(ctx context.Context, userID bson.ObjectID, meter string, start, end time.Time) (int64, error) {
+	cur, err := database.Collection(schema.COLLECTION_USAGE_BUCKETS).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id":      userID,
+			"meter":        meter,
+			"granularity":  schema.USAGE_GRANULARITY_HOUR,
+			"period_start": bson.M{"$gte": start, "$lt": end},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "reported": bson.M{"$sum": "$reported_value"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var rows []struct {
+		Reported int64 `bson:"reported"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Reported, nil
+}
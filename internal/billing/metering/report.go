@@ -0,0 +1,169 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/stripe"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	REPORT_JOB      = "usage_report"
+	REPORT_INTERVAL = 15 * time.Minute
+	// Closed buckets wait this long so buffered events are flushed and aggregated first
+	REPORT_DELAY = 10 * time.Minute
+	REPORT_BATCH = 500
+	// Failed reports are retried after REPORT_RETRY_BASE, doubling up to REPORT_RETRY_MAX
+	REPORT_RETRY_BASE = REPORT_INTERVAL
+	REPORT_RETRY_MAX  = 24 * time.Hour
+)
+
+// stripeClient returns the Stripe API client of the configured gateway
+func stripeClient() (*stripe.Client, error) {
+	g, err := billing.Gateway(gateway.STRIPE)
+	if err != nil {
+		return nil, err
+	}
+	sg, ok := g.(*stripe.Gateway)
+	if !ok {
+		return nil, billing.ErrUnknownGateway
+	}
+	return sg.Client(), nil
+}
+
+// reportGranularity returns the buckets reported for a meter. Sum meters
+// report every closed hour and Stripe adds them up; max and unique meters
+// report the closed month, to a Stripe meter aggregating the last value.
+func reportGranularity(m *config.MeterConfig) schema.USAGE_GRANULARITY {
+	if schema.USAGE_AGGREGATION(m.Aggregation) == schema.USAGE_AGGREGATION_SUM {
+		return schema.USAGE_GRANULARITY_HOUR
+	}
+	return schema.USAGE_GRANULARITY_MONTH
+}
+
+// ReportUsage sends the usage of closed buckets to Stripe metered billing.
+// Buckets that change after being reported, because of late events, are
+// reported again: sum meters send the difference, other meters the new value.
+// It is run by the scheduler under a lease.
+func ReportUsage(ctx context.Context) error {
+	client, err := stripeClient()
+	if err != nil {
+		return err
+	}
+	for _, m := range config.GetBillingConfig().Metering.Meters {
+		if m.StripeEventName == "" {
+			continue
+		}
+		if err := reportMeter(ctx, client, &m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportMeter reports the pending buckets of a meter. Buckets of users without
+// a Stripe customer are marked not billable, other failures are retried with
+// a backoff, so neither holds back newer buckets.
+func reportMeter(ctx context.Context, client *stripe.Client, m *config.MeterConfig) error {
+	buckets := database.Collection(schema.COLLECTION_USAGE_BUCKETS)
+	now := time.Now().UTC()
+	cur, err := buckets.Find(ctx,
+		bson.M{
+			"meter":        m.Name,
+			"granularity":  reportGranularity(m),
+			"period_end":   bson.M{"$lte": now.Add(-REPORT_DELAY)},
+			"not_billable": bson.M{"$ne": true},
+			"$or": bson.A{
+				bson.M{"next_attempt_at": nil},
+				bson.M{"next_attempt_at": bson.M{"$lte": now}},
+			},
+			"$expr": bson.M{"$ne": bson.A{"$value", "$reported_value"}},
+		},
+		options.Find().SetSort(bson.M{"period_start": 1}).SetLimit(REPORT_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	var pending []schema.UsageBucket
+	if err := cur.All(ctx, &pending); err != nil {
+		return err
+	}
+
+	for _, b := range pending {
+		err := reportBucket(ctx, client, m, &b)
+		if errors.Is(err, errNoStripeCustomer) {
+			if _, err := buckets.UpdateByID(ctx, b.ID, bson.M{
+				"$set":   bson.M{"not_billable": true},
+				"$unset": bson.M{"report_error": "", "report_attempts": "", "next_attempt_at": ""},
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("meter", m.Name).Str("user_id", b.UserID.Hex()).Time("period_start", b.PeriodStart).Msg("Error reporting usage")
+			if _, err := buckets.UpdateByID(ctx, b.ID, bson.M{"$set": bson.M{
+				"report_error":    err.Error(),
+				"report_attempts": b.ReportAttempts + 1,
+				"next_attempt_at": now.Add(reportBackoff(b.ReportAttempts + 1)),
+			}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reportBackoff returns the wait before the next report of a bucket that
+// failed attempts times in a row
+func reportBackoff(attempts int) time.Duration {
+	d := REPORT_RETRY_BASE
+	for i := 1; i < attempts && d < REPORT_RETRY_MAX; i++ {
+		d *= 2
+	}
+	return min(d, REPORT_RETRY_MAX)
+}
+
+var errNoStripeCustomer = errors.New("user has no stripe customer")
+
+func reportBucket(ctx context.Context, client *stripe.Client, m *config.MeterConfig, b *schema.UsageBucket) error {
+	c, err := billing.FindCustomer(ctx, b.UserID, gateway.STRIPE)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errNoStripeCustomer
+	}
+	if err != nil {
+		return err
+	}
+
+	value, timestamp := b.Value, b.PeriodEnd.Add(-time.Second)
+	if reportGranularity(m) == schema.USAGE_GRANULARITY_HOUR {
+		value, timestamp = b.Value-b.ReportedValue, b.PeriodStart
+		if value < 0 {
+			return fmt.Errorf("usage decreased after reporting (%d to %d)", b.ReportedValue, b.Value)
+		}
+	}
+	// The identifier changes with the bucket value, so a retry of the same
+	// report is deduplicated by Stripe but a later correction is not
+	identifier := fmt.Sprintf("%s-%d", b.ID.Hex(), b.Value)
+	if err := client.CreateMeterEvent(ctx, m.StripeEventName, c.ExternalID, value, identifier, timestamp); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = database.Collection(schema.COLLECTION_USAGE_BUCKETS).UpdateByID(ctx, b.ID, bson.M{
+		"$set":   bson.M{"reported_value": b.Value, "reported_at": now},
+		"$unset": bson.M{"report_error": "", "report_attempts": "", "next_attempt_at": ""},
+	})
+	return err
+}
@@ -0,0 +1,168 @@
+package paypal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_API_BASE = "https://api-m.paypal.com"
+	SANDBOX_API_BASE = "https://api-m.sandbox.paypal.com"
+)
+
+// Client is a minimal PayPal REST API client authenticating with OAuth2
+// client credentials
+type Client struct {
+	apiBase      string
+	clientID     string
+	clientSecret string
+	http         *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// Error is an error response returned by the PayPal API
+type Error struct {
+	StatusCode int    `json:"-"`
+	Name       string `json:"name"`
+	Message    string `json:"message"`
+	DebugID    string `json:"debug_id"`
+	Details    []struct {
+		Issue       string `json:"issue"`
+		Description string `json:"description"`
+	} `json:"details"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("paypal: %s (%d %s, debug_id %s)", e.Message, e.StatusCode, e.Name, e.DebugID)
+}
+
+// Issue returns the first detailed issue code, e.g. INSTRUMENT_DECLINED
+func (e *Error) Issue() string {
+	if len(e.Details) == 0 {
+		return ""
+	}
+	return e.Details[0].Issue
+}
+
+// NewClient creates a client, an empty apiBase uses the live PayPal API
+func NewClient(apiBase, clientID, clientSecret string) *Client {
+	if apiBase == "" {
+		apiBase = DEFAULT_API_BASE
+	}
+	return &Client{
+		apiBase:      strings.TrimRight(apiBase, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		http:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// token returns a cached access token, fetching a new one shortly before expiry
+func (c *Client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != "" && time.Now().Before(c.expiresAt) {
+		return c.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiBase+"/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := c.do(req, &out); err != nil {
+		return "", err
+	}
+	c.accessToken = out.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return c.accessToken, nil
+}
+
+// call performs a JSON API request. A non-empty requestID makes retried POSTs
+// safe (PayPal-Request-Id).
+func (c *Client) call(ctx context.Context, method, path string, in any, requestID string, out any) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+	if requestID != "" {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		e := Error{}
+		_ = json.Unmarshal(data, &e)
+		e.StatusCode = res.StatusCode
+		if e.Message == "" {
+			e.Message = http.StatusText(res.StatusCode)
+		}
+		return &e
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Link is a HATEOAS link of a PayPal resource
+type Link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+func linkHref(links []Link, rel string) string {
+	for _, l := range links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
@@ -0,0 +1,308 @@
+package paypal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// ErrPaymentMethodOwner is returned when a vaulted payment token belongs to another customer
+var ErrPaymentMethodOwner = errors.New("paypal: payment token belongs to another customer")
+
+// customerPrefix prefixes the merchant-side customer IDs used in the PayPal vault
+const customerPrefix = "brain-"
+
+// Gateway implements gateway.PaymentGateway on top of the PayPal REST API.
+// PayPal has no customer objects: brain uses its own customer ID to group
+// vaulted payment tokens.
+type Gateway struct {
+	client    *Client
+	webhookID string
+}
+
+// NewGateway creates the PayPal payment gateway
+func NewGateway(client *Client, webhookID string) *Gateway {
+	return &Gateway{client: client, webhookID: webhookID}
+}
+
+func (g *Gateway) Name() string {
+	return gateway.PAYPAL
+}
+
+func (g *Gateway) CreateCustomer(ctx context.Context, p gateway.CustomerParams) (string, error) {
+	return customerPrefix + p.UserID, nil
+}
+
+// DeleteCustomer removes every payment token the customer saved in the vault
+func (g *Gateway) DeleteCustomer(ctx context.Context, customerID string) error {
+	tokens, err := g.client.ListPaymentTokens(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if err := g.client.DeletePaymentToken(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttachPaymentMethod checks that a vaulted payment token belongs to the
+// customer. Tokens are bound to the customer when they are vaulted, so there
+// is nothing to attach.
+func (g *Gateway) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	t, err := g.client.GetPaymentToken(ctx, paymentMethodID)
+	if err != nil {
+		return err
+	}
+	if t.Customer.ID != customerID {
+		return ErrPaymentMethodOwner
+	}
+	return nil
+}
+
+func (g *Gateway) Charge(ctx context.Context, p gateway.ChargeParams) (*gateway.Charge, error) {
+	o, err := g.client.ChargeVault(ctx, p.PaymentMethodID, NewAmount(p.Amount, p.Currency), p.Description, p.Reference, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	c := o.Capture()
+	if c == nil {
+		return nil, fmt.Errorf("paypal: order %s has no capture (status %s)", o.ID, o.Status)
+	}
+	return &gateway.Charge{
+		ID:       c.ID,
+		Status:   strings.ToLower(c.Status),
+		Amount:   c.Amount.Minor(),
+		Currency: strings.ToLower(c.Amount.CurrencyCode),
+	}, nil
+}
+
+func (g *Gateway) Refund(ctx context.Context, p gateway.RefundParams) (*gateway.Refund, error) {
+	var amount *Amount
+	if p.Amount > 0 {
+		a := NewAmount(p.Amount, p.Currency)
+		amount = &a
+	}
+	r, err := g.client.RefundCapture(ctx, p.ChargeID, amount, p.Reason, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return &gateway.Refund{ID: r.ID, Status: strings.ToLower(r.Status), Amount: r.Amount.Minor()}, nil
+}
+
+// CreateSubscription creates a subscription that waits for approval at the
+// returned ApprovalURL. Trials are part of the PayPal plan, so TrialDays is
+// not used. PayPal plans have no coupons, so discounts are not supported.
+func (g *Gateway) CreateSubscription(ctx context.Context, p gateway.SubscriptionParams) (*gateway.Subscription, error) {
+	if p.CouponID != "" {
+		return nil, gateway.ErrUnsupported
+	}
+	s, err := g.client.CreateSubscription(ctx, SubscriptionParams{
+		PlanID:    p.PriceID,
+		CustomID:  p.UserID,
+		Email:     p.Email,
+		Name:      p.Name,
+		ReturnURL: p.ReturnURL,
+		CancelURL: p.CancelURL,
+	}, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	out := s.neutral()
+	out.CustomerID = p.CustomerID
+	return out, nil
+}
+
+func (g *Gateway) GetSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	s, err := g.client.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+// ChangeSubscriptionPrice requests a plan revision. The subscription keeps
+// its current plan until the subscriber approves the change at ApprovalURL.
+func (g *Gateway) ChangeSubscriptionPrice(ctx context.Context, id, priceID, idempotencyKey string) (*gateway.Subscription, error) {
+	approval, err := g.client.ReviseSubscription(ctx, id, priceID, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	s, err := g.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.ApprovalURL = approval
+	return s, nil
+}
+
+// CancelSubscription cancels immediately. PayPal cannot schedule a
+// cancellation for the end of the period.
+func (g *Gateway) CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*gateway.Subscription, error) {
+	if atPeriodEnd {
+		return nil, gateway.ErrUnsupported
+	}
+	if err := g.client.CancelSubscription(ctx, id, "Canceled by subscriber"); err != nil {
+		return nil, err
+	}
+	return g.GetSubscription(ctx, id)
+}
+
+// ResumeSubscription reactivates a suspended subscription
+func (g *Gateway) ResumeSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	if err := g.client.ActivateSubscription(ctx, id, "Resumed by subscriber"); err != nil {
+		return nil, err
+	}
+	return g.GetSubscription(ctx, id)
+}
+
+// ChangeSubscriptionQuantity is not supported: seat-based plans are billed
+// through Stripe
+func (g *Gateway) ChangeSubscriptionQuantity(ctx context.Context, id string, quantity int64, idempotencyKey string) (*gateway.Subscription, error) {
+	return nil, gateway.ErrUnsupported
+}
+
+// CreditBalance is not supported: PayPal subscriptions have no customer
+// balance
+func (g *Gateway) CreditBalance(ctx context.Context, p gateway.CreditParams) error {
+	return gateway.ErrUnsupported
+}
+
+// RetryPayment captures the outstanding balance of the subscription. PayPal
+// has no invoices, so invoiceID is not used.
+func (g *Gateway) RetryPayment(ctx context.Context, subscriptionID, invoiceID, idempotencyKey string) error {
+	s, err := g.client.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	balance := s.BillingInfo.OutstandingBalance
+	if balance == nil || balance.Minor() <= 0 {
+		return nil
+	}
+	return g.client.CaptureOutstanding(ctx, subscriptionID, *balance, "Retry of failed payment", idempotencyKey)
+}
+
+// ParseWebhook verifies a delivery through PayPal's verification endpoint
+// and maps the event to the subscription it affects
+func (g *Gateway) ParseWebhook(ctx context.Context, payload []byte, header http.Header) (*gateway.Event, error) {
+	if !json.Valid(payload) {
+		return nil, gateway.ErrInvalidSignature
+	}
+	ok, err := g.client.VerifyWebhookSignature(ctx, g.webhookID, header, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("paypal: %w", gateway.ErrInvalidSignature)
+	}
+
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+	event := &gateway.Event{
+		ID:        e.ID,
+		Type:      e.EventType,
+		Kind:      gateway.EVENT_KIND_OTHER,
+		CreatedAt: e.CreateTime,
+	}
+	switch {
+	case strings.HasPrefix(e.EventType, "BILLING.SUBSCRIPTION."):
+		var s Subscription
+		if err := json.Unmarshal(e.Resource, &s); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_SUBSCRIPTION
+		event.SubscriptionID = s.ID
+		event.PaymentFailed = e.EventType == "BILLING.SUBSCRIPTION.PAYMENT.FAILED"
+
+	case strings.HasPrefix(e.EventType, "PAYMENT.SALE."):
+		// Recurring subscription payments are reported as sales
+		var sale struct {
+			ID                 string `json:"id"`
+			BillingAgreementID string `json:"billing_agreement_id"`
+			Amount             struct {
+				Total    string `json:"total"`
+				Currency string `json:"currency"`
+			} `json:"amount"`
+		}
+		if err := json.Unmarshal(e.Resource, &sale); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_PAYMENT
+		event.SubscriptionID = sale.BillingAgreementID
+		if e.EventType == "PAYMENT.SALE.COMPLETED" && sale.BillingAgreementID != "" {
+			amount := Amount{CurrencyCode: strings.ToUpper(sale.Amount.Currency), Value: sale.Amount.Total}
+			event.Paid = true
+			event.PaymentID = sale.ID
+			event.Amount = amount.Minor()
+			event.Currency = strings.ToLower(sale.Amount.Currency)
+		}
+
+	case strings.HasPrefix(e.EventType, "PAYMENT.CAPTURE."):
+		// One-off charges, reported again when a pending capture completes
+		var c Capture
+		if err := json.Unmarshal(e.Resource, &c); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_PAYMENT
+		if e.EventType == "PAYMENT.CAPTURE.COMPLETED" && c.CustomID != "" {
+			event.Charge = &gateway.Charge{
+				ID:       c.ID,
+				Status:   strings.ToLower(c.Status),
+				Amount:   c.Amount.Minor(),
+				Currency: strings.ToLower(c.Amount.CurrencyCode),
+			}
+			event.ChargeReference = c.CustomID
+		}
+	}
+	return event, nil
+}
+
+// neutral maps a PayPal subscription onto the neutral model. PayPal bills in
+// advance, so the current period runs from the last payment to the next
+// billing time.
+func (s *Subscription) neutral() *gateway.Subscription {
+	out := &gateway.Subscription{
+		ID:                 s.ID,
+		PriceID:            s.PlanID,
+		CurrentPeriodStart: s.BillingInfo.LastPayment.Time,
+		CurrentPeriodEnd:   s.BillingInfo.NextBillingTime,
+		ApprovalURL:        s.ApprovalURL(),
+	}
+	if s.CustomID != "" {
+		out.CustomerID = customerPrefix + s.CustomID
+	}
+	switch s.Status {
+	case "APPROVAL_PENDING", "APPROVED":
+		out.Status = schema.SUBSCRIPTION_STATUS_INCOMPLETE
+	case "ACTIVE":
+		out.Status = schema.SUBSCRIPTION_STATUS_ACTIVE
+		if s.InTrial() {
+			out.Status = schema.SUBSCRIPTION_STATUS_TRIALING
+			out.TrialEnd = s.BillingInfo.NextBillingTime
+		} else if s.BillingInfo.FailedPaymentsCount > 0 {
+			out.Status = schema.SUBSCRIPTION_STATUS_PAST_DUE
+		}
+	case "SUSPENDED":
+		out.Status = schema.SUBSCRIPTION_STATUS_PAUSED
+	case "CANCELLED", "EXPIRED":
+		out.Status = schema.SUBSCRIPTION_STATUS_CANCELED
+		out.CanceledAt = s.UpdateTime
+	default:
+		out.Status = schema.SUBSCRIPTION_STATUS(strings.ToLower(s.Status))
+	}
+	if out.CurrentPeriodStart == nil {
+		out.CurrentPeriodStart = s.StartTime
+	}
+	return out
+}
+
+var _ gateway.PaymentGateway = (*Gateway)(nil)
@@ -0,0 +1,172 @@
+package paypal
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// zeroDecimal lists the currencies PayPal accepts without a fractional part
+var zeroDecimal = map[string]bool{"HUF": true, "JPY": true, "TWD": true}
+
+// Amount is a PayPal money amount
+type Amount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// NewAmount converts an amount in minor units to PayPal's decimal string
+func NewAmount(minor int64, currency string) Amount {
+	currency = strings.ToUpper(currency)
+	if zeroDecimal[currency] {
+		return Amount{CurrencyCode: currency, Value: strconv.FormatInt(minor, 10)}
+	}
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	return Amount{CurrencyCode: currency, Value: sign + strconv.FormatInt(minor/100, 10) + "." + leftPad(minor%100)}
+}
+
+// Minor converts the amount back to minor units
+func (a Amount) Minor() int64 {
+	whole, frac, _ := strings.Cut(a.Value, ".")
+	n, _ := strconv.ParseInt(whole, 10, 64)
+	if zeroDecimal[a.CurrencyCode] {
+		return n
+	}
+	frac = (frac + "00")[:2]
+	f, _ := strconv.ParseInt(frac, 10, 64)
+	if strings.HasPrefix(whole, "-") {
+		return n*100 - f
+	}
+	return n*100 + f
+}
+
+func leftPad(n int64) string {
+	if n < 10 {
+		return "0" + strconv.FormatInt(n, 10)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// Capture is a captured payment of an order
+type Capture struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Amount   Amount `json:"amount"`
+	CustomID string `json:"custom_id,omitempty"` // Set on the purchase unit by ChargeVault
+}
+
+// Order is a PayPal checkout order
+type Order struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		Payments struct {
+			Captures []Capture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+// Capture returns the first capture of the order
+func (o *Order) Capture() *Capture {
+	for _, u := range o.PurchaseUnits {
+		if len(u.Payments.Captures) > 0 {
+			return &u.Payments.Captures[0]
+		}
+	}
+	return nil
+}
+
+// ChargeVault creates and captures an order paid with a vaulted payment token
+func (c *Client) ChargeVault(ctx context.Context, vaultID string, amount Amount, description, customID, requestID string) (*Order, error) {
+	unit := map[string]any{
+		"amount":      amount,
+		"description": description,
+	}
+	if customID != "" {
+		unit["custom_id"] = customID
+	}
+	in := map[string]any{
+		"intent":         "CAPTURE",
+		"purchase_units": []map[string]any{unit},
+		"payment_source": map[string]any{
+			"paypal": map[string]string{"vault_id": vaultID},
+		},
+	}
+	var out Order
+	if err := c.call(ctx, http.MethodPost, "/v2/checkout/orders", in, requestID, &out); err != nil {
+		return nil, err
+	}
+	if out.Status == "COMPLETED" {
+		return &out, nil
+	}
+
+	path := "/v2/checkout/orders/" + url.PathEscape(out.ID) + "/capture"
+	var captured Order
+	if err := c.call(ctx, http.MethodPost, path, map[string]any{}, requestID+"-capture", &captured); err != nil {
+		return nil, err
+	}
+	return &captured, nil
+}
+
+// Refund is a refund of a captured payment
+type Refund struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount Amount `json:"amount"`
+}
+
+// RefundCapture refunds a captured payment, fully when amount is nil
+func (c *Client) RefundCapture(ctx context.Context, captureID string, amount *Amount, note, requestID string) (*Refund, error) {
+	in := map[string]any{}
+	if amount != nil {
+		in["amount"] = amount
+	}
+	if note != "" {
+		in["note_to_payer"] = note
+	}
+	var out Refund
+	path := "/v2/payments/captures/" + url.PathEscape(captureID) + "/refund"
+	if err := c.call(ctx, http.MethodPost, path, in, requestID, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PaymentToken is a payment method saved in the PayPal vault
+type PaymentToken struct {
+	ID       string `json:"id"`
+	Customer struct {
+		ID string `json:"id"`
+	} `json:"customer"`
+}
+
+// GetPaymentToken retrieves a vaulted payment token
+func (c *Client) GetPaymentToken(ctx context.Context, id string) (*PaymentToken, error) {
+	var out PaymentToken
+	if err := c.call(ctx, http.MethodGet, "/v3/vault/payment-tokens/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListPaymentTokens lists the vaulted payment tokens of a customer
+func (c *Client) ListPaymentTokens(ctx context.Context, customerID string) ([]PaymentToken, error) {
+	var out struct {
+		PaymentTokens []PaymentToken `json:"payment_tokens"`
+	}
+	path := "/v3/vault/payment-tokens?customer_id=" + url.QueryEscape(customerID)
+	if err := c.call(ctx, http.MethodGet, path, nil, "", &out); err != nil {
+		return nil, err
+	}
+	return out.PaymentTokens, nil
+}
+
+// DeletePaymentToken removes a payment token from the vault
+func (c *Client) DeletePaymentToken(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, "/v3/vault/payment-tokens/"+url.PathEscape(id), nil, "", nil)
+}
@@ -0,0 +1,130 @@
+package paypal
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Subscription is a PayPal billing subscription
+type Subscription struct {
+	ID         string     `json:"id"`
+	PlanID     string     `json:"plan_id"`
+	Status     string     `json:"status"`
+	CustomID   string     `json:"custom_id"`
+	StartTime  *time.Time `json:"start_time"`
+	UpdateTime *time.Time `json:"status_update_time"`
+	Subscriber struct {
+		PayerID string `json:"payer_id"`
+	} `json:"subscriber"`
+	BillingInfo struct {
+		NextBillingTime *time.Time `json:"next_billing_time"`
+		LastPayment     struct {
+			Time *time.Time `json:"time"`
+		} `json:"last_payment"`
+		CycleExecutions []struct {
+			TenureType      string `json:"tenure_type"`
+			CyclesCompleted int    `json:"cycles_completed"`
+			CyclesRemaining int    `json:"cycles_remaining"`
+		} `json:"cycle_executions"`
+		FailedPaymentsCount int     `json:"failed_payments_count"`
+		OutstandingBalance  *Amount `json:"outstanding_balance"`
+	} `json:"billing_info"`
+	Links []Link `json:"links"`
+}
+
+// ApprovalURL returns the link the subscriber follows to approve the subscription
+func (s *Subscription) ApprovalURL() string {
+	return linkHref(s.Links, "approve")
+}
+
+// InTrial reports whether the subscription is still in a trial billing cycle
+func (s *Subscription) InTrial() bool {
+	for _, c := range s.BillingInfo.CycleExecutions {
+		if c.TenureType == "TRIAL" && c.CyclesRemaining > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionParams are the fields set when creating a subscription
+type SubscriptionParams struct {
+	PlanID    string
+	CustomID  string
+	Email     string
+	Name      string
+	ReturnURL string
+	CancelURL string
+}
+
+// CreateSubscription creates a subscription that waits for the subscriber's
+// approval at ApprovalURL
+func (c *Client) CreateSubscription(ctx context.Context, p SubscriptionParams, requestID string) (*Subscription, error) {
+	in := map[string]any{
+		"plan_id":   p.PlanID,
+		"custom_id": p.CustomID,
+		"subscriber": map[string]any{
+			"email_address": p.Email,
+			"name":          map[string]string{"given_name": p.Name},
+		},
+		"application_context": map[string]string{
+			"return_url":          p.ReturnURL,
+			"cancel_url":          p.CancelURL,
+			"user_action":         "SUBSCRIBE_NOW",
+			"shipping_preference": "NO_SHIPPING",
+		},
+	}
+	var out Subscription
+	if err := c.call(ctx, http.MethodPost, "/v1/billing/subscriptions", in, requestID, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetSubscription retrieves a subscription
+func (c *Client) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	var out Subscription
+	if err := c.call(ctx, http.MethodGet, "/v1/billing/subscriptions/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReviseSubscription moves a subscription to another plan. The change takes
+// effect once the subscriber approves it at the returned approve link.
+func (c *Client) ReviseSubscription(ctx context.Context, id, planID, requestID string) (string, error) {
+	var out struct {
+		Links []Link `json:"links"`
+	}
+	path := "/v1/billing/subscriptions/" + url.PathEscape(id) + "/revise"
+	if err := c.call(ctx, http.MethodPost, path, map[string]string{"plan_id": planID}, requestID, &out); err != nil {
+		return "", err
+	}
+	return linkHref(out.Links, "approve"), nil
+}
+
+// CancelSubscription cancels a subscription immediately
+func (c *Client) CancelSubscription(ctx context.Context, id, reason string) error {
+	path := "/v1/billing/subscriptions/" + url.PathEscape(id) + "/cancel"
+	return c.call(ctx, http.MethodPost, path, map[string]string{"reason": reason}, "", nil)
+}
+
+// CaptureOutstanding charges the subscriber for the outstanding balance of
+// failed payments
+func (c *Client) CaptureOutstanding(ctx context.Context, id string, amount Amount, note, requestID string) error {
+	path := "/v1/billing/subscriptions/" + url.PathEscape(id) + "/capture"
+	in := map[string]any{
+		"note":         note,
+		"capture_type": "OUTSTANDING_BALANCE",
+		"amount":       amount,
+	}
+	return c.call(ctx, http.MethodPost, path, in, requestID, nil)
+}
+
+// ActivateSubscription reactivates a suspended subscription
+func (c *Client) ActivateSubscription(ctx context.Context, id, reason string) error {
+	path := "/v1/billing/subscriptions/" + url.PathEscape(id) + "/activate"
+	return c.call(ctx, http.MethodPost, path, map[string]string{"reason": reason}, "", nil)
+}
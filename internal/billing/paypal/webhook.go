@@ -0,0 +1,38 @@
+package paypal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Event is a PayPal webhook event
+type Event struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	CreateTime   time.Time       `json:"create_time"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+// VerifyWebhookSignature asks PayPal to verify the transmission headers of a
+// webhook delivery against the configured webhook
+func (c *Client) VerifyWebhookSignature(ctx context.Context, webhookID string, header http.Header, payload []byte) (bool, error) {
+	in := map[string]any{
+		"auth_algo":         header.Get("PAYPAL-AUTH-ALGO"),
+		"cert_url":          header.Get("PAYPAL-CERT-URL"),
+		"transmission_id":   header.Get("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": header.Get("PAYPAL-TRANSMISSION-TIME"),
+		"webhook_id":        webhookID,
+		"webhook_event":     json.RawMessage(payload),
+	}
+	var out struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/v1/notifications/verify-webhook-signature", in, "", &out); err != nil {
+		return false, err
+	}
+	return out.VerificationStatus == "SUCCESS", nil
+}
@@ -0,0 +1,315 @@
+package billing
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	REFERRAL_CODE_LENGTH = 8
+	// Without 0/O and 1/I, which are easily confused when codes are shared
+	referralAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+var (
+	ErrReferralsDisabled   = errors.New("referrals are not enabled")
+	ErrInvalidReferralCode = errors.New("referral code is invalid")
+	ErrOwnReferralCode     = errors.New("users cannot refer themselves")
+	ErrAlreadyReferred     = errors.New("user was already referred")
+	ErrNotNewCustomer      = errors.New("referrals are only for users who never subscribed")
+)
+
+func referralCodes() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_REFERRAL_CODES)
+}
+
+func referrals() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_REFERRALS)
+}
+
+func referralsEnabled() bool {
+	cfg := config.GetBillingConfig().Referrals
+	return len(cfg.ReferrerCredit) > 0 || len(cfg.RefereeCredit) > 0
+}
+
+// ReferralSummary is a user's referral code and the referrals made with it
+type ReferralSummary struct {
+	Code      string `json:"code"`
+	Referred  int64  `json:"referred"`  // Users who claimed the code
+	Qualified int64  `json:"qualified"` // Referrals whose referee paid
+}
+
+// Referral returns the user's referral code, creating it on first use
+func Referral(ctx context.Context, userID bson.ObjectID) (*ReferralSummary, error) {
+	if !referralsEnabled() {
+		return nil, ErrReferralsDisabled
+	}
+	code, err := ensureReferralCode(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	referred, err := referrals().CountDocuments(ctx, bson.M{"referrer_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	qualified, err := referrals().CountDocuments(ctx, bson.M{"referrer_id": userID, "status": bson.M{"$ne": schema.REFERRAL_STATUS_PENDING}})
+	if err != nil {
+		return nil, err
+	}
+	return &ReferralSummary{Code: code.Code, Referred: referred, Qualified: qualified}, nil
+}
+
+func ensureReferralCode(ctx context.Context, userID bson.ObjectID) (*schema.ReferralCode, error) {
+	for {
+		var c schema.ReferralCode
+		err := referralCodes().FindOne(ctx, bson.M{"user_id": userID}).Decode(&c)
+		if err == nil {
+			return &c, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		c = schema.ReferralCode{ID: bson.NewObjectID(), CreatedAt: time.Now().UTC(), UserID: userID, Code: newReferralCode()}
+		_, err = referralCodes().InsertOne(ctx, c)
+		if err == nil {
+			return &c, nil
+		}
+		// The user got a code concurrently, or the code is taken: look again
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+	}
+}
+
+func newReferralCode() string {
+	b := make([]byte, REFERRAL_CODE_LENGTH)
+	rand.Read(b)
+	for i := range b {
+		b[i] = referralAlphabet[int(b[i])%len(referralAlphabet)]
+	}
+	return string(b)
+}
+
+// ClaimReferral records that a user was referred with a code. Only users who
+// never subscribed can be referred, and only once.
+func ClaimReferral(ctx context.Context, userID bson.ObjectID, code string, meta history.Meta) (*schema.Referral, error) {
+	if !referralsEnabled() {
+		return nil, ErrReferralsDisabled
+	}
+	var rc schema.ReferralCode
+	err := referralCodes().FindOne(ctx, bson.M{"code": strings.ToUpper(strings.TrimSpace(code))}).Decode(&rc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidReferralCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rc.UserID == userID {
+		return nil, ErrOwnReferralCode
+	}
+	n, err := subscriptions().CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"status":  bson.M{"$ne": schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, ErrNotNewCustomer
+	}
+
+	now := time.Now().UTC()
+	r := &schema.Referral{
+		ID:         bson.NewObjectID(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ReferrerID: rc.UserID,
+		RefereeID:  userID,
+		Code:       rc.Code,
+		Status:     schema.REFERRAL_STATUS_PENDING,
+	}
+	if _, err := referrals().InsertOne(ctx, r); mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyReferred
+	} else if err != nil {
+		return nil, err
+	}
+	if err := history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    userID,
+		EventType: schema.ACCOUNT_EVENT_REFERRAL,
+		Field:     "referred_by",
+		NewValue:  rc.UserID.Hex(),
+		ChangedBy: userID.Hex(),
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// rewardReferral qualifies the referral of a user whose subscription payment
+// was collected and credits both parties in the currency of the payment
+func rewardReferral(ctx context.Context, g gateway.PaymentGateway, event *gateway.Event) error {
+	if !referralsEnabled() {
+		return nil
+	}
+	var s schema.Subscription
+	err := subscriptions().FindOne(ctx, bson.M{"gateway": g.Name(), "external_id": event.SubscriptionID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := config.GetBillingConfig().Referrals
+	var r schema.Referral
+	err = referrals().FindOneAndUpdate(ctx,
+		bson.M{"referee_id": s.UserID, "status": schema.REFERRAL_STATUS_PENDING},
+		bson.M{"$set": bson.M{
+			"status":          schema.REFERRAL_STATUS_QUALIFIED,
+			"currency":        event.Currency,
+			"referrer_credit": cfg.ReferrerCredit[event.Currency],
+			"referee_credit":  cfg.RefereeCredit[event.Currency],
+			"updated_at":      time.Now().UTC(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Not referred, or qualified by an earlier payment: finish crediting
+		err = referrals().FindOne(ctx, bson.M{"referee_id": s.UserID, "status": schema.REFERRAL_STATUS_QUALIFIED}).Decode(&r)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return creditReferral(ctx, &r)
+}
+
+// creditPendingReferrals credits the referrals of a user who could not be
+// credited before, e.g. because they had no customer at a gateway with
+// customer balances
+func creditPendingReferrals(ctx context.Context, userID bson.ObjectID) error {
+	cur, err := referrals().Find(ctx, bson.M{
+		"status": schema.REFERRAL_STATUS_QUALIFIED,
+		"$or":    bson.A{bson.M{"referrer_id": userID}, bson.M{"referee_id": userID}},
+	})
+	if err != nil {
+		return err
+	}
+	var pending []schema.Referral
+	if err := cur.All(ctx, &pending); err != nil {
+		return err
+	}
+	for i := range pending {
+		if err := creditReferral(ctx, &pending[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// creditReferral credits the parties of a qualified referral that were not
+// credited yet. Idempotency keys make a retry after a partial failure credit
+// each party once.
+func creditReferral(ctx context.Context, r *schema.Referral) error {
+	parties := []struct {
+		role     string
+		userID   bson.ObjectID
+		amount   int64
+		credited *time.Time
+	}{
+		{"referrer", r.ReferrerID, r.ReferrerCredit, r.ReferrerCredited},
+		{"referee", r.RefereeID, r.RefereeCredit, r.RefereeCredited},
+	}
+	done := true
+	for _, p := range parties {
+		if p.credited != nil || p.amount == 0 {
+			continue
+		}
+		ok, err := creditParty(ctx, r, p.role, p.userID, p.amount)
+		if err != nil {
+			_, _ = referrals().UpdateByID(ctx, r.ID, bson.M{"$set": bson.M{"last_error": err.Error(), "updated_at": time.Now().UTC()}})
+			return err
+		}
+		if !ok {
+			done = false
+			continue
+		}
+		now := time.Now().UTC()
+		if _, err := referrals().UpdateByID(ctx, r.ID, bson.M{"$set": bson.M{p.role + "_credited": now, "updated_at": now}}); err != nil {
+			return err
+		}
+		if err := history.RecordAccount(ctx, schema.AccountHistory{
+			UserID:    p.userID,
+			EventType: schema.ACCOUNT_EVENT_REFERRAL,
+			Field:     "referral_credit",
+			NewValue:  fmt.Sprintf("%d %s", p.amount, r.Currency),
+			ChangedBy: history.SYSTEM_ACTOR,
+		}); err != nil {
+			return err
+		}
+	}
+	if !done {
+		return nil
+	}
+	_, err := referrals().UpdateByID(ctx, r.ID, bson.M{
+		"$set":   bson.M{"status": schema.REFERRAL_STATUS_CREDITED, "updated_at": time.Now().UTC()},
+		"$unset": bson.M{"last_error": ""},
+	})
+	return err
+}
+
+// creditParty credits a user's balance at the first of their gateway
+// customers that supports customer balances. It reports false when the user
+// has no such customer yet.
+func creditParty(ctx context.Context, r *schema.Referral, role string, userID bson.ObjectID, amount int64) (bool, error) {
+	cur, err := customers().Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	var list []schema.BillingCustomer
+	if err := cur.All(ctx, &list); err != nil {
+		return false, err
+	}
+	for _, c := range list {
+		g, err := Gateway(c.Gateway)
+		if err != nil {
+			continue
+		}
+		err = g.CreditBalance(ctx, gateway.CreditParams{
+			CustomerID:     c.ExternalID,
+			Amount:         amount,
+			Currency:       r.Currency,
+			Description:    "Referral credit",
+			IdempotencyKey: "referral-" + r.ID.Hex() + "-" + role,
+		})
+		if errors.Is(err, gateway.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	log.Debug().Str("referral", r.ID.Hex()).Str("role", role).Msg("No customer with a balance to credit yet")
+	return false, nil
+}
@@ -0,0 +1,101 @@
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_API_BASE = "https://api.stripe.com"
+	// API_VERSION pins the response shapes this package decodes
+	API_VERSION = "2024-06-20"
+)
+
+// Client is a minimal Stripe REST API client
+type Client struct {
+	apiBase   string
+	secretKey string
+	http      *http.Client
+}
+
+// Error is an error response returned by the Stripe API
+type Error struct {
+	StatusCode int    `json:"-"`
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stripe: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// NewClient creates a client, an empty apiBase uses the live Stripe API
+func NewClient(apiBase, secretKey string) *Client {
+	if apiBase == "" {
+		apiBase = DEFAULT_API_BASE
+	}
+	return &Client{
+		apiBase:   strings.TrimRight(apiBase, "/"),
+		secretKey: secretKey,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call performs an API request. Form parameters are sent as the request body
+// for POST and as the query string otherwise. A non-empty idempotencyKey makes
+// retried POSTs safe.
+func (c *Client) call(ctx context.Context, method, path string, params url.Values, idempotencyKey string, out any) error {
+	u := c.apiBase + path
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(params.Encode())
+	} else if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.secretKey, "")
+	req.Header.Set("Stripe-Version", API_VERSION)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		var e struct {
+			Error Error `json:"error"`
+		}
+		_ = json.Unmarshal(data, &e)
+		e.Error.StatusCode = res.StatusCode
+		if e.Error.Message == "" {
+			e.Error.Message = http.StatusText(res.StatusCode)
+		}
+		return &e.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
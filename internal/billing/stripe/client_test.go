@@ -0,0 +1,104 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallSendsIdempotencyKey(t *testing.T) {
+	f, c := newFakeStripe(t)
+	ctx := context.Background()
+
+	first, err := c.CreateCustomer(ctx, CustomerParams{Email: "ada@example.com", Name: "Ada"}, "customer-u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.lastRequest(http.MethodPost, "/v1/customers").Header.Get("Idempotency-Key"); got != "customer-u1" {
+		t.Fatalf("Idempotency-Key = %q, want customer-u1", got)
+	}
+
+	// A retry with the same key returns the same customer
+	again, err := c.CreateCustomer(ctx, CustomerParams{Email: "ada@example.com", Name: "Ada"}, "customer-u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Fatalf("retry created customer %s, want %s", again.ID, first.ID)
+	}
+}
+
+func TestCallOmitsIdempotencyKeyOnReads(t *testing.T) {
+	f, c := newFakeStripe(t)
+	ctx := context.Background()
+	s, err := c.CreateSubscription(ctx, SubscriptionParams{Customer: "cus_1", PriceID: "price_pro"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.lastRequest(http.MethodPost, "/v1/subscriptions").Header.Get("Idempotency-Key"); got != "" {
+		t.Fatalf("empty key sent as %q", got)
+	}
+	if _, err := c.GetSubscription(ctx, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	req := f.lastRequest(http.MethodGet, "/v1/subscriptions/"+s.ID)
+	if req.Header.Get("Idempotency-Key") != "" || req.Header.Get("Content-Type") != "" {
+		t.Fatalf("GET sent POST headers: %v", req.Header)
+	}
+}
+
+func TestCallDecodesErrors(t *testing.T) {
+	_, c := newFakeStripe(t)
+	_, err := c.GetSubscription(context.Background(), "sub_unknown")
+	var se *Error
+	if !errors.As(err, &se) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if se.StatusCode != http.StatusNotFound || se.Code != "resource_missing" || se.Type != "invalid_request_error" {
+		t.Fatalf("decoded %+v", se)
+	}
+	if se.Message != "No such subscription: 'sub_unknown'" {
+		t.Fatalf("message = %q", se.Message)
+	}
+}
+
+func TestCallDecodesUnauthorized(t *testing.T) {
+	f, _ := newFakeStripe(t)
+	c := NewClient(f.server.URL, "sk_test_wrong")
+	_, err := c.CreateCustomer(context.Background(), CustomerParams{Email: "ada@example.com"}, "")
+	var se *Error
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want a 401 *Error", err)
+	}
+}
+
+func TestCallErrorWithoutBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := NewClient(server.URL, "sk_test_123").DeleteCustomer(context.Background(), "cus_1")
+	var se *Error
+	if !errors.As(err, &se) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if se.StatusCode != http.StatusBadGateway || se.Message != http.StatusText(http.StatusBadGateway) {
+		t.Fatalf("decoded %+v", se)
+	}
+}
+
+func TestGatewayDeleteMissingCustomer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"type":"invalid_request_error","code":"resource_missing","message":"No such customer"}}`))
+	}))
+	defer server.Close()
+
+	g := NewGateway(NewClient(server.URL, "sk_test_123"), "whsec")
+	if err := g.DeleteCustomer(context.Background(), "cus_gone"); err != nil {
+		t.Fatalf("deleting a missing customer: %v", err)
+	}
+}
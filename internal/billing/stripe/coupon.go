@@ -0,0 +1,89 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Coupon is a Stripe coupon object
+type Coupon struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	PercentOff       float64 `json:"percent_off"`
+	AmountOff        int64   `json:"amount_off"`
+	Currency         string  `json:"currency"`
+	Duration         string  `json:"duration"`
+	DurationInMonths int     `json:"duration_in_months"`
+	Valid            bool    `json:"valid"`
+}
+
+// CouponParams are the terms of a coupon. Either PercentOff or AmountOff
+// with Currency is set.
+type CouponParams struct {
+	ID               string
+	Name             string
+	PercentOff       int
+	AmountOff        int64
+	Currency         string
+	Duration         string
+	DurationInMonths int
+}
+
+// BalanceTransaction is a change of a customer's credit balance. Negative
+// amounts are credits.
+type BalanceTransaction struct {
+	ID            string `json:"id"`
+	Customer      string `json:"customer"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	EndingBalance int64  `json:"ending_balance"`
+}
+
+// CreateCoupon creates a coupon with a chosen ID. Its terms cannot be
+// changed afterwards.
+func (c *Client) CreateCoupon(ctx context.Context, p CouponParams, idempotencyKey string) (*Coupon, error) {
+	params := url.Values{}
+	params.Set("id", p.ID)
+	params.Set("name", p.Name)
+	if p.PercentOff > 0 {
+		params.Set("percent_off", strconv.Itoa(p.PercentOff))
+	} else {
+		params.Set("amount_off", strconv.FormatInt(p.AmountOff, 10))
+		params.Set("currency", p.Currency)
+	}
+	params.Set("duration", p.Duration)
+	if p.DurationInMonths > 0 {
+		params.Set("duration_in_months", strconv.Itoa(p.DurationInMonths))
+	}
+	var out Coupon
+	if err := c.call(ctx, http.MethodPost, "/v1/coupons", params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetCoupon retrieves a coupon
+func (c *Client) GetCoupon(ctx context.Context, id string) (*Coupon, error) {
+	var out Coupon
+	if err := c.call(ctx, http.MethodGet, "/v1/coupons/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreditCustomer adds a credit to the customer's balance, which Stripe
+// applies to the customer's next invoices
+func (c *Client) CreditCustomer(ctx context.Context, customerID string, amount int64, currency, description, idempotencyKey string) (*BalanceTransaction, error) {
+	params := url.Values{}
+	params.Set("amount", strconv.FormatInt(-amount, 10))
+	params.Set("currency", currency)
+	params.Set("description", description)
+	var out BalanceTransaction
+	path := "/v1/customers/" + url.PathEscape(customerID) + "/balance_transactions"
+	if err := c.call(ctx, http.MethodPost, path, params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
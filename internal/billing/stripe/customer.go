@@ -0,0 +1,42 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Customer is a Stripe customer object
+type Customer struct {
+	ID      string `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
+
+// CustomerParams are the fields set when creating a customer
+type CustomerParams struct {
+	Email    string
+	Name     string
+	Metadata map[string]string
+}
+
+// CreateCustomer creates a customer
+func (c *Client) CreateCustomer(ctx context.Context, p CustomerParams, idempotencyKey string) (*Customer, error) {
+	params := url.Values{}
+	params.Set("email", p.Email)
+	params.Set("name", p.Name)
+	for k, v := range p.Metadata {
+		params.Set("metadata["+k+"]", v)
+	}
+	var out Customer
+	if err := c.call(ctx, http.MethodPost, "/v1/customers", params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteCustomer permanently deletes a customer and cancels its subscriptions
+func (c *Client) DeleteCustomer(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, "/v1/customers/"+url.PathEscape(id), nil, "", nil)
+}
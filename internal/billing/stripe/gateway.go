@@ -0,0 +1,290 @@
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// METADATA_REFERENCE is the payment intent metadata key holding the
+// reference of a charge
+const METADATA_REFERENCE = "reference"
+
+// Gateway implements gateway.PaymentGateway on top of the Stripe API
+type Gateway struct {
+	client        *Client
+	webhookSecret string
+}
+
+// NewGateway creates the Stripe payment gateway
+func NewGateway(client *Client, webhookSecret string) *Gateway {
+	return &Gateway{client: client, webhookSecret: webhookSecret}
+}
+
+func (g *Gateway) Name() string {
+	return gateway.STRIPE
+}
+
+func (g *Gateway) CreateCustomer(ctx context.Context, p gateway.CustomerParams) (string, error) {
+	c, err := g.client.CreateCustomer(ctx, CustomerParams{
+		Email:    p.Email,
+		Name:     p.Name,
+		Metadata: map[string]string{"user_id": p.UserID},
+	}, p.IdempotencyKey)
+	if err != nil {
+		return "", err
+	}
+	return c.ID, nil
+}
+
+// DeleteCustomer deletes the customer, which also cancels its subscriptions.
+// A customer that no longer exists counts as deleted.
+func (g *Gateway) DeleteCustomer(ctx context.Context, customerID string) error {
+	err := g.client.DeleteCustomer(ctx, customerID)
+	var se *Error
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (g *Gateway) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	return g.client.AttachPaymentMethod(ctx, customerID, paymentMethodID)
+}
+
+func (g *Gateway) Charge(ctx context.Context, p gateway.ChargeParams) (*gateway.Charge, error) {
+	pi, err := g.client.CreatePaymentIntent(ctx, PaymentIntentParams{
+		Customer:      p.CustomerID,
+		PaymentMethod: p.PaymentMethodID,
+		Amount:        p.Amount,
+		Currency:      strings.ToLower(p.Currency),
+		Description:   p.Description,
+		Metadata:      chargeMetadata(p.Reference),
+	}, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return &gateway.Charge{ID: pi.ID, Status: pi.Status, Amount: pi.Amount, Currency: pi.Currency}, nil
+}
+
+// chargeMetadata stores the reference of a charge on its payment intent
+func chargeMetadata(reference string) map[string]string {
+	if reference == "" {
+		return nil
+	}
+	return map[string]string{METADATA_REFERENCE: reference}
+}
+
+func (g *Gateway) Refund(ctx context.Context, p gateway.RefundParams) (*gateway.Refund, error) {
+	r, err := g.client.CreateRefund(ctx, p.ChargeID, p.Amount, p.Reason, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return &gateway.Refund{ID: r.ID, Status: r.Status, Amount: r.Amount}, nil
+}
+
+// CreateSubscription creates a subscription that stays incomplete until the
+// first payment is confirmed with the returned client secret
+func (g *Gateway) CreateSubscription(ctx context.Context, p gateway.SubscriptionParams) (*gateway.Subscription, error) {
+	s, err := g.client.CreateSubscription(ctx, SubscriptionParams{
+		Customer:  p.CustomerID,
+		PriceID:   p.PriceID,
+		TrialDays: p.TrialDays,
+		CouponID:  p.CouponID,
+		Metadata:  map[string]string{"user_id": p.UserID},
+	}, p.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+func (g *Gateway) GetSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	s, err := g.client.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+func (g *Gateway) ChangeSubscriptionPrice(ctx context.Context, id, priceID, idempotencyKey string) (*gateway.Subscription, error) {
+	s, err := g.client.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s, err = g.client.ChangePrice(ctx, s, priceID, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+func (g *Gateway) ChangeSubscriptionQuantity(ctx context.Context, id string, quantity int64, idempotencyKey string) (*gateway.Subscription, error) {
+	s, err := g.client.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s, err = g.client.ChangeQuantity(ctx, s, quantity, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+func (g *Gateway) CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*gateway.Subscription, error) {
+	var s *Subscription
+	var err error
+	if atPeriodEnd {
+		s, err = g.client.SetCancelAtPeriodEnd(ctx, id, true)
+	} else {
+		s, err = g.client.CancelSubscription(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+// ResumeSubscription withdraws a cancellation scheduled for the period end
+func (g *Gateway) ResumeSubscription(ctx context.Context, id string) (*gateway.Subscription, error) {
+	s, err := g.client.SetCancelAtPeriodEnd(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.neutral(), nil
+}
+
+// RetryPayment pays the failed invoice, or the subscription's latest invoice
+// when none is given, with the customer's default payment method
+func (g *Gateway) RetryPayment(ctx context.Context, subscriptionID, invoiceID, idempotencyKey string) error {
+	if invoiceID == "" {
+		s, err := g.client.GetSubscription(ctx, subscriptionID)
+		if err != nil {
+			return err
+		}
+		if s.LatestInvoice == nil {
+			return nil
+		}
+		invoiceID = s.LatestInvoice.ID
+	}
+	inv, err := g.client.GetInvoice(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+	if inv.Status != "open" {
+		return nil
+	}
+	_, err = g.client.PayInvoice(ctx, invoiceID, idempotencyKey)
+	return err
+}
+
+// CreditBalance adds a customer balance transaction, which Stripe deducts
+// from the customer's next invoices
+func (g *Gateway) CreditBalance(ctx context.Context, p gateway.CreditParams) error {
+	_, err := g.client.CreditCustomer(ctx, p.CustomerID, p.Amount, strings.ToLower(p.Currency), p.Description, p.IdempotencyKey)
+	return err
+}
+
+// ParseWebhook verifies the Stripe-Signature header and maps the event to
+// the subscription it affects. Payment intent events carry no subscription,
+// so it is looked up through the intent's invoice.
+func (g *Gateway) ParseWebhook(ctx context.Context, payload []byte, header http.Header) (*gateway.Event, error) {
+	e, err := ParseEvent(payload, header.Get("Stripe-Signature"), g.webhookSecret, DEFAULT_TOLERANCE, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	event := &gateway.Event{
+		ID:        e.ID,
+		Type:      e.Type,
+		Kind:      gateway.EVENT_KIND_OTHER,
+		CreatedAt: time.Unix(e.Created, 0).UTC(),
+	}
+	switch {
+	case strings.HasPrefix(e.Type, "customer.subscription."):
+		var s Subscription
+		if err := json.Unmarshal(e.Data.Object, &s); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_SUBSCRIPTION
+		event.SubscriptionID = s.ID
+		event.CustomerID = s.Customer
+
+	case strings.HasPrefix(e.Type, "invoice."):
+		var inv Invoice
+		if err := json.Unmarshal(e.Data.Object, &inv); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_INVOICE
+		event.InvoiceID = inv.ID
+		event.SubscriptionID = inv.Subscription
+		event.CustomerID = inv.Customer
+		event.PaymentFailed = e.Type == "invoice.payment_failed" && inv.Subscription != ""
+		if e.Type == "invoice.paid" && inv.AmountPaid > 0 {
+			event.Paid = true
+			event.PaymentID = inv.ID
+			event.Amount = inv.AmountPaid
+			event.Currency = inv.Currency
+		}
+
+	case strings.HasPrefix(e.Type, "payment_intent."):
+		var pi PaymentIntent
+		if err := json.Unmarshal(e.Data.Object, &pi); err != nil {
+			return nil, err
+		}
+		event.Kind = gateway.EVENT_KIND_PAYMENT
+		event.InvoiceID = pi.Invoice
+		event.CustomerID = pi.Customer
+		if e.Type == "payment_intent.succeeded" && pi.Metadata[METADATA_REFERENCE] != "" {
+			event.Charge = &gateway.Charge{ID: pi.ID, Status: pi.Status, Amount: pi.Amount, Currency: pi.Currency}
+			event.ChargeReference = pi.Metadata[METADATA_REFERENCE]
+		}
+		if pi.Invoice != "" {
+			inv, err := g.client.GetInvoice(ctx, pi.Invoice)
+			if err != nil {
+				return nil, err
+			}
+			event.SubscriptionID = inv.Subscription
+		}
+	}
+	return event, nil
+}
+
+// Client returns the API client for Stripe features outside the gateway interface
+func (g *Gateway) Client() *Client {
+	return g.client
+}
+
+// neutral converts a Stripe subscription, whose statuses the neutral model
+// already uses
+func (s *Subscription) neutral() *gateway.Subscription {
+	return &gateway.Subscription{
+		ID:                 s.ID,
+		CustomerID:         s.Customer,
+		PriceID:            s.PriceID(),
+		Quantity:           s.Quantity(),
+		Status:             schema.SUBSCRIPTION_STATUS(s.Status),
+		CurrentPeriodStart: unixTime(s.CurrentPeriodStart),
+		CurrentPeriodEnd:   unixTime(s.CurrentPeriodEnd),
+		TrialEnd:           unixTime(s.TrialEnd),
+		CancelAtPeriodEnd:  s.CancelAtPeriodEnd,
+		CanceledAt:         unixTime(s.CanceledAt),
+		ClientSecret:       s.ClientSecret(),
+	}
+}
+
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0).UTC()
+	return &t
+}
+
+var _ gateway.PaymentGateway = (*Gateway)(nil)
@@ -0,0 +1,50 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Invoice is a Stripe invoice object
+type Invoice struct {
+	ID           string `json:"id"`
+	Customer     string `json:"customer"`
+	Subscription string `json:"subscription"`
+	Status       string `json:"status"`
+	Currency     string `json:"currency"`
+	AmountDue    int64  `json:"amount_due"`
+	AmountPaid   int64  `json:"amount_paid"`
+	AttemptCount int    `json:"attempt_count"`
+	Paid         bool   `json:"paid"`
+}
+
+// PaymentIntent is a Stripe payment intent object
+type PaymentIntent struct {
+	ID       string            `json:"id"`
+	Customer string            `json:"customer"`
+	Invoice  string            `json:"invoice"`
+	Status   string            `json:"status"`
+	Amount   int64             `json:"amount"`
+	Currency string            `json:"currency"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// GetInvoice retrieves an invoice
+func (c *Client) GetInvoice(ctx context.Context, id string) (*Invoice, error) {
+	var out Invoice
+	if err := c.call(ctx, http.MethodGet, "/v1/invoices/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PayInvoice attempts to collect an open invoice with the customer's default
+// payment method
+func (c *Client) PayInvoice(ctx context.Context, id, idempotencyKey string) (*Invoice, error) {
+	var out Invoice
+	if err := c.call(ctx, http.MethodPost, "/v1/invoices/"+url.PathEscape(id)+"/pay", url.Values{}, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,50 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CreateMeterEvent reports usage to a billing meter. Stripe deduplicates
+// events by identifier, so a retried report is counted once.
+func (c *Client) CreateMeterEvent(ctx context.Context, eventName, customerID string, value int64, identifier string, timestamp time.Time) error {
+	params := url.Values{}
+	params.Set("event_name", eventName)
+	params.Set("payload[stripe_customer_id]", customerID)
+	params.Set("payload[value]", strconv.FormatInt(value, 10))
+	params.Set("identifier", identifier)
+	params.Set("timestamp", strconv.FormatInt(timestamp.Unix(), 10))
+	return c.call(ctx, http.MethodPost, "/v1/billing/meter_events", params, identifier, nil)
+}
+
+// MeterEventSummary is the usage Stripe aggregated for a customer in a period
+type MeterEventSummary struct {
+	AggregatedValue float64 `json:"aggregated_value"`
+	StartTime       int64   `json:"start_time"`
+	EndTime         int64   `json:"end_time"`
+}
+
+// MeterUsage returns the usage Stripe aggregated for a customer between start
+// and end. Both must be aligned to full hours.
+func (c *Client) MeterUsage(ctx context.Context, meterID, customerID string, start, end time.Time) (float64, error) {
+	params := url.Values{}
+	params.Set("customer", customerID)
+	params.Set("start_time", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end_time", strconv.FormatInt(end.Unix(), 10))
+	params.Set("limit", "100")
+	var out struct {
+		Data []MeterEventSummary `json:"data"`
+	}
+	path := "/v1/billing/meters/" + url.PathEscape(meterID) + "/event_summaries"
+	if err := c.call(ctx, http.MethodGet, path, params, "", &out); err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, s := range out.Data {
+		total += s.AggregatedValue
+	}
+	return total, nil
+}
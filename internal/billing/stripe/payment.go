@@ -0,0 +1,81 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Refund is a Stripe refund object
+type Refund struct {
+	ID            string `json:"id"`
+	PaymentIntent string `json:"payment_intent"`
+	Status        string `json:"status"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+}
+
+// AttachPaymentMethod attaches a payment method to a customer and makes it
+// the default for invoices
+func (c *Client) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	params := url.Values{}
+	params.Set("customer", customerID)
+	path := "/v1/payment_methods/" + url.PathEscape(paymentMethodID) + "/attach"
+	if err := c.call(ctx, http.MethodPost, path, params, "", nil); err != nil {
+		return err
+	}
+
+	params = url.Values{}
+	params.Set("invoice_settings[default_payment_method]", paymentMethodID)
+	return c.call(ctx, http.MethodPost, "/v1/customers/"+url.PathEscape(customerID), params, "", nil)
+}
+
+// PaymentIntentParams are the fields set when charging a saved payment method
+type PaymentIntentParams struct {
+	Customer      string
+	PaymentMethod string
+	Amount        int64
+	Currency      string
+	Description   string
+	Metadata      map[string]string
+}
+
+// CreatePaymentIntent charges a saved payment method off-session
+func (c *Client) CreatePaymentIntent(ctx context.Context, p PaymentIntentParams, idempotencyKey string) (*PaymentIntent, error) {
+	params := url.Values{}
+	params.Set("customer", p.Customer)
+	params.Set("payment_method", p.PaymentMethod)
+	params.Set("amount", strconv.FormatInt(p.Amount, 10))
+	params.Set("currency", p.Currency)
+	params.Set("confirm", "true")
+	params.Set("off_session", "true")
+	if p.Description != "" {
+		params.Set("description", p.Description)
+	}
+	for k, v := range p.Metadata {
+		params.Set("metadata["+k+"]", v)
+	}
+	var out PaymentIntent
+	if err := c.call(ctx, http.MethodPost, "/v1/payment_intents", params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateRefund refunds a payment intent, fully when amount is 0
+func (c *Client) CreateRefund(ctx context.Context, paymentIntentID string, amount int64, reason string, idempotencyKey string) (*Refund, error) {
+	params := url.Values{}
+	params.Set("payment_intent", paymentIntentID)
+	if amount > 0 {
+		params.Set("amount", strconv.FormatInt(amount, 10))
+	}
+	if reason != "" {
+		params.Set("reason", reason)
+	}
+	var out Refund
+	if err := c.call(ctx, http.MethodPost, "/v1/refunds", params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,228 @@
+package stripe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStripe is a stripe-mock style stand-in for the Stripe API, keeping
+// customers and subscriptions in memory
+type fakeStripe struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu            sync.Mutex
+	seq           int
+	subscriptions map[string]*Subscription
+	invoices      map[string]*Invoice
+	// idempotent caches the response of each Idempotency-Key like Stripe does
+	idempotent map[string][]byte
+	requests   []*http.Request
+}
+
+func newFakeStripe(t *testing.T) (*fakeStripe, *Client) {
+	f := &fakeStripe{
+		t:             t,
+		subscriptions: map[string]*Subscription{},
+		invoices:      map[string]*Invoice{},
+		idempotent:    map[string][]byte{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/customers", f.createCustomer)
+	mux.HandleFunc("POST /v1/subscriptions", f.createSubscription)
+	mux.HandleFunc("GET /v1/subscriptions/{id}", f.getSubscription)
+	mux.HandleFunc("POST /v1/subscriptions/{id}", f.updateSubscription)
+	mux.HandleFunc("DELETE /v1/subscriptions/{id}", f.cancelSubscription)
+	mux.HandleFunc("GET /v1/invoices/{id}", f.getInvoice)
+	f.server = httptest.NewServer(f.authenticate(mux))
+	t.Cleanup(f.server.Close)
+	return f, NewClient(f.server.URL, "sk_test_123")
+}
+
+func (f *fakeStripe) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, ok := r.BasicAuth(); !ok || key != "sk_test_123" {
+			f.fail(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API Key provided")
+			return
+		}
+		if r.Header.Get("Stripe-Version") != API_VERSION {
+			f.fail(w, http.StatusBadRequest, "invalid_request_error", "", "missing Stripe-Version")
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				f.fail(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+				return
+			}
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Clone(r.Context()))
+		f.mu.Unlock()
+
+		key := r.Header.Get("Idempotency-Key")
+		f.mu.Lock()
+		cached, replay := f.idempotent[key]
+		f.mu.Unlock()
+		if key != "" && replay {
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.Write(cached)
+			return
+		}
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		if key != "" && rec.Code < 300 {
+			f.mu.Lock()
+			f.idempotent[key] = rec.Body.Bytes()
+			f.mu.Unlock()
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+func (f *fakeStripe) id(prefix string) string {
+	f.seq++
+	return prefix + "_" + strconv.Itoa(f.seq)
+}
+
+func (f *fakeStripe) reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeStripe) fail(w http.ResponseWriter, status int, typ, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"type":%q,"code":%q,"message":%q}}`, typ, code, message)
+}
+
+func (f *fakeStripe) createCustomer(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reply(w, Customer{ID: f.id("cus"), Email: r.PostForm.Get("email"), Name: r.PostForm.Get("name")})
+}
+
+func (f *fakeStripe) createSubscription(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.PostForm.Get("items[0][price]") == "price_missing" {
+		f.fail(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such price: 'price_missing'")
+		return
+	}
+	now := time.Now().Unix()
+	s := &Subscription{
+		ID:                 f.id("sub"),
+		Customer:           r.PostForm.Get("customer"),
+		Status:             "incomplete",
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   now + 30*24*3600,
+	}
+	s.Items.Data = []SubscriptionItem{{ID: f.id("si"), Quantity: 1}}
+	s.Items.Data[0].Price.ID = r.PostForm.Get("items[0][price]")
+	if days, _ := strconv.Atoi(r.PostForm.Get("trial_period_days")); days > 0 {
+		s.Status = "trialing"
+		s.TrialEnd = now + int64(days)*24*3600
+	}
+	inv := &Invoice{ID: f.id("in"), Customer: s.Customer, Subscription: s.ID, Status: "open"}
+	f.invoices[inv.ID] = inv
+	s.LatestInvoice = &struct {
+		ID            string `json:"id"`
+		PaymentIntent *struct {
+			ID           string `json:"id"`
+			ClientSecret string `json:"client_secret"`
+			Status       string `json:"status"`
+		} `json:"payment_intent"`
+	}{ID: inv.ID}
+	if r.PostForm.Get("expand[]") == "latest_invoice.payment_intent" {
+		s.LatestInvoice.PaymentIntent = &struct {
+			ID           string `json:"id"`
+			ClientSecret string `json:"client_secret"`
+			Status       string `json:"status"`
+		}{ID: "pi_" + s.ID, ClientSecret: "pi_" + s.ID + "_secret", Status: "requires_payment_method"}
+	}
+	f.subscriptions[s.ID] = s
+	f.reply(w, s)
+}
+
+func (f *fakeStripe) subscription(w http.ResponseWriter, r *http.Request) *Subscription {
+	s, ok := f.subscriptions[r.PathValue("id")]
+	if !ok {
+		f.fail(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such subscription: '"+r.PathValue("id")+"'")
+	}
+	return s
+}
+
+func (f *fakeStripe) getSubscription(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s := f.subscription(w, r); s != nil {
+		f.reply(w, s)
+	}
+}
+
+func (f *fakeStripe) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.subscription(w, r)
+	if s == nil {
+		return
+	}
+	if s.Status == "canceled" {
+		f.fail(w, http.StatusBadRequest, "invalid_request_error", "", "A canceled subscription can only update its cancellation_details and metadata.")
+		return
+	}
+	form := r.PostForm
+	if id := form.Get("items[0][id]"); id != "" && id != s.Items.Data[0].ID {
+		f.fail(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such subscription item")
+		return
+	}
+	if p := form.Get("items[0][price]"); p != "" {
+		s.Items.Data[0].Price.ID = p
+	}
+	if q := form.Get("items[0][quantity]"); q != "" {
+		s.Items.Data[0].Quantity, _ = strconv.ParseInt(q, 10, 64)
+	}
+	if c := form.Get("cancel_at_period_end"); c != "" {
+		s.CancelAtPeriodEnd = c == "true"
+	}
+	f.reply(w, s)
+}
+
+func (f *fakeStripe) cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s := f.subscription(w, r); s != nil {
+		s.Status = "canceled"
+		s.CanceledAt = time.Now().Unix()
+		f.reply(w, s)
+	}
+}
+
+func (f *fakeStripe) getInvoice(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inv, ok := f.invoices[r.PathValue("id")]
+	if !ok {
+		f.fail(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such invoice")
+		return
+	}
+	f.reply(w, inv)
+}
+
+// lastRequest returns the latest request to the given method and path
+func (f *fakeStripe) lastRequest(method, path string) *http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if r := f.requests[i]; r.Method == method && r.URL.Path == path {
+			return r
+		}
+	}
+	f.t.Fatalf("no %s %s request", method, path)
+	return nil
+}
@@ -0,0 +1,158 @@
+package stripe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Subscription is a Stripe subscription object
+type Subscription struct {
+	ID                 string `json:"id"`
+	Customer           string `json:"customer"`
+	Status             string `json:"status"`
+	CurrentPeriodStart int64  `json:"current_period_start"`
+	CurrentPeriodEnd   int64  `json:"current_period_end"`
+	CancelAtPeriodEnd  bool   `json:"cancel_at_period_end"`
+	CanceledAt         int64  `json:"canceled_at"`
+	TrialEnd           int64  `json:"trial_end"`
+	Items              struct {
+		Data []SubscriptionItem `json:"data"`
+	} `json:"items"`
+	LatestInvoice *struct {
+		ID            string `json:"id"`
+		PaymentIntent *struct {
+			ID           string `json:"id"`
+			ClientSecret string `json:"client_secret"`
+			Status       string `json:"status"`
+		} `json:"payment_intent"`
+	} `json:"latest_invoice"`
+}
+
+// SubscriptionItem is one price of a subscription
+type SubscriptionItem struct {
+	ID       string `json:"id"`
+	Quantity int64  `json:"quantity"`
+	Price    struct {
+		ID string `json:"id"`
+	} `json:"price"`
+}
+
+// PriceID returns the price of the subscription's first item
+func (s *Subscription) PriceID() string {
+	if len(s.Items.Data) == 0 {
+		return ""
+	}
+	return s.Items.Data[0].Price.ID
+}
+
+// Quantity returns the quantity of the subscription's first item
+func (s *Subscription) Quantity() int64 {
+	if len(s.Items.Data) == 0 {
+		return 0
+	}
+	return s.Items.Data[0].Quantity
+}
+
+// ClientSecret returns the secret the frontend needs to confirm the first payment
+func (s *Subscription) ClientSecret() string {
+	if s.LatestInvoice == nil || s.LatestInvoice.PaymentIntent == nil {
+		return ""
+	}
+	return s.LatestInvoice.PaymentIntent.ClientSecret
+}
+
+// SubscriptionParams are the fields set when creating a subscription
+type SubscriptionParams struct {
+	Customer  string
+	PriceID   string
+	TrialDays int
+	CouponID  string
+	Metadata  map[string]string
+}
+
+// CreateSubscription creates a subscription that stays incomplete until the
+// first payment is confirmed with the returned client secret
+func (c *Client) CreateSubscription(ctx context.Context, p SubscriptionParams, idempotencyKey string) (*Subscription, error) {
+	params := url.Values{}
+	params.Set("customer", p.Customer)
+	params.Set("items[0][price]", p.PriceID)
+	params.Set("payment_behavior", "default_incomplete")
+	params.Set("payment_settings[save_default_payment_method]", "on_subscription")
+	params.Add("expand[]", "latest_invoice.payment_intent")
+	if p.TrialDays > 0 {
+		params.Set("trial_period_days", strconv.Itoa(p.TrialDays))
+	}
+	if p.CouponID != "" {
+		params.Set("discounts[0][coupon]", p.CouponID)
+	}
+	for k, v := range p.Metadata {
+		params.Set("metadata["+k+"]", v)
+	}
+	var out Subscription
+	if err := c.call(ctx, http.MethodPost, "/v1/subscriptions", params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetSubscription retrieves a subscription
+func (c *Client) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	var out Subscription
+	if err := c.call(ctx, http.MethodGet, "/v1/subscriptions/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ChangePrice moves the subscription's item to another price, prorating the difference
+func (c *Client) ChangePrice(ctx context.Context, sub *Subscription, priceID string, idempotencyKey string) (*Subscription, error) {
+	params := url.Values{}
+	if len(sub.Items.Data) > 0 {
+		params.Set("items[0][id]", sub.Items.Data[0].ID)
+	}
+	params.Set("items[0][price]", priceID)
+	params.Set("proration_behavior", "create_prorations")
+	params.Set("cancel_at_period_end", "false")
+	var out Subscription
+	if err := c.call(ctx, http.MethodPost, "/v1/subscriptions/"+url.PathEscape(sub.ID), params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ChangeQuantity sets the quantity of the subscription's item, prorating the difference
+func (c *Client) ChangeQuantity(ctx context.Context, sub *Subscription, quantity int64, idempotencyKey string) (*Subscription, error) {
+	params := url.Values{}
+	if len(sub.Items.Data) > 0 {
+		params.Set("items[0][id]", sub.Items.Data[0].ID)
+	}
+	params.Set("items[0][quantity]", strconv.FormatInt(quantity, 10))
+	params.Set("proration_behavior", "create_prorations")
+	var out Subscription
+	if err := c.call(ctx, http.MethodPost, "/v1/subscriptions/"+url.PathEscape(sub.ID), params, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetCancelAtPeriodEnd schedules (or with false, withdraws) cancellation at the end of the period
+func (c *Client) SetCancelAtPeriodEnd(ctx context.Context, id string, cancel bool) (*Subscription, error) {
+	params := url.Values{}
+	params.Set("cancel_at_period_end", strconv.FormatBool(cancel))
+	var out Subscription
+	if err := c.call(ctx, http.MethodPost, "/v1/subscriptions/"+url.PathEscape(id), params, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelSubscription cancels a subscription immediately
+func (c *Client) CancelSubscription(ctx context.Context, id string) (*Subscription, error) {
+	var out Subscription
+	if err := c.call(ctx, http.MethodDelete, "/v1/subscriptions/"+url.PathEscape(id), nil, "", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,114 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/schema"
+)
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	f, c := newFakeStripe(t)
+	g := NewGateway(c, "whsec")
+	ctx := context.Background()
+
+	s, err := g.CreateSubscription(ctx, gateway.SubscriptionParams{
+		CustomerID:     "cus_1",
+		PriceID:        "price_pro",
+		UserID:         "u1",
+		IdempotencyKey: "subscribe-u1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != schema.SUBSCRIPTION_STATUS_INCOMPLETE || s.PriceID != "price_pro" || s.Quantity != 1 {
+		t.Fatalf("created %+v", s)
+	}
+	if s.ClientSecret == "" {
+		t.Fatal("no client secret to confirm the first payment")
+	}
+	form := f.lastRequest(http.MethodPost, "/v1/subscriptions").PostForm
+	if form.Get("payment_behavior") != "default_incomplete" || form.Get("metadata[user_id]") != "u1" {
+		t.Fatalf("create params %v", form)
+	}
+
+	s, err = g.ChangeSubscriptionPrice(ctx, s.ID, "price_business", "change-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.PriceID != "price_business" {
+		t.Fatalf("price = %s after change", s.PriceID)
+	}
+	req := f.lastRequest(http.MethodPost, "/v1/subscriptions/"+s.ID)
+	if req.PostForm.Get("proration_behavior") != "create_prorations" || req.PostForm.Get("items[0][id]") == "" {
+		t.Fatalf("change params %v", req.PostForm)
+	}
+	if req.Header.Get("Idempotency-Key") != "change-1" {
+		t.Fatalf("change key %q", req.Header.Get("Idempotency-Key"))
+	}
+
+	s, err = g.ChangeSubscriptionQuantity(ctx, s.ID, 5, "seats-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Quantity != 5 {
+		t.Fatalf("quantity = %d", s.Quantity)
+	}
+
+	s, err = g.CancelSubscription(ctx, s.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.CancelAtPeriodEnd || s.Status == schema.SUBSCRIPTION_STATUS_CANCELED {
+		t.Fatalf("scheduled cancellation %+v", s)
+	}
+	s, err = g.ResumeSubscription(ctx, s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CancelAtPeriodEnd {
+		t.Fatal("cancellation not withdrawn")
+	}
+
+	s, err = g.CancelSubscription(ctx, s.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != schema.SUBSCRIPTION_STATUS_CANCELED || s.CanceledAt == nil {
+		t.Fatalf("canceled %+v", s)
+	}
+
+	// Stripe refuses to change a canceled subscription
+	_, err = g.ChangeSubscriptionPrice(ctx, s.ID, "price_pro", "change-2")
+	var se *Error
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Fatalf("changing a canceled subscription: %v", err)
+	}
+}
+
+func TestCreateSubscriptionWithTrial(t *testing.T) {
+	_, c := newFakeStripe(t)
+	s, err := NewGateway(c, "whsec").CreateSubscription(context.Background(), gateway.SubscriptionParams{
+		CustomerID: "cus_1",
+		PriceID:    "price_pro",
+		TrialDays:  14,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != schema.SUBSCRIPTION_STATUS_TRIALING || s.TrialEnd == nil {
+		t.Fatalf("trial subscription %+v", s)
+	}
+}
+
+func TestCreateSubscriptionUnknownPrice(t *testing.T) {
+	_, c := newFakeStripe(t)
+	_, err := c.CreateSubscription(context.Background(), SubscriptionParams{Customer: "cus_1", PriceID: "price_missing"}, "k")
+	var se *Error
+	if !errors.As(err, &se) || se.Code != "resource_missing" {
+		t.Fatalf("err = %v", err)
+	}
+}
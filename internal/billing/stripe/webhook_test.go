@@ -0,0 +1,138 @@
+package stripe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// sign builds a Stripe-Signature header for the payload at the given time
+func sign(payload []byte, secret string, at time.Time) string {
+	ts := fmt.Sprint(at.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(payload)))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseEvent(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.updated","created":1718000000,"data":{"object":{"id":"sub_1"}}}`)
+	now := time.Unix(1718000000, 0)
+
+	tests := []struct {
+		name   string
+		header string
+		now    time.Time
+		err    error
+	}{
+		{"valid", sign(payload, testWebhookSecret, now), now, nil},
+		{"valid within tolerance", sign(payload, testWebhookSecret, now), now.Add(4 * time.Minute), nil},
+		{"rotated secret", sign(payload, "whsec_old", now) + "," + sign(payload, testWebhookSecret, now)[len("t=1718000000,"):], now, nil},
+		{"wrong secret", sign(payload, "whsec_other", now), now, ErrInvalidSignature},
+		{"no signature", "t=1718000000", now, ErrInvalidSignature},
+		{"no timestamp", "v1=00", now, ErrInvalidSignature},
+		{"empty header", "", now, ErrInvalidSignature},
+		{"expired", sign(payload, testWebhookSecret, now), now.Add(6 * time.Minute), ErrSignatureExpired},
+		{"from the future", sign(payload, testWebhookSecret, now.Add(10*time.Minute)), now, ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseEvent(payload, tt.header, testWebhookSecret, DEFAULT_TOLERANCE, tt.now)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) || !errors.Is(err, gateway.ErrInvalidSignature) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.ID != "evt_1" || e.Type != "customer.subscription.updated" {
+				t.Fatalf("event %+v", e)
+			}
+		})
+	}
+}
+
+func TestParseEventTamperedPayload(t *testing.T) {
+	now := time.Now()
+	header := sign([]byte(`{"id":"evt_1","type":"invoice.paid"}`), testWebhookSecret, now)
+	_, err := ParseEvent([]byte(`{"id":"evt_2","type":"invoice.paid"}`), header, testWebhookSecret, DEFAULT_TOLERANCE, now)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParseWebhook(t *testing.T) {
+	f, c := newFakeStripe(t)
+	g := NewGateway(c, testWebhookSecret)
+	ctx := context.Background()
+
+	s, err := c.CreateSubscription(ctx, SubscriptionParams{Customer: "cus_1", PriceID: "price_pro"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoiceID := s.LatestInvoice.ID
+
+	deliver := func(payload string) (*gateway.Event, error) {
+		header := http.Header{}
+		header.Set("Stripe-Signature", sign([]byte(payload), testWebhookSecret, time.Now()))
+		return g.ParseWebhook(ctx, []byte(payload), header)
+	}
+
+	e, err := deliver(`{"id":"evt_1","type":"invoice.paid","created":1718000000,"data":{"object":{"id":"in_9","customer":"cus_1","subscription":"sub_1","amount_paid":1900,"currency":"eur"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != gateway.EVENT_KIND_INVOICE || !e.Paid || e.Amount != 1900 || e.SubscriptionID != "sub_1" {
+		t.Fatalf("invoice event %+v", e)
+	}
+
+	// Payment events are resolved to their subscription through the invoice
+	e, err = deliver(`{"id":"evt_2","type":"payment_intent.succeeded","created":1718000000,"data":{"object":{"id":"pi_1","customer":"cus_1","invoice":"` + invoiceID + `"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != gateway.EVENT_KIND_PAYMENT || e.SubscriptionID != s.ID || e.Charge != nil {
+		t.Fatalf("payment event %+v", e)
+	}
+	f.lastRequest(http.MethodGet, "/v1/invoices/"+invoiceID)
+
+	// One-off charges that succeed later carry their reference
+	e, err = deliver(`{"id":"evt_5","type":"payment_intent.succeeded","created":1718000000,"data":{"object":{"id":"pi_2","customer":"cus_1","status":"succeeded","amount":2000,"currency":"eur","metadata":{"reference":"topup:665f"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Charge == nil || e.Charge.ID != "pi_2" || e.Charge.Amount != 2000 || e.ChargeReference != "topup:665f" || e.SubscriptionID != "" {
+		t.Fatalf("charge event %+v", e)
+	}
+	e, err = deliver(`{"id":"evt_6","type":"payment_intent.payment_failed","created":1718000000,"data":{"object":{"id":"pi_3","customer":"cus_1","status":"requires_payment_method","metadata":{"reference":"topup:665f"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Charge != nil {
+		t.Fatalf("failed charge reported as succeeded: %+v", e.Charge)
+	}
+
+	e, err = deliver(`{"id":"evt_3","type":"charge.dispute.created","created":1718000000,"data":{"object":{}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != gateway.EVENT_KIND_OTHER {
+		t.Fatalf("unhandled event kind %s", e.Kind)
+	}
+
+	_, err = g.ParseWebhook(ctx, []byte(`{"id":"evt_4"}`), http.Header{})
+	if !errors.Is(err, gateway.ErrInvalidSignature) {
+		t.Fatalf("unsigned delivery: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Checkout is returned when a subscription is created or changed. Depending
+// on the gateway the frontend confirms the first payment with ClientSecret
+// (Stripe) or sends the user to ApprovalURL (PayPal); neither is set when no
+// action is needed. Tax holds the totals of one billing period.
+type Checkout struct {
+	Subscription *schema.Subscription `json:"subscription"`
+	ClientSecret string               `json:"client_secret,omitempty"`
+	ApprovalURL  string               `json:"approval_url,omitempty"`
+	Tax          *tax.Result          `json:"tax"`
+}
+
+// PlanSelection picks a plan and one of its prices. Empty Currency and
+// Interval select the plan's first price.
+type PlanSelection struct {
+	AccountType string               `json:"account_type"`
+	Currency    string               `json:"currency,omitempty"`
+	Interval    schema.PLAN_INTERVAL `json:"interval,omitempty"`
+	// PromotionCode redeems a coupon when subscribing
+	PromotionCode string `json:"promotion_code,omitempty"`
+}
+
+// selectPrice returns the current version of the selected plan and its price
+func selectPrice(ctx context.Context, sel PlanSelection) (*schema.Plan, *schema.PlanPrice, error) {
+	plan, err := catalog.Current(ctx, sel.AccountType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !plan.Available {
+		return nil, nil, ErrUnknownPlan
+	}
+	price := plan.PriceFor(sel.Currency, sel.Interval)
+	if price == nil {
+		return nil, nil, ErrUnknownPrice
+	}
+	return plan, price, nil
+}
+
+// CurrentSubscription returns the user's subscription that has not ended yet
+func CurrentSubscription(ctx context.Context, userID bson.ObjectID) (*schema.Subscription, error) {
+	var s schema.Subscription
+	err := subscriptions().FindOne(ctx,
+		bson.M{
+			"user_id": userID,
+			"status": bson.M{"$nin": bson.A{
+				schema.SUBSCRIPTION_STATUS_CANCELED,
+				schema.SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED,
+			}},
+		},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
+	).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Subscribe creates a subscription to the current version of a plan at the
+// gateway of the selected price
+func Subscribe(ctx context.Context, userID bson.ObjectID, sel PlanSelection, meta history.Meta) (*Checkout, error) {
+	plan, price, err := selectPrice(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	g, err := Gateway(price.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := CurrentSubscription(ctx, userID); err == nil {
+		return nil, ErrAlreadySubscribed
+	} else if !errors.Is(err, ErrNoSubscription) {
+		return nil, err
+	}
+
+	var promo *promotion
+	amount := price.Amount
+	if sel.PromotionCode != "" {
+		if promo, err = findPromotion(ctx, userID, sel.PromotionCode, plan, price); err != nil {
+			return nil, err
+		}
+		amount = promo.discount(amount)
+	}
+	totals, err := quote(ctx, userID, meta.Country, amount)
+	if err != nil {
+		return nil, err
+	}
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := EnsureCustomer(ctx, u, g)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keyed on the number of earlier subscriptions so a retried request cannot
+	// create a second subscription at the gateway
+	previous, err := subscriptions().CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	var redemption *schema.CouponRedemption
+	couponID := ""
+	if promo != nil {
+		if redemption, err = reserveRedemption(ctx, userID, promo, g.Name(), meta); err != nil {
+			return nil, err
+		}
+		couponID = promo.gatewayCoupon(g.Name())
+	}
+	site := config.GetSiteConfig().URL
+	gs, err := g.CreateSubscription(ctx, gateway.SubscriptionParams{
+		CustomerID:     c.ExternalID,
+		PriceID:        price.PriceID,
+		TrialDays:      plan.TrialDays,
+		UserID:         userID.Hex(),
+		Email:          u.Email,
+		Name:           u.DisplayName,
+		ReturnURL:      site + "/billing/complete",
+		CancelURL:      site + "/billing/canceled",
+		Country:        totals.Country,
+		CouponID:       couponID,
+		IdempotencyKey: fmt.Sprintf("subscription-%s-%d", userID.Hex(), previous),
+	})
+	if err != nil {
+		if redemption != nil {
+			releaseRedemption(ctx, redemption)
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	s := &schema.Subscription{
+		ID:          bson.NewObjectID(),
+		CreatedAt:   now,
+		UserID:      userID,
+		Gateway:     g.Name(),
+		ExternalID:  gs.ID,
+		AccountType: plan.AccountType,
+		PlanVersion: plan.Version,
+		TaxCountry:  totals.Country,
+	}
+	if promo != nil {
+		s.Discount = promo.subscriptionDiscount(now)
+	}
+	if err := applyGateway(ctx, s, gs); err != nil {
+		return nil, err
+	}
+	if _, err := subscriptions().InsertOne(ctx, s); err != nil {
+		return nil, err
+	}
+	if redemption != nil {
+		if err := applyRedemption(ctx, redemption, s.ID, meta); err != nil {
+			return nil, err
+		}
+	}
+	if err := SyncAccountType(ctx, userID, meta); err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: s, ClientSecret: gs.ClientSecret, ApprovalURL: gs.ApprovalURL, Tax: totals}, nil
+}
+
+// ChangePlan upgrades or downgrades the current subscription to the current
+// version of a plan. Currency and interval default to the subscription's, and
+// the new price must use the subscription's gateway. Changing to the current
+// version of the same plan gives up a grandfathered price.
+func ChangePlan(ctx context.Context, userID bson.ObjectID, sel PlanSelection, meta history.Meta) (*Checkout, error) {
+	if sel.PromotionCode != "" {
+		return nil, ErrPromotionCodeChange
+	}
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sel.Currency == "" {
+		sel.Currency = s.Currency
+	}
+	if sel.Interval == "" {
+		sel.Interval = s.Interval
+	}
+	plan, price, err := selectPrice(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	if s.Gateway != price.Gateway {
+		return nil, ErrGatewayMismatch
+	}
+	if s.PriceID == price.PriceID {
+		return nil, ErrSamePlan
+	}
+	g, err := Gateway(s.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := quote(ctx, userID, meta.Country, price.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like seat changes, keyed by the last update so that switching back and
+	// forth does not replay an earlier revision and its approval link
+	key := fmt.Sprintf("change-%s-%d-%s", s.ExternalID, s.UpdatedAt.UnixMilli(), price.PriceID)
+	gs, err := g.ChangeSubscriptionPrice(ctx, s.ExternalID, price.PriceID, key)
+	if err != nil {
+		return nil, err
+	}
+	if gs.PriceID == price.PriceID {
+		s.AccountType = plan.AccountType
+		s.PlanVersion = plan.Version
+		s.TaxCountry = totals.Country
+	}
+	if s, err = save(ctx, s, gs, meta); err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: s, ApprovalURL: gs.ApprovalURL, Tax: totals}, nil
+}
+
+// ChangeSeats sets the number of seats billed by the current subscription.
+// The gateway prorates the difference.
+func ChangeSeats(ctx context.Context, userID bson.ObjectID, seats int64, meta history.Meta) (*schema.Subscription, error) {
+	if seats < 1 {
+		return nil, ErrInvalidSeats
+	}
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Quantity == seats {
+		return s, nil
+	}
+	g, err := Gateway(s.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	// Every saved change moves updated_at, so a retry of this change reuses
+	// the key but changing back and forth again does not replay an old one
+	key := fmt.Sprintf("seats-%s-%d-%d", s.ExternalID, s.UpdatedAt.UnixMilli(), seats)
+	gs, err := g.ChangeSubscriptionQuantity(ctx, s.ExternalID, seats, key)
+	if err != nil {
+		return nil, err
+	}
+	return save(ctx, s, gs, meta)
+}
+
+// Cancel ends the current subscription, either immediately or at the end of
+// the paid period
+func Cancel(ctx context.Context, userID bson.ObjectID, atPeriodEnd bool, meta history.Meta) (*schema.Subscription, error) {
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	g, err := Gateway(s.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	gs, err := g.CancelSubscription(ctx, s.ExternalID, atPeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	return save(ctx, s, gs, meta)
+}
+
+// Resume withdraws a cancellation scheduled for the end of the period, or
+// reactivates a paused subscription
+func Resume(ctx context.Context, userID bson.ObjectID, meta history.Meta) (*schema.Subscription, error) {
+	s, err := CurrentSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.CancelAtPeriodEnd && s.Status != schema.SUBSCRIPTION_STATUS_PAUSED {
+		return nil, ErrNotPendingCancel
+	}
+	g, err := Gateway(s.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	gs, err := g.ResumeSubscription(ctx, s.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+	return save(ctx, s, gs, meta)
+}
+
+// save stores the gateway state of a subscription and syncs the user's account type
+func save(ctx context.Context, s *schema.Subscription, gs *gateway.Subscription, meta history.Meta) (*schema.Subscription, error) {
+	if err := applyGateway(ctx, s, gs); err != nil {
+		return nil, err
+	}
+	if _, err := subscriptions().ReplaceOne(ctx, bson.M{"_id": s.ID}, s); err != nil {
+		return nil, err
+	}
+	if err := SyncAccountType(ctx, s.UserID, meta); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// applyGateway copies the fields of a gateway subscription onto the local
+// copy. The subscription stays on its plan version while that version still
+// charges the gateway's price; otherwise it moves to the newest version that
+// does.
+func applyGateway(ctx context.Context, s *schema.Subscription, gs *gateway.Subscription) error {
+	s.UpdatedAt = time.Now().UTC()
+	s.Status = gs.Status
+	s.PriceID = gs.PriceID
+	s.Quantity = max(gs.Quantity, 1)
+
+	var price *schema.PlanPrice
+	if s.PlanVersion > 0 {
+		plan, err := catalog.Version(ctx, s.AccountType, s.PlanVersion)
+		if err != nil && !errors.Is(err, catalog.ErrUnknownPlan) {
+			return err
+		}
+		if plan != nil {
+			price = plan.Price(s.Gateway, s.PriceID)
+		}
+	}
+	if price == nil {
+		plan, p, err := catalog.FindByPrice(ctx, s.Gateway, s.PriceID)
+		if err != nil && !errors.Is(err, catalog.ErrUnknownPlan) {
+			return err
+		}
+		if plan != nil {
+			s.AccountType = plan.AccountType
+			s.PlanVersion = plan.Version
+			price = p
+		}
+	}
+	if price != nil {
+		s.Currency = price.Currency
+		s.Interval = price.Interval
+	}
+	s.CurrentPeriodStart = gs.CurrentPeriodStart
+	s.CurrentPeriodEnd = gs.CurrentPeriodEnd
+	s.TrialEnd = gs.TrialEnd
+	s.CancelAtPeriodEnd = gs.CancelAtPeriodEnd
+	s.CanceledAt = gs.CanceledAt
+	return nil
+}
@@ -0,0 +1,60 @@
+package billing
+
+import (
+	"context"
+
+	"github.com/Auth5/brain/internal/billing/tax"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Quote returns the tax and total a user would pay for a plan price, using
+// the billing address and otherwise the country of the request. With a
+// promotion code it quotes the first discounted period.
+func Quote(ctx context.Context, userID bson.ObjectID, sel PlanSelection, meta history.Meta) (*tax.Result, error) {
+	plan, price, err := selectPrice(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	amount := price.Amount
+	if sel.PromotionCode != "" {
+		promo, err := findPromotion(ctx, userID, sel.PromotionCode, plan, price)
+		if err != nil {
+			return nil, err
+		}
+		amount = promo.discount(amount)
+	}
+	return quote(ctx, userID, meta.Country, amount)
+}
+
+func quote(ctx context.Context, userID bson.ObjectID, ipCountry string, amount int64) (*tax.Result, error) {
+	c, err := taxCustomer(ctx, userID, ipCountry)
+	if err != nil {
+		return nil, err
+	}
+	return tax.Calculate(sellerCountry(), c, amount, config.GetBillingConfig().Tax.PricesIncludeTax), nil
+}
+
+// taxCustomer collects what the tax of a user's purchases depends on
+func taxCustomer(ctx context.Context, userID bson.ObjectID, ipCountry string) (tax.Customer, error) {
+	c := tax.Customer{IPCountry: ipCountry}
+	d, err := Details(ctx, userID)
+	if err != nil {
+		return c, err
+	}
+	if d != nil {
+		c.BillingCountry = d.Country
+		c.VATID = d.VATID
+	}
+	return c, nil
+}
+
+// sellerCountry is the country of the entity issuing invoices
+func sellerCountry() string {
+	entity, err := config.GetLegalEntity(config.GetBillingConfig().Invoicing.DefaultEntity)
+	if err != nil {
+		return ""
+	}
+	return entity.Country
+}
@@ -0,0 +1,79 @@
+package tax
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+//go:embed rates.json
+var defaultRates []byte
+
+// RateTable is a versioned set of VAT rates. Rates are in basis points
+// (1900 = 19%) and are the standard rates, which apply to digital services.
+type RateTable struct {
+	Version   string                 `json:"version"` // Date the rates took effect, recorded with every calculation
+	Countries map[string]CountryRate `json:"countries"`
+}
+
+// CountryRate is the VAT of one country, keyed by ISO 3166-1 alpha-2 code
+type CountryRate struct {
+	Name      string `json:"name"`
+	EU        bool   `json:"eu"`                   // Member of the EU VAT area
+	Standard  int64  `json:"standard"`             // Standard rate in basis points
+	VATPrefix string `json:"vat_prefix,omitempty"` // VAT ID prefix if it differs from the country code
+}
+
+var rates atomic.Pointer[RateTable]
+
+// loadRates reads a rate table file, or the embedded table when path is empty
+func loadRates(path string) (*RateTable, error) {
+	data := defaultRates
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var t RateTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if t.Version == "" {
+		return nil, fmt.Errorf("tax rate table has no version")
+	}
+	for code, c := range t.Countries {
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("tax rate table: invalid country code %q", code)
+		}
+		if c.Standard < 0 || c.Standard >= 10000 {
+			return nil, fmt.Errorf("tax rate table: invalid rate %d for %s", c.Standard, code)
+		}
+	}
+	return &t, nil
+}
+
+// Rates returns the loaded rate table
+func Rates() *RateTable {
+	return rates.Load()
+}
+
+// vatPrefix returns the prefix VAT IDs of a country start with
+func (t *RateTable) vatPrefix(country string) string {
+	if c, ok := t.Countries[country]; ok && c.VATPrefix != "" {
+		return c.VATPrefix
+	}
+	return country
+}
+
+// countryOfPrefix maps a VAT ID prefix back to its country
+func (t *RateTable) countryOfPrefix(prefix string) string {
+	for code, c := range t.Countries {
+		if c.VATPrefix == prefix {
+			return code
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,130 @@
+package tax
+
+import (
+	"github.com/Auth5/brain/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+type TREATMENT string
+
+const (
+	TREATMENT_DOMESTIC       TREATMENT = "domestic"       // Customer in the seller's country, seller's rate
+	TREATMENT_EU_CONSUMER    TREATMENT = "eu_consumer"    // EU consumer in another member state, customer's rate (OSS)
+	TREATMENT_REVERSE_CHARGE TREATMENT = "reverse_charge" // EU business in another member state, the customer accounts for VAT
+	TREATMENT_OUTSIDE_SCOPE  TREATMENT = "outside_scope"  // Customer outside the seller's VAT area, no VAT
+)
+
+type COUNTRY_SOURCE string
+
+const (
+	COUNTRY_SOURCE_BILLING_ADDRESS COUNTRY_SOURCE = "billing_address" // Country of the billing details
+	COUNTRY_SOURCE_GEOIP           COUNTRY_SOURCE = "geoip"           // Country of the customer's IP address
+	COUNTRY_SOURCE_SELLER          COUNTRY_SOURCE = "seller"          // Unknown, taxed like a domestic sale
+)
+
+// InitTax loads the rate table
+func InitTax() {
+	cfg := config.GetBillingConfig().Tax
+	t, err := loadRates(cfg.RatesFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", cfg.RatesFile).Msg("Error loading tax rates")
+	}
+	rates.Store(t)
+	log.Info().Str("version", t.Version).Int("countries", len(t.Countries)).Msg("Loaded tax rates")
+}
+
+// Customer is what the tax of a sale depends on
+type Customer struct {
+	BillingCountry string // Country of the billing address, preferred
+	IPCountry      string // GeoIP country, used without a billing address
+	VATID          string // Business customers only
+}
+
+// Result is the tax of one amount
+type Result struct {
+	Country       string         `json:"country"`          // Country whose rules applied
+	CountrySource COUNTRY_SOURCE `json:"country_source"`   // Where the country came from
+	Treatment     TREATMENT      `json:"treatment"`        // Applied VAT rule
+	Rate          int64          `json:"rate"`             // Basis points (1900 = 19%)
+	VATID         string         `json:"vat_id,omitempty"` // Normalized VAT ID, for reverse charge
+	Inclusive     bool           `json:"inclusive"`        // Whether the amount included tax
+	Net           int64          `json:"net"`              // Amount before tax
+	Tax           int64          `json:"tax"`              // Tax amount
+	Total         int64          `json:"total"`            // Amount charged
+	RatesVersion  string         `json:"rates_version"`    // Rate table the rate came from
+}
+
+// Calculate determines the VAT of an amount in minor units sold by a seller
+// in sellerCountry. With inclusive set the amount is the price the customer
+// pays and tax is taken out of it, otherwise tax is added on top.
+func Calculate(sellerCountry string, c Customer, amount int64, inclusive bool) *Result {
+	t := Rates()
+	res := &Result{Inclusive: inclusive, RatesVersion: t.Version}
+
+	switch {
+	case c.BillingCountry != "":
+		res.Country, res.CountrySource = c.BillingCountry, COUNTRY_SOURCE_BILLING_ADDRESS
+	case c.IPCountry != "":
+		res.Country, res.CountrySource = c.IPCountry, COUNTRY_SOURCE_GEOIP
+	default:
+		res.Country, res.CountrySource = sellerCountry, COUNTRY_SOURCE_SELLER
+	}
+
+	seller, sellerKnown := t.Countries[sellerCountry]
+	customer, customerKnown := t.Countries[res.Country]
+	business := false
+	if c.VATID != "" {
+		if id, country, err := ValidateVATID(c.VATID); err == nil && country == res.Country {
+			business = true
+			res.VATID = id
+		}
+	}
+
+	switch {
+	case res.Country == sellerCountry:
+		res.Treatment = TREATMENT_DOMESTIC
+		if sellerKnown {
+			res.Rate = seller.Standard
+		}
+	case seller.EU && customerKnown && customer.EU && business:
+		res.Treatment = TREATMENT_REVERSE_CHARGE
+	case seller.EU && customerKnown && customer.EU:
+		res.Treatment = TREATMENT_EU_CONSUMER
+		res.Rate = customer.Standard
+	default:
+		res.Treatment = TREATMENT_OUTSIDE_SCOPE
+	}
+
+	if inclusive {
+		res.Net, res.Tax = Split(amount, res.Rate)
+	} else {
+		res.Net, res.Tax = amount, TaxOf(amount, res.Rate)
+	}
+	res.Total = res.Net + res.Tax
+	return res
+}
+
+// TaxOf is the tax on a net amount, rounded half away from zero like the
+// invoice totals
+func TaxOf(net, rate int64) int64 {
+	n := net * rate
+	if n < 0 {
+		return -((-n + 5000) / 10000)
+	}
+	return (n + 5000) / 10000
+}
+
+// Split divides a tax-inclusive amount into net and tax so that net plus
+// TaxOf(net) gives back exactly the amount whenever such a net exists
+func Split(gross, rate int64) (int64, int64) {
+	if rate == 0 {
+		return gross, 0
+	}
+	net := (gross*10000 + (10000+rate)/2) / (10000 + rate)
+	for _, candidate := range []int64{net, net - 1, net + 1} {
+		if candidate+TaxOf(candidate, rate) == gross {
+			return candidate, gross - candidate
+		}
+	}
+	return net, gross - net
+}
@@ -0,0 +1,228 @@
+package tax
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrVATIDFormat   = errors.New("invalid VAT ID format")
+	ErrVATIDChecksum = errors.New("invalid VAT ID check digit")
+	ErrVATIDCountry  = errors.New("VAT ID does not belong to the billing country")
+)
+
+// vatFormats are the formats of EU VAT IDs after the prefix, keyed by prefix
+var vatFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^\d{9,10}$`),
+	"CY": regexp.MustCompile(`^\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^\d{8,10}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"EE": regexp.MustCompile(`^\d{9}$`),
+	"EL": regexp.MustCompile(`^\d{9}$`),
+	"ES": regexp.MustCompile(`^[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"HR": regexp.MustCompile(`^\d{11}$`),
+	"HU": regexp.MustCompile(`^\d{8}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LT": regexp.MustCompile(`^(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"LV": regexp.MustCompile(`^\d{11}$`),
+	"MT": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"RO": regexp.MustCompile(`^[1-9]\d{1,9}$`),
+	"SE": regexp.MustCompile(`^\d{10}01$`),
+	"SI": regexp.MustCompile(`^[1-9]\d{7}$`),
+	"SK": regexp.MustCompile(`^[1-9]\d{9}$`),
+}
+
+// vatChecksums verify the check digits of the countries whose algorithm is
+// public. IDs of the other countries are only checked for their format.
+var vatChecksums = map[string]func(string) bool{
+	"AT": checkAT,
+	"BE": checkBE,
+	"DE": checkDE,
+	"DK": func(n string) bool { return weighted(n, []int{2, 7, 6, 5, 4, 3, 2, 1})%11 == 0 },
+	"EL": checkEL,
+	"FI": checkFI,
+	"FR": checkFR,
+	"IT": luhn,
+	"LU": checkLU,
+	"NL": checkNL,
+	"PL": checkPL,
+	"PT": checkPT,
+	"SE": func(n string) bool { return luhn(n[:10]) },
+}
+
+// NormalizeVATID uppercases a VAT ID and removes spaces, dots and dashes
+func NormalizeVATID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-', '\t':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(id)))
+}
+
+// ValidateVATID checks the format and, where the algorithm is known, the
+// check digits of an EU VAT ID offline. It returns the normalized ID and the
+// country it was issued in. A format-valid ID may still not be registered,
+// which only the VIES service can confirm.
+func ValidateVATID(id string) (string, string, error) {
+	id = NormalizeVATID(id)
+	if len(id) < 4 {
+		return "", "", ErrVATIDFormat
+	}
+	prefix, number := id[:2], id[2:]
+	format, ok := vatFormats[prefix]
+	if !ok || !format.MatchString(number) {
+		return "", "", ErrVATIDFormat
+	}
+	if check, ok := vatChecksums[prefix]; ok && !check(number) {
+		return "", "", ErrVATIDChecksum
+	}
+	return id, Rates().countryOfPrefix(prefix), nil
+}
+
+func digit(s string, i int) int {
+	return int(s[i] - '0')
+}
+
+// weighted sums the leading digits of s multiplied by weights
+func weighted(s string, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += digit(s, i) * w
+	}
+	return sum
+}
+
+func luhn(s string) bool {
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := digit(s, i)
+		if (len(s)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func checkAT(n string) bool {
+	s := n[1:] // Skip the leading U
+	sum := 0
+	for i := range 7 {
+		d := digit(s, i)
+		if i%2 == 1 {
+			d = d*2/10 + d*2%10
+		}
+		sum += d
+	}
+	return (10-(sum+4)%10)%10 == digit(s, 7)
+}
+
+func checkBE(n string) bool {
+	base, _ := strconv.Atoi(n[:8])
+	check, _ := strconv.Atoi(n[8:])
+	return 97-base%97 == check
+}
+
+// checkDE implements ISO 7064 MOD 11,10
+func checkDE(n string) bool {
+	p := 10
+	for i := range 8 {
+		s := (digit(n, i) + p) % 10
+		if s == 0 {
+			s = 10
+		}
+		p = 2 * s % 11
+	}
+	c := 11 - p
+	if c == 10 {
+		c = 0
+	}
+	return c == digit(n, 8)
+}
+
+func checkEL(n string) bool {
+	sum := 0
+	for i := range 8 {
+		sum += digit(n, i) << (8 - i)
+	}
+	return sum%11%10 == digit(n, 8)
+}
+
+func checkFI(n string) bool {
+	r := weighted(n, []int{7, 9, 10, 5, 8, 4, 2}) % 11
+	switch r {
+	case 0:
+		return digit(n, 7) == 0
+	case 1:
+		return false
+	}
+	return 11-r == digit(n, 7)
+}
+
+// checkFR verifies numeric keys; the newer alphanumeric keys have no public algorithm
+func checkFR(n string) bool {
+	key, err := strconv.Atoi(n[:2])
+	if err != nil {
+		return true
+	}
+	siren, _ := strconv.Atoi(n[2:])
+	return (12+3*(siren%97))%97 == key
+}
+
+func checkLU(n string) bool {
+	base, _ := strconv.Atoi(n[:6])
+	check, _ := strconv.Atoi(n[6:])
+	return base%89 == check
+}
+
+// checkNL accepts both the MOD 11 check of older IDs and the MOD 97 check
+// introduced for sole proprietors in 2020
+func checkNL(n string) bool {
+	if r := weighted(n, []int{9, 8, 7, 6, 5, 4, 3, 2}) % 11; r != 10 && r == digit(n, 8) {
+		return true
+	}
+	// ISO 7064 MOD 97-10 over "NL" + number with letters as numbers (A=10)
+	var b strings.Builder
+	for _, r := range "NL" + n {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteString(strconv.Itoa(int(r-'A') + 10))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	rem := 0
+	for _, r := range b.String() {
+		rem = (rem*10 + int(r-'0')) % 97
+	}
+	return rem == 1
+}
+
+func checkPL(n string) bool {
+	r := weighted(n, []int{6, 5, 7, 2, 3, 4, 5, 6, 7}) % 11
+	return r != 10 && r == digit(n, 9)
+}
+
+func checkPT(n string) bool {
+	c := 11 - weighted(n, []int{9, 8, 7, 6, 5, 4, 3, 2})%11
+	if c >= 10 {
+		c = 0
+	}
+	return c == digit(n, 8)
+}
@@ -0,0 +1,183 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing/gateway"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// Processed event IDs are remembered longer than gateways keep retrying (Stripe 3 days, PayPal 3 days)
+	EVENT_DONE_TTL = 30 * 24 * time.Hour
+	// A claimed but unfinished event can be retried after this long
+	EVENT_CLAIM_TTL = 5 * time.Minute
+
+	eventKeyInfix = ":event:"
+	eventDone     = "done"
+	eventClaimed  = "processing"
+)
+
+var (
+	ErrDuplicateEvent  = errors.New("webhook event already processed")
+	ErrEventInProgress = errors.New("webhook event is being processed")
+)
+
+// HandleWebhook verifies and processes one webhook delivery of a gateway.
+// Each event ID is handled exactly once: deliveries of an event that was
+// already processed return ErrDuplicateEvent, deliveries of an event that is
+// being processed right now ErrEventInProgress, so the gateway retries them
+// in case that attempt fails.
+func HandleWebhook(ctx context.Context, gatewayName string, payload []byte, header http.Header) error {
+	g, err := Gateway(gatewayName)
+	if err != nil {
+		return err
+	}
+	event, err := g.ParseWebhook(ctx, payload, header)
+	if err != nil {
+		return err
+	}
+
+	key := []byte(g.Name() + eventKeyInfix + event.ID)
+	if err := claimEvent(key); err != nil {
+		return err
+	}
+	if err := dispatch(ctx, g, event); err != nil {
+		releaseEvent(key)
+		return err
+	}
+	return finishEvent(key)
+}
+
+// dispatch routes an event to its handler. Handlers never trust the event
+// payload to be the latest state: they fetch the current subscription from
+// the gateway, so deliveries arriving out of order still converge on the
+// right state.
+func dispatch(ctx context.Context, g gateway.PaymentGateway, event *gateway.Event) error {
+	if event.Kind == gateway.EVENT_KIND_OTHER {
+		log.Debug().Str("gateway", g.Name()).Str("event_id", event.ID).Str("type", event.Type).Msg("Ignoring webhook event")
+		return nil
+	}
+
+	log.Info().
+		Str("gateway", g.Name()).
+		Str("type", event.Type).
+		Str("invoice", event.InvoiceID).
+		Str("subscription", event.SubscriptionID).
+		Msg("Webhook event")
+	if event.Charge != nil {
+		return creditSucceededCharge(ctx, g, event)
+	}
+	if event.SubscriptionID == "" {
+		return nil
+	}
+	// Paid and failed invoices and payments move the subscription between
+	// active, past_due and unpaid; failed payments start dunning
+	if err := SyncSubscription(ctx, g, event.SubscriptionID); err != nil {
+		return err
+	}
+	if event.PaymentFailed {
+		if err := startDunning(ctx, g, event); err != nil {
+			return err
+		}
+	}
+	if event.Paid {
+		if err := resolveDunning(ctx, g.Name(), event.SubscriptionID, schema.DUNNING_STATUS_RECOVERED, schema.DUNNING_STEP_RECOVERED); err != nil {
+			return err
+		}
+		if err := invoicePayment(ctx, g, event); err != nil {
+			return err
+		}
+		return rewardReferral(ctx, g, event)
+	}
+	return nil
+}
+
+// SyncSubscription fetches a subscription from its gateway and stores it
+// locally, creating the local copy for subscriptions made outside brain
+func SyncSubscription(ctx context.Context, g gateway.PaymentGateway, externalID string) error {
+	gs, err := g.GetSubscription(ctx, externalID)
+	if err != nil {
+		return err
+	}
+
+	var s schema.Subscription
+	err = subscriptions().FindOne(ctx, bson.M{"gateway": g.Name(), "external_id": externalID}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var c schema.BillingCustomer
+		err := customers().FindOne(ctx, bson.M{"gateway": g.Name(), "external_id": gs.CustomerID}).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Warn().Str("gateway", g.Name()).Str("subscription", externalID).Str("customer", gs.CustomerID).Msg("Subscription for unknown customer")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s = schema.Subscription{
+			ID:         bson.NewObjectID(),
+			CreatedAt:  time.Now().UTC(),
+			UserID:     c.UserID,
+			Gateway:    g.Name(),
+			ExternalID: externalID,
+		}
+		if err := applyGateway(ctx, &s, gs); err != nil {
+			return err
+		}
+		if _, err := subscriptions().InsertOne(ctx, s); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		return SyncAccountType(ctx, c.UserID, history.Meta{})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = save(ctx, &s, gs, history.Meta{})
+	return err
+}
+
+// claimEvent atomically marks an event as being processed
+func claimEvent(key []byte) error {
+	return database.KV.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == nil {
+			state, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if string(state) == eventDone {
+				return ErrDuplicateEvent
+			}
+			return ErrEventInProgress
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry(key, []byte(eventClaimed)).WithTTL(EVENT_CLAIM_TTL))
+	})
+}
+
+// finishEvent remembers a processed event so redeliveries are ignored
+func finishEvent(key []byte) error {
+	return database.KV.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, []byte(eventDone)).WithTTL(EVENT_DONE_TTL))
+	})
+}
+
+// releaseEvent drops the claim of a failed event so the gateway's retry is processed
+func releaseEvent(key []byte) {
+	if err := database.KV.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	}); err != nil {
+		log.Error().Err(err).Str("key", string(key)).Msg("Error releasing webhook event")
+	}
+}
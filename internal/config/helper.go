@@ -20,6 +20,16 @@ func GetStripeConfig() *StripeConfig {
 	return &Cfg.Stripe
 }
 
+// GetPayPalConfig returns nil when PayPal is not configured
+func GetPayPalConfig() *PayPalConfig {
+	return Cfg.PayPal
+}
+
+// GetCryptoConfig returns nil when crypto payments are not configured
+func GetCryptoConfig() *CryptoConfig {
+	return Cfg.Crypto
+}
+
 func GetMaxMind() *MaxMindConfig {
 	return &Cfg.MaxMind
 }
@@ -48,3 +58,36 @@ func GetDatabaseConfig() *DatabaseConfig {
 func GetOauthConfig() *OAuthProviders {
 	return &Cfg.OAuth
 }
+
+func GetGDPRConfig() *GDPRConfig {
+	return &Cfg.GDPR
+}
+
+func GetBillingConfig() *BillingConfig {
+	return &Cfg.Billing
+}
+
+func GetEntitlementsConfig() *EntitlementsConfig {
+	return &Cfg.Entitlements
+}
+
+func GetOrganizationsConfig() *OrganizationsConfig {
+	return &Cfg.Organizations
+}
+
+func GetRBACConfig() *RBACConfig {
+	return &Cfg.RBAC
+}
+
+func GetProvisioningConfig() *ProvisioningConfig {
+	return &Cfg.Provisioning
+}
+
+func GetLegalEntity(id string) (*LegalEntityConfig, error) {
+	for i, e := range Cfg.Billing.Invoicing.Entities {
+		if e.ID == id {
+			return &Cfg.Billing.Invoicing.Entities[i], nil
+		}
+	}
+	return nil, fmt.Errorf("legal entity not found: %s", id)
+}
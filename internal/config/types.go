@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type SiteConfig struct {
 	Name   string `koanf:"name" validate:"required"`
 	URL    string `koanf:"url" validate:"required,url"`
@@ -22,15 +24,156 @@ type WebhookConfig struct {
 
 type StripeConfig struct {
 	SecretKey string        `koanf:"secret_key" validate:"required"`
+	APIBase   string        `koanf:"api_base" validate:"omitempty,url"`
 	Webhook   WebhookConfig `koanf:"webhook" validate:"required"`
 }
 
+// PayPalConfig is optional, it is only needed when a plan uses the paypal gateway
+type PayPalConfig struct {
+	ClientID     string `koanf:"client_id" validate:"required"`
+	ClientSecret string `koanf:"client_secret" validate:"required"`
+	APIBase      string `koanf:"api_base" validate:"omitempty,url"`
+	WebhookID    string `koanf:"webhook_id" validate:"required"`
+}
+
+// CryptoConfig is optional, it is only needed when a plan uses the crypto
+// gateway. The processor is a BTCPay Server store, see docs/billing_crypto.md.
+type CryptoConfig struct {
+	APIBase           string `koanf:"api_base" validate:"required,url"`
+	APIKey            string `koanf:"api_key" validate:"required"`
+	StoreID           string `koanf:"store_id" validate:"required"`
+	WebhookSecret     string `koanf:"webhook_secret" validate:"required"`
+	SpeedPolicy       string `koanf:"speed_policy" validate:"omitempty,oneof=HighSpeed MediumSpeed LowMediumSpeed LowSpeed"` // Confirmations before a payment settles
+	ExpirationMinutes int    `koanf:"expiration_minutes" validate:"min=0"`                                                   // How long an invoice's exchange rate is guaranteed
+	RenewalLeadDays   int    `koanf:"renewal_lead_days" validate:"required,min=1"`                                           // Payment reminder before the period ends
+	GraceDays         int    `koanf:"grace_days" validate:"min=0"`                                                           // Past due before a subscription turns unpaid
+}
+
+type BillingConfig struct {
+	DefaultAccountType string          `koanf:"default_account_type" validate:"required"`
+	Plans              []PlanConfig    `koanf:"plans" validate:"required,min=1,unique=AccountType,dive"`
+	Metering           MeteringConfig  `koanf:"metering"`
+	Invoicing          InvoicingConfig `koanf:"invoicing" validate:"required"`
+	Tax                TaxConfig       `koanf:"tax"`
+	Dunning            DunningConfig   `koanf:"dunning" validate:"required"`
+	Coupons            []CouponConfig  `koanf:"coupons" validate:"unique=ID,dive"`
+	Referrals          ReferralConfig  `koanf:"referrals"`
+	Credits            CreditsConfig   `koanf:"credits"`
+}
+
+// CouponConfig seeds a discount and the promotion codes redeeming it, see
+// docs/billing_coupons.md
+type CouponConfig struct {
+	ID                string                `koanf:"id" validate:"required,max=40"`
+	Name              string                `koanf:"name" validate:"required"`
+	PercentOff        int                   `koanf:"percent_off" validate:"required_without=AmountOff,excluded_with=AmountOff,min=0,max=100"`
+	AmountOff         int64                 `koanf:"amount_off" validate:"min=0"` // In the currency's minor unit
+	Currency          string                `koanf:"currency" validate:"required_with=AmountOff,excluded_without=AmountOff,omitempty,len=3,lowercase"`
+	Duration          string                `koanf:"duration" validate:"required,oneof=once repeating forever"`
+	DurationMonths    int                   `koanf:"duration_months" validate:"required_if=Duration repeating,excluded_unless=Duration repeating,min=0"`
+	MaxRedemptions    int                   `koanf:"max_redemptions" validate:"min=0"` // 0 for unlimited
+	ExpiresAt         time.Time             `koanf:"expires_at"`                       // RFC 3339, zero for no expiry
+	AccountTypes      []string              `koanf:"account_types" validate:"dive,required"`
+	FirstPurchaseOnly bool                  `koanf:"first_purchase_only"`
+	PromotionCodes    []PromotionCodeConfig `koanf:"promotion_codes" validate:"required,min=1,dive"`
+}
+
+type PromotionCodeConfig struct {
+	Code              string    `koanf:"code" validate:"required,uppercase,max=32"`
+	MaxRedemptions    int       `koanf:"max_redemptions" validate:"min=0"` // 0 for unlimited
+	ExpiresAt         time.Time `koanf:"expires_at"`                       // RFC 3339, zero for no expiry
+	FirstPurchaseOnly bool      `koanf:"first_purchase_only"`
+}
+
+// ReferralConfig sets the customer balance credits of a referral per
+// currency, in minor units. Referrals are off when both are empty.
+type ReferralConfig struct {
+	ReferrerCredit map[string]int64 `koanf:"referrer_credit" validate:"dive,keys,len=3,lowercase,endkeys,min=0"`
+	RefereeCredit  map[string]int64 `koanf:"referee_credit" validate:"dive,keys,len=3,lowercase,endkeys,min=0"`
+}
+
+// CreditsConfig configures prepaid usage credits, see docs/billing_credits.md
+type CreditsConfig struct {
+	Currency   string `koanf:"currency" validate:"omitempty,len=3,lowercase"`                            // Currency of credits, empty to disable top-ups
+	MinTopUp   int64  `koanf:"min_top_up" validate:"required_with=Currency,omitempty,min=1"`             // Smallest top-up in minor units
+	MaxTopUp   int64  `koanf:"max_top_up" validate:"required_with=Currency,omitempty,gtefield=MinTopUp"` // Largest top-up in minor units
+	ExpiryDays int    `koanf:"expiry_days" validate:"min=0"`                                             // Days until unused credits expire, 0 for never
+	RefundDays int    `koanf:"refund_days" validate:"min=0"`                                             // Days the owner can refund unused credits of a top-up, 0 to not allow
+}
+
+// DunningConfig sets the recovery schedule of failed subscription payments,
+// in days after the first failure, see docs/billing_dunning.md
+type DunningConfig struct {
+	RetryDays   []int  `koanf:"retry_days" validate:"required,min=1,dive,min=1"`          // When the payment is retried
+	GraceDays   int    `koanf:"grace_days" validate:"required,min=1"`                     // How long the user keeps the plan
+	FinalAction string `koanf:"final_action" validate:"required,oneof=downgrade suspend"` // Taken when grace ends unpaid
+}
+
+// TaxConfig configures VAT calculation, see docs/billing_tax.md
+type TaxConfig struct {
+	RatesFile        string `koanf:"rates_file"`                            // Rate table to use instead of the built-in one
+	PricesIncludeTax bool   `koanf:"prices_include_tax" validate:"eq=true"` // Plan prices are gross amounts; must be true, the gateways do not add tax on top
+}
+
+// InvoicingConfig configures invoice generation, see docs/billing_invoices.md
+type InvoicingConfig struct {
+	DefaultEntity   string              `koanf:"default_entity" validate:"required"`
+	PaymentTermDays int                 `koanf:"payment_term_days" validate:"min=0"`
+	Entities        []LegalEntityConfig `koanf:"entities" validate:"required,min=1,unique=ID,dive"`
+}
+
+// LegalEntityConfig is a company issuing invoices, each with its own number sequences
+type LegalEntityConfig struct {
+	ID               string   `koanf:"id" validate:"required"`
+	Name             string   `koanf:"name" validate:"required"`
+	Address          []string `koanf:"address" validate:"required,min=1"`
+	Country          string   `koanf:"country" validate:"required,len=2,uppercase"`
+	VATID            string   `koanf:"vat_id"`
+	Email            string   `koanf:"email" validate:"omitempty,email"`
+	InvoicePrefix    string   `koanf:"invoice_prefix" validate:"required"`
+	CreditNotePrefix string   `koanf:"credit_note_prefix" validate:"required,nefield=InvoicePrefix"`
+}
+
+// MeteringConfig configures usage metering, see docs/billing_metering.md
+type MeteringConfig struct {
+	IngestToken string        `koanf:"ingest_token" validate:"required_with=Meters,omitempty,min=32"` // Bearer token of services reporting usage
+	Meters      []MeterConfig `koanf:"meters" validate:"unique=Name,dive"`
+}
+
+type MeterConfig struct {
+	Name            string `koanf:"name" validate:"required"`
+	Aggregation     string `koanf:"aggregation" validate:"required,oneof=sum max unique"`
+	StripeEventName string `koanf:"stripe_event_name"`                                           // Event name of the Stripe billing meter, empty to not report
+	StripeMeterID   string `koanf:"stripe_meter_id" validate:"required_with=StripeEventName"`    // Stripe billing meter ID, used for reconciliation
+	CreditPrice     int64  `koanf:"credit_price" validate:"excluded_with=StripeEventName,min=0"` // Credits in minor units burned per CreditUnits of usage, 0 to not burn
+	CreditUnits     int64  `koanf:"credit_units" validate:"required_with=CreditPrice,min=0"`     // Usage priced at CreditPrice
+}
+
+// PlanConfig seeds the plan catalog, see docs/billing.md
+type PlanConfig struct {
+	AccountType string           `koanf:"account_type" validate:"required"`
+	Name        string           `koanf:"name" validate:"required"`
+	TrialDays   int              `koanf:"trial_days" validate:"min=0"`
+	Features    []string         `koanf:"features" validate:"dive,required"`
+	Quotas      map[string]int64 `koanf:"quotas" validate:"dive,keys,required,endkeys,min=0"`
+	Prices      []PriceConfig    `koanf:"prices" validate:"dive"` // Empty for free plans
+}
+
+type PriceConfig struct {
+	Currency string `koanf:"currency" validate:"required,len=3,lowercase"`
+	Interval string `koanf:"interval" validate:"required,oneof=month year"`
+	Amount   int64  `koanf:"amount" validate:"min=0"` // In the currency's minor unit
+	Gateway  string `koanf:"gateway" validate:"required,oneof=stripe paypal crypto"`
+	PriceID  string `koanf:"price_id" validate:"required"`
+}
+
 type MaxMindConfig struct {
 	GeoLite2 GeoLite2Config `koanf:"geolite2" validate:"required"`
 }
 
 type GeoLite2Config struct {
 	Country string `koanf:"country" validate:"required,url"`
+	Path    string `koanf:"path" validate:"required"` // Where the downloaded database is kept
 }
 
 type SentryConfig struct {
@@ -81,15 +224,101 @@ type OAuthProviders struct {
 	GitHub OAuthConfig `koanf:"github" validate:"required"`
 }
 
+type GDPRConfig struct {
+	GracePeriodDays  int              `koanf:"grace_period_days" validate:"required,min=1"`
+	AnonymizerDryRun bool             `koanf:"anonymizer_dry_run"`
+	Export           ExportConfig     `koanf:"export" validate:"required"`
+	Erasure          ErasureConfig    `koanf:"erasure" validate:"required"`
+	Inactivity       InactivityConfig `koanf:"inactivity"`
+}
+
+type InactivityConfig struct {
+	DryRun bool             `koanf:"dry_run"`
+	Rules  []InactivityRule `koanf:"rules" validate:"dive"`
+}
+
+type InactivityRule struct {
+	Name            string   `koanf:"name" validate:"required"`
+	AccountTypes    []string `koanf:"account_types"` // Empty matches every account type
+	WarnAfterDays   int      `koanf:"warn_after_days" validate:"required,min=1"`
+	DeleteAfterDays int      `koanf:"delete_after_days" validate:"required,gtfield=WarnAfterDays"`
+}
+
+type ErasureConfig struct {
+	HashSecret string            `koanf:"hash_secret" validate:"required,min=32"`
+	Policies   map[string]string `koanf:"policies" validate:"required,dive,keys,oneof=login_history email_history account_history security_history admin_history membership_history,endkeys,oneof=keep delete pseudonymize hash"`
+}
+
+type ExportConfig struct {
+	TTLHours int `koanf:"ttl_hours" validate:"required,min=1"`
+}
+
+// OrganizationsConfig configures team accounts, see docs/organizations.md
+type OrganizationsConfig struct {
+	AccountTypes       []string `koanf:"account_types"`                                                              // Account types that can create organizations, empty disables them
+	InvitationTTLHours int      `koanf:"invitation_ttl_hours" validate:"required_with=AccountTypes,omitempty,min=1"` // How long an invitation can be accepted
+}
+
+// RBACConfig configures admin roles, see docs/rbac.md
+type RBACConfig struct {
+	SuperAdmins []string `koanf:"super_admins" validate:"dive,email"` // Accounts granted super-admin at startup if they have no global grant
+}
+
+// ProvisioningConfig configures admin-created accounts, see docs/provisioning.md
+type ProvisioningConfig struct {
+	InvitationTTLHours int          `koanf:"invitation_ttl_hours" validate:"required,min=1"` // How long a set-password link stays valid
+	MaxImportRows      int          `koanf:"max_import_rows" validate:"required,min=1"`      // Rows accepted in one CSV upload
+	Import             ImportConfig `koanf:"import" validate:"required"`
+}
+
+// ImportConfig configures user imports from other identity providers, see
+// docs/migration.md
+type ImportConfig struct {
+	MaxFileMB int                   `koanf:"max_file_mb" validate:"required,min=1"` // Largest accepted upload
+	Firebase  *FirebaseScryptConfig `koanf:"firebase" validate:"omitempty"`         // Hash parameters of the Firebase project, needed to verify its passwords
+}
+
+// FirebaseScryptConfig holds the password hash parameters shown in the
+// Firebase console (Authentication > Users > Password hash parameters)
+type FirebaseScryptConfig struct {
+	SignerKey     string `koanf:"signer_key" validate:"required,base64"`     // base64_signer_key
+	SaltSeparator string `koanf:"salt_separator" validate:"required,base64"` // base64_salt_separator
+	Rounds        int    `koanf:"rounds" validate:"required,min=1,max=8"`
+	MemCost       int    `koanf:"mem_cost" validate:"required,min=1,max=14"`
+}
+
+// EntitlementsConfig configures entitlement checks, see docs/entitlements.md
+type EntitlementsConfig struct {
+	ServiceToken string                 `koanf:"service_token" validate:"omitempty,min=32"` // Bearer token of services calling /entitlements, empty disables the service API
+	CacheSeconds int                    `koanf:"cache_seconds" validate:"min=0"`            // How long an instance caches resolved entitlements, 0 disables the cache
+	Token        EntitlementTokenConfig `koanf:"token"`
+}
+
+// EntitlementTokenConfig configures the signed tokens carrying entitlements as claims
+type EntitlementTokenConfig struct {
+	SigningKeyFile string   `koanf:"signing_key_file"`                                                             // PEM PKCS #8 Ed25519 private key, empty disables tokens
+	Issuer         string   `koanf:"issuer" validate:"omitempty,url"`                                              // iss claim, site.api_url when empty
+	Audience       []string `koanf:"audience"`                                                                     // aud claim
+	TTLMinutes     int      `koanf:"ttl_minutes" validate:"required_with=SigningKeyFile,omitempty,min=1,max=1440"` // Token lifetime, shortened to the end of a trial or grace period
+}
+
 type Config struct {
-	Server   ServerConfig   `koanf:"server" validate:"required"`
-	Swagger  SwaggerConfig  `koanf:"swagger" validate:"required"`
-	Stripe   StripeConfig   `koanf:"stripe" validate:"required"`
-	MaxMind  MaxMindConfig  `koanf:"maxmind" validate:"required"`
-	Sentry   SentryConfig   `koanf:"sentry" validate:"required"`
-	Emails   []EmailConfig  `koanf:"emails" validate:"required,min=1,dive"`
-	CORS     CORSConfig     `koanf:"cors" validate:"required"`
-	Database DatabaseConfig `koanf:"database" validate:"required"`
-	Site     SiteConfig     `koanf:"site" validate:"required"`
-	OAuth    OAuthProviders `koanf:"oauth" validate:"required"`
+	Server        ServerConfig        `koanf:"server" validate:"required"`
+	Swagger       SwaggerConfig       `koanf:"swagger" validate:"required"`
+	Stripe        StripeConfig        `koanf:"stripe" validate:"required"`
+	PayPal        *PayPalConfig       `koanf:"paypal" validate:"omitempty"`
+	Crypto        *CryptoConfig       `koanf:"crypto" validate:"omitempty"`
+	MaxMind       MaxMindConfig       `koanf:"maxmind" validate:"required"`
+	Sentry        SentryConfig        `koanf:"sentry" validate:"required"`
+	Emails        []EmailConfig       `koanf:"emails" validate:"required,min=1,dive"`
+	CORS          CORSConfig          `koanf:"cors" validate:"required"`
+	Database      DatabaseConfig      `koanf:"database" validate:"required"`
+	Site          SiteConfig          `koanf:"site" validate:"required"`
+	OAuth         OAuthProviders      `koanf:"oauth" validate:"required"`
+	GDPR          GDPRConfig          `koanf:"gdpr" validate:"required"`
+	Billing       BillingConfig       `koanf:"billing" validate:"required"`
+	Entitlements  EntitlementsConfig  `koanf:"entitlements"`
+	Organizations OrganizationsConfig `koanf:"organizations"`
+	RBAC          RBACConfig          `koanf:"rbac"`
+	Provisioning  ProvisioningConfig  `koanf:"provisioning" validate:"required"`
 }
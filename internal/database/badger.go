@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var KV *badger.DB
+
+// InitBadger opens the local BadgerDB key-value store
+func InitBadger() {
+	dir := config.GetDatabaseConfig().Badger.Dir
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(badgerLogger{}))
+	if err != nil {
+		log.Fatal().Err(err).Str("dir", dir).Msg("Error opening BadgerDB")
+	}
+	KV = db
+	log.Info().Str("dir", dir).Msg("Opened BadgerDB")
+}
+
+// CloseBadger closes the key-value store
+func CloseBadger() {
+	if KV == nil {
+		return
+	}
+	if err := KV.Close(); err != nil {
+		log.Error().Err(err).Msg("Error closing BadgerDB")
+	}
+}
+
+// badgerLogger routes Badger's logging through zerolog
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(f string, v ...any) {
+	log.Error().Str("component", "badger").Msg(strings.TrimSpace(fmt.Sprintf(f, v...)))
+}
+
+func (badgerLogger) Warningf(f string, v ...any) {
+	log.Warn().Str("component", "badger").Msg(strings.TrimSpace(fmt.Sprintf(f, v...)))
+}
+
+func (badgerLogger) Infof(f string, v ...any) {
+	log.Debug().Str("component", "badger").Msg(strings.TrimSpace(fmt.Sprintf(f, v...)))
+}
+
+func (badgerLogger) Debugf(f string, v ...any) {
+	log.Trace().Str("component", "badger").Msg(strings.TrimSpace(fmt.Sprintf(f, v...)))
+}
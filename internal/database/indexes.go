@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// indexes lists the indexes required by each collection
+var indexes = map[string][]mongo.IndexModel{
+	schema.COLLECTION_USERS: {
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "phone_number", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "auth_info.setup_token_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "deletion_info.recovery_token_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "import.source", Value: 1}, {Key: "import.external_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"import": bson.M{"$exists": true}})},
+	},
+	schema.COLLECTION_USER_IMPORTS: {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_SESSIONS: {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	schema.COLLECTION_DATA_EXPORTS: {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	schema.COLLECTION_ERASURE_REPORTS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	},
+	schema.COLLECTION_INACTIVITY_REPORTS: {
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_BILLING_CUSTOMERS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "gateway", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "external_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_BILLING_DETAILS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_PLANS: {
+		{Keys: bson.D{{Key: "account_type", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "prices.gateway", Value: 1}, {Key: "prices.price_id", Value: 1}}},
+	},
+	schema.COLLECTION_SUBSCRIPTIONS: {
+		{Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "external_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	schema.COLLECTION_CRYPTO_SUBSCRIPTIONS: {
+		{Keys: bson.D{{Key: "invoices.external_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+		{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$gt": ""}})},
+	},
+	schema.COLLECTION_DUNNING_CASES: {
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": schema.DUNNING_STATUS_OPEN})},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_retry_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_COUPONS: {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_PROMOTION_CODES: {
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_COUPON_REDEMPTIONS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "coupon_key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "coupon_key", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_REFERRAL_CODES: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_REFERRALS: {
+		{Keys: bson.D{{Key: "referee_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "referrer_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	schema.COLLECTION_INVOICES: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "payment_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"payment_id": bson.M{"$type": "string"}})},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+	},
+	schema.COLLECTION_INVOICE_NUMBERS: {
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "kind", Value: 1}, {Key: "year", Value: 1}, {Key: "sequence", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "invoice_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_INVOICE_DOCUMENTS: {
+		{Keys: bson.D{{Key: "invoice_id", Value: 1}, {Key: "format", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_USAGE_EVENTS: {
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(schema.TTL_USAGE_EVENTS)},
+		{Keys: bson.D{{Key: "aggregated", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "meter", Value: 1}, {Key: "timestamp", Value: 1}}},
+	},
+	schema.COLLECTION_USAGE_BUCKETS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "meter", Value: 1}, {Key: "granularity", Value: 1}, {Key: "period_start", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "granularity", Value: 1}, {Key: "period_end", Value: 1}}},
+	},
+	schema.COLLECTION_USAGE_RECONCILIATIONS: {
+		{Keys: bson.D{{Key: "meter", Value: 1}, {Key: "period_start", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_LEDGER_ACCOUNTS: {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "owner_type", Value: 1}, {Key: "owner_id", Value: 1}, {Key: "currency", Value: 1}, {Key: "kind", Value: 1}}},
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "expires_at", Value: 1}}, Options: options.Index().SetPartialFilterExpression(bson.M{"balance": bson.M{"$gt": 0}})},
+	},
+	schema.COLLECTION_LEDGER_ENTRIES: {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// One posting per sequence number of a credit account, see ledger.post
+		{Keys: bson.D{{Key: "locks", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"locks": bson.M{"$exists": true}})},
+		{Keys: bson.D{{Key: "owner_type", Value: 1}, {Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_LEDGER_TRIAL_BALANCES: {
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_ENTITLEMENT_OVERRIDES: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "kind", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	schema.COLLECTION_ORGANIZATIONS: {
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	},
+	schema.COLLECTION_ORGANIZATION_MEMBERS: {
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	schema.COLLECTION_ORGANIZATION_INVITES: {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": schema.INVITATION_STATUS_PENDING})},
+	},
+	schema.COLLECTION_ORGANIZATION_DOMAINS: {
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "domain", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "domain", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"verified": true})},
+	},
+	schema.COLLECTION_MEMBERSHIP_HISTORY: {
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	schema.COLLECTION_ROLES: {
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	schema.COLLECTION_ROLE_GRANTS: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "role", Value: 1}, {Key: "organization_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "role", Value: 1}}},
+	},
+	schema.COLLECTION_LOGIN_HISTORY:    historyIndexes(schema.TTL_LOGIN_HISTORY),
+	schema.COLLECTION_EMAIL_HISTORY:    historyIndexes(schema.TTL_EMAIL_HISTORY),
+	schema.COLLECTION_ACCOUNT_HISTORY:  historyIndexes(schema.TTL_ACCOUNT_HISTORY),
+	schema.COLLECTION_SECURITY_HISTORY: historyIndexes(schema.TTL_SECURITY_HISTORY),
+	schema.COLLECTION_ADMIN_HISTORY:    historyIndexes(schema.TTL_ADMIN_HISTORY),
+}
+
+// historyIndexes returns the TTL and per-user feed indexes shared by all event collections
+func historyIndexes(ttl int32) []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(ttl)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+}
+
+func ensureIndexes(ctx context.Context) error {
+	for name, models := range indexes {
+		if _, err := DB.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes for %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	Client *mongo.Client
+	DB     *mongo.Database
+)
+
+// InitMongo connects to MongoDB using the database configuration
+func InitMongo() {
+	cfg := config.GetDatabaseConfig().MongoDB
+
+	client, err := mongo.Connect(options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error connecting to MongoDB")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Fatal().Err(err).Msg("Error pinging MongoDB")
+	}
+
+	Client = client
+	DB = client.Database(cfg.DBName)
+	log.Info().Str("db_name", cfg.DBName).Msg("Connected to MongoDB")
+
+	if err := ensureIndexes(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Error creating MongoDB indexes")
+	}
+}
+
+// CloseMongo disconnects the MongoDB client
+func CloseMongo() {
+	if Client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := Client.Disconnect(ctx); err != nil {
+		log.Error().Err(err).Msg("Error disconnecting from MongoDB")
+	}
+}
+
+// Collection returns a handle for the named collection
+func Collection(name string) *mongo.Collection {
+	return DB.Collection(name)
+}
+
+// Bucket returns the named GridFS bucket, for files every instance has to
+// read that are too large for a document
+func Bucket(name string) *mongo.GridFSBucket {
+	return DB.GridFSBucket(options.GridFSBucket().SetName(name))
+}
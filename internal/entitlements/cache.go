@@ -0,0 +1,70 @@
+package entitlements
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// MAX_CACHE_ENTRIES bounds the users cached per instance. When it is
+// reached, expired entries are dropped, and the whole cache if none were.
+const MAX_CACHE_ENTRIES = 100_000
+
+type cacheEntry struct {
+	entitlements *Entitlements
+	expiresAt    time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = map[bson.ObjectID]cacheEntry{}
+)
+
+// cached returns the cached entitlements of a user, or nil
+func cached(userID bson.ObjectID) *Entitlements {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	c, ok := cache[userID]
+	if !ok || !time.Now().Before(c.expiresAt) {
+		return nil
+	}
+	return c.entitlements
+}
+
+// store caches entitlements for cache_seconds, or until they change on
+// their own at the end of a trial, grace period or override
+func store(e *Entitlements) {
+	ttl := time.Duration(config.GetEntitlementsConfig().CacheSeconds) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := e.ResolvedAt.Add(ttl)
+	if e.ValidUntil != nil && e.ValidUntil.Before(expiresAt) {
+		expiresAt = *e.ValidUntil
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if len(cache) >= MAX_CACHE_ENTRIES {
+		now := time.Now()
+		for id, c := range cache {
+			if !now.Before(c.expiresAt) {
+				delete(cache, id)
+			}
+		}
+		if len(cache) >= MAX_CACHE_ENTRIES {
+			clear(cache)
+		}
+	}
+	cache[e.UserID] = cacheEntry{entitlements: e, expiresAt: expiresAt}
+}
+
+// Invalidate drops the cached entitlements of a user on this instance.
+// Other instances pick up the change when their entry expires.
+func Invalidate(userID bson.ObjectID) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	delete(cache, userID)
+}
@@ -0,0 +1,201 @@
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/catalog"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type STATUS string
+
+const (
+	STATUS_FREE     STATUS = "free"     // No entitled subscription, the plan of User.AccountType applies
+	STATUS_ACTIVE   STATUS = "active"   // Paid subscription
+	STATUS_TRIALING STATUS = "trialing" // Subscription in free trial until TrialEndsAt
+	STATUS_GRACE    STATUS = "grace"    // Renewal payment failed, the plan is kept until GraceEndsAt
+	STATUS_BLOCKED  STATUS = "blocked"  // Account is suspended or deleted, nothing is granted
+)
+
+// Entitlements are the features and limits a user currently has
+type Entitlements struct {
+	UserID      bson.ObjectID    `json:"user_id"`
+	AccountType string           `json:"account_type"`            // Plan the entitlements come from
+	PlanVersion int              `json:"plan_version"`            // Catalog version of the plan
+	Status      STATUS           `json:"status"`                  // Why the plan applies
+	Features    []string         `json:"features"`                // Granted features, sorted
+	Limits      map[string]int64 `json:"limits"`                  // Quotas by name, schema.UNLIMITED_QUOTA when lifted
+	TrialEndsAt *time.Time       `json:"trial_ends_at,omitempty"` // End of the free trial
+	GraceEndsAt *time.Time       `json:"grace_ends_at,omitempty"` // When the plan is lost unless the payment is collected
+	ValidUntil  *time.Time       `json:"valid_until,omitempty"`   // Next known change: end of the trial, the grace period or an override
+	ResolvedAt  time.Time        `json:"resolved_at"`
+}
+
+// Has reports whether a feature is granted
+func (e *Entitlements) Has(feature string) bool {
+	i := sort.SearchStrings(e.Features, feature)
+	return i < len(e.Features) && e.Features[i] == feature
+}
+
+// Limit returns a quota, 0 when the plan does not grant it
+func (e *Entitlements) Limit(name string) int64 {
+	return e.Limits[name]
+}
+
+// Allows reports whether usage stays within a quota
+func (e *Entitlements) Allows(name string, usage int64) bool {
+	limit, ok := e.Limits[name]
+	return ok && (limit == schema.UNLIMITED_QUOTA || usage <= limit)
+}
+
+// until moves ValidUntil to t when t is earlier
+func (e *Entitlements) until(t *time.Time) {
+	if t != nil && t.After(e.ResolvedAt) && (e.ValidUntil == nil || t.Before(*e.ValidUntil)) {
+		e.ValidUntil = t
+	}
+}
+
+// InitEntitlements drops cached entitlements whenever billing changes a
+// user's plan and loads the token signing key
+func InitEntitlements() {
+	billing.RegisterPlanListener(Invalidate)
+	if err := loadSigningKey(config.GetEntitlementsConfig().Token); err != nil {
+		log.Fatal().Err(err).Msg("Error loading entitlement token signing key")
+	}
+}
+
+// Get returns the user's entitlements, from the local cache when they were
+// resolved recently. The result is shared with other callers and must not
+// be modified.
+func Get(ctx context.Context, userID bson.ObjectID) (*Entitlements, error) {
+	if e := cached(userID); e != nil {
+		return e, nil
+	}
+	e, err := Resolve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	store(e)
+	return e, nil
+}
+
+// HasFeature reports whether the user has a feature
+func HasFeature(ctx context.Context, userID bson.ObjectID, feature string) (bool, error) {
+	e, err := Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return e.Has(feature), nil
+}
+
+// Resolve computes the user's entitlements without the cache. The plan is
+// the catalog version of an entitled subscription, or the current version
+// of the plan of User.AccountType without one; overrides are applied on top.
+func Resolve(ctx context.Context, userID bson.ObjectID) (*Entitlements, error) {
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entitlements{
+		UserID:      userID,
+		AccountType: u.AccountType,
+		Status:      STATUS_FREE,
+		Features:    []string{},
+		Limits:      map[string]int64{},
+		ResolvedAt:  time.Now().UTC(),
+	}
+	switch u.Status {
+	case schema.USER_STATUS_SUSPENDED, schema.USER_STATUS_DELETED, schema.USER_STATUS_ANONYMIZED:
+		e.Status = STATUS_BLOCKED
+		return e, nil
+	}
+
+	plan, err := resolvePlan(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	features := map[string]bool{}
+	if plan != nil {
+		e.PlanVersion = plan.Version
+		for _, f := range plan.Features {
+			features[f] = true
+		}
+		for k, v := range plan.Quotas {
+			e.Limits[k] = v
+		}
+	}
+
+	overrides, err := activeOverrides(ctx, userID, e.ResolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		switch o.Kind {
+		case schema.ENTITLEMENT_KIND_FEATURE:
+			features[o.Key] = o.Enabled
+		case schema.ENTITLEMENT_KIND_LIMIT:
+			e.Limits[o.Key] = o.Limit
+		}
+		e.until(o.ExpiresAt)
+	}
+	for f, ok := range features {
+		if ok {
+			e.Features = append(e.Features, f)
+		}
+	}
+	sort.Strings(e.Features)
+	return e, nil
+}
+
+// resolvePlan sets the status of the entitlements from the user's
+// subscription and returns the plan granting them. It returns nil when the
+// account type has no plan, which is logged at startup by billing.
+//
+// A subscription whose grace period ended is not entitled anymore, even
+// before dunning takes its final action, so the default plan applies.
+func resolvePlan(ctx context.Context, e *Entitlements) (*schema.Plan, error) {
+	s, err := billing.CurrentSubscription(ctx, e.UserID)
+	if err != nil && !errors.Is(err, billing.ErrNoSubscription) {
+		return nil, err
+	}
+	if s != nil && s.Status == schema.SUBSCRIPTION_STATUS_PAST_DUE && s.GraceEndsAt != nil && !s.GraceEndsAt.After(e.ResolvedAt) {
+		e.AccountType = config.GetBillingConfig().DefaultAccountType
+		s = nil
+	}
+
+	var plan *schema.Plan
+	if s != nil && s.Entitled() && s.AccountType != "" {
+		e.AccountType = s.AccountType
+		switch s.Status {
+		case schema.SUBSCRIPTION_STATUS_TRIALING:
+			e.Status = STATUS_TRIALING
+			e.TrialEndsAt = s.TrialEnd
+			e.until(s.TrialEnd)
+		case schema.SUBSCRIPTION_STATUS_PAST_DUE:
+			e.Status = STATUS_GRACE
+			e.GraceEndsAt = s.GraceEndsAt
+			e.until(s.GraceEndsAt)
+		default:
+			e.Status = STATUS_ACTIVE
+		}
+		if s.PlanVersion > 0 {
+			plan, err = catalog.Version(ctx, s.AccountType, s.PlanVersion)
+		} else {
+			plan, err = catalog.Current(ctx, s.AccountType)
+		}
+	} else {
+		plan, err = catalog.Current(ctx, e.AccountType)
+	}
+	if errors.Is(err, catalog.ErrUnknownPlan) {
+		return nil, nil
+	}
+	return plan, err
+}
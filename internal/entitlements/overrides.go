@@ -0,0 +1,189 @@
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrInvalidOverride = errors.New("invalid entitlement override")
+	ErrUnknownOverride = errors.New("entitlement override not found")
+)
+
+func overrides() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ENTITLEMENT_OVERRIDES)
+}
+
+// Override is a request to set a feature or limit of one user
+type Override struct {
+	Kind      schema.ENTITLEMENT_KIND `json:"kind"`
+	Key       string                  `json:"key"`
+	Enabled   bool                    `json:"enabled"`              // Features only
+	Limit     int64                   `json:"limit"`                // Limits only, schema.UNLIMITED_QUOTA lifts the quota
+	ExpiresAt *time.Time              `json:"expires_at,omitempty"` // Permanent when nil
+	Reason    string                  `json:"reason"`
+}
+
+func (o Override) validate(now time.Time) error {
+	if o.Key == "" || o.Reason == "" {
+		return ErrInvalidOverride
+	}
+	if o.ExpiresAt != nil && !o.ExpiresAt.After(now) {
+		return ErrInvalidOverride
+	}
+	switch o.Kind {
+	case schema.ENTITLEMENT_KIND_FEATURE:
+		return nil
+	case schema.ENTITLEMENT_KIND_LIMIT:
+		if o.Limit < 0 && o.Limit != schema.UNLIMITED_QUOTA {
+			return ErrInvalidOverride
+		}
+		return nil
+	}
+	return ErrInvalidOverride
+}
+
+// overrideValue formats an override's setting for AccountHistory
+func overrideValue(o *schema.EntitlementOverride) string {
+	if o == nil {
+		return ""
+	}
+	var v string
+	switch {
+	case o.Kind == schema.ENTITLEMENT_KIND_FEATURE && o.Enabled:
+		v = "enabled"
+	case o.Kind == schema.ENTITLEMENT_KIND_FEATURE:
+		v = "disabled"
+	case o.Limit == schema.UNLIMITED_QUOTA:
+		v = "unlimited"
+	default:
+		v = strconv.FormatInt(o.Limit, 10)
+	}
+	if o.ExpiresAt != nil {
+		v += " until " + o.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// SetOverride creates or replaces the user's override of a feature or limit
+// and records the change in AccountHistory
+func SetOverride(ctx context.Context, userID bson.ObjectID, o Override, changedBy string, meta history.Meta) (*schema.EntitlementOverride, error) {
+	now := time.Now().UTC()
+	if err := o.validate(now); err != nil {
+		return nil, err
+	}
+	if _, err := users.FindByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	// Only the setting of the override's kind is kept
+	if o.Kind == schema.ENTITLEMENT_KIND_FEATURE {
+		o.Limit = 0
+	} else {
+		o.Enabled = false
+	}
+	set := bson.M{
+		"updated_at": now,
+		"enabled":    o.Enabled,
+		"limit":      o.Limit,
+		"reason":     o.Reason,
+		"created_by": changedBy,
+	}
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"_id": bson.NewObjectID(), "created_at": now},
+	}
+	if o.ExpiresAt != nil {
+		set["expires_at"] = o.ExpiresAt.UTC()
+	} else {
+		update["$unset"] = bson.M{"expires_at": ""}
+	}
+
+	var old *schema.EntitlementOverride
+	var prev schema.EntitlementOverride
+	err := overrides().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID, "kind": o.Kind, "key": o.Key},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&prev)
+	switch {
+	case err == nil:
+		if prev.ExpiresAt == nil || prev.ExpiresAt.After(now) {
+			old = &prev
+		}
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, err
+	}
+	Invalidate(userID)
+
+	var saved schema.EntitlementOverride
+	if err := overrides().FindOne(ctx, bson.M{"user_id": userID, "kind": o.Kind, "key": o.Key}).Decode(&saved); err != nil {
+		return nil, err
+	}
+	err = history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    userID,
+		EventType: schema.ACCOUNT_EVENT_ENTITLEMENT,
+		Field:     string(o.Kind) + ":" + o.Key,
+		OldValue:  overrideValue(old),
+		NewValue:  overrideValue(&saved),
+		ChangedBy: changedBy,
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	})
+	return &saved, err
+}
+
+// RemoveOverride deletes the user's override of a feature or limit, so the
+// plan applies again, and records the change in AccountHistory
+func RemoveOverride(ctx context.Context, userID bson.ObjectID, kind schema.ENTITLEMENT_KIND, key, changedBy string, meta history.Meta) error {
+	var o schema.EntitlementOverride
+	err := overrides().FindOneAndDelete(ctx, bson.M{"user_id": userID, "kind": kind, "key": key}).Decode(&o)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUnknownOverride
+	}
+	if err != nil {
+		return err
+	}
+	Invalidate(userID)
+	return history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    userID,
+		EventType: schema.ACCOUNT_EVENT_ENTITLEMENT,
+		Field:     string(kind) + ":" + key,
+		OldValue:  overrideValue(&o),
+		ChangedBy: changedBy,
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	})
+}
+
+// Overrides returns the user's overrides that have not expired
+func Overrides(ctx context.Context, userID bson.ObjectID) ([]schema.EntitlementOverride, error) {
+	return activeOverrides(ctx, userID, time.Now().UTC())
+}
+
+func activeOverrides(ctx context.Context, userID bson.ObjectID, now time.Time) ([]schema.EntitlementOverride, error) {
+	cur, err := overrides().Find(ctx, bson.M{
+		"user_id": userID,
+		"$or":     bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}},
+	}, options.Find().SetSort(bson.D{{Key: "kind", Value: 1}, {Key: "key", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.EntitlementOverride{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,155 @@
+package entitlements
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var ErrTokensDisabled = errors.New("entitlement tokens are not enabled")
+
+var (
+	signingKey ed25519.PrivateKey
+	keyID      string
+)
+
+// loadSigningKey reads the Ed25519 key signing entitlement tokens. Tokens
+// stay disabled without one.
+func loadSigningKey(cfg config.EntitlementTokenConfig) error {
+	if cfg.SigningKeyFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(cfg.SigningKeyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM block in " + cfg.SigningKeyFile)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New("entitlement token signing key is not an Ed25519 key")
+	}
+	signingKey = key
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	keyID = base64.RawURLEncoding.EncodeToString(sum[:8])
+	return nil
+}
+
+// Claims is the payload of an entitlement token
+type Claims struct {
+	Issuer       string      `json:"iss"`
+	Subject      string      `json:"sub"` // User ID
+	Audience     []string    `json:"aud,omitempty"`
+	IssuedAt     int64       `json:"iat"`
+	ExpiresAt    int64       `json:"exp"`
+	Entitlements TokenClaims `json:"entitlements"`
+}
+
+// TokenClaims are the entitlements carried by a token
+type TokenClaims struct {
+	AccountType string           `json:"account_type"`
+	PlanVersion int              `json:"plan_version"`
+	Status      STATUS           `json:"status"`
+	Features    []string         `json:"features"`
+	Limits      map[string]int64 `json:"limits"`
+}
+
+// Token is a signed entitlement token
+type Token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssueToken signs a JWT (EdDSA) carrying the user's entitlements. It
+// expires after token.ttl_minutes, or when the entitlements change on their
+// own, e.g. at the end of a trial.
+func IssueToken(ctx context.Context, userID bson.ObjectID) (*Token, error) {
+	if signingKey == nil {
+		return nil, ErrTokensDisabled
+	}
+	cfg := config.GetEntitlementsConfig().Token
+	e, err := Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	exp := now.Add(time.Duration(cfg.TTLMinutes) * time.Minute)
+	if e.ValidUntil != nil && e.ValidUntil.Before(exp) {
+		exp = *e.ValidUntil
+	}
+	issuer := cfg.Issuer
+	if issuer == "" {
+		issuer = config.GetSiteConfig().APIURL
+	}
+	claims := Claims{
+		Issuer:    issuer,
+		Subject:   userID.Hex(),
+		Audience:  cfg.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+		Entitlements: TokenClaims{
+			AccountType: e.AccountType,
+			PlanVersion: e.PlanVersion,
+			Status:      e.Status,
+			Features:    e.Features,
+			Limits:      e.Limits,
+		},
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(signingKey, []byte(signed))
+	return &Token{
+		Token:     signed + "." + base64.RawURLEncoding.EncodeToString(sig),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKS returns the public keys verifying entitlement tokens
+func JWKS() []JWK {
+	if signingKey == nil {
+		return []JWK{}
+	}
+	return []JWK{{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey)),
+		KeyID:     keyID,
+		Use:       "sig",
+		Algorithm: "EdDSA",
+	}}
+}
@@ -0,0 +1,193 @@
+package gdpr
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	ANONYMIZER_JOB      = "gdpr_anonymizer"
+	ANONYMIZER_INTERVAL = time.Hour
+	ANONYMIZER_BATCH    = 100
+
+	// Replacement values, see docs/gdpr_deletion.md
+	ANONYMIZED_DISPLAY_NAME = "Deleted User"
+	ANONYMIZED_PHONE        = "DELETED"
+	ANONYMIZED_EMAIL_DOMAIN = "deleted.com"
+)
+
+// Anonymization is one account handled (or, in dry-run mode, that would be
+// handled) by the anonymizer
+type Anonymization struct {
+	UserID    bson.ObjectID `json:"user_id"`
+	DeletedAt time.Time     `json:"deleted_at"`
+	Done      bool          `json:"done"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// AnonymizationReport is the outcome of one anonymizer run
+type AnonymizationReport struct {
+	DryRun   bool            `json:"dry_run"`
+	RanAt    time.Time       `json:"ran_at"`
+	Accounts []Anonymization `json:"accounts"`
+}
+
+// RunAnonymizer is the scheduler job, honouring the configured dry-run mode
+func RunAnonymizer(ctx context.Context) error {
+	report, err := AnonymizeDue(ctx, config.GetGDPRConfig().AnonymizerDryRun)
+	if err != nil {
+		return err
+	}
+	for _, a := range report.Accounts {
+		log.Info().
+			Bool("dry_run", report.DryRun).
+			Bool("done", a.Done).
+			Str("user_id", a.UserID.Hex()).
+			Str("error", a.Error).
+			Msg("GDPR anonymization")
+	}
+	return nil
+}
+
+// AnonymizeDue anonymizes every deleted account whose grace period and legal
+// hold have both ended. With dryRun set nothing is written.
+func AnonymizeDue(ctx context.Context, dryRun bool) (*AnonymizationReport, error) {
+	now := time.Now().UTC()
+	grace := time.Duration(config.GetGDPRConfig().GracePeriodDays) * 24 * time.Hour
+
+	cursor, err := database.Collection(schema.COLLECTION_USERS).Find(ctx,
+		bson.M{
+			"status":                      schema.USER_STATUS_DELETED,
+			"deletion_info.anonymized_at": nil,
+			"$and": bson.A{
+				bson.M{"$or": bson.A{
+					bson.M{"deletion_info.retention_until": nil},
+					bson.M{"deletion_info.retention_until": bson.M{"$lte": now}},
+				}},
+				bson.M{"$or": bson.A{
+					bson.M{"deletion_info.deleted_at": bson.M{"$lte": now.Add(-grace)}},
+					// Deleted before deletion details were kept, the last
+					// update is the latest the deletion can have happened
+					bson.M{
+						"deletion_info.deleted_at": nil,
+						"updated_at":               bson.M{"$lte": now.Add(-grace)},
+					},
+				}},
+			},
+		},
+		options.Find().
+			SetProjection(bson.M{"_id": 1, "updated_at": 1, "deletion_info.deleted_at": 1}).
+			SetSort(bson.M{"deletion_info.deleted_at": 1}).
+			SetLimit(ANONYMIZER_BATCH),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var rows []schema.User
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	report := &AnonymizationReport{DryRun: dryRun, RanAt: now, Accounts: []Anonymization{}}
+	for _, r := range rows {
+		a := Anonymization{UserID: r.ID, DeletedAt: r.UpdatedAt}
+		if r.DeletionInfo != nil && !r.DeletionInfo.DeletedAt.IsZero() {
+			a.DeletedAt = r.DeletionInfo.DeletedAt
+		}
+		if !dryRun {
+			if _, err := Anonymize(ctx, r.ID, users.SystemActor()); err != nil {
+				a.Error = err.Error()
+			} else {
+				a.Done = true
+			}
+		}
+		report.Accounts = append(report.Accounts, a)
+	}
+	return report, nil
+}
+
+// Anonymize irreversibly replaces the personal data of a deleted account.
+// Anonymizing an already anonymized account is a no-op.
+func Anonymize(ctx context.Context, userID bson.ObjectID, actor users.Actor) (*schema.User, error) {
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status == schema.USER_STATUS_ANONYMIZED {
+		return u, nil
+	}
+	if u.Status != schema.USER_STATUS_DELETED {
+		return nil, ErrNotDeleted
+	}
+
+	hash, err := password.RandomHash()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	anonID := "user_" + u.ID.Hex()
+	set := bson.M{
+		"email":                       anonID + "@" + ANONYMIZED_EMAIL_DOMAIN,
+		"username":                    anonID,
+		"display_name":                ANONYMIZED_DISPLAY_NAME,
+		"phone_number":                ANONYMIZED_PHONE,
+		"password":                    hash,
+		"auth_info":                   schema.AuthInfo{LastPasswordChange: now},
+		"deletion_info.anonymized_at": now,
+		"deletion_info.anonymized_by": actor.String(),
+	}
+	// Accounts deleted before deletion details were kept have none, or only
+	// a legal hold placed since; they are filled in with what is known
+	reason := schema.DELETION_REASON_SYSTEM_ACTION
+	if u.DeletionInfo != nil && !u.DeletionInfo.DeletedAt.IsZero() {
+		reason = u.DeletionInfo.Reason
+	} else {
+		set["deletion_info.deleted_at"] = u.UpdatedAt
+		set["deletion_info.reason"] = reason
+		set["deletion_info.requested_by"] = users.SystemActor().String()
+	}
+
+	updated, err := users.Apply(ctx, users.Transition{
+		UserID: userID,
+		To:     schema.USER_STATUS_ANONYMIZED,
+		Actor:  actor,
+		Reason: string(reason),
+		Set:    set,
+		Unset: []string{
+			"avatar_url",
+			"timezone",
+			"last_login_at",
+			"last_activity_at",
+			"last_email_change",
+			"last_phone_change",
+		},
+		Meta: history.Meta{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The users document is anonymized, now propagate the erasure to the
+	// history collections and external processors. A failure is stored as a
+	// pending erasure report and retried by RetryErasures.
+	report, err := Erase(ctx, userID, false)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID.Hex()).Msg("Error propagating erasure")
+		if err := recordFailedErasure(ctx, userID, 1, err); err != nil {
+			log.Error().Err(err).Str("user_id", userID.Hex()).Msg("Error recording failed erasure")
+		}
+	} else {
+		logErasure(report)
+	}
+	return updated, nil
+}
@@ -0,0 +1,108 @@
+package gdpr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const REVOKE_REASON_DELETED = "account_deleted"
+
+var (
+	ErrNotDeleted        = errors.New("user is not marked for deletion")
+	ErrAlreadyAnonymized = errors.New("user data has already been anonymized")
+	ErrNoLegalHold       = errors.New("user is not on legal hold")
+)
+
+// DeletionRequest marks an account for deletion
+type DeletionRequest struct {
+	UserID         bson.ObjectID
+	Reason         schema.DELETION_REASON
+	Actor          users.Actor
+	RetentionUntil *time.Time // Legal hold, anonymization waits until this date
+	Note           string     // Explanation recorded in AdminHistory
+	Meta           history.Meta
+}
+
+// RequestDeletion marks the account as deleted and revokes its sessions. Data
+// is kept until the anonymizer runs after the grace period or legal hold.
+// Requesting deletion of an already deleted account is a no-op.
+func RequestDeletion(ctx context.Context, req DeletionRequest) (*schema.User, error) {
+	current, err := users.FindByID(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status == schema.USER_STATUS_DELETED || current.Status == schema.USER_STATUS_ANONYMIZED {
+		return current, nil
+	}
+
+	// A legal hold placed while the account was live still applies
+	retention := req.RetentionUntil
+	if d := current.DeletionInfo; d != nil && d.RetentionUntil != nil && (retention == nil || d.RetentionUntil.After(*retention)) {
+		retention = d.RetentionUntil
+	}
+	info := schema.DeletionInfo{
+		DeletedAt:      time.Now().UTC(),
+		Reason:         req.Reason,
+		RequestedBy:    req.Actor.String(),
+		RetentionUntil: retention,
+		PreviousStatus: current.Status,
+	}
+	u, err := users.Apply(ctx, users.Transition{
+		UserID:  req.UserID,
+		To:      schema.USER_STATUS_DELETED,
+		Actor:   req.Actor,
+		Reason:  string(req.Reason),
+		Details: req.Note,
+		Set:     bson.M{"deletion_info": info},
+		Meta:    req.Meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := session.RevokeAllForUser(ctx, u.ID, REVOKE_REASON_DELETED, req.Meta); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error revoking sessions of deleted user")
+	}
+	return u, nil
+}
+
+// SetLegalHold places (or with a nil until, lifts) a legal retention hold on an
+// account. A live account on hold is not deleted for inactivity, a deleted one
+// is not anonymized until the hold ends; the hold carries over when a live
+// account is deleted.
+func SetLegalHold(ctx context.Context, userID bson.ObjectID, until *time.Time, reason string, admin history.AdminActor) error {
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u.Status == schema.USER_STATUS_ANONYMIZED {
+		return ErrAlreadyAnonymized
+	}
+	if until == nil && (u.DeletionInfo == nil || u.DeletionInfo.RetentionUntil == nil) {
+		return ErrNoLegalHold
+	}
+
+	if err := users.Update(ctx, userID, bson.M{"deletion_info.retention_until": until}); err != nil {
+		return err
+	}
+
+	action := "legal hold lifted"
+	if until != nil {
+		action = "legal hold placed"
+	}
+	return admin.Record(ctx, schema.AdminHistory{
+		UserID:    userID,
+		EventType: schema.ADMIN_EVENT_LEGAL_HOLD,
+		Action:    action,
+		Reason:    reason,
+		ExpiresAt: until,
+	})
+}
@@ -0,0 +1,311 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/useragent"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	ERASURE_RETRY_JOB      = "gdpr_erasure_retry"
+	ERASURE_RETRY_INTERVAL = time.Hour
+	ERASURE_RETRY_BATCH    = 100
+	MAX_ERASURE_ATTEMPTS   = 24 // Hourly retries for a day before giving up
+)
+
+type fieldKind int
+
+const (
+	fieldIP         fieldKind = iota // Truncated when pseudonymized
+	fieldUserAgent                   // Reduced to a device summary when pseudonymized
+	fieldIdentifier                  // Always hashed
+)
+
+// erasureCollections lists, in order, the history collections and the
+// fields in them that identify the user. The IP address and user agent of
+// admin_history belong to the admin, not to the user.
+var erasureCollections = []struct {
+	name   string
+	fields map[string]fieldKind
+}{
+	{schema.COLLECTION_LOGIN_HISTORY, map[string]fieldKind{"ip_address": fieldIP, "user_agent": fieldUserAgent}},
+	{schema.COLLECTION_EMAIL_HISTORY, map[string]fieldKind{"to": fieldIdentifier}},
+	{schema.COLLECTION_ACCOUNT_HISTORY, map[string]fieldKind{"ip_address": fieldIP, "user_agent": fieldUserAgent, "old_value": fieldIdentifier, "new_value": fieldIdentifier}},
+	{schema.COLLECTION_SECURITY_HISTORY, map[string]fieldKind{"ip_address": fieldIP, "user_agent": fieldUserAgent}},
+	{schema.COLLECTION_ADMIN_HISTORY, map[string]fieldKind{"reason": fieldIdentifier, "details": fieldIdentifier}},
+	{schema.COLLECTION_MEMBERSHIP_HISTORY, map[string]fieldKind{"ip_address": fieldIP, "user_agent": fieldUserAgent}},
+}
+
+func erasureReports() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ERASURE_REPORTS)
+}
+
+// Erase applies the configured erasure policy to every history collection,
+// runs the registered processors and stores the resulting report. Records
+// already rewritten by an earlier run are skipped, so it is safe to repeat.
+// A report with a failed step is left pending for RetryErasures.
+func Erase(ctx context.Context, userID bson.ObjectID, dryRun bool) (*schema.ErasureReport, error) {
+	return erase(ctx, userID, dryRun, 1)
+}
+
+func erase(ctx context.Context, userID bson.ObjectID, dryRun bool, attempt int) (*schema.ErasureReport, error) {
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &schema.ErasureReport{
+		ID:          bson.NewObjectID(),
+		CreatedAt:   time.Now().UTC(),
+		UserID:      userID,
+		DryRun:      dryRun,
+		Attempt:     attempt,
+		Collections: []schema.ErasureCollectionResult{},
+		Processors:  []schema.ErasureProcessorResult{},
+	}
+
+	policies := config.GetGDPRConfig().Erasure.Policies
+	for _, c := range erasureCollections {
+		action := schema.ERASURE_ACTION(policies[c.name])
+		if action == "" {
+			action = schema.ERASURE_ACTION_KEEP
+		}
+		result := schema.ErasureCollectionResult{Collection: c.name, Action: action}
+		if err := eraseCollection(ctx, userID, c.name, c.fields, action, dryRun, &result); err != nil {
+			result.Error = err.Error()
+		}
+		report.Collections = append(report.Collections, result)
+	}
+
+	for _, p := range registeredProcessors() {
+		result := schema.ErasureProcessorResult{Name: p.Name()}
+		detail, err := p.Erase(ctx, u, dryRun)
+		result.Detail = detail
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+		}
+		report.Processors = append(report.Processors, result)
+	}
+
+	if !dryRun {
+		report.Status = erasureStatus(report, attempt)
+	}
+	if _, err := erasureReports().InsertOne(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// erasureStatus is pending while a step failed and attempts are left
+func erasureStatus(report *schema.ErasureReport, attempt int) schema.ERASURE_STATUS {
+	failed := report.Error != ""
+	for _, c := range report.Collections {
+		failed = failed || c.Error != ""
+	}
+	for _, p := range report.Processors {
+		failed = failed || !p.Success
+	}
+	switch {
+	case !failed:
+		return schema.ERASURE_STATUS_COMPLETE
+	case attempt >= MAX_ERASURE_ATTEMPTS:
+		return schema.ERASURE_STATUS_FAILED
+	}
+	return schema.ERASURE_STATUS_PENDING
+}
+
+// recordFailedErasure stores the report of an erasure that stopped before
+// running its steps, so that it is retried like one with a failed step
+func recordFailedErasure(ctx context.Context, userID bson.ObjectID, attempt int, cause error) error {
+	report := &schema.ErasureReport{
+		ID:          bson.NewObjectID(),
+		CreatedAt:   time.Now().UTC(),
+		UserID:      userID,
+		Attempt:     attempt,
+		Error:       cause.Error(),
+		Collections: []schema.ErasureCollectionResult{},
+		Processors:  []schema.ErasureProcessorResult{},
+	}
+	report.Status = erasureStatus(report, attempt)
+	_, err := erasureReports().InsertOne(ctx, report)
+	return err
+}
+
+// RetryErasures is the scheduler job repeating erasures left pending by a
+// failed step. The pending report is marked retried and the retry stores a
+// new report, pending again until every step succeeds or the attempts run out.
+func RetryErasures(ctx context.Context) error {
+	cursor, err := erasureReports().Find(ctx,
+		bson.M{"status": schema.ERASURE_STATUS_PENDING},
+		options.Find().SetSort(bson.M{"created_at": 1}).SetLimit(ERASURE_RETRY_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	var pending []schema.ErasureReport
+	if err := cursor.All(ctx, &pending); err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		res, err := erasureReports().UpdateOne(ctx,
+			bson.M{"_id": p.ID, "status": schema.ERASURE_STATUS_PENDING},
+			bson.M{"$set": bson.M{"status": schema.ERASURE_STATUS_RETRIED}},
+		)
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		report, err := erase(ctx, p.UserID, false, p.Attempt+1)
+		if err != nil {
+			log.Error().Err(err).Str("user_id", p.UserID.Hex()).Msg("Error retrying erasure")
+			if err := recordFailedErasure(ctx, p.UserID, p.Attempt+1, err); err != nil {
+				return err
+			}
+			continue
+		}
+		logErasure(report)
+		if report.Status == schema.ERASURE_STATUS_FAILED {
+			log.Error().Str("user_id", p.UserID.Hex()).Str("report_id", report.ID.Hex()).Msg("Erasure still failing, giving up")
+		}
+	}
+	return nil
+}
+
+func eraseCollection(ctx context.Context, userID bson.ObjectID, name string, fields map[string]fieldKind, action schema.ERASURE_ACTION, dryRun bool, result *schema.ErasureCollectionResult) error {
+	coll := database.Collection(name)
+	filter := bson.M{"user_id": userID}
+	matched, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	result.Matched = matched
+
+	switch action {
+	case schema.ERASURE_ACTION_KEEP:
+		return nil
+	case schema.ERASURE_ACTION_DELETE:
+		if dryRun {
+			result.Modified = matched
+			return nil
+		}
+		res, err := coll.DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+		result.Modified = res.DeletedCount
+		return nil
+	}
+
+	// Rewrite records that an earlier run has not handled yet
+	filter["erased_at"] = bson.M{"$exists": false}
+	projection := bson.M{"_id": 1}
+	for f := range fields {
+		projection[f] = 1
+	}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	if dryRun || len(docs) == 0 {
+		result.Modified = int64(len(docs))
+		return nil
+	}
+
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		set := bson.M{"erased_at": now}
+		for f, kind := range fields {
+			v, _ := doc[f].(string)
+			if v == "" {
+				continue
+			}
+			set[f] = transform(v, kind, action)
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc["_id"]}).
+			SetUpdate(bson.M{"$set": set}))
+	}
+	res, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	result.Modified = res.ModifiedCount
+	return nil
+}
+
+func transform(v string, kind fieldKind, action schema.ERASURE_ACTION) string {
+	if action == schema.ERASURE_ACTION_PSEUDONYMIZE {
+		switch kind {
+		case fieldIP:
+			return truncateIP(v)
+		case fieldUserAgent:
+			return useragent.Parse(v).Summary()
+		}
+	}
+	return keyedHash(v)
+}
+
+// truncateIP keeps the /24 network of an IPv4 address and the /48 of an IPv6 address
+func truncateIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return keyedHash(s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// keyedHash is an HMAC so that hashed identifiers cannot be reversed by
+// hashing candidate values without the configured secret
+func keyedHash(s string) string {
+	mac := hmac.New(sha256.New, []byte(config.GetGDPRConfig().Erasure.HashSecret))
+	mac.Write([]byte(s))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// logErasure summarizes an erasure report in the application log
+func logErasure(report *schema.ErasureReport) {
+	for _, c := range report.Collections {
+		log.Info().
+			Str("user_id", report.UserID.Hex()).
+			Str("collection", c.Collection).
+			Str("action", string(c.Action)).
+			Int64("matched", c.Matched).
+			Int64("modified", c.Modified).
+			Str("error", c.Error).
+			Msg("GDPR erasure")
+	}
+	for _, p := range report.Processors {
+		log.Info().
+			Str("user_id", report.UserID.Hex()).
+			Str("processor", p.Name).
+			Bool("success", p.Success).
+			Str("error", p.Error).
+			Msg("GDPR erasure processor")
+	}
+}
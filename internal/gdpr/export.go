@@ -0,0 +1,382 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/url"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	EXPORT_JOB      = "gdpr_exports"
+	EXPORT_INTERVAL = time.Minute
+
+	// EXPORT_LEASE is how long an export may stay processing. After that the
+	// worker that claimed it is assumed to have crashed and the export is
+	// generated again.
+	EXPORT_LEASE = 15 * time.Minute
+)
+
+var (
+	ErrExportInProgress = errors.New("a data export is already in progress")
+	ErrExportNotFound   = errors.New("data export not found")
+	ErrExportNotReady   = errors.New("data export is not ready")
+	ErrExportKey        = errors.New("invalid data export key")
+)
+
+//go:embed templates/*.html
+var exportTemplateFS embed.FS
+
+var exportTemplates = template.Must(template.New("").ParseFS(exportTemplateFS, "templates/*.html"))
+
+// Archive is the machine-readable content of a data export (data.json)
+type Archive struct {
+	GeneratedAt     time.Time                       `json:"generated_at"`
+	User            *schema.User                    `json:"user"`
+	OAuthProviders  map[string]schema.OAuthProvider `json:"oauth_providers"`
+	LoginHistory    []schema.LoginHistory           `json:"login_history"`
+	EmailHistory    []schema.EmailHistory           `json:"email_history"`
+	AccountHistory  []schema.AccountHistory         `json:"account_history"`
+	SecurityHistory []schema.SecurityHistory        `json:"security_history"`
+	AdminHistory    []schema.AdminHistory           `json:"admin_history"`
+}
+
+func exportsCollection() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_DATA_EXPORTS)
+}
+
+func archivesBucket() *mongo.GridFSBucket {
+	return database.Bucket(schema.BUCKET_EXPORT_ARCHIVES)
+}
+
+// RequestExport queues a data export for the user and logs the request
+func RequestExport(ctx context.Context, userID bson.ObjectID, meta history.Meta) (*schema.DataExport, error) {
+	err := exportsCollection().FindOne(ctx, bson.M{
+		"user_id": userID,
+		"status":  bson.M{"$in": bson.A{schema.EXPORT_STATUS_PENDING, schema.EXPORT_STATUS_PROCESSING}},
+	}).Err()
+	if err == nil {
+		return nil, ErrExportInProgress
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	export := &schema.DataExport{
+		ID:        bson.NewObjectID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    userID,
+		Status:    schema.EXPORT_STATUS_PENDING,
+	}
+	if _, err := exportsCollection().InsertOne(ctx, export); err != nil {
+		return nil, err
+	}
+
+	if err := history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    userID,
+		EventType: schema.ACCOUNT_EVENT_DATA_EXPORT,
+		Field:     "export",
+		NewValue:  export.ID.Hex(),
+		ChangedBy: userID.Hex(),
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", userID.Hex()).Msg("Error recording data export request")
+	}
+	return export, nil
+}
+
+// ProcessExports generates pending exports, and exports whose lease ran out,
+// and removes expired archives
+func ProcessExports(ctx context.Context) error {
+	if err := expireExports(ctx); err != nil {
+		return err
+	}
+	for {
+		var export schema.DataExport
+		now := time.Now().UTC()
+		err := exportsCollection().FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"status": schema.EXPORT_STATUS_PENDING},
+				bson.M{"status": schema.EXPORT_STATUS_PROCESSING, "updated_at": bson.M{"$lt": now.Add(-EXPORT_LEASE)}},
+			}},
+			bson.M{"$set": bson.M{"status": schema.EXPORT_STATUS_PROCESSING, "updated_at": now}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"created_at": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&export)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := generate(ctx, &export); err != nil {
+			log.Error().Err(err).Str("export_id", export.ID.Hex()).Msg("Data export failed")
+			if rerr := removeArchive(ctx, export.ID); rerr != nil {
+				log.Error().Err(rerr).Str("export_id", export.ID.Hex()).Msg("Error removing failed data export")
+			}
+			if _, uerr := exportsCollection().UpdateByID(ctx, export.ID, bson.M{"$set": bson.M{
+				"status":     schema.EXPORT_STATUS_FAILED,
+				"error":      err.Error(),
+				"updated_at": time.Now().UTC(),
+			}}); uerr != nil {
+				return uerr
+			}
+		}
+	}
+}
+
+// generate builds, encrypts and stores the archive, then emails the download link.
+// The encryption key only exists in the link, brain never stores it.
+func generate(ctx context.Context, export *schema.DataExport) error {
+	u, err := users.FindByID(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	archive, err := collect(ctx, u)
+	if err != nil {
+		return err
+	}
+	plain, err := pack(archive)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	sealed, err := encrypt(key, plain)
+	if err != nil {
+		return err
+	}
+
+	// A reclaimed export may have left an archive behind
+	if err := removeArchive(ctx, export.ID); err != nil {
+		return err
+	}
+	if err := archivesBucket().UploadFromStreamWithID(ctx, export.ID, export.ID.Hex()+".zip.enc", bytes.NewReader(sealed)); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(time.Duration(config.GetGDPRConfig().Export.TTLHours) * time.Hour)
+	if _, err := exportsCollection().UpdateByID(ctx, export.ID, bson.M{"$set": bson.M{
+		"status":       schema.EXPORT_STATUS_READY,
+		"size":         int64(len(sealed)),
+		"completed_at": now,
+		"expires_at":   expires,
+		"updated_at":   now,
+	}}); err != nil {
+		return err
+	}
+
+	link := config.GetSiteConfig().APIURL + "/gdpr/exports/" + export.ID.Hex() +
+		"?key=" + url.QueryEscape(base64.RawURLEncoding.EncodeToString(key))
+	return mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_DATA_EXPORT,
+		Template: "data_export",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Link":        link,
+			"Expires":     expires,
+		},
+	})
+}
+
+// collect gathers everything brain holds about the user. Secrets are left
+// out by the json tags of schema.User and admin identities are redacted.
+func collect(ctx context.Context, u *schema.User) (*Archive, error) {
+	a := &Archive{
+		GeneratedAt:    time.Now().UTC(),
+		User:           u,
+		OAuthProviders: u.AuthInfo.OAuthProviders,
+	}
+	filter := bson.M{"user_id": u.ID}
+	if err := findAll(ctx, schema.COLLECTION_LOGIN_HISTORY, filter, &a.LoginHistory); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, schema.COLLECTION_EMAIL_HISTORY, filter, &a.EmailHistory); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, schema.COLLECTION_ACCOUNT_HISTORY, filter, &a.AccountHistory); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, schema.COLLECTION_SECURITY_HISTORY, filter, &a.SecurityHistory); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, schema.COLLECTION_ADMIN_HISTORY, filter, &a.AdminHistory); err != nil {
+		return nil, err
+	}
+	for i := range a.AdminHistory {
+		a.AdminHistory[i].AdminID = bson.NilObjectID
+	}
+	return a, nil
+}
+
+func findAll(ctx context.Context, collection string, filter bson.M, out any) error {
+	cursor, err := database.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+// pack writes data.json and summary.html into a zip archive
+func pack(a *Archive) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	w, err := zw.Create("data.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a); err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("summary.html")
+	if err != nil {
+		return nil, err
+	}
+	if err := exportTemplates.ExecuteTemplate(w, "export_summary.html", map[string]any{
+		"Site":    config.GetSiteConfig(),
+		"Archive": a,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encrypt seals data with AES-256-GCM, the nonce is prepended to the output
+func encrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrExportKey
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrExportKey
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrExportKey
+	}
+	return cipher.NewGCM(block)
+}
+
+// OpenExport decrypts a ready export archive with the key from the download link
+func OpenExport(ctx context.Context, exportID bson.ObjectID, encodedKey string) ([]byte, error) {
+	var export schema.DataExport
+	err := exportsCollection().FindOne(ctx, bson.M{"_id": exportID}).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if export.Status != schema.EXPORT_STATUS_READY || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return nil, ErrExportNotReady
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, ErrExportKey
+	}
+	var sealed bytes.Buffer
+	if _, err := archivesBucket().DownloadToStream(ctx, export.ID, &sealed); err != nil {
+		if errors.Is(err, mongo.ErrFileNotFound) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	return decrypt(key, sealed.Bytes())
+}
+
+// removeArchive deletes the stored archive of an export, if any
+func removeArchive(ctx context.Context, exportID bson.ObjectID) error {
+	if err := archivesBucket().Delete(ctx, exportID); err != nil && !errors.Is(err, mongo.ErrFileNotFound) {
+		return err
+	}
+	return nil
+}
+
+// expireExports removes archives past their download window
+func expireExports(ctx context.Context) error {
+	cursor, err := exportsCollection().Find(ctx, bson.M{
+		"status":     schema.EXPORT_STATUS_READY,
+		"expires_at": bson.M{"$lte": time.Now().UTC()},
+	})
+	if err != nil {
+		return err
+	}
+	var expired []schema.DataExport
+	if err := cursor.All(ctx, &expired); err != nil {
+		return err
+	}
+	for _, e := range expired {
+		if err := removeArchive(ctx, e.ID); err != nil {
+			log.Error().Err(err).Str("export_id", e.ID.Hex()).Msg("Error removing expired data export")
+			continue
+		}
+		if _, err := exportsCollection().UpdateByID(ctx, e.ID, bson.M{
+			"$set": bson.M{"status": schema.EXPORT_STATUS_EXPIRED, "updated_at": time.Now().UTC()},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
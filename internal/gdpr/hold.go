@@ -0,0 +1,49 @@
+package gdpr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// HOLD_LEGAL is reported for accounts with a RetentionUntil in the future
+const HOLD_LEGAL = "legal_hold"
+
+// HoldCheck reports whether an account must not be deleted by the system,
+// e.g. because it has an active paid subscription
+type HoldCheck func(ctx context.Context, u *schema.User) (bool, error)
+
+var (
+	holdsMu sync.RWMutex
+	holds   = map[string]HoldCheck{}
+)
+
+// RegisterHold adds a named check consulted before system-initiated deletion
+func RegisterHold(name string, check HoldCheck) {
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	holds[name] = check
+}
+
+// onHold returns the name of the first hold preventing system deletion of
+// the account, or an empty string
+func onHold(ctx context.Context, u *schema.User, now time.Time) (string, error) {
+	if u.DeletionInfo != nil && u.DeletionInfo.RetentionUntil != nil && u.DeletionInfo.RetentionUntil.After(now) {
+		return HOLD_LEGAL, nil
+	}
+
+	holdsMu.RLock()
+	defer holdsMu.RUnlock()
+	for name, check := range holds {
+		held, err := check(ctx, u)
+		if err != nil {
+			return "", err
+		}
+		if held {
+			return name, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,209 @@
+package gdpr
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	INACTIVITY_JOB      = "gdpr_inactivity"
+	INACTIVITY_INTERVAL = 24 * time.Hour
+	INACTIVITY_BATCH    = 500 // Accounts fetched per cursor batch
+)
+
+// RunInactivityPolicy is the scheduler job, honouring the configured dry-run mode
+func RunInactivityPolicy(ctx context.Context) error {
+	cfg := config.GetGDPRConfig().Inactivity
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	report, err := ApplyInactivityPolicy(ctx, cfg.DryRun)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Bool("dry_run", report.DryRun).
+		Int("accounts", len(report.Entries)).
+		Str("report_id", report.ID.Hex()).
+		Msg("Inactive account policy evaluated")
+	return nil
+}
+
+// ApplyInactivityPolicy warns and then deletes inactive accounts according to
+// the configured rules. Each account is handled by the first rule matching
+// its account type, even when a later rule would have matched as well.
+// Deletion only happens after a warning was sent, and accounts on hold are
+// skipped. The report is stored whether or not dryRun is set.
+func ApplyInactivityPolicy(ctx context.Context, dryRun bool) (*schema.InactivityReport, error) {
+	now := time.Now().UTC()
+	report := &schema.InactivityReport{
+		ID:        bson.NewObjectID(),
+		CreatedAt: now,
+		DryRun:    dryRun,
+		Entries:   []schema.InactivityEntry{},
+	}
+
+	if rules := config.GetGDPRConfig().Inactivity.Rules; len(rules) > 0 {
+		if err := inactiveAccounts(ctx, rules, now, func(rule config.InactivityRule, u *schema.User) {
+			report.Entries = append(report.Entries, evaluate(ctx, rule, u, now, dryRun))
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := database.Collection(schema.COLLECTION_INACTIVITY_REPORTS).InsertOne(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// inactiveAccounts calls fn with every live account unseen for the warning
+// period of the first rule matching its account type. A single query for
+// the shortest warning period finds the candidates of every rule.
+func inactiveAccounts(ctx context.Context, rules []config.InactivityRule, now time.Time, fn func(config.InactivityRule, *schema.User)) error {
+	shortest := slices.MinFunc(rules, func(a, b config.InactivityRule) int { return a.WarnAfterDays - b.WarnAfterDays })
+	cursor, err := database.Collection(schema.COLLECTION_USERS).Find(ctx,
+		inactiveFilter(rules, now.AddDate(0, 0, -shortest.WarnAfterDays)),
+		options.Find().SetSort(bson.M{"_id": 1}).SetBatchSize(INACTIVITY_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var u schema.User
+		if err := cursor.Decode(&u); err != nil {
+			return err
+		}
+		rule, ok := ruleFor(rules, u.AccountType)
+		if ok && lastSeenAt(&u).Before(now.AddDate(0, 0, -rule.WarnAfterDays)) {
+			fn(rule, &u)
+		}
+	}
+	return cursor.Err()
+}
+
+// inactiveFilter selects live accounts of the rules' account types that have
+// not been seen since the cutoff
+func inactiveFilter(rules []config.InactivityRule, cutoff time.Time) bson.M {
+	filter := bson.M{
+		"status": bson.M{"$in": bson.A{schema.USER_STATUS_ACTIVE, schema.USER_STATUS_INACTIVE}},
+		"$expr": bson.M{"$lt": bson.A{
+			bson.M{"$max": bson.A{"$last_activity_at", "$last_login_at", "$created_at"}},
+			cutoff,
+		}},
+	}
+	types := []string{}
+	for _, rule := range rules {
+		if len(rule.AccountTypes) == 0 {
+			return filter
+		}
+		types = append(types, rule.AccountTypes...)
+	}
+	filter["account_type"] = bson.M{"$in": types}
+	return filter
+}
+
+// ruleFor returns the first rule matching the account type
+func ruleFor(rules []config.InactivityRule, accountType string) (config.InactivityRule, bool) {
+	for _, rule := range rules {
+		if len(rule.AccountTypes) == 0 || slices.Contains(rule.AccountTypes, accountType) {
+			return rule, true
+		}
+	}
+	return config.InactivityRule{}, false
+}
+
+func evaluate(ctx context.Context, rule config.InactivityRule, u *schema.User, now time.Time, dryRun bool) schema.InactivityEntry {
+	lastSeen := lastSeenAt(u)
+	entry := schema.InactivityEntry{
+		UserID:      u.ID,
+		Rule:        rule.Name,
+		AccountType: u.AccountType,
+		LastSeenAt:  lastSeen,
+	}
+
+	if hold, err := onHold(ctx, u, now); err != nil {
+		entry.Action = schema.INACTIVITY_ACTION_SKIP
+		entry.Error = err.Error()
+		return entry
+	} else if hold != "" {
+		entry.Action = schema.INACTIVITY_ACTION_SKIP
+		entry.SkipReason = hold
+		return entry
+	}
+
+	deleteAt := lastSeen.AddDate(0, 0, rule.DeleteAfterDays)
+	// A warning only counts if it was sent after the last activity, and the
+	// user must get the full notice period between warning and deletion
+	warned := u.InactivityWarnedAt != nil && u.InactivityWarnedAt.After(lastSeen)
+	notice := time.Duration(rule.DeleteAfterDays-rule.WarnAfterDays) * 24 * time.Hour
+	if warned && now.After(deleteAt) && now.Sub(*u.InactivityWarnedAt) >= notice {
+		entry.Action = schema.INACTIVITY_ACTION_DELETE
+		if !dryRun {
+			if _, err := RequestDeletion(ctx, DeletionRequest{
+				UserID: u.ID,
+				Reason: schema.DELETION_REASON_SYSTEM_ACTION,
+				Actor:  users.SystemActor(),
+				Meta:   history.Meta{},
+			}); err != nil {
+				entry.Error = err.Error()
+			}
+		}
+		return entry
+	}
+	if warned {
+		// Still within the notice period
+		entry.Action = schema.INACTIVITY_ACTION_SKIP
+		entry.SkipReason = "notice_period"
+		return entry
+	}
+
+	entry.Action = schema.INACTIVITY_ACTION_WARN
+	if !dryRun {
+		if err := warn(ctx, u, now.Add(notice)); err != nil {
+			entry.Error = err.Error()
+		}
+	}
+	return entry
+}
+
+func warn(ctx context.Context, u *schema.User, deleteAt time.Time) error {
+	if err := mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_INACTIVITY,
+		Template: "inactivity_warning",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"DeleteAt":    deleteAt,
+		},
+	}); err != nil {
+		return err
+	}
+	return users.Update(ctx, u.ID, bson.M{"inactivity_warned_at": time.Now().UTC()})
+}
+
+// lastSeenAt is the latest of the user's last activity, last login and creation
+func lastSeenAt(u *schema.User) time.Time {
+	times := []time.Time{u.CreatedAt}
+	if u.LastActivityAt != nil {
+		times = append(times, *u.LastActivityAt)
+	}
+	if u.LastLoginAt != nil {
+		times = append(times, *u.LastLoginAt)
+	}
+	return slices.MaxFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+}
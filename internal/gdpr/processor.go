@@ -0,0 +1,36 @@
+package gdpr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+// Processor is an external system holding personal data on brain's behalf
+// (e.g. a payment provider). Erasure runs every registered processor.
+type Processor interface {
+	// Name identifies the processor in erasure reports
+	Name() string
+	// Erase removes the user's data from the processor and describes what
+	// was done. With dryRun set it must only describe what would be done.
+	Erase(ctx context.Context, u *schema.User, dryRun bool) (string, error)
+}
+
+var (
+	processorsMu sync.RWMutex
+	processors   []Processor
+)
+
+// RegisterProcessor adds a processor to every future erasure run
+func RegisterProcessor(p Processor) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	processors = append(processors, p)
+}
+
+func registeredProcessors() []Processor {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	return append([]Processor(nil), processors...)
+}
@@ -0,0 +1,166 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/totp"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// RECOVERY_TOKEN_TTL is how long an emailed recovery link stays valid
+const RECOVERY_TOKEN_TTL = 24 * time.Hour
+
+var (
+	ErrRecoveryInvalid     = errors.New("invalid or expired recovery token")
+	ErrRecoveryUnavailable = errors.New("account can no longer be recovered")
+	ErrIdentityNotVerified = errors.New("identity verification failed")
+)
+
+// Confirmation re-verifies the identity of the account owner
+type Confirmation struct {
+	Token    string // Token from the recovery email
+	Password string // Required if the account has a password
+	TOTPCode string // Required if 2FA is enabled
+}
+
+// RequestRecovery emails a recovery link to a deleted account that is still
+// in its grace period. Unknown or unrecoverable addresses are silently
+// ignored so the endpoint cannot be used to probe for accounts.
+func RequestRecovery(ctx context.Context, email string) error {
+	u, err := users.FindByEmail(ctx, email)
+	if errors.Is(err, users.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recoverable(u, time.Now()) != nil {
+		return nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	if err := users.Update(ctx, u.ID, bson.M{
+		"deletion_info.recovery_token_hash": hashToken(token),
+		"deletion_info.recovery_sent_at":    now,
+	}); err != nil {
+		return err
+	}
+
+	link := config.GetSiteConfig().URL + "/account/recover?token=" + url.QueryEscape(token)
+	return mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_ACCOUNT_RECOVERY,
+		Template: "account_recovery",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Link":        link,
+			"Expires":     now.Add(RECOVERY_TOKEN_TTL),
+		},
+	})
+}
+
+// ConfirmRecovery restores a deleted account to the status it had before the
+// deletion request. DeletionInfo is kept as history of the reverted deletion.
+func ConfirmRecovery(ctx context.Context, c Confirmation, meta history.Meta) (*schema.User, error) {
+	if c.Token == "" {
+		return nil, ErrRecoveryInvalid
+	}
+	u, err := users.FindOne(ctx, bson.M{
+		"status":                            schema.USER_STATUS_DELETED,
+		"deletion_info.recovery_token_hash": hashToken(c.Token),
+	})
+	if errors.Is(err, users.ErrNotFound) {
+		return nil, ErrRecoveryInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sent := u.DeletionInfo.RecoverySentAt
+	if sent == nil || now.Sub(*sent) > RECOVERY_TOKEN_TTL {
+		return nil, ErrRecoveryInvalid
+	}
+	if err := recoverable(u, now); err != nil {
+		return nil, err
+	}
+	if err := verifyIdentity(ctx, u, c, now); err != nil {
+		log.Warn().Str("user_id", u.ID.Hex()).Msg("Account recovery identity verification failed")
+		return nil, err
+	}
+
+	to := u.DeletionInfo.PreviousStatus
+	if to == "" || !users.CanTransition(schema.USER_STATUS_DELETED, to) || to == schema.USER_STATUS_ANONYMIZED {
+		to = schema.USER_STATUS_ACTIVE
+	}
+	actor := users.Actor{Kind: users.ACTOR_KIND_USER, ID: u.ID}
+	recoveredAt := now.UTC()
+	return users.Apply(ctx, users.Transition{
+		UserID: u.ID,
+		To:     to,
+		Actor:  actor,
+		Reason: "account recovery",
+		Set: bson.M{
+			"deletion_info.recovered_at": recoveredAt,
+			"deletion_info.recovered_by": actor.String(),
+		},
+		Unset: []string{
+			"deletion_info.recovery_token_hash",
+			"deletion_info.recovery_sent_at",
+		},
+		Meta: meta,
+	})
+}
+
+// recoverable checks that the account is deleted, not anonymized and still
+// within the grace period
+func recoverable(u *schema.User, now time.Time) error {
+	if u.Status != schema.USER_STATUS_DELETED || u.DeletionInfo == nil {
+		return ErrNotDeleted
+	}
+	if u.DeletionInfo.AnonymizedAt != nil {
+		return ErrRecoveryUnavailable
+	}
+	grace := time.Duration(config.GetGDPRConfig().GracePeriodDays) * 24 * time.Hour
+	if now.After(u.DeletionInfo.DeletedAt.Add(grace)) {
+		return ErrRecoveryUnavailable
+	}
+	return nil
+}
+
+// verifyIdentity requires the password and, with 2FA enabled, a TOTP code on
+// top of the emailed token
+func verifyIdentity(ctx context.Context, u *schema.User, c Confirmation, now time.Time) error {
+	if u.Password != "" && !users.CheckPassword(ctx, u, c.Password) {
+		return ErrIdentityNotVerified
+	}
+	if u.AuthInfo.Is2FAEnabled && !totp.Validate(u.AuthInfo.TOTPSecret, c.TOTPCode, now) {
+		return ErrIdentityNotVerified
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
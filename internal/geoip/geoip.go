@@ -0,0 +1,185 @@
+package geoip
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MaxMind publishes GeoLite2 updates twice a week
+	UPDATE_INTERVAL = 7 * 24 * time.Hour
+	CHECK_INTERVAL  = 6 * time.Hour
+
+	downloadTimeout = 5 * time.Minute
+	maxDatabaseSize = 256 << 20
+)
+
+var db atomic.Pointer[reader]
+
+// InitGeoIP loads the GeoLite2 Country database, downloading it first when
+// it is missing or outdated. Without a database countries stay empty, which
+// every caller tolerates, so a failed download does not stop brain.
+func InitGeoIP() {
+	path := config.GetMaxMind().GeoLite2.Path
+	if err := load(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Str("path", path).Msg("Error loading GeoIP database")
+	}
+	if outdated(path) {
+		if err := update(context.Background(), path); err != nil {
+			log.Error().Err(err).Msg("Error downloading GeoIP database")
+		}
+	}
+	if db.Load() == nil {
+		log.Warn().Msg("No GeoIP database, countries will not be resolved")
+	}
+}
+
+// StartUpdater keeps the database current. Every instance keeps its own copy.
+func StartUpdater(ctx context.Context) {
+	path := config.GetMaxMind().GeoLite2.Path
+	go func() {
+		ticker := time.NewTicker(CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !outdated(path) {
+				continue
+			}
+			if err := update(ctx, path); err != nil {
+				log.Error().Err(err).Msg("Error updating GeoIP database")
+			}
+		}
+	}()
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country an IP address
+// is located in, or "" when it is unknown
+func Country(ip string) string {
+	r := db.Load()
+	if r == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	rec, err := r.lookup(addr)
+	if err != nil {
+		log.Debug().Err(err).Str("ip", ip).Msg("GeoIP lookup failed")
+		return ""
+	}
+	m, _ := rec.(map[string]any)
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := m[key].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+func load(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	r, err := newReader(buf)
+	if err != nil {
+		return err
+	}
+	db.Store(r)
+	log.Info().Str("path", path).Time("built", time.Unix(int64(r.buildEpoch), 0)).Msg("Loaded GeoIP database")
+	return nil
+}
+
+func outdated(path string) bool {
+	info, err := os.Stat(path)
+	return err != nil || time.Since(info.ModTime()) > UPDATE_INTERVAL
+}
+
+// update downloads the database archive, extracts the .mmdb file and swaps
+// it in. The file is replaced atomically so a crash never leaves a partial one.
+func update(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.GetMaxMind().GeoLite2.Country, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geoip: download failed with status %d", resp.StatusCode)
+	}
+
+	buf, err := extract(resp.Body)
+	if err != nil {
+		return err
+	}
+	r, err := newReader(buf)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	db.Store(r)
+	log.Info().Time("built", time.Unix(int64(r.buildEpoch), 0)).Msg("Updated GeoIP database")
+	return nil
+}
+
+// extract returns the .mmdb file of a MaxMind tar.gz archive
+func extract(body io.Reader) ([]byte, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("geoip: archive contains no .mmdb file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg || !strings.HasSuffix(h.Name, ".mmdb") {
+			continue
+		}
+		if h.Size > maxDatabaseSize {
+			return nil, errors.New("geoip: database too large")
+		}
+		return io.ReadAll(io.LimitReader(tr, maxDatabaseSize))
+	}
+}
@@ -0,0 +1,79 @@
+package history
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// SYSTEM_ACTOR is recorded as the actor for changes made by brain itself
+const SYSTEM_ACTOR = "system"
+
+// Meta carries the request details recorded alongside every event
+type Meta struct {
+	IPAddress string
+	Country   string
+	UserAgent string
+}
+
+// RecordLogin stores a login event
+func RecordLogin(ctx context.Context, event schema.LoginHistory) error {
+	event.CreatedAt = now(event.CreatedAt)
+	return insert(ctx, schema.COLLECTION_LOGIN_HISTORY, event)
+}
+
+// RecordEmail stores an email sending attempt
+func RecordEmail(ctx context.Context, event schema.EmailHistory) error {
+	event.CreatedAt = now(event.CreatedAt)
+	return insert(ctx, schema.COLLECTION_EMAIL_HISTORY, event)
+}
+
+// RecordAccount stores an account change
+func RecordAccount(ctx context.Context, event schema.AccountHistory) error {
+	event.CreatedAt = now(event.CreatedAt)
+	return insert(ctx, schema.COLLECTION_ACCOUNT_HISTORY, event)
+}
+
+// RecordSecurity stores a security event
+func RecordSecurity(ctx context.Context, event schema.SecurityHistory) error {
+	event.CreatedAt = now(event.CreatedAt)
+	return insert(ctx, schema.COLLECTION_SECURITY_HISTORY, event)
+}
+
+// RecordAdmin stores an administrative action
+func RecordAdmin(ctx context.Context, event schema.AdminHistory) error {
+	event.CreatedAt = now(event.CreatedAt)
+	return insert(ctx, schema.COLLECTION_ADMIN_HISTORY, event)
+}
+
+// AdminActor is the admin performing an action, recorded as AdminID in
+// AdminHistory. A zero AdminActor is the system.
+type AdminActor struct {
+	ID   bson.ObjectID
+	Meta Meta
+}
+
+// Record stores an administrative action taken by the admin, with the
+// admin's request details
+func (a AdminActor) Record(ctx context.Context, event schema.AdminHistory) error {
+	event.AdminID = a.ID
+	event.IPAddress = a.Meta.IPAddress
+	event.Country = a.Meta.Country
+	event.UserAgent = a.Meta.UserAgent
+	return RecordAdmin(ctx, event)
+}
+
+func insert(ctx context.Context, collection string, event any) error {
+	_, err := database.Collection(collection).InsertOne(ctx, event)
+	return err
+}
+
+func now(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now().UTC()
+	}
+	return t
+}
@@ -0,0 +1,229 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Nicknames of the config.Emails profiles used by brain
+const (
+	PROFILE_NOREPLY = "noreply"
+	PROFILE_BILLING = "billing"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").ParseFS(templateFS, "templates/*.tmpl"))
+
+// Message is an email rendered from one of the embedded templates. Each
+// template defines a "<name>_subject" and a "<name>_body" block.
+type Message struct {
+	Profile  string                // config.Emails nickname to send with
+	To       string                // Recipient email
+	UserID   bson.ObjectID         // Recipient user (if applicable)
+	Type     schema.EmailEventType // Recorded in EmailHistory
+	Template string                // Template name
+	Data     map[string]any        // Template data, "Site" is always available
+
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Send renders and sends the message and records the attempt in EmailHistory
+func Send(ctx context.Context, msg Message) error {
+	subject, body, err := render(msg)
+	if err == nil {
+		err = deliver(msg.Profile, msg.To, subject, body, msg.Attachments)
+	}
+
+	event := schema.EmailHistory{
+		UserID:    msg.UserID,
+		EmailType: msg.Type,
+		To:        msg.To,
+		Subject:   subject,
+		Success:   err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if herr := history.RecordEmail(ctx, event); herr != nil {
+		log.Error().Err(herr).Str("email_type", string(msg.Type)).Msg("Error recording email")
+	}
+	return err
+}
+
+func render(msg Message) (string, string, error) {
+	data := map[string]any{"Site": config.GetSiteConfig()}
+	for k, v := range msg.Data {
+		data[k] = v
+	}
+
+	var subject, body bytes.Buffer
+	if err := templates.ExecuteTemplate(&subject, msg.Template+"_subject", data); err != nil {
+		return "", "", err
+	}
+	if err := templates.ExecuteTemplate(&body, msg.Template+"_body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+func deliver(profile, to, subject, body string, attachments []Attachment) error {
+	cfg, err := config.GetSMTPConfig(profile)
+	if err != nil {
+		return err
+	}
+
+	from := mail.Address{Name: cfg.Name, Address: cfg.From}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", messageID(), domain(cfg.From))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if len(attachments) == 0 {
+		writeText(&buf, body)
+		return sendSMTP(cfg, to, buf.Bytes())
+	}
+
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", w.Boundary())
+	part, err := w.CreatePart(textproto.MIMEHeader{})
+	if err != nil {
+		return err
+	}
+	var text bytes.Buffer
+	writeText(&text, body)
+	if _, err := part.Write(text.Bytes()); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(wrapBase64(a.Data)); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return sendSMTP(cfg, to, buf.Bytes())
+}
+
+// writeText writes the headers and body of a plain text part
+func writeText(buf *bytes.Buffer, body string) {
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+}
+
+// wrapBase64 encodes data in lines of 76 characters as required by RFC 2045
+func wrapBase64(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var out bytes.Buffer
+	for len(enc) > 76 {
+		out.WriteString(enc[:76])
+		out.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	out.WriteString(enc)
+	out.WriteString("\r\n")
+	return out.Bytes()
+}
+
+func sendSMTP(cfg *config.SMTPConfig, to string, data []byte) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	var client *smtp.Client
+	var err error
+	if cfg.TLS && cfg.Port == 465 {
+		// Implicit TLS (SMTPS)
+		conn, derr := tls.Dial("tcp", addr, tlsConfig)
+		if derr != nil {
+			return derr
+		}
+		client, err = smtp.NewClient(conn, cfg.Host)
+	} else {
+		client, err = smtp.Dial(addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if cfg.TLS && cfg.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+		return err
+	}
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
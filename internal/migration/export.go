@@ -0,0 +1,94 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Export writes every user that is not deleted as a JSONL record, the format
+// read back by the jsonl import source. Password hashes are only included
+// when asked for. The export is recorded in AdminHistory once written.
+func Export(ctx context.Context, w io.Writer, withHashes bool, actor history.AdminActor) error {
+	cur, err := database.Collection(schema.COLLECTION_USERS).Find(ctx,
+		bson.M{"status": bson.M{"$nin": bson.A{schema.USER_STATUS_DELETED, schema.USER_STATUS_ANONYMIZED}}},
+		options.Find().SetSort(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	enc := json.NewEncoder(w)
+	exported := 0
+	for cur.Next(ctx) {
+		var u schema.User
+		if err := cur.Decode(&u); err != nil {
+			return err
+		}
+		if err := enc.Encode(exportRecord(&u, withHashes)); err != nil {
+			return err
+		}
+		exported++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("%d users", exported)
+	if withHashes {
+		details += ", with password hashes"
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_EXPORT,
+		Action:    "users exported",
+		Details:   details,
+	}); err != nil {
+		log.Error().Err(err).Msg("Error recording user export")
+	}
+	return nil
+}
+
+// exportRecord maps a user onto the JSONL line format. The external ID is
+// the user ID in this instance.
+func exportRecord(u *schema.User, withHashes bool) Record {
+	r := Record{
+		ExternalID:    u.ID.Hex(),
+		Email:         u.Email,
+		EmailVerified: u.AuthInfo.EmailVerified,
+		Username:      u.Username,
+		DisplayName:   u.DisplayName,
+		PhoneNumber:   u.PhoneNumber,
+		AvatarURL:     u.AvatarURL,
+		Locale:        u.Locale,
+		TimeZone:      u.TimeZone,
+		AccountType:   u.AccountType,
+		Disabled:      u.Status == schema.USER_STATUS_SUSPENDED,
+		CreatedAt:     &u.CreatedAt,
+		LastLoginAt:   u.LastLoginAt,
+	}
+	if _, ok := password.Scheme(u.Password); withHashes && ok {
+		r.PasswordHash = u.Password
+	}
+	for name, p := range u.AuthInfo.OAuthProviders {
+		r.Identities = append(r.Identities, Identity{
+			Provider:   name,
+			ProviderID: p.ProviderID,
+			Email:      p.ProviderEmail,
+			Username:   p.ProviderUsername,
+			AvatarURL:  p.ProviderAvatar,
+		})
+	}
+	sort.Slice(r.Identities, func(i, j int) bool { return r.Identities[i].Provider < r.Identities[j].Provider })
+	return r
+}
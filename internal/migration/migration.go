@@ -0,0 +1,231 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+var (
+	ErrUnknownSource     = errors.New("unknown import source")
+	ErrFileTooLarge      = errors.New("import file too large")
+	ErrFirebaseNotConfig = errors.New("provisioning.import.firebase is not configured")
+	ErrImportNotFound    = errors.New("user import not found")
+	ErrNotReviewed       = errors.New("only a finished dry run can be run")
+	ErrImportFinished    = errors.New("user import is already finished")
+)
+
+func importsCollection() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_USER_IMPORTS)
+}
+
+func filesBucket() *mongo.GridFSBucket {
+	return database.Bucket(schema.BUCKET_IMPORT_FILES)
+}
+
+// Upload stores an export file in GridFS, where the worker of any instance
+// reads it, and queues its import. The first record is read right away so
+// that a wrong source or a broken file is reported now rather than by the
+// worker.
+func Upload(ctx context.Context, source schema.IMPORT_SOURCE, dryRun bool, body io.Reader, actor history.AdminActor) (*schema.UserImport, error) {
+	switch source {
+	case schema.IMPORT_SOURCE_AUTH0, schema.IMPORT_SOURCE_FIREBASE, schema.IMPORT_SOURCE_KEYCLOAK, schema.IMPORT_SOURCE_JSONL:
+	default:
+		return nil, ErrUnknownSource
+	}
+	cfg := config.GetProvisioningConfig().Import
+	if source == schema.IMPORT_SOURCE_FIREBASE && cfg.Firebase == nil {
+		return nil, ErrFirebaseNotConfig
+	}
+
+	now := time.Now().UTC()
+	job := &schema.UserImport{
+		ID:        bson.NewObjectID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		CreatedBy: actor.ID,
+		Source:    source,
+		DryRun:    dryRun,
+		Status:    schema.IMPORT_STATUS_PENDING,
+		Report:    schema.ImportReport{Hashes: map[string]int{}},
+	}
+	size, err := store(ctx, job, body, int64(cfg.MaxFileMB)<<20)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(ctx, job); err != nil {
+		removeFile(ctx, job.ID)
+		return nil, err
+	}
+	job.Size = size
+
+	if _, err := importsCollection().InsertOne(ctx, job); err != nil {
+		removeFile(ctx, job.ID)
+		return nil, err
+	}
+	details := fmt.Sprintf("%s file of %d bytes", source, size)
+	if dryRun {
+		details += ", dry run"
+	}
+	// Imports concern many users, so no user_id is recorded
+	if err := actor.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_IMPORT,
+		Action:    "import uploaded",
+		Details:   job.ID.Hex() + ": " + details,
+	}); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// store uploads at most limit bytes of body as the file of a job. Nothing is
+// kept when the body is larger.
+func store(ctx context.Context, job *schema.UserImport, body io.Reader, limit int64) (int64, error) {
+	up, err := filesBucket().OpenUploadStreamWithID(ctx, job.ID, job.ID.Hex()+"."+string(job.Source))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(up, io.LimitReader(body, limit+1))
+	if err == nil && n > limit {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		up.Abort()
+		return 0, err
+	}
+	return n, up.Close()
+}
+
+// check reads the first record of a stored file
+func check(ctx context.Context, job *schema.UserImport) error {
+	f, err := filesBucket().OpenDownloadStream(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	next, err := newReader(job.Source, f)
+	if err != nil {
+		return err
+	}
+	if _, err := next(); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	return nil
+}
+
+// Run queues a reviewed dry run again, this time creating the users
+func Run(ctx context.Context, id bson.ObjectID, actor history.AdminActor) (*schema.UserImport, error) {
+	var job schema.UserImport
+	err := importsCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": schema.IMPORT_STATUS_REVIEWED},
+		bson.M{"$set": bson.M{
+			"status":     schema.IMPORT_STATUS_PENDING,
+			"dry_run":    false,
+			"checkpoint": 0,
+			"report":     schema.ImportReport{Hashes: map[string]int{}},
+			"updated_at": time.Now().UTC(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := Get(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotReviewed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_IMPORT,
+		Action:    "import started",
+		Details:   job.ID.Hex(),
+	}); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Cancel stops an import and removes its file. Users created so far are kept.
+func Cancel(ctx context.Context, id bson.ObjectID, actor history.AdminActor) (*schema.UserImport, error) {
+	var job schema.UserImport
+	err := importsCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": bson.A{
+			schema.IMPORT_STATUS_PENDING, schema.IMPORT_STATUS_PROCESSING, schema.IMPORT_STATUS_REVIEWED,
+		}}},
+		bson.M{"$set": bson.M{"status": schema.IMPORT_STATUS_CANCELLED, "updated_at": time.Now().UTC()}},
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := Get(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrImportFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+	removeFile(ctx, job.ID)
+	job.Status = schema.IMPORT_STATUS_CANCELLED
+
+	if err := actor.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_IMPORT,
+		Action:    "import cancelled",
+		Details:   fmt.Sprintf("%s: %d records processed, %d users created", job.ID.Hex(), job.Checkpoint, job.Report.Created),
+	}); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Get returns an import with its report
+func Get(ctx context.Context, id bson.ObjectID) (*schema.UserImport, error) {
+	var job schema.UserImport
+	err := importsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the latest imports, newest first
+func List(ctx context.Context, limit int) ([]schema.UserImport, error) {
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(min(limit, MAX_LIMIT)))
+	cur, err := importsCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.UserImport{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// removeFile deletes the uploaded file of an import, if any
+func removeFile(ctx context.Context, id bson.ObjectID) {
+	if err := filesBucket().Delete(ctx, id); err != nil && !errors.Is(err, mongo.ErrFileNotFound) {
+		log.Error().Err(err).Str("import_id", id.Hex()).Msg("Error removing import file")
+	}
+}
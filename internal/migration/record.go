@@ -0,0 +1,186 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+)
+
+var ErrInvalidFile = errors.New("invalid import file")
+
+// Record is a user as read from an export file, mapped onto brain's fields.
+// It is also the line format of the JSONL import and export.
+type Record struct {
+	ExternalID    string     `json:"external_id"` // User ID at the source
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Username      string     `json:"username,omitempty"`
+	DisplayName   string     `json:"display_name,omitempty"`
+	PhoneNumber   string     `json:"phone_number,omitempty"`
+	AvatarURL     string     `json:"avatar_url,omitempty"`
+	Locale        string     `json:"locale,omitempty"`
+	TimeZone      string     `json:"timezone,omitempty"`
+	AccountType   string     `json:"account_type,omitempty"`  // billing.default_account_type when empty or unknown
+	PasswordHash  string     `json:"password_hash,omitempty"` // In a format accepted by password.Verify
+	Disabled      bool       `json:"disabled,omitempty"`      // Imported as suspended
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+	Identities    []Identity `json:"identities,omitempty"` // Linked social accounts
+
+	// Problems found while reading the record that do not prevent its import,
+	// e.g. an unsupported password hash
+	Warnings []string `json:"-"`
+}
+
+// Identity is a social account linked to a user at the source
+type Identity struct {
+	Provider   string `json:"provider"` // Provider name in brain, e.g. "google"
+	ProviderID string `json:"provider_id"`
+	Email      string `json:"email,omitempty"`
+	Username   string `json:"username,omitempty"`
+	AvatarURL  string `json:"avatar_url,omitempty"`
+}
+
+func (r *Record) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// reader returns the records of an export file one by one and io.EOF after
+// the last one
+type reader func() (*Record, error)
+
+// newReader reads an export file of the given source
+func newReader(source schema.IMPORT_SOURCE, r io.Reader) (reader, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	switch source {
+	case schema.IMPORT_SOURCE_AUTH0:
+		return lines(dec, auth0Record), nil
+	case schema.IMPORT_SOURCE_FIREBASE:
+		return array(dec, firebaseRecord)
+	case schema.IMPORT_SOURCE_KEYCLOAK:
+		return array(dec, keycloakRecord)
+	case schema.IMPORT_SOURCE_JSONL:
+		return lines(dec, jsonlRecord), nil
+	}
+	return nil, ErrUnknownSource
+}
+
+// lines reads a file with one JSON object per line
+func lines(dec *json.Decoder, convert func(json.RawMessage) (*Record, error)) reader {
+	return func() (*Record, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		return convert(raw)
+	}
+}
+
+// array reads the elements of the top-level "users" array of a JSON
+// document, skipping every other field without loading it in memory at once
+func array(dec *json.Decoder, convert func(json.RawMessage) (*Record, error)) (reader, error) {
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("%w: expected a JSON object", ErrInvalidFile)
+	}
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: no users array", ErrInvalidFile)
+		}
+		if key == "users" {
+			break
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return nil, fmt.Errorf("%w: users is not an array", ErrInvalidFile)
+	}
+
+	return func() (*Record, error) {
+		if !dec.More() {
+			return nil, io.EOF
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		return convert(raw)
+	}, nil
+}
+
+// decode unmarshals a record, numbers are kept as json.Number
+func decode(raw json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func jsonlRecord(raw json.RawMessage) (*Record, error) {
+	var r Record
+	if err := decode(raw, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// millis is a Unix time in milliseconds, as a JSON number or string
+type millis struct{ t *time.Time }
+
+func (m *millis) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	t := time.UnixMilli(ms).UTC()
+	m.t = &t
+	return nil
+}
+
+// providerNames maps identity provider names of the sources onto the OAuth
+// provider names of brain. Unlisted names are kept as they are.
+var providerNames = map[string]string{
+	"google-oauth2": "google",
+	"google.com":    "google",
+	"github.com":    "github",
+	"facebook.com":  "facebook",
+	"apple.com":     "apple",
+	"twitter.com":   "twitter",
+	"microsoft.com": "microsoft",
+	"windowslive":   "microsoft",
+	"linkedin":      "linkedin",
+	"linkedin.com":  "linkedin",
+}
+
+// identity maps a linked account onto brain's provider names. ok is false for
+// providers that cannot be stored as an OAuth provider key.
+func identity(provider, id, email, username, avatar string) (Identity, bool) {
+	if name, ok := providerNames[provider]; ok {
+		provider = name
+	}
+	if provider == "" || id == "" || strings.ContainsAny(provider, ".$") {
+		return Identity{}, false
+	}
+	return Identity{Provider: provider, ProviderID: id, Email: email, Username: username, AvatarURL: avatar}, true
+}
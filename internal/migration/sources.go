@@ -0,0 +1,305 @@
+package migration
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/password"
+)
+
+// auth0User is a line of an Auth0 user export job, or of the password hash
+// export provided by Auth0 support (_id, email, passwordHash). The bulk import
+// format with custom_password_hash is accepted as well.
+type auth0User struct {
+	UserID string `json:"user_id"`
+	OID    struct {
+		OID string `json:"$oid"`
+	} `json:"_id"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Username      string     `json:"username"`
+	Name          string     `json:"name"`
+	Nickname      string     `json:"nickname"`
+	Picture       string     `json:"picture"`
+	PhoneNumber   string     `json:"phone_number"`
+	CreatedAt     *time.Time `json:"created_at"`
+	LastLogin     *time.Time `json:"last_login"`
+	Blocked       bool       `json:"blocked"`
+	PasswordHash  string     `json:"passwordHash"`
+	CustomHash    *struct {
+		Algorithm string `json:"algorithm"`
+		Hash      struct {
+			Value string `json:"value"`
+		} `json:"hash"`
+	} `json:"custom_password_hash"`
+	Identities []struct {
+		Provider    string `json:"provider"`
+		UserID      any    `json:"user_id"` // A string, or a number for some social providers
+		IsSocial    bool   `json:"isSocial"`
+		ProfileData struct {
+			Email    string `json:"email"`
+			Username string `json:"username"`
+			Picture  string `json:"picture"`
+		} `json:"profileData"`
+	} `json:"identities"`
+}
+
+func auth0Record(raw json.RawMessage) (*Record, error) {
+	var u auth0User
+	if err := decode(raw, &u); err != nil {
+		return nil, err
+	}
+	r := &Record{
+		ExternalID:    u.UserID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Username:      u.Username,
+		DisplayName:   u.Name,
+		PhoneNumber:   u.PhoneNumber,
+		AvatarURL:     u.Picture,
+		Disabled:      u.Blocked,
+		CreatedAt:     u.CreatedAt,
+		LastLoginAt:   u.LastLogin,
+	}
+	if r.ExternalID == "" && u.OID.OID != "" {
+		r.ExternalID = "auth0|" + u.OID.OID
+	}
+	if r.DisplayName == "" {
+		r.DisplayName = u.Nickname
+	}
+
+	switch {
+	case u.PasswordHash != "":
+		r.setHash(password.Bcrypt(u.PasswordHash))
+	case u.CustomHash != nil && u.CustomHash.Algorithm == "bcrypt":
+		r.setHash(password.Bcrypt(u.CustomHash.Hash.Value))
+	case u.CustomHash != nil && u.CustomHash.Algorithm == "pbkdf2":
+		r.setHash(phcPBKDF2(u.CustomHash.Hash.Value))
+	case u.CustomHash != nil:
+		r.warn("unsupported password hash algorithm %q", u.CustomHash.Algorithm)
+	}
+
+	for _, i := range u.Identities {
+		if !i.IsSocial {
+			continue
+		}
+		id := ""
+		switch v := i.UserID.(type) {
+		case string:
+			id = v
+		case json.Number:
+			id = v.String()
+		}
+		if ident, ok := identity(i.Provider, id, i.ProfileData.Email, i.ProfileData.Username, i.ProfileData.Picture); ok {
+			r.Identities = append(r.Identities, ident)
+		} else {
+			r.warn("identity of provider %q not imported", i.Provider)
+		}
+	}
+	return r, nil
+}
+
+// phcPBKDF2 converts a PBKDF2 hash in PHC string format, as used by Auth0
+// bulk imports: $pbkdf2-sha256$i=100000,l=32$<salt>$<hash>
+func phcPBKDF2(phc string) (string, error) {
+	parts := strings.Split(phc, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return "", password.ErrUnsupportedHash
+	}
+	iterations := 0
+	for _, p := range strings.Split(parts[2], ",") {
+		if v, ok := strings.CutPrefix(p, "i="); ok {
+			iterations, _ = strconv.Atoi(v)
+		}
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[3], "="))
+	h, err2 := base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[4], "="))
+	if err1 != nil || err2 != nil {
+		return "", password.ErrUnsupportedHash
+	}
+	return password.PBKDF2(parts[1], iterations, salt, h)
+}
+
+// firebaseUser is an element of the users array written by
+// firebase auth:export --format=json
+type firebaseUser struct {
+	LocalID       string `json:"localId"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	DisplayName   string `json:"displayName"`
+	PhotoURL      string `json:"photoUrl"`
+	PhoneNumber   string `json:"phoneNumber"`
+	Disabled      bool   `json:"disabled"`
+	PasswordHash  string `json:"passwordHash"`
+	Salt          string `json:"salt"`
+	CreatedAt     millis `json:"createdAt"`
+	LastSignedIn  millis `json:"lastSignedInAt"`
+	Providers     []struct {
+		ProviderID  string `json:"providerId"`
+		RawID       string `json:"rawId"`
+		Email       string `json:"email"`
+		DisplayName string `json:"displayName"`
+		PhotoURL    string `json:"photoUrl"`
+		ScreenName  string `json:"screenName"`
+	} `json:"providerUserInfo"`
+}
+
+func firebaseRecord(raw json.RawMessage) (*Record, error) {
+	var u firebaseUser
+	if err := decode(raw, &u); err != nil {
+		return nil, err
+	}
+	r := &Record{
+		ExternalID:    u.LocalID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		PhoneNumber:   u.PhoneNumber,
+		AvatarURL:     u.PhotoURL,
+		Disabled:      u.Disabled,
+		CreatedAt:     u.CreatedAt.t,
+		LastLoginAt:   u.LastSignedIn.t,
+	}
+	if u.PasswordHash != "" {
+		r.setHash(password.FirebaseScrypt(u.Salt, u.PasswordHash))
+	}
+	for _, p := range u.Providers {
+		// Email/password and phone sign-in are not linked accounts
+		if p.ProviderID == "password" || p.ProviderID == "phone" {
+			continue
+		}
+		if ident, ok := identity(p.ProviderID, p.RawID, p.Email, p.ScreenName, p.PhotoURL); ok {
+			r.Identities = append(r.Identities, ident)
+		} else {
+			r.warn("identity of provider %q not imported", p.ProviderID)
+		}
+	}
+	return r, nil
+}
+
+// keycloakUser is an element of the users array of a realm export
+type keycloakUser struct {
+	ID            string               `json:"id"`
+	Username      string               `json:"username"`
+	Email         string               `json:"email"`
+	EmailVerified bool                 `json:"emailVerified"`
+	Enabled       bool                 `json:"enabled"`
+	FirstName     string               `json:"firstName"`
+	LastName      string               `json:"lastName"`
+	Attributes    map[string][]string  `json:"attributes"`
+	Created       millis               `json:"createdTimestamp"`
+	Credentials   []keycloakCredential `json:"credentials"`
+	Federated     []struct {
+		Provider string `json:"identityProvider"`
+		UserID   string `json:"userId"`
+		UserName string `json:"userName"`
+	} `json:"federatedIdentities"`
+}
+
+// keycloakCredential holds a password either in the secretData and
+// credentialData JSON strings of Keycloak 12+ or in the legacy fields
+type keycloakCredential struct {
+	Type           string `json:"type"`
+	SecretData     string `json:"secretData"`
+	CredentialData string `json:"credentialData"`
+
+	HashedSaltedValue string `json:"hashedSaltedValue"`
+	Salt              string `json:"salt"`
+	HashIterations    int    `json:"hashIterations"`
+	Algorithm         string `json:"algorithm"`
+}
+
+func keycloakRecord(raw json.RawMessage) (*Record, error) {
+	var u keycloakUser
+	if err := decode(raw, &u); err != nil {
+		return nil, err
+	}
+	r := &Record{
+		ExternalID:    u.ID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Username:      u.Username,
+		DisplayName:   strings.TrimSpace(u.FirstName + " " + u.LastName),
+		PhoneNumber:   first(u.Attributes["phoneNumber"]),
+		Locale:        first(u.Attributes["locale"]),
+		Disabled:      !u.Enabled,
+		CreatedAt:     u.Created.t,
+	}
+	// Keycloak sets the username to the email address by default
+	if r.Username == strings.ToLower(r.Email) {
+		r.Username = ""
+	}
+	for _, c := range u.Credentials {
+		if c.Type == "password" {
+			r.setHash(keycloakHash(c))
+			break
+		}
+	}
+	for _, f := range u.Federated {
+		if ident, ok := identity(f.Provider, f.UserID, "", f.UserName, ""); ok {
+			r.Identities = append(r.Identities, ident)
+		} else {
+			r.warn("identity of provider %q not imported", f.Provider)
+		}
+	}
+	return r, nil
+}
+
+// keycloakHashes maps Keycloak's PBKDF2 algorithm names to password schemes
+var keycloakHashes = map[string]string{
+	"pbkdf2":        password.SCHEME_PBKDF2_SHA1,
+	"pbkdf2-sha256": password.SCHEME_PBKDF2_SHA256,
+	"pbkdf2-sha512": password.SCHEME_PBKDF2_SHA512,
+}
+
+func keycloakHash(c keycloakCredential) (string, error) {
+	value, salt, iterations, algorithm := c.HashedSaltedValue, c.Salt, c.HashIterations, c.Algorithm
+	if c.SecretData != "" {
+		var secret struct {
+			Value string `json:"value"`
+			Salt  string `json:"salt"`
+		}
+		var data struct {
+			HashIterations int    `json:"hashIterations"`
+			Algorithm      string `json:"algorithm"`
+		}
+		if err := json.Unmarshal([]byte(c.SecretData), &secret); err != nil {
+			return "", password.ErrUnsupportedHash
+		}
+		if err := json.Unmarshal([]byte(c.CredentialData), &data); err != nil {
+			return "", password.ErrUnsupportedHash
+		}
+		value, salt, iterations, algorithm = secret.Value, secret.Salt, data.HashIterations, data.Algorithm
+	}
+	scheme, ok := keycloakHashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", password.ErrUnsupportedHash, algorithm)
+	}
+	s, err1 := base64.StdEncoding.DecodeString(salt)
+	h, err2 := base64.StdEncoding.DecodeString(value)
+	if err1 != nil || err2 != nil {
+		return "", password.ErrUnsupportedHash
+	}
+	return password.PBKDF2(scheme, iterations, s, h)
+}
+
+// setHash stores a converted password hash, or a warning when the source
+// used a format brain cannot verify. Such users must reset their password.
+func (r *Record) setHash(h string, err error) {
+	if err != nil {
+		r.warn("password hash not imported: %v", err)
+		return
+	}
+	r.PasswordHash = h
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,358 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	IMPORT_JOB      = "user_imports"
+	IMPORT_INTERVAL = time.Minute
+
+	// IMPORT_BUDGET bounds one run of the job, well below the lease of two
+	// intervals. A large import continues on the next runs.
+	IMPORT_BUDGET = 45 * time.Second
+
+	// CHECKPOINT_EVERY is how many records are processed between two saves
+	// of the progress
+	CHECKPOINT_EVERY = 100
+
+	// CREATED_BY_IMPORT prefixes the new value of the "created" account event,
+	// followed by the source
+	CREATED_BY_IMPORT = "import:"
+
+	// HASH_NONE counts users imported without a usable password in the report
+	HASH_NONE = "none"
+)
+
+// errStopped is returned when an import was cancelled while it ran
+var errStopped = errors.New("import stopped")
+
+// ProcessImports works through pending imports, oldest first. An import still
+// processing was interrupted, by the time budget or a restart, and resumes
+// after its checkpoint; the scheduler lease keeps a single instance running.
+func ProcessImports(ctx context.Context) error {
+	deadline := time.Now().Add(IMPORT_BUDGET)
+	for time.Now().Before(deadline) {
+		var job schema.UserImport
+		err := importsCollection().FindOneAndUpdate(ctx,
+			bson.M{"status": bson.M{"$in": bson.A{schema.IMPORT_STATUS_PENDING, schema.IMPORT_STATUS_PROCESSING}}},
+			bson.M{"$set": bson.M{"status": schema.IMPORT_STATUS_PROCESSING, "updated_at": time.Now().UTC()}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"created_at": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&job)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		done, err := process(ctx, &job, deadline)
+		switch {
+		case errors.Is(err, errStopped):
+			continue
+		case err != nil:
+			log.Error().Err(err).Str("import_id", job.ID.Hex()).Msg("User import failed")
+			if err := finish(ctx, &job, schema.IMPORT_STATUS_FAILED, err.Error()); err != nil {
+				return err
+			}
+		case !done:
+			return nil
+		case job.DryRun:
+			// The file is kept so that the import can be run for real
+			if err := save(ctx, &job, bson.M{"status": schema.IMPORT_STATUS_REVIEWED}); err != nil && !errors.Is(err, errStopped) {
+				return err
+			}
+		default:
+			if err := finish(ctx, &job, schema.IMPORT_STATUS_COMPLETED, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// process imports the records of a job after its checkpoint until the file
+// ends or the deadline passes. done is false when time ran out.
+func process(ctx context.Context, job *schema.UserImport, deadline time.Time) (done bool, err error) {
+	f, err := filesBucket().OpenDownloadStream(ctx, job.ID)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	next, err := newReader(job.Source, f)
+	if err != nil {
+		return false, err
+	}
+	if job.Report.Hashes == nil {
+		job.Report.Hashes = map[string]int{}
+	}
+
+	for n := 0; ; n++ {
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if errors.Is(err, ErrInvalidFile) {
+			return false, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		if n < job.Checkpoint {
+			continue
+		}
+
+		if err != nil {
+			job.Report.Failed++
+			issue(job, n+1, nil, err.Error())
+		} else if err := importRecord(ctx, job, n+1, rec); err != nil {
+			return false, err
+		}
+		job.Report.Total++
+		job.Checkpoint = n + 1
+
+		if job.Checkpoint%CHECKPOINT_EVERY == 0 {
+			if err := save(ctx, job, nil); err != nil {
+				return false, err
+			}
+			if time.Now().After(deadline) {
+				return false, nil
+			}
+		}
+	}
+}
+
+// importRecord creates the user of one record, or on a dry run checks that
+// it would be created. Only database errors are returned; problems with the
+// record are counted in the report.
+func importRecord(ctx context.Context, job *schema.UserImport, n int, rec *Record) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(rec.Email))
+	if err != nil || rec.ExternalID == "" {
+		job.Report.Failed++
+		issue(job, n, rec, "missing or invalid email address or user ID")
+		return nil
+	}
+	email := strings.ToLower(addr.Address)
+
+	existing, err := users.FindOne(ctx, bson.M{"$or": bson.A{
+		bson.M{"email": email},
+		bson.M{"import.source": job.Source, "import.external_id": rec.ExternalID},
+	}})
+	switch {
+	case err == nil && existing.Import != nil && existing.Import.JobID == job.ID:
+		// Created by this import before an interruption, after the last checkpoint
+		job.Report.Created++
+		return nil
+	case err == nil:
+		job.Report.Skipped++
+		issue(job, n, rec, "a user with this email address or user ID already exists")
+		return nil
+	case !errors.Is(err, users.ErrNotFound):
+		return err
+	}
+
+	for _, w := range rec.Warnings {
+		issue(job, n, rec, w)
+	}
+	hash := rec.PasswordHash
+	scheme, ok := password.Scheme(hash)
+	if hash != "" && !ok {
+		issue(job, n, rec, "password hash not imported: "+password.ErrUnsupportedHash.Error())
+	}
+	if !ok {
+		scheme, hash = HASH_NONE, ""
+	}
+
+	u := newUser(job, rec, email, hash)
+	if job.DryRun {
+		count(job, scheme, u)
+		return nil
+	}
+	if u.Password == "" {
+		// Nobody can sign in with a password until the user resets it
+		if u.Password, err = password.RandomHash(); err != nil {
+			return err
+		}
+	}
+	if err := users.Create(ctx, u); errors.Is(err, users.ErrAlreadyExists) {
+		job.Report.Skipped++
+		issue(job, n, rec, err.Error())
+		return nil
+	} else if err != nil {
+		return err
+	}
+	count(job, scheme, u)
+
+	actor := users.Actor{Kind: users.ACTOR_KIND_ADMIN, ID: job.CreatedBy}
+	if err := history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    u.ID,
+		EventType: schema.ACCOUNT_EVENT_CREATED,
+		Field:     "account",
+		NewValue:  CREATED_BY_IMPORT + string(job.Source),
+		ChangedBy: actor.String(),
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error recording imported account")
+	}
+	if rec.Disabled {
+		if err := suspend(ctx, u, job, actor); err != nil {
+			issue(job, n, rec, "imported but not suspended: "+err.Error())
+		}
+	}
+	return nil
+}
+
+// newUser maps a record onto a new user. Verified addresses are active right
+// away; the others stay pending until the user verifies them.
+func newUser(job *schema.UserImport, rec *Record, email, hash string) *schema.User {
+	now := time.Now().UTC()
+	u := &schema.User{
+		ID:          bson.NewObjectID(),
+		CreatedAt:   now,
+		Username:    strings.TrimSpace(rec.Username),
+		DisplayName: strings.TrimSpace(rec.DisplayName),
+		Email:       email,
+		PhoneNumber: strings.TrimSpace(rec.PhoneNumber),
+		Password:    hash,
+		AvatarURL:   rec.AvatarURL,
+		Locale:      rec.Locale,
+		TimeZone:    rec.TimeZone,
+		AccountType: accountType(rec.AccountType),
+		LastLoginAt: rec.LastLoginAt,
+		Status:      schema.USER_STATUS_PENDING,
+		AuthInfo:    schema.AuthInfo{EmailVerified: rec.EmailVerified},
+		Import: &schema.ImportInfo{
+			Source:     job.Source,
+			ExternalID: rec.ExternalID,
+			JobID:      job.ID,
+			ImportedAt: now,
+		},
+	}
+	if rec.CreatedAt != nil {
+		u.CreatedAt = *rec.CreatedAt
+	}
+	if rec.EmailVerified {
+		u.Status = schema.USER_STATUS_ACTIVE
+	}
+	if u.DisplayName == "" {
+		u.DisplayName = email[:strings.IndexByte(email, '@')]
+	}
+	for _, i := range rec.Identities {
+		if u.AuthInfo.OAuthProviders == nil {
+			u.AuthInfo.OAuthProviders = map[string]schema.OAuthProvider{}
+		}
+		u.AuthInfo.OAuthProviders[i.Provider] = schema.OAuthProvider{
+			ProviderID:       i.ProviderID,
+			ProviderEmail:    i.Email,
+			ProviderUsername: i.Username,
+			ProviderAvatar:   i.AvatarURL,
+			ConnectedAt:      u.CreatedAt,
+		}
+	}
+	return u
+}
+
+// accountType keeps the account type of a record if a plan grants it
+func accountType(t string) string {
+	billing := config.GetBillingConfig()
+	if slices.ContainsFunc(billing.Plans, func(p config.PlanConfig) bool { return p.AccountType == t }) {
+		return t
+	}
+	return billing.DefaultAccountType
+}
+
+// suspend carries over an account disabled at the source. No notice is sent,
+// the user was already locked out there.
+func suspend(ctx context.Context, u *schema.User, job *schema.UserImport, actor users.Actor) error {
+	now := time.Now().UTC()
+	reason := "disabled at " + string(job.Source)
+	_, err := users.Apply(ctx, users.Transition{
+		UserID:  u.ID,
+		To:      schema.USER_STATUS_SUSPENDED,
+		Actor:   actor,
+		Reason:  reason,
+		Details: "import " + job.ID.Hex(),
+		Set: bson.M{"suspension": schema.SuspensionInfo{
+			IsSuspended:     true,
+			SuspendedAt:     &now,
+			SuspendedReason: reason,
+			SuspendedBy:     actor.String(),
+			PreviousStatus:  u.Status,
+		}},
+	})
+	return err
+}
+
+// count adds a user that was or would be created to the report
+func count(job *schema.UserImport, scheme string, u *schema.User) {
+	job.Report.Created++
+	job.Report.Hashes[scheme]++
+	job.Report.Identities += len(u.AuthInfo.OAuthProviders)
+}
+
+// issue notes a problem with a record in the report, up to MAX_IMPORT_ISSUES
+func issue(job *schema.UserImport, n int, rec *Record, message string) {
+	if len(job.Report.Issues) >= schema.MAX_IMPORT_ISSUES {
+		return
+	}
+	i := schema.ImportIssue{Record: n, Message: message}
+	if rec != nil {
+		i.ExternalID, i.Email = rec.ExternalID, rec.Email
+	}
+	job.Report.Issues = append(job.Report.Issues, i)
+}
+
+// save stores the progress of a job with the given extra fields. It returns
+// errStopped when the job was cancelled in the meantime.
+func save(ctx context.Context, job *schema.UserImport, set bson.M) error {
+	fields := bson.M{
+		"checkpoint": job.Checkpoint,
+		"report":     job.Report,
+		"updated_at": time.Now().UTC(),
+	}
+	for k, v := range set {
+		fields[k] = v
+	}
+	res, err := importsCollection().UpdateOne(ctx,
+		bson.M{"_id": job.ID, "status": schema.IMPORT_STATUS_PROCESSING},
+		bson.M{"$set": fields},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errStopped
+	}
+	return nil
+}
+
+// finish ends a job and removes its file
+func finish(ctx context.Context, job *schema.UserImport, status schema.IMPORT_STATUS, message string) error {
+	set := bson.M{"status": status, "completed_at": time.Now().UTC()}
+	if message != "" {
+		set["error"] = message
+	}
+	if err := save(ctx, job, set); err != nil {
+		if errors.Is(err, errStopped) {
+			return nil
+		}
+		return err
+	}
+	removeFile(ctx, job.ID)
+	return nil
+}
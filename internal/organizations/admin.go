@@ -0,0 +1,63 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The functions below serve the admin console. They skip the membership
+// checks; callers check the organizations permissions of the admin first.
+
+// Inspect returns an organization
+func Inspect(ctx context.Context, orgID bson.ObjectID) (*schema.Organization, error) {
+	return find(ctx, orgID)
+}
+
+// InspectMembers returns the members of an organization
+func InspectMembers(ctx context.Context, orgID bson.ObjectID) ([]Member, error) {
+	if _, err := find(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return listMembers(ctx, orgID)
+}
+
+// InspectHistory returns the membership events of an organization, newest first
+func InspectHistory(ctx context.Context, orgID bson.ObjectID, before time.Time, limit int) ([]schema.MembershipHistory, error) {
+	if _, err := find(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return events(ctx, orgID, before, limit)
+}
+
+// Expel removes a member on behalf of an admin, recorded as removed by the
+// admin. The owner cannot be removed this way either.
+func Expel(ctx context.Context, orgID, userID bson.ObjectID, actor Actor) (*schema.OrganizationMember, error) {
+	if _, err := find(ctx, orgID); err != nil {
+		return nil, err
+	}
+	m, err := membership(ctx, orgID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnknownMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Role == schema.ORGANIZATION_ROLE_OWNER {
+		return nil, ErrOwnerRole
+	}
+	res, err := members().DeleteOne(ctx, bson.M{"_id": m.ID})
+	if err != nil {
+		return nil, err
+	}
+	if res.DeletedCount == 0 {
+		return nil, ErrUnknownMember
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_REMOVED, UserID: &userID, OldRole: m.Role}, actor); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,269 @@
+package organizations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// VERIFICATION_RECORD is the DNS name, below the domain, of the TXT record
+// holding the verification token
+const VERIFICATION_RECORD = "_auth5-verification"
+
+var (
+	ErrInvalidDomain     = errors.New("invalid domain name")
+	ErrDomainExists      = errors.New("domain already added to the organization")
+	ErrUnknownDomain     = errors.New("domain not found")
+	ErrDomainNotVerified = errors.New("verification record not found")
+	ErrDomainClaimed     = errors.New("domain is verified by another organization")
+)
+
+// validDomain normalizes a domain name and checks its syntax
+func validDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	labels := strings.Split(domain, ".")
+	if len(domain) > 253 || len(labels) < 2 {
+		return "", ErrInvalidDomain
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return "", ErrInvalidDomain
+		}
+		for _, c := range l {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", ErrInvalidDomain
+			}
+		}
+	}
+	return domain, nil
+}
+
+// Domains returns the email domains of an organization to its admins
+func Domains(ctx context.Context, orgID, userID bson.ObjectID) ([]schema.OrganizationDomain, error) {
+	if _, err := authorize(ctx, orgID, userID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	cur, err := domains().Find(ctx, bson.M{"organization_id": orgID}, options.Find().SetSort(bson.M{"domain": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.OrganizationDomain{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// AddDomain claims an email domain for the organization. It has to be
+// verified with a TXT record at VERIFICATION_RECORD.<domain> containing the
+// returned verification token.
+func AddDomain(ctx context.Context, orgID bson.ObjectID, domain string, actor Actor) (*schema.OrganizationDomain, error) {
+	domain, err := validDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	d := &schema.OrganizationDomain{
+		ID:                bson.NewObjectID(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		OrganizationID:    orgID,
+		Domain:            domain,
+		VerificationToken: hex.EncodeToString(raw),
+	}
+	_, err = domains().InsertOne(ctx, d)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDomainExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_DOMAIN, Detail: "added " + domain}, actor); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// VerifyDomain looks up the TXT record of a domain and marks it verified when
+// it contains the verification token
+func VerifyDomain(ctx context.Context, orgID bson.ObjectID, domain string, actor Actor) (*schema.OrganizationDomain, error) {
+	d, err := adminDomain(ctx, orgID, domain, actor)
+	if err != nil {
+		return nil, err
+	}
+	if d.Verified {
+		return d, nil
+	}
+	records, err := net.DefaultResolver.LookupTXT(ctx, VERIFICATION_RECORD+"."+d.Domain)
+	if err != nil {
+		log.Debug().Err(err).Str("domain", d.Domain).Msg("Domain verification lookup failed")
+		return nil, ErrDomainNotVerified
+	}
+	if !slices.Contains(records, d.VerificationToken) {
+		return nil, ErrDomainNotVerified
+	}
+
+	now := time.Now().UTC()
+	var updated schema.OrganizationDomain
+	err = domains().FindOneAndUpdate(ctx,
+		bson.M{"_id": d.ID},
+		bson.M{"$set": bson.M{"verified": true, "verified_at": now, "updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDomainClaimed
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownDomain
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_DOMAIN, Detail: "verified " + d.Domain}, actor); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// SetAutoJoin lets users with a verified email address at a verified domain
+// join the organization without an invitation
+func SetAutoJoin(ctx context.Context, orgID bson.ObjectID, domain string, autoJoin bool, actor Actor) (*schema.OrganizationDomain, error) {
+	d, err := adminDomain(ctx, orgID, domain, actor)
+	if err != nil {
+		return nil, err
+	}
+	if !d.Verified {
+		return nil, ErrDomainNotVerified
+	}
+	if d.AutoJoin == autoJoin {
+		return d, nil
+	}
+	var updated schema.OrganizationDomain
+	err = domains().FindOneAndUpdate(ctx,
+		bson.M{"_id": d.ID},
+		bson.M{"$set": bson.M{"auto_join": autoJoin, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownDomain
+	}
+	if err != nil {
+		return nil, err
+	}
+	detail := "auto_join disabled for " + d.Domain
+	if autoJoin {
+		detail = "auto_join enabled for " + d.Domain
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_DOMAIN, Detail: detail}, actor); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// RemoveDomain releases an email domain. Members who joined through it stay.
+func RemoveDomain(ctx context.Context, orgID bson.ObjectID, domain string, actor Actor) error {
+	d, err := adminDomain(ctx, orgID, domain, actor)
+	if err != nil {
+		return err
+	}
+	if _, err := domains().DeleteOne(ctx, bson.M{"_id": d.ID}); err != nil {
+		return err
+	}
+	return record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_DOMAIN, Detail: "removed " + d.Domain}, actor)
+}
+
+// adminDomain returns a domain of the organization to one of its admins
+func adminDomain(ctx context.Context, orgID bson.ObjectID, domain string, actor Actor) (*schema.OrganizationDomain, error) {
+	domain, err := validDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	var d schema.OrganizationDomain
+	err = domains().FindOne(ctx, bson.M{"organization_id": orgID, "domain": domain}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownDomain
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// AutoJoin adds the user to the organization that verified the domain of
+// their email address, if it allows auto-join and has a free seat. The user's
+// email address must be verified. It returns the joined organizations.
+func AutoJoin(ctx context.Context, actor Actor) ([]Membership, error) {
+	u, err := users.FindByID(ctx, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	list := []Membership{}
+	at := strings.LastIndexByte(u.Email, '@')
+	if !u.AuthInfo.EmailVerified || at < 0 {
+		return list, nil
+	}
+	domain := strings.ToLower(u.Email[at+1:])
+
+	var d schema.OrganizationDomain
+	err = domains().FindOne(ctx, bson.M{"domain": domain, "verified": true, "auto_join": true}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o, err := find(ctx, d.OrganizationID)
+	if errors.Is(err, ErrNotFound) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := membership(ctx, o.ID, u.ID); err == nil {
+		return list, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	seats, err := seatUsage(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if !seats.Available() {
+		log.Info().Str("organization_id", o.ID.Hex()).Str("user_id", u.ID.Hex()).Msg("No seat left for domain auto-join")
+		return list, nil
+	}
+
+	m, err := addMember(ctx, o.ID, u.ID, schema.ORGANIZATION_ROLE_MEMBER, schema.MEMBERSHIP_JOIN_DOMAIN, nil)
+	if errors.Is(err, ErrAlreadyMember) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_JOINED, UserID: &u.ID, NewRole: m.Role, Detail: string(schema.MEMBERSHIP_JOIN_DOMAIN)}, actor); err != nil {
+		return nil, err
+	}
+	return append(list, Membership{Organization: *o, Role: m.Role}), nil
+}
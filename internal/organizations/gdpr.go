@@ -0,0 +1,146 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// HOLD_ORGANIZATION prevents system deletion of accounts owning an
+// organization with other members
+const HOLD_ORGANIZATION = "organization_owner"
+
+func ownsTeam(ctx context.Context, u *schema.User) (bool, error) {
+	cur, err := organizations().Find(ctx, bson.M{"owner_id": u.ID})
+	if err != nil {
+		return false, err
+	}
+	var owned []schema.Organization
+	if err := cur.All(ctx, &owned); err != nil {
+		return false, err
+	}
+	for _, o := range owned {
+		n, err := members().CountDocuments(ctx, bson.M{"organization_id": o.ID, "user_id": bson.M{"$ne": u.ID}})
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// membershipProcessor removes an erased user from their organizations.
+// Organizations they own pass to the longest-standing admin, or else member,
+// and are deleted when nobody else is left.
+type membershipProcessor struct{}
+
+func (membershipProcessor) Name() string {
+	return "organizations"
+}
+
+func (membershipProcessor) Erase(ctx context.Context, u *schema.User, dryRun bool) (string, error) {
+	cur, err := members().Find(ctx, bson.M{"user_id": u.ID})
+	if err != nil {
+		return "", err
+	}
+	var ms []schema.OrganizationMember
+	if err := cur.All(ctx, &ms); err != nil {
+		return "", err
+	}
+
+	var left, transferred, deleted int
+	for _, m := range ms {
+		if m.Role != schema.ORGANIZATION_ROLE_OWNER {
+			left++
+			if dryRun {
+				continue
+			}
+			if _, err := members().DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+				return "", err
+			}
+			if err := record(ctx, m.OrganizationID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_REMOVED, UserID: &u.ID, OldRole: m.Role, Detail: "account erased"}, Actor{}); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		o, err := find(ctx, m.OrganizationID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		heir, err := successor(ctx, o.ID, u.ID)
+		if err != nil {
+			return "", err
+		}
+		if heir == nil {
+			deleted++
+			if !dryRun {
+				if err := remove(ctx, o, Actor{}); err != nil {
+					return "", err
+				}
+			}
+			continue
+		}
+		transferred++
+		if !dryRun {
+			if err := inherit(ctx, o, u.ID, heir); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	return verb + " " + strconv.Itoa(left) + " memberships, transfer " + strconv.Itoa(transferred) + " and delete " + strconv.Itoa(deleted) + " owned organizations", nil
+}
+
+// successor returns the member who takes over an organization from its
+// owner: the oldest admin, or else the oldest member
+func successor(ctx context.Context, orgID, ownerID bson.ObjectID) (*schema.OrganizationMember, error) {
+	for _, role := range []schema.ORGANIZATION_ROLE{schema.ORGANIZATION_ROLE_ADMIN, schema.ORGANIZATION_ROLE_MEMBER} {
+		var m schema.OrganizationMember
+		err := members().FindOne(ctx,
+			bson.M{"organization_id": orgID, "role": role, "user_id": bson.M{"$ne": ownerID}},
+			options.FindOne().SetSort(bson.M{"created_at": 1}),
+		).Decode(&m)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// inherit makes heir the owner of an organization and removes the previous owner
+func inherit(ctx context.Context, o *schema.Organization, ownerID bson.ObjectID, heir *schema.OrganizationMember) error {
+	now := time.Now().UTC()
+	if _, err := organizations().UpdateOne(ctx, bson.M{"_id": o.ID}, bson.M{"$set": bson.M{"owner_id": heir.UserID, "updated_at": now}}); err != nil {
+		return err
+	}
+	if _, err := members().UpdateOne(ctx, bson.M{"_id": heir.ID}, bson.M{"$set": bson.M{"role": schema.ORGANIZATION_ROLE_OWNER, "updated_at": now}}); err != nil {
+		return err
+	}
+	if _, err := members().DeleteOne(ctx, bson.M{"organization_id": o.ID, "user_id": ownerID}); err != nil {
+		return err
+	}
+	if err := record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_OWNER_TRANSFERRED, UserID: &heir.UserID, OldRole: heir.Role, NewRole: schema.ORGANIZATION_ROLE_OWNER, Detail: "owner account erased"}, Actor{}); err != nil {
+		return err
+	}
+	return record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_REMOVED, UserID: &ownerID, OldRole: schema.ORGANIZATION_ROLE_OWNER, Detail: "account erased"}, Actor{})
+}
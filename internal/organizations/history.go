@@ -0,0 +1,58 @@
+package organizations
+
+import (
+	"context"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MAX_HISTORY_EVENTS is the most events returned by History
+const MAX_HISTORY_EVENTS = 100
+
+// record stores a membership event made by actor. A zero actor is the system.
+func record(ctx context.Context, orgID bson.ObjectID, event schema.MembershipHistory, actor Actor) error {
+	event.CreatedAt = time.Now().UTC()
+	event.OrganizationID = orgID
+	event.ChangedBy = history.SYSTEM_ACTOR
+	if !actor.UserID.IsZero() {
+		event.ChangedBy = actor.UserID.Hex()
+	}
+	event.IPAddress = actor.Meta.IPAddress
+	event.Country = actor.Meta.Country
+	event.UserAgent = actor.Meta.UserAgent
+	_, err := database.Collection(schema.COLLECTION_MEMBERSHIP_HISTORY).InsertOne(ctx, event)
+	return err
+}
+
+// History returns the membership events of an organization, newest first,
+// to its admins
+func History(ctx context.Context, orgID, userID bson.ObjectID, before time.Time, limit int) ([]schema.MembershipHistory, error) {
+	if _, err := authorize(ctx, orgID, userID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	return events(ctx, orgID, before, limit)
+}
+
+// events returns up to limit membership events before the given time, newest first
+func events(ctx context.Context, orgID bson.ObjectID, before time.Time, limit int) ([]schema.MembershipHistory, error) {
+	if limit <= 0 || limit > MAX_HISTORY_EVENTS {
+		limit = MAX_HISTORY_EVENTS
+	}
+	cur, err := database.Collection(schema.COLLECTION_MEMBERSHIP_HISTORY).Find(ctx,
+		bson.M{"organization_id": orgID, "created_at": bson.M{"$lt": before}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.MembershipHistory{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
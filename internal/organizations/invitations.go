@@ -0,0 +1,258 @@
+package organizations
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrInvalidEmail      = errors.New("invalid email address")
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	ErrUnknownInvitation = errors.New("invitation not found")
+	ErrEmailNotVerified  = errors.New("the email address of the account is not verified")
+)
+
+// pendingInvitations filters the invitations of an organization that can
+// still be accepted and therefore take a seat
+func pendingInvitations(orgID bson.ObjectID, now time.Time) bson.M {
+	return bson.M{
+		"organization_id": orgID,
+		"status":          schema.INVITATION_STATUS_PENDING,
+		"expires_at":      bson.M{"$gt": now},
+	}
+}
+
+// Invite emails an invitation to join the organization. Admins may invite
+// members, only the owner may invite admins. The invitation takes a seat
+// until it expires. Inviting the same address again replaces the pending
+// invitation with a fresh token.
+func Invite(ctx context.Context, orgID bson.ObjectID, email string, role schema.ORGANIZATION_ROLE, actor Actor) (*schema.OrganizationInvitation, error) {
+	if role != schema.ORGANIZATION_ROLE_ADMIN && role != schema.ORGANIZATION_ROLE_MEMBER {
+		return nil, ErrInvalidRole
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != strings.TrimSpace(email) {
+		return nil, ErrInvalidEmail
+	}
+	email = strings.ToLower(addr.Address)
+
+	a, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	if rank[a.Role] <= rank[role] {
+		return nil, ErrForbidden
+	}
+	o, err := find(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := users.FindByEmail(ctx, email); err == nil {
+		if _, err := membership(ctx, orgID, u.ID); err == nil {
+			return nil, ErrAlreadyMember
+		}
+	} else if !errors.Is(err, users.ErrNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var existing schema.OrganizationInvitation
+	err = invitations().FindOne(ctx, bson.M{"organization_id": orgID, "email": email, "status": schema.INVITATION_STATUS_PENDING}).Decode(&existing)
+	found := err == nil
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	// A pending invitation already holds its seat unless it has expired
+	if !found || !existing.ExpiresAt.After(now) {
+		seats, err := seatUsage(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+		if !seats.Available() {
+			return nil, ErrNoSeats
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	ttl := time.Duration(config.GetOrganizationsConfig().InvitationTTLHours) * time.Hour
+
+	var inv schema.OrganizationInvitation
+	err = invitations().FindOneAndUpdate(ctx,
+		bson.M{"organization_id": orgID, "email": email, "status": schema.INVITATION_STATUS_PENDING},
+		bson.M{
+			"$set": bson.M{
+				"updated_at": now,
+				"role":       role,
+				"token_hash": hashToken(token),
+				"expires_at": now.Add(ttl),
+				"invited_by": actor.UserID,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&inv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_INVITED, Email: email, NewRole: role}, actor); err != nil {
+		return nil, err
+	}
+	inviter, err := users.FindByID(ctx, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	err = mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       email,
+		Type:     schema.EMAIL_EVENT_ORGANIZATION,
+		Template: "organization_invitation",
+		Data: map[string]any{
+			"Organization": o.Name,
+			"Inviter":      inviter.DisplayName,
+			"Role":         role,
+			"Link":         config.GetSiteConfig().URL + "/organizations/invitations/accept?token=" + url.QueryEscape(token),
+			"Expires":      inv.ExpiresAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Invitations returns the pending invitations of an organization to its admins
+func Invitations(ctx context.Context, orgID, userID bson.ObjectID) ([]schema.OrganizationInvitation, error) {
+	if _, err := authorize(ctx, orgID, userID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	cur, err := invitations().Find(ctx, pendingInvitations(orgID, time.Now().UTC()), options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.OrganizationInvitation{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// RevokeInvitation withdraws a pending invitation and frees its seat
+func RevokeInvitation(ctx context.Context, orgID, invitationID bson.ObjectID, actor Actor) error {
+	a, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": invitationID, "organization_id": orgID, "status": schema.INVITATION_STATUS_PENDING}
+	var inv schema.OrganizationInvitation
+	err = invitations().FindOne(ctx, filter).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUnknownInvitation
+	}
+	if err != nil {
+		return err
+	}
+	if rank[a.Role] <= rank[inv.Role] {
+		return ErrForbidden
+	}
+
+	now := time.Now().UTC()
+	res, err := invitations().UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"status":      schema.INVITATION_STATUS_REVOKED,
+		"resolved_at": now,
+		"updated_at":  now,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return ErrUnknownInvitation
+	}
+	return record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_INVITATION_REVOKED, Email: inv.Email, OldRole: inv.Role}, actor)
+}
+
+// AcceptInvitation joins the organization of an invitation. The invitation
+// must be addressed to the account's primary email, which must be verified.
+func AcceptInvitation(ctx context.Context, token string, actor Actor) (*Membership, error) {
+	if token == "" {
+		return nil, ErrInvalidInvitation
+	}
+	u, err := users.FindByID(ctx, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	var inv schema.OrganizationInvitation
+	err = invitations().FindOne(ctx, bson.M{
+		"token_hash": hashToken(token),
+		"status":     schema.INVITATION_STATUS_PENDING,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Email, inv.Email) {
+		return nil, ErrInvalidInvitation
+	}
+	if !u.AuthInfo.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	o, err := find(ctx, inv.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the invitation first so a token is only ever used once
+	res, err := invitations().UpdateOne(ctx,
+		bson.M{"_id": inv.ID, "status": schema.INVITATION_STATUS_PENDING},
+		bson.M{"$set": bson.M{
+			"status":      schema.INVITATION_STATUS_ACCEPTED,
+			"accepted_by": u.ID,
+			"resolved_at": now,
+			"updated_at":  now,
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount == 0 {
+		return nil, ErrInvalidInvitation
+	}
+	invitedBy := inv.InvitedBy
+	m, err := addMember(ctx, o.ID, u.ID, inv.Role, schema.MEMBERSHIP_JOIN_INVITATION, &invitedBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_JOINED, UserID: &u.ID, NewRole: m.Role, Detail: string(schema.MEMBERSHIP_JOIN_INVITATION)}, actor); err != nil {
+		return nil, err
+	}
+	return &Membership{Organization: *o, Role: m.Role}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
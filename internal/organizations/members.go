@@ -0,0 +1,209 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrAlreadyMember = errors.New("user is already a member of the organization")
+	ErrUnknownMember = errors.New("user is not a member of the organization")
+	ErrInvalidRole   = errors.New("role must be admin or member")
+	ErrOwnerRole     = errors.New("the owner's role only changes with an ownership transfer")
+)
+
+// Member is a membership with the member's public profile
+type Member struct {
+	schema.OrganizationMember `bson:",inline"`
+	Email                     string `json:"email"`
+	DisplayName               string `json:"display_name"`
+}
+
+// addMember makes a user a member of an organization
+func addMember(ctx context.Context, orgID, userID bson.ObjectID, role schema.ORGANIZATION_ROLE, via schema.MEMBERSHIP_JOIN, invitedBy *bson.ObjectID) (*schema.OrganizationMember, error) {
+	now := time.Now().UTC()
+	m := &schema.OrganizationMember{
+		ID:             bson.NewObjectID(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		OrganizationID: orgID,
+		UserID:         userID,
+		Role:           role,
+		JoinedVia:      via,
+		InvitedBy:      invitedBy,
+	}
+	_, err := members().InsertOne(ctx, m)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Members returns the members of an organization to one of its members
+func Members(ctx context.Context, orgID, userID bson.ObjectID) ([]Member, error) {
+	if _, err := membership(ctx, orgID, userID); err != nil {
+		return nil, err
+	}
+	return listMembers(ctx, orgID)
+}
+
+// listMembers returns the members of an organization, oldest first
+func listMembers(ctx context.Context, orgID bson.ObjectID) ([]Member, error) {
+	cur, err := members().Find(ctx, bson.M{"organization_id": orgID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var ms []schema.OrganizationMember
+	if err := cur.All(ctx, &ms); err != nil {
+		return nil, err
+	}
+	list := []Member{}
+	for _, m := range ms {
+		u, err := users.FindByID(ctx, m.UserID)
+		if errors.Is(err, users.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Member{OrganizationMember: m, Email: u.Email, DisplayName: u.DisplayName})
+	}
+	return list, nil
+}
+
+// ChangeRole makes a member an admin or an admin a member. Admins manage
+// members; only the owner manages admins.
+func ChangeRole(ctx context.Context, orgID, userID bson.ObjectID, role schema.ORGANIZATION_ROLE, actor Actor) (*schema.OrganizationMember, error) {
+	if role != schema.ORGANIZATION_ROLE_ADMIN && role != schema.ORGANIZATION_ROLE_MEMBER {
+		return nil, ErrInvalidRole
+	}
+	a, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	m, err := membership(ctx, orgID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnknownMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Role == schema.ORGANIZATION_ROLE_OWNER {
+		return nil, ErrOwnerRole
+	}
+	if rank[a.Role] <= rank[m.Role] || rank[a.Role] <= rank[role] {
+		return nil, ErrForbidden
+	}
+	if m.Role == role {
+		return m, nil
+	}
+
+	var updated schema.OrganizationMember
+	err = members().FindOneAndUpdate(ctx,
+		bson.M{"_id": m.ID, "role": m.Role},
+		bson.M{"$set": bson.M{"role": role, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_ROLE_CHANGED, UserID: &userID, OldRole: m.Role, NewRole: role}, actor); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// RemoveMember removes a member from an organization, freeing their seat.
+// Members may leave on their own, except the owner, who has to transfer
+// ownership or delete the organization first.
+func RemoveMember(ctx context.Context, orgID, userID bson.ObjectID, actor Actor) error {
+	m, err := membership(ctx, orgID, userID)
+	if errors.Is(err, ErrNotFound) && userID != actor.UserID {
+		return ErrUnknownMember
+	}
+	if err != nil {
+		return err
+	}
+	if m.Role == schema.ORGANIZATION_ROLE_OWNER {
+		return ErrOwnerRole
+	}
+	event := schema.MEMBERSHIP_EVENT_LEFT
+	if userID != actor.UserID {
+		a, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN)
+		if err != nil {
+			return err
+		}
+		if rank[a.Role] <= rank[m.Role] {
+			return ErrForbidden
+		}
+		event = schema.MEMBERSHIP_EVENT_REMOVED
+	}
+
+	res, err := members().DeleteOne(ctx, bson.M{"_id": m.ID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrUnknownMember
+	}
+	return record(ctx, orgID, schema.MembershipHistory{EventType: event, UserID: &userID, OldRole: m.Role}, actor)
+}
+
+// TransferOwnership makes another member the owner. The previous owner
+// becomes an admin. Seats are billed to the new owner's subscription from
+// then on.
+func TransferOwnership(ctx context.Context, orgID, userID bson.ObjectID, actor Actor) (*schema.Organization, error) {
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_OWNER); err != nil {
+		return nil, err
+	}
+	if userID == actor.UserID {
+		return find(ctx, orgID)
+	}
+	m, err := membership(ctx, orgID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnknownMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var o schema.Organization
+	err = organizations().FindOneAndUpdate(ctx,
+		bson.M{"_id": orgID, "owner_id": actor.UserID},
+		bson.M{"$set": bson.M{"owner_id": userID, "updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&o)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := members().UpdateOne(ctx, bson.M{"_id": m.ID}, bson.M{"$set": bson.M{"role": schema.ORGANIZATION_ROLE_OWNER, "updated_at": now}}); err != nil {
+		return nil, err
+	}
+	if _, err := members().UpdateOne(ctx,
+		bson.M{"organization_id": orgID, "user_id": actor.UserID},
+		bson.M{"$set": bson.M{"role": schema.ORGANIZATION_ROLE_ADMIN, "updated_at": now}},
+	); err != nil {
+		return nil, err
+	}
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_OWNER_TRANSFERRED, UserID: &userID, OldRole: m.Role, NewRole: schema.ORGANIZATION_ROLE_OWNER}, actor); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
@@ -0,0 +1,246 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MAX_NAME_LENGTH is the longest organization name
+const MAX_NAME_LENGTH = 100
+
+var (
+	ErrOrganizationsDisabled = errors.New("organizations are not enabled")
+	ErrPlanWithoutTeams      = errors.New("the account's plan does not include organizations")
+	ErrInvalidName           = errors.New("invalid organization name")
+	ErrNotFound              = errors.New("organization not found")
+	ErrForbidden             = errors.New("not allowed in this organization")
+)
+
+func organizations() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ORGANIZATIONS)
+}
+
+func members() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ORGANIZATION_MEMBERS)
+}
+
+func invitations() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ORGANIZATION_INVITES)
+}
+
+func domains() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ORGANIZATION_DOMAINS)
+}
+
+// InitOrganizations registers the organization hooks of the GDPR workflows
+func InitOrganizations() {
+	gdpr.RegisterProcessor(membershipProcessor{})
+	gdpr.RegisterHold(HOLD_ORGANIZATION, ownsTeam)
+}
+
+// Membership is an organization with the role of one of its members
+type Membership struct {
+	Organization schema.Organization      `json:"organization"`
+	Role         schema.ORGANIZATION_ROLE `json:"role"`
+}
+
+// Actor is the member making a change, with the request details recorded in
+// MembershipHistory
+type Actor struct {
+	UserID bson.ObjectID
+	Meta   history.Meta
+}
+
+func validName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MAX_NAME_LENGTH {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// Create creates an organization owned by the user. The user's account type
+// must be one of organizations.account_types.
+func Create(ctx context.Context, name string, actor Actor) (*schema.Organization, error) {
+	cfg := config.GetOrganizationsConfig()
+	if len(cfg.AccountTypes) == 0 {
+		return nil, ErrOrganizationsDisabled
+	}
+	name, err := validName(name)
+	if err != nil {
+		return nil, err
+	}
+	u, err := users.FindByID(ctx, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(cfg.AccountTypes, u.AccountType) {
+		return nil, ErrPlanWithoutTeams
+	}
+
+	now := time.Now().UTC()
+	o := &schema.Organization{
+		ID:        bson.NewObjectID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Name:      name,
+		OwnerID:   u.ID,
+	}
+	if _, err := organizations().InsertOne(ctx, o); err != nil {
+		return nil, err
+	}
+	if _, err := addMember(ctx, o.ID, u.ID, schema.ORGANIZATION_ROLE_OWNER, schema.MEMBERSHIP_JOIN_CREATED, nil); err != nil {
+		return nil, err
+	}
+	if err := record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_CREATED, UserID: &u.ID, NewRole: schema.ORGANIZATION_ROLE_OWNER, Detail: name}, actor); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Get returns an organization to one of its members
+func Get(ctx context.Context, orgID, userID bson.ObjectID) (*Membership, error) {
+	m, err := membership(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	o, err := find(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return &Membership{Organization: *o, Role: m.Role}, nil
+}
+
+// List returns the organizations the user is a member of, oldest membership first
+func List(ctx context.Context, userID bson.ObjectID) ([]Membership, error) {
+	cur, err := members().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var ms []schema.OrganizationMember
+	if err := cur.All(ctx, &ms); err != nil {
+		return nil, err
+	}
+	list := []Membership{}
+	for _, m := range ms {
+		o, err := find(ctx, m.OrganizationID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Membership{Organization: *o, Role: m.Role})
+	}
+	return list, nil
+}
+
+// Rename changes the name of an organization. Admins and the owner may rename it.
+func Rename(ctx context.Context, orgID bson.ObjectID, name string, actor Actor) (*schema.Organization, error) {
+	name, err := validName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_ADMIN); err != nil {
+		return nil, err
+	}
+	var o schema.Organization
+	err = organizations().FindOneAndUpdate(ctx,
+		bson.M{"_id": orgID},
+		bson.M{"$set": bson.M{"name": name, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&o)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	return &o, err
+}
+
+// Delete removes an organization with its members, invitations and domains.
+// Only the owner may delete it; its history is kept.
+func Delete(ctx context.Context, orgID bson.ObjectID, actor Actor) error {
+	o, err := find(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_OWNER); err != nil {
+		return err
+	}
+	return remove(ctx, o, actor)
+}
+
+// remove deletes an organization and everything that belongs to it
+func remove(ctx context.Context, o *schema.Organization, actor Actor) error {
+	filter := bson.M{"organization_id": o.ID}
+	if _, err := members().DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	if _, err := invitations().DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	if _, err := domains().DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	if _, err := organizations().DeleteOne(ctx, bson.M{"_id": o.ID}); err != nil {
+		return err
+	}
+	return record(ctx, o.ID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_DELETED, Detail: o.Name}, actor)
+}
+
+func find(ctx context.Context, orgID bson.ObjectID) (*schema.Organization, error) {
+	var o schema.Organization
+	err := organizations().FindOne(ctx, bson.M{"_id": orgID}).Decode(&o)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// membership returns the user's membership. Non-members get ErrNotFound, so
+// organizations cannot be discovered by ID.
+func membership(ctx context.Context, orgID, userID bson.ObjectID) (*schema.OrganizationMember, error) {
+	var m schema.OrganizationMember
+	err := members().FindOne(ctx, bson.M{"organization_id": orgID, "user_id": userID}).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// rank orders roles by the rights they have
+var rank = map[schema.ORGANIZATION_ROLE]int{
+	schema.ORGANIZATION_ROLE_MEMBER: 1,
+	schema.ORGANIZATION_ROLE_ADMIN:  2,
+	schema.ORGANIZATION_ROLE_OWNER:  3,
+}
+
+// authorize returns the user's membership when their role is at least min
+func authorize(ctx context.Context, orgID, userID bson.ObjectID, min schema.ORGANIZATION_ROLE) (*schema.OrganizationMember, error) {
+	m, err := membership(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rank[m.Role] < rank[min] {
+		return nil, ErrForbidden
+	}
+	return m, nil
+}
@@ -0,0 +1,109 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/entitlements"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// SEATS_QUOTA is the plan quota granting seats without a per-seat price
+const SEATS_QUOTA = "seats"
+
+var (
+	ErrNoSeats    = errors.New("all seats of the organization are taken")
+	ErrSeatsInUse = errors.New("more seats are in use than requested")
+)
+
+// SeatUsage is the number of seats of an organization and how many are taken
+type SeatUsage struct {
+	Limit              int64 `json:"limit"` // schema.UNLIMITED_QUOTA when not limited
+	Used               int64 `json:"used"`  // Members and pending invitations
+	Members            int64 `json:"members"`
+	PendingInvitations int64 `json:"pending_invitations"`
+}
+
+// Available reports whether another member can be added
+func (s *SeatUsage) Available() bool {
+	return s.Limit == schema.UNLIMITED_QUOTA || s.Used < s.Limit
+}
+
+// seatUsage counts the seats of an organization. The limit is the larger of
+// the quantity of the owner's subscription and the owner's seats quota, so
+// per-seat plans are limited by what is billed and flat team plans by their
+// quota (or an entitlement override).
+func seatUsage(ctx context.Context, o *schema.Organization) (*SeatUsage, error) {
+	var u SeatUsage
+	s, err := billing.CurrentSubscription(ctx, o.OwnerID)
+	if err != nil && !errors.Is(err, billing.ErrNoSubscription) {
+		return nil, err
+	}
+	if s != nil && s.Entitled() {
+		u.Limit = max(s.Quantity, 1)
+	}
+	e, err := entitlements.Get(ctx, o.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if q, ok := e.Limits[SEATS_QUOTA]; ok {
+		if q == schema.UNLIMITED_QUOTA {
+			u.Limit = q
+		} else {
+			u.Limit = max(u.Limit, q)
+		}
+	}
+
+	if u.Members, err = members().CountDocuments(ctx, bson.M{"organization_id": o.ID}); err != nil {
+		return nil, err
+	}
+	if u.PendingInvitations, err = invitations().CountDocuments(ctx, pendingInvitations(o.ID, time.Now().UTC())); err != nil {
+		return nil, err
+	}
+	u.Used = u.Members + u.PendingInvitations
+	return &u, nil
+}
+
+// Seats returns the seat usage of an organization to one of its members
+func Seats(ctx context.Context, orgID, userID bson.ObjectID) (*SeatUsage, error) {
+	if _, err := membership(ctx, orgID, userID); err != nil {
+		return nil, err
+	}
+	o, err := find(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return seatUsage(ctx, o)
+}
+
+// SetSeats changes the quantity of the owner's subscription. Only the owner
+// may change it, and not below the seats in use.
+func SetSeats(ctx context.Context, orgID bson.ObjectID, seats int64, actor Actor) (*SeatUsage, error) {
+	o, err := find(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := authorize(ctx, orgID, actor.UserID, schema.ORGANIZATION_ROLE_OWNER); err != nil {
+		return nil, err
+	}
+	before, err := seatUsage(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if seats < before.Used {
+		return nil, ErrSeatsInUse
+	}
+	s, err := billing.ChangeSeats(ctx, o.OwnerID, seats, actor.Meta)
+	if err != nil {
+		return nil, err
+	}
+	detail := strconv.FormatInt(before.Limit, 10) + " -> " + strconv.FormatInt(s.Quantity, 10)
+	if err := record(ctx, orgID, schema.MembershipHistory{EventType: schema.MEMBERSHIP_EVENT_SEATS_CHANGED, Detail: detail}, actor); err != nil {
+		return nil, err
+	}
+	return seatUsage(ctx, o)
+}
@@ -0,0 +1,144 @@
+package password
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/Auth5/brain/internal/config"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Imported hash formats. Salts and hashes are standard base64.
+//
+//	$firebase-scrypt$<salt>$<hash>           parameters from provisioning.import.firebase
+//	$pbkdf2-sha256$<iterations>$<salt>$<hash> also pbkdf2-sha1 and pbkdf2-sha512
+const (
+	SCHEME_FIREBASE_SCRYPT = "firebase-scrypt"
+	SCHEME_PBKDF2_SHA1     = "pbkdf2-sha1"
+	SCHEME_PBKDF2_SHA256   = "pbkdf2-sha256"
+	SCHEME_PBKDF2_SHA512   = "pbkdf2-sha512"
+)
+
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	SCHEME_PBKDF2_SHA1:   sha1.New,
+	SCHEME_PBKDF2_SHA256: sha256.New,
+	SCHEME_PBKDF2_SHA512: sha512.New,
+}
+
+// Bcrypt checks that an imported bcrypt hash can be verified
+func Bcrypt(h string) (string, error) {
+	if _, err := bcrypt.Cost([]byte(h)); err != nil {
+		return "", ErrUnsupportedHash
+	}
+	return h, nil
+}
+
+// FirebaseScrypt encodes a Firebase password hash and salt as exported by
+// the Firebase CLI
+func FirebaseScrypt(salt, h string) (string, error) {
+	if _, err := base64.StdEncoding.DecodeString(salt); err != nil {
+		return "", ErrUnsupportedHash
+	}
+	if _, err := base64.StdEncoding.DecodeString(h); err != nil || h == "" {
+		return "", ErrUnsupportedHash
+	}
+	return "$" + SCHEME_FIREBASE_SCRYPT + "$" + salt + "$" + h, nil
+}
+
+// PBKDF2 encodes a PBKDF2 hash of one of the pbkdf2-* schemes
+func PBKDF2(scheme string, iterations int, salt, h []byte) (string, error) {
+	if _, ok := pbkdf2Hashes[scheme]; !ok || iterations < 1 || len(h) == 0 {
+		return "", ErrUnsupportedHash
+	}
+	enc := base64.StdEncoding
+	return "$" + scheme + "$" + strconv.Itoa(iterations) + "$" + enc.EncodeToString(salt) + "$" + enc.EncodeToString(h), nil
+}
+
+// Scheme names the format of a stored hash, "bcrypt" or one of the imported
+// schemes. ok is false for hashes Verify cannot check.
+func Scheme(h string) (name string, ok bool) {
+	if _, err := bcrypt.Cost([]byte(h)); err == nil {
+		return "bcrypt", true
+	}
+	parts := strings.Split(h, "$")
+	switch {
+	case len(parts) == 4 && parts[0] == "" && parts[1] == SCHEME_FIREBASE_SCRYPT:
+		return SCHEME_FIREBASE_SCRYPT, true
+	case len(parts) == 5 && parts[0] == "" && pbkdf2Hashes[parts[1]] != nil:
+		return parts[1], true
+	}
+	return "", false
+}
+
+func verifyImported(encoded, plain string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 2 || parts[0] != "" {
+		return false
+	}
+	switch scheme := parts[1]; {
+	case scheme == SCHEME_FIREBASE_SCRYPT && len(parts) == 4:
+		return verifyFirebase(parts[2], parts[3], plain)
+	case pbkdf2Hashes[scheme] != nil && len(parts) == 5:
+		return verifyPBKDF2(pbkdf2Hashes[scheme], parts[2], parts[3], parts[4], plain)
+	}
+	return false
+}
+
+// verifyFirebase implements Firebase's modified scrypt: the scrypt key of the
+// password encrypts the project's signer key with AES-256-CTR and a zero IV
+func verifyFirebase(salt64, hash64, plain string) bool {
+	cfg := config.GetProvisioningConfig().Import.Firebase
+	if cfg == nil {
+		return false
+	}
+	enc := base64.StdEncoding
+	salt, err1 := enc.DecodeString(salt64)
+	want, err2 := enc.DecodeString(hash64)
+	separator, err3 := enc.DecodeString(cfg.SaltSeparator)
+	signer, err4 := enc.DecodeString(cfg.SignerKey)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return false
+	}
+
+	key, err := scrypt.Key([]byte(plain), append(salt, separator...), 1<<cfg.MemCost, cfg.Rounds, 1, 32)
+	if err != nil {
+		return false
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return false
+	}
+	got := make([]byte, len(signer))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(got, signer)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func verifyPBKDF2(h func() hash.Hash, iterations, salt64, hash64, plain string) bool {
+	iter, err := strconv.Atoi(iterations)
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err1 := base64.StdEncoding.DecodeString(salt64)
+	want, err2 := base64.StdEncoding.DecodeString(hash64)
+	if errors.Join(err1, err2) != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(h, plain, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
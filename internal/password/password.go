@@ -0,0 +1,65 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// COST is the bcrypt work factor used for new hashes
+const COST = 12
+
+// Length limits of new passwords. bcrypt ignores everything after 72 bytes.
+const (
+	MIN_LENGTH = 8
+	MAX_LENGTH = 72
+)
+
+var ErrInvalidLength = errors.New("password must be 8 to 72 bytes long")
+
+// Validate checks a new password against the length limits
+func Validate(plain string) error {
+	if len(plain) < MIN_LENGTH || len(plain) > MAX_LENGTH {
+		return ErrInvalidLength
+	}
+	return nil
+}
+
+// Hash returns the bcrypt hash of a plain password
+func Hash(plain string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(plain), COST)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Verify reports whether plain matches the stored hash. Besides bcrypt it
+// accepts the hash formats imported from other identity providers.
+func Verify(hash, plain string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil
+	}
+	return verifyImported(hash, plain)
+}
+
+// NeedsRehash reports whether a hash should be replaced by Hash the next time
+// the password is known: imported formats and bcrypt below COST
+func NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < COST
+}
+
+// RandomHash returns the hash of a random password nobody knows, used to
+// invalidate credentials without leaving the field empty
+func RandomHash() (string, error) {
+	b := make([]byte, 48)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// bcrypt only considers the first 72 bytes, base64 of 48 bytes is 64
+	return Hash(base64.RawStdEncoding.EncodeToString(b))
+}
@@ -0,0 +1,119 @@
+package provisioning
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+)
+
+var (
+	ErrInvalidCSV  = errors.New("invalid CSV")
+	ErrTooManyRows = errors.New("too many rows")
+)
+
+// csvColumns maps the accepted CSV header names to NewUser fields. Only email
+// is required; the column order is free.
+var csvColumns = map[string]func(n *NewUser, v string){
+	"email":        func(n *NewUser, v string) { n.Email = v },
+	"display_name": func(n *NewUser, v string) { n.DisplayName = v },
+	"username":     func(n *NewUser, v string) { n.Username = v },
+	"phone_number": func(n *NewUser, v string) { n.PhoneNumber = v },
+	"account_type": func(n *NewUser, v string) { n.AccountType = v },
+	"locale":       func(n *NewUser, v string) { n.Locale = v },
+}
+
+// RowResult is the outcome of one CSV row
+type RowResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	UserID string `json:"user_id,omitempty"` // Set when the account was created
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResult reports a CSV upload row by row
+type BulkResult struct {
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []RowResult `json:"rows"`
+}
+
+// CreateFromCSV creates a pending account for every row of a CSV file with a
+// header line, as Create does for a single one. The file is parsed in full
+// before anything is created; a failing row does not stop the others.
+func CreateFromCSV(ctx context.Context, r io.Reader, actor history.AdminActor) (*BulkResult, error) {
+	rows, err := parseCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &BulkResult{Rows: make([]RowResult, 0, len(rows))}
+	for _, row := range rows {
+		rr := RowResult{Line: row.line, Email: row.user.Email}
+		u, err := Create(ctx, row.user, actor)
+		if err != nil {
+			rr.Error = err.Error()
+			res.Failed++
+		} else {
+			rr.UserID = u.ID.Hex()
+			res.Created++
+		}
+		res.Rows = append(res.Rows, rr)
+	}
+	return res, nil
+}
+
+type csvRow struct {
+	line int
+	user NewUser
+}
+
+func parseCSV(r io.Reader) ([]csvRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+	}
+	setters := make([]func(*NewUser, string), len(header))
+	hasEmail := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		set, ok := csvColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidCSV, name)
+		}
+		setters[i] = set
+		hasEmail = hasEmail || name == "email"
+	}
+	if !hasEmail {
+		return nil, fmt.Errorf("%w: missing email column", ErrInvalidCSV)
+	}
+
+	limit := config.GetProvisioningConfig().MaxImportRows
+	var rows []csvRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+		}
+		if len(rows) == limit {
+			return nil, fmt.Errorf("%w: at most %d per upload", ErrTooManyRows, limit)
+		}
+		line, _ := cr.FieldPos(0)
+		row := csvRow{line: line}
+		for i, v := range record {
+			setters[i](&row.user, strings.TrimSpace(v))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,250 @@
+package provisioning
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/organizations"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CREATED_BY_ADMIN is recorded as the new value of the "created" account event
+const CREATED_BY_ADMIN = "admin"
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrUnknownAccountType = errors.New("account type has no plan")
+	ErrInvalidSetup       = errors.New("invalid or expired setup link")
+	ErrNotPending         = errors.New("account setup is already complete")
+	ErrNotAdminCreated    = errors.New("account was not created by an admin")
+)
+
+// NewUser holds the details an admin enters for a new account
+type NewUser struct {
+	Email       string `json:"email"`
+	DisplayName string `json:"display_name"`
+	Username    string `json:"username"`
+	PhoneNumber string `json:"phone_number"`
+	AccountType string `json:"account_type"` // billing.default_account_type when empty
+	Locale      string `json:"locale"`
+}
+
+// Create adds a pending account on behalf of an admin and emails the user a
+// link to set their password
+func Create(ctx context.Context, n NewUser, actor history.AdminActor) (*schema.User, error) {
+	addr, err := mail.ParseAddress(n.Email)
+	if err != nil || addr.Address != strings.TrimSpace(n.Email) {
+		return nil, ErrInvalidEmail
+	}
+	billing := config.GetBillingConfig()
+	if n.AccountType == "" {
+		n.AccountType = billing.DefaultAccountType
+	}
+	if !slices.ContainsFunc(billing.Plans, func(p config.PlanConfig) bool { return p.AccountType == n.AccountType }) {
+		return nil, ErrUnknownAccountType
+	}
+	// Nobody can sign in with a password until the user sets one
+	hash, err := password.RandomHash()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &schema.User{
+		CreatedBy:   &actor.ID,
+		Username:    strings.TrimSpace(n.Username),
+		DisplayName: strings.TrimSpace(n.DisplayName),
+		Email:       strings.ToLower(addr.Address),
+		PhoneNumber: strings.TrimSpace(n.PhoneNumber),
+		Password:    hash,
+		Locale:      strings.TrimSpace(n.Locale),
+		AccountType: n.AccountType,
+		Status:      schema.USER_STATUS_PENDING,
+	}
+	if u.DisplayName == "" {
+		u.DisplayName = u.Email[:strings.IndexByte(u.Email, '@')]
+	}
+	if err := users.Create(ctx, u); err != nil {
+		return nil, err
+	}
+
+	if err := history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    u.ID,
+		EventType: schema.ACCOUNT_EVENT_CREATED,
+		Field:     "account",
+		NewValue:  CREATED_BY_ADMIN,
+		ChangedBy: actor.ID.Hex(),
+		IPAddress: actor.Meta.IPAddress,
+		Country:   actor.Meta.Country,
+		UserAgent: actor.Meta.UserAgent,
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error recording account creation")
+	}
+	if err := actor.Record(ctx, schema.AdminHistory{
+		UserID:    u.ID,
+		EventType: schema.ADMIN_EVENT_CREATE,
+		Action:    "account created",
+		Details:   u.Email,
+	}); err != nil {
+		return nil, err
+	}
+	return sendSetup(ctx, u, actor)
+}
+
+// Resend replaces the set-password link of a pending admin-created account
+// with a fresh one, for users whose link expired or got lost
+func Resend(ctx context.Context, userID bson.ObjectID, actor history.AdminActor) (*schema.User, error) {
+	u, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.CreatedBy == nil {
+		return nil, ErrNotAdminCreated
+	}
+	if u.Status != schema.USER_STATUS_PENDING {
+		return nil, ErrNotPending
+	}
+	return sendSetup(ctx, u, actor)
+}
+
+// sendSetup stores a new set-password token and emails its link
+func sendSetup(ctx context.Context, u *schema.User, actor history.AdminActor) (*schema.User, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	expires := time.Now().UTC().Add(time.Duration(config.GetProvisioningConfig().InvitationTTLHours) * time.Hour)
+	if err := users.Update(ctx, u.ID, bson.M{
+		"auth_info.setup_token_hash": hashToken(token),
+		"auth_info.setup_expires_at": expires,
+	}); err != nil {
+		return nil, err
+	}
+	u.AuthInfo.SetupTokenHash = hashToken(token)
+	u.AuthInfo.SetupExpiresAt = &expires
+
+	if err := actor.Record(ctx, schema.AdminHistory{
+		UserID:    u.ID,
+		EventType: schema.ADMIN_EVENT_CREATE,
+		Action:    "set-password link sent",
+		ExpiresAt: &expires,
+	}); err != nil {
+		return nil, err
+	}
+	link := config.GetSiteConfig().URL + "/account/setup?token=" + url.QueryEscape(token)
+	if err := mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_ACCOUNT_SETUP,
+		Template: "account_setup",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Link":        link,
+			"Expires":     expires,
+		},
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error sending account setup link")
+	}
+	return u, nil
+}
+
+// CompleteSetup sets the password of an admin-created account from its
+// emailed link. Opening the link proves the email address, so the account is
+// verified and activated, then joins its organization by domain if any.
+func CompleteSetup(ctx context.Context, token, plain string, meta history.Meta) (*schema.User, error) {
+	if token == "" {
+		return nil, ErrInvalidSetup
+	}
+	u, err := users.FindOne(ctx, bson.M{
+		"status":                     schema.USER_STATUS_PENDING,
+		"auth_info.setup_token_hash": hashToken(token),
+	})
+	if errors.Is(err, users.ErrNotFound) {
+		return nil, ErrInvalidSetup
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.AuthInfo.SetupExpiresAt == nil || time.Now().After(*u.AuthInfo.SetupExpiresAt) {
+		return nil, ErrInvalidSetup
+	}
+	if err := password.Validate(plain); err != nil {
+		return nil, err
+	}
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pending -> active transition requires a verified email address
+	if err := users.Update(ctx, u.ID, bson.M{"auth_info.email_verified": true}); err != nil {
+		return nil, err
+	}
+	actor := users.Actor{Kind: users.ACTOR_KIND_USER, ID: u.ID}
+	activated, err := users.Apply(ctx, users.Transition{
+		UserID: u.ID,
+		To:     schema.USER_STATUS_ACTIVE,
+		Actor:  actor,
+		Reason: "account setup",
+		Set: bson.M{
+			"password":                       hash,
+			"auth_info.last_password_change": time.Now().UTC(),
+		},
+		Unset: []string{"auth_info.setup_token_hash", "auth_info.setup_expires_at"},
+		Meta:  meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    u.ID,
+		EventType: schema.ACCOUNT_EVENT_PASSWORD_CHANGE,
+		Field:     "password",
+		OldValue:  "[REDACTED]",
+		NewValue:  "[REDACTED]",
+		ChangedBy: actor.String(),
+		IPAddress: meta.IPAddress,
+		Country:   meta.Country,
+		UserAgent: meta.UserAgent,
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error recording password set")
+	}
+	// Closes the admin's audit trail; the IP is the user's
+	if u.CreatedBy != nil {
+		admin := history.AdminActor{ID: *u.CreatedBy, Meta: meta}
+		if err := admin.Record(ctx, schema.AdminHistory{
+			UserID:    u.ID,
+			EventType: schema.ADMIN_EVENT_CREATE,
+			Action:    "setup completed by the user",
+		}); err != nil {
+			log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error recording account setup")
+		}
+	}
+	if _, err := organizations.AutoJoin(ctx, organizations.Actor{UserID: u.ID, Meta: meta}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error joining organization after account setup")
+	}
+	return activated, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
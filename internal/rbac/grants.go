@@ -0,0 +1,237 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Access is what a user may do in one scope
+type Access struct {
+	Grants      []schema.RoleGrant  `json:"grants"`      // Grants applying to the scope
+	Permissions []schema.PERMISSION `json:"permissions"` // Union of the permissions of their roles
+}
+
+// Has reports whether the access includes a permission
+func (a *Access) Has(p schema.PERMISSION) bool {
+	return slices.Contains(a.Permissions, schema.PERMISSION_ALL) || slices.Contains(a.Permissions, p)
+}
+
+// scopeFilter matches the grants of a user that apply to a scope: global
+// grants everywhere, organization grants within their organization only
+func scopeFilter(userID bson.ObjectID, orgID *bson.ObjectID) bson.M {
+	if orgID == nil {
+		return bson.M{"user_id": userID, "organization_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"user_id": userID, "$or": bson.A{
+		bson.M{"organization_id": bson.M{"$exists": false}},
+		bson.M{"organization_id": *orgID},
+	}}
+}
+
+// Resolve returns the grants and permissions of a user within a scope, nil
+// for global
+func Resolve(ctx context.Context, userID bson.ObjectID, orgID *bson.ObjectID) (*Access, error) {
+	cur, err := grants().Find(ctx, scopeFilter(userID, orgID), options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	a := &Access{Grants: []schema.RoleGrant{}, Permissions: []schema.PERMISSION{}}
+	if err := cur.All(ctx, &a.Grants); err != nil {
+		return nil, err
+	}
+	for _, g := range a.Grants {
+		role, err := FindRole(ctx, g.Role)
+		if errors.Is(err, ErrUnknownRole) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range role.Permissions {
+			if !slices.Contains(a.Permissions, p) {
+				a.Permissions = append(a.Permissions, p)
+			}
+		}
+	}
+	slices.Sort(a.Permissions)
+	return a, nil
+}
+
+// Can reports whether a user holds a permission within a scope, nil for global
+func Can(ctx context.Context, userID bson.ObjectID, p schema.PERMISSION, orgID *bson.ObjectID) (bool, error) {
+	a, err := Resolve(ctx, userID, orgID)
+	if err != nil {
+		return false, err
+	}
+	return a.Has(p), nil
+}
+
+// covers checks that an admin holds every permission of a role within a
+// scope, so nobody can hand out more than they have. The system holds all.
+func covers(ctx context.Context, admin history.AdminActor, role *schema.Role, orgID *bson.ObjectID) error {
+	if admin.ID.IsZero() {
+		return nil
+	}
+	a, err := Resolve(ctx, admin.ID, orgID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(a.Permissions, schema.PERMISSION_ALL) {
+		return nil
+	}
+	for _, p := range role.Permissions {
+		if !slices.Contains(a.Permissions, p) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+// Grants returns every grant of a user, global and per organization
+func Grants(ctx context.Context, userID bson.ObjectID) ([]schema.RoleGrant, error) {
+	cur, err := grants().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []schema.RoleGrant{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Grant gives a role to a user, globally or within an organization when
+// OrganizationID is set
+func Grant(ctx context.Context, g schema.RoleGrant, admin history.AdminActor) (*schema.RoleGrant, error) {
+	if _, err := users.FindByID(ctx, g.UserID); err != nil {
+		return nil, err
+	}
+	if g.OrganizationID != nil {
+		n, err := database.Collection(schema.COLLECTION_ORGANIZATIONS).CountDocuments(ctx, bson.M{"_id": *g.OrganizationID})
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, ErrUnknownOrganization
+		}
+	}
+	return grant(ctx, g, admin)
+}
+
+func grant(ctx context.Context, g schema.RoleGrant, admin history.AdminActor) (*schema.RoleGrant, error) {
+	role, err := FindRole(ctx, g.Role)
+	if err != nil {
+		return nil, err
+	}
+	if err := covers(ctx, admin, role, g.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	g.ID = bson.NewObjectID()
+	g.CreatedAt = time.Now().UTC()
+	g.GrantedBy = admin.ID
+	_, err = grants().InsertOne(ctx, g)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyGranted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := admin.Record(ctx, schema.AdminHistory{
+		UserID:    g.UserID,
+		EventType: schema.ADMIN_EVENT_ROLE_CHANGE,
+		Action:    "granted " + describe(g),
+		Reason:    g.Reason,
+		Details:   permissionList(role.Permissions),
+	}); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Revoke removes a grant of a user. The last global super-admin grant is kept
+// so the installation cannot lock itself out.
+func Revoke(ctx context.Context, userID, grantID bson.ObjectID, reason string, admin history.AdminActor) error {
+	var g schema.RoleGrant
+	err := grants().FindOne(ctx, bson.M{"_id": grantID, "user_id": userID}).Decode(&g)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUnknownGrant
+	}
+	if err != nil {
+		return err
+	}
+	role, err := FindRole(ctx, g.Role)
+	if err != nil && !errors.Is(err, ErrUnknownRole) {
+		return err
+	}
+	if role != nil {
+		if err := covers(ctx, admin, role, g.OrganizationID); err != nil {
+			return err
+		}
+	}
+	if g.Role == ROLE_SUPER_ADMIN && g.OrganizationID == nil {
+		n, err := grants().CountDocuments(ctx, bson.M{"role": ROLE_SUPER_ADMIN, "organization_id": bson.M{"$exists": false}})
+		if err != nil {
+			return err
+		}
+		if n <= 1 {
+			return ErrLastSuperAdmin
+		}
+	}
+
+	res, err := grants().DeleteOne(ctx, bson.M{"_id": g.ID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrUnknownGrant
+	}
+	return admin.Record(ctx, schema.AdminHistory{
+		UserID:    g.UserID,
+		EventType: schema.ADMIN_EVENT_ROLE_CHANGE,
+		Action:    "revoked " + describe(g),
+		Reason:    reason,
+	})
+}
+
+// describe names the role and scope of a grant for AdminHistory
+func describe(g schema.RoleGrant) string {
+	if g.OrganizationID == nil {
+		return g.Role
+	}
+	return g.Role + " in organization " + g.OrganizationID.Hex()
+}
+
+// grantProcessor removes the grants of an erased user
+type grantProcessor struct{}
+
+func (grantProcessor) Name() string {
+	return "rbac"
+}
+
+func (grantProcessor) Erase(ctx context.Context, u *schema.User, dryRun bool) (string, error) {
+	filter := bson.M{"user_id": u.ID}
+	if dryRun {
+		n, err := grants().CountDocuments(ctx, filter)
+		if err != nil {
+			return "", err
+		}
+		return "would revoke " + strconv.FormatInt(n, 10) + " role grants", nil
+	}
+	res, err := grants().DeleteMany(ctx, filter)
+	if err != nil {
+		return "", err
+	}
+	return "revoked " + strconv.FormatInt(res.DeletedCount, 10) + " role grants", nil
+}
@@ -0,0 +1,222 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Built-in roles
+const (
+	ROLE_SUPER_ADMIN   = "super-admin"
+	ROLE_SUPPORT       = "support"
+	ROLE_BILLING_ADMIN = "billing-admin"
+)
+
+var builtIn = map[string]schema.Role{
+	ROLE_SUPER_ADMIN: {
+		Name:        ROLE_SUPER_ADMIN,
+		Description: "Full access, including roles",
+		Permissions: []schema.PERMISSION{schema.PERMISSION_ALL},
+		BuiltIn:     true,
+	},
+	ROLE_SUPPORT: {
+		Name:        ROLE_SUPPORT,
+		Description: "Customer support: look up and unblock accounts",
+		Permissions: []schema.PERMISSION{
+			schema.PERMISSION_USERS_READ,
+			schema.PERMISSION_USERS_SUSPEND,
+			schema.PERMISSION_USERS_CREDENTIALS,
+			schema.PERMISSION_USERS_IMPERSONATE,
+			schema.PERMISSION_BILLING_READ,
+			schema.PERMISSION_ORGANIZATIONS_READ,
+		},
+		BuiltIn: true,
+	},
+	ROLE_BILLING_ADMIN: {
+		Name:        ROLE_BILLING_ADMIN,
+		Description: "Subscriptions, credits and entitlements",
+		Permissions: []schema.PERMISSION{
+			schema.PERMISSION_USERS_READ,
+			schema.PERMISSION_BILLING_READ,
+			schema.PERMISSION_BILLING_WRITE,
+			schema.PERMISSION_ORGANIZATIONS_READ,
+		},
+		BuiltIn: true,
+	},
+}
+
+var validRoleName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+var (
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrUnknownRole         = errors.New("role not found")
+	ErrBuiltInRole         = errors.New("built-in roles cannot be changed")
+	ErrRoleInUse           = errors.New("role is still granted")
+	ErrForbidden           = errors.New("permission denied")
+	ErrUnknownGrant        = errors.New("grant not found")
+	ErrAlreadyGranted      = errors.New("role already granted")
+	ErrLastSuperAdmin      = errors.New("the last global super-admin cannot be revoked")
+	ErrUnknownOrganization = errors.New("organization not found")
+)
+
+func roles() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ROLES)
+}
+
+func grants() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_ROLE_GRANTS)
+}
+
+// InitRBAC grants super-admin to the accounts in rbac.super_admins that have
+// no global super-admin grant yet, so a fresh installation has an admin
+func InitRBAC() {
+	gdpr.RegisterProcessor(grantProcessor{})
+
+	ctx := context.Background()
+	for _, email := range config.GetRBACConfig().SuperAdmins {
+		u, err := users.FindByEmail(ctx, email)
+		if errors.Is(err, users.ErrNotFound) {
+			log.Warn().Str("email", email).Msg("Configured super-admin has no account")
+			continue
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error loading configured super-admin")
+		}
+		_, err = grant(ctx, schema.RoleGrant{UserID: u.ID, Role: ROLE_SUPER_ADMIN, Reason: "rbac.super_admins"}, history.AdminActor{})
+		if err != nil && !errors.Is(err, ErrAlreadyGranted) {
+			log.Fatal().Err(err).Msg("Error granting configured super-admin")
+		}
+	}
+}
+
+// Roles returns the built-in roles followed by the custom roles, by name
+func Roles(ctx context.Context) ([]schema.Role, error) {
+	list := make([]schema.Role, 0, len(builtIn))
+	for _, r := range builtIn {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	cur, err := roles().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var custom []schema.Role
+	if err := cur.All(ctx, &custom); err != nil {
+		return nil, err
+	}
+	return append(list, custom...), nil
+}
+
+// FindRole returns a built-in or custom role by name
+func FindRole(ctx context.Context, name string) (*schema.Role, error) {
+	if r, ok := builtIn[name]; ok {
+		return &r, nil
+	}
+	var r schema.Role
+	err := roles().FindOne(ctx, bson.M{"name": name}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownRole
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SaveRole creates or replaces a custom role. An admin can only define roles
+// with permissions they hold globally.
+func SaveRole(ctx context.Context, role schema.Role, admin history.AdminActor) (*schema.Role, error) {
+	if !validRoleName.MatchString(role.Name) || len(role.Permissions) == 0 {
+		return nil, ErrInvalidRole
+	}
+	if _, ok := builtIn[role.Name]; ok {
+		return nil, ErrBuiltInRole
+	}
+	for _, p := range role.Permissions {
+		if !slices.Contains(schema.Permissions, p) {
+			return nil, ErrInvalidRole
+		}
+	}
+	if err := covers(ctx, admin, &role, nil); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var saved schema.Role
+	err := roles().FindOneAndUpdate(ctx,
+		bson.M{"name": role.Name},
+		bson.M{
+			"$set":         bson.M{"description": role.Description, "permissions": role.Permissions, "updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&saved)
+	if err != nil {
+		return nil, err
+	}
+	if err := admin.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_ROLE_CHANGE,
+		Action:    "role " + role.Name + " defined",
+		Details:   permissionList(role.Permissions),
+	}); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteRole removes a custom role that is no longer granted
+func DeleteRole(ctx context.Context, name string, admin history.AdminActor) error {
+	if _, ok := builtIn[name]; ok {
+		return ErrBuiltInRole
+	}
+	role, err := FindRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := covers(ctx, admin, role, nil); err != nil {
+		return err
+	}
+	n, err := grants().CountDocuments(ctx, bson.M{"role": name})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrRoleInUse
+	}
+	res, err := roles().DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrUnknownRole
+	}
+	return admin.Record(ctx, schema.AdminHistory{
+		EventType: schema.ADMIN_EVENT_ROLE_CHANGE,
+		Action:    "role " + name + " deleted",
+	})
+}
+
+func permissionList(perms []schema.PERMISSION) string {
+	s := make([]string, len(perms))
+	for i, p := range perms {
+		s[i] = string(p)
+	}
+	return strings.Join(s, " ")
+}
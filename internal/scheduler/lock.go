@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// instanceID uniquely identifies this process as a lock owner
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// acquire takes or renews the named lease. It returns false when another
+// instance holds an unexpired lease.
+func acquire(ctx context.Context, name string, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	_, err := database.Collection(schema.COLLECTION_LOCKS).UpdateOne(ctx,
+		bson.M{
+			"_id": name,
+			"$or": bson.A{
+				bson.M{"owner": instanceID},
+				bson.M{"expires_at": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"owner": instanceID, "expires_at": now.Add(lease)}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	// The upsert collides on _id when the lock exists and is held by someone else
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Job is a unit of background work run by the scheduler
+type Job func(ctx context.Context) error
+
+// Every runs job at the given interval until ctx is done. Only the instance
+// holding the job's lease runs it, so it is safe to start on every replica.
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	// The lease outlives one interval so a slow run does not hand over leadership
+	lease := 2 * interval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			run(ctx, name, lease, job)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Info().Str("job", name).Dur("interval", interval).Msg("Scheduled background job")
+}
+
+func run(ctx context.Context, name string, lease time.Duration, job Job) {
+	ok, err := acquire(ctx, name, lease)
+	if err != nil {
+		log.Error().Err(err).Str("job", name).Msg("Error acquiring job lock")
+		return
+	}
+	if !ok {
+		return
+	}
+	if err := job(ctx); err != nil {
+		log.Error().Err(err).Str("job", name).Msg("Background job failed")
+	}
+}
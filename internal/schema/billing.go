@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_BILLING_CUSTOMERS = "billing_customers"
+	COLLECTION_SUBSCRIPTIONS     = "subscriptions"
+	COLLECTION_BILLING_DETAILS   = "billing_details"
+)
+
+type SUBSCRIPTION_STATUS string
+
+// Subscription statuses. They follow Stripe's, other gateways map onto them.
+const (
+	SUBSCRIPTION_STATUS_INCOMPLETE         SUBSCRIPTION_STATUS = "incomplete"         // Waiting for the first payment
+	SUBSCRIPTION_STATUS_INCOMPLETE_EXPIRED SUBSCRIPTION_STATUS = "incomplete_expired" // First payment never completed
+	SUBSCRIPTION_STATUS_TRIALING           SUBSCRIPTION_STATUS = "trialing"           // In free trial
+	SUBSCRIPTION_STATUS_ACTIVE             SUBSCRIPTION_STATUS = "active"             // Paid and current
+	SUBSCRIPTION_STATUS_PAST_DUE           SUBSCRIPTION_STATUS = "past_due"           // Renewal payment failed, retrying
+	SUBSCRIPTION_STATUS_UNPAID             SUBSCRIPTION_STATUS = "unpaid"             // Retries exhausted
+	SUBSCRIPTION_STATUS_CANCELED           SUBSCRIPTION_STATUS = "canceled"           // Ended
+	SUBSCRIPTION_STATUS_PAUSED             SUBSCRIPTION_STATUS = "paused"             // Paused
+)
+
+// BillingCustomer links a User to a payment gateway's customer record, one per gateway
+type BillingCustomer struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID     bson.ObjectID `bson:"user_id" json:"user_id"` // Reference to User model
+	Gateway    string        `bson:"gateway" json:"gateway"` // Payment gateway (stripe, paypal)
+	ExternalID string        `bson:"external_id" json:"-"`   // Customer ID at the gateway
+}
+
+// Subscription is the local copy of a subscription at a payment gateway
+type Subscription struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID             bson.ObjectID         `bson:"user_id" json:"user_id"`           // Reference to User model
+	Gateway            string                `bson:"gateway" json:"gateway"`           // Payment gateway (stripe, paypal)
+	ExternalID         string                `bson:"external_id" json:"-"`             // Subscription ID at the gateway
+	PriceID            string                `bson:"price_id" json:"-"`                // Price or plan ID at the gateway
+	AccountType        string                `bson:"account_type" json:"account_type"` // User.AccountType granted by the plan
+	PlanVersion        int                   `bson:"plan_version" json:"plan_version"` // Catalog plan version the subscriber is on
+	Currency           string                `bson:"currency" json:"currency"`         // Currency of the price
+	Interval           PLAN_INTERVAL         `bson:"interval" json:"interval"`         // Billing interval of the price
+	Quantity           int64                 `bson:"quantity" json:"quantity"`         // Seats billed, 1 unless the plan is priced per seat
+	Status             SUBSCRIPTION_STATUS   `bson:"status" json:"status"`             // Current status
+	CurrentPeriodStart *time.Time            `bson:"current_period_start,omitempty" json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time            `bson:"current_period_end,omitempty" json:"current_period_end,omitempty"`
+	TrialEnd           *time.Time            `bson:"trial_end,omitempty" json:"trial_end,omitempty"`
+	CancelAtPeriodEnd  bool                  `bson:"cancel_at_period_end" json:"cancel_at_period_end"`       // Cancellation scheduled for the period end
+	CanceledAt         *time.Time            `bson:"canceled_at,omitempty" json:"canceled_at,omitempty"`     // When the subscription was canceled
+	TaxCountry         string                `bson:"tax_country,omitempty" json:"tax_country,omitempty"`     // Customer country determined at checkout
+	GraceEndsAt        *time.Time            `bson:"grace_ends_at,omitempty" json:"grace_ends_at,omitempty"` // Set while a failed payment is being recovered, when the plan is lost
+	Discount           *SubscriptionDiscount `bson:"discount,omitempty" json:"discount,omitempty"`           // Coupon redeemed at checkout
+}
+
+// Entitled reports whether the subscription currently grants its account type
+func (s *Subscription) Entitled() bool {
+	switch s.Status {
+	case SUBSCRIPTION_STATUS_TRIALING, SUBSCRIPTION_STATUS_ACTIVE, SUBSCRIPTION_STATUS_PAST_DUE:
+		return true
+	}
+	return false
+}
+
+// BillingDetails is the billing address of a User, printed on invoices and
+// used to determine VAT
+type BillingDetails struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID  bson.ObjectID `bson:"user_id" json:"-"`                         // Reference to User model
+	Name    string        `bson:"name" json:"name"`                         // Person or company name
+	Address []string      `bson:"address" json:"address"`                   // Address lines
+	Country string        `bson:"country" json:"country"`                   // ISO 3166-1 alpha-2
+	VATID   string        `bson:"vat_id,omitempty" json:"vat_id,omitempty"` // Normalized EU VAT ID of a business
+}
@@ -0,0 +1,138 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_COUPONS            = "coupons"
+	COLLECTION_PROMOTION_CODES    = "promotion_codes"
+	COLLECTION_COUPON_REDEMPTIONS = "coupon_redemptions"
+	COLLECTION_REFERRAL_CODES     = "referral_codes"
+	COLLECTION_REFERRALS          = "referrals"
+)
+
+type COUPON_DURATION string
+
+// How long a coupon discounts a subscription
+const (
+	COUPON_DURATION_ONCE      COUPON_DURATION = "once"      // First invoice only
+	COUPON_DURATION_REPEATING COUPON_DURATION = "repeating" // Invoices of the first DurationMonths months
+	COUPON_DURATION_FOREVER   COUPON_DURATION = "forever"   // Every invoice
+)
+
+type REDEMPTION_STATUS string
+
+const (
+	REDEMPTION_STATUS_RESERVED REDEMPTION_STATUS = "reserved" // Counted while the subscription is being created
+	REDEMPTION_STATUS_APPLIED  REDEMPTION_STATUS = "applied"  // Applied to a subscription
+	REDEMPTION_STATUS_RELEASED REDEMPTION_STATUS = "released" // Subscription creation failed, no longer counted
+)
+
+type REFERRAL_STATUS string
+
+const (
+	REFERRAL_STATUS_PENDING   REFERRAL_STATUS = "pending"   // Waiting for the referee's first payment
+	REFERRAL_STATUS_QUALIFIED REFERRAL_STATUS = "qualified" // Referee paid, credits not yet applied to both parties
+	REFERRAL_STATUS_CREDITED  REFERRAL_STATUS = "credited"  // Both parties were credited
+)
+
+// Coupon is a discount seeded from the config. Its terms cannot change at a
+// gateway, so changed terms get a new gateway coupon; subscriptions keep the
+// discount they redeemed.
+type Coupon struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"-"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"-"`
+
+	Key               string          `bson:"key" json:"id"`                                              // Coupon ID from the config
+	Name              string          `bson:"name" json:"name"`                                           // Shown to customers
+	PercentOff        int             `bson:"percent_off,omitempty" json:"percent_off,omitempty"`         // Percentage discount (1-100)
+	AmountOff         int64           `bson:"amount_off,omitempty" json:"amount_off,omitempty"`           // Fixed discount in minor units
+	Currency          string          `bson:"currency,omitempty" json:"currency,omitempty"`               // Currency of AmountOff
+	Duration          COUPON_DURATION `bson:"duration" json:"duration"`                                   // How long the discount applies
+	DurationMonths    int             `bson:"duration_months,omitempty" json:"duration_months,omitempty"` // Months of a repeating discount
+	MaxRedemptions    int             `bson:"max_redemptions" json:"-"`                                   // 0 for unlimited
+	Redemptions       int             `bson:"redemptions" json:"-"`                                       // Redemptions counted against the limit
+	ExpiresAt         *time.Time      `bson:"expires_at,omitempty" json:"expires_at,omitempty"`           // Last moment it can be redeemed
+	AccountTypes      []string        `bson:"account_types,omitempty" json:"account_types,omitempty"`     // Plans it applies to, all when empty
+	FirstPurchaseOnly bool            `bson:"first_purchase_only" json:"first_purchase_only"`             // Only for users who never subscribed
+	Active            bool            `bson:"active" json:"-"`                                            // Still in the config
+	Fingerprint       string          `bson:"fingerprint" json:"-"`                                       // Hash of the discount terms
+	StripeCouponID    string          `bson:"stripe_coupon_id,omitempty" json:"-"`                        // Coupon synced to Stripe for the current terms
+}
+
+// PromotionCode is a customer-facing code that redeems a coupon, with its
+// own limits on top of the coupon's
+type PromotionCode struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"-"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"-"`
+
+	Code              string     `bson:"code" json:"code"`                                 // Uppercase code entered at checkout
+	CouponKey         string     `bson:"coupon_key" json:"coupon"`                         // Coupon it redeems
+	MaxRedemptions    int        `bson:"max_redemptions" json:"-"`                         // 0 for unlimited
+	Redemptions       int        `bson:"redemptions" json:"-"`                             // Redemptions counted against the limit
+	ExpiresAt         *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Last moment it can be redeemed
+	FirstPurchaseOnly bool       `bson:"first_purchase_only" json:"first_purchase_only"`   // Only for users who never subscribed
+	Active            bool       `bson:"active" json:"-"`                                  // Still in the config
+}
+
+// CouponRedemption records the use of a promotion code by a user. A user
+// redeems each coupon once.
+type CouponRedemption struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID         bson.ObjectID     `bson:"user_id" json:"user_id"`                                     // Reference to User model
+	CouponKey      string            `bson:"coupon_key" json:"coupon"`                                   // Coupon redeemed
+	Code           string            `bson:"code" json:"code"`                                           // Promotion code entered
+	SubscriptionID *bson.ObjectID    `bson:"subscription_id,omitempty" json:"subscription_id,omitempty"` // Subscription discounted
+	Gateway        string            `bson:"gateway" json:"gateway"`                                     // Gateway of the subscription
+	Status         REDEMPTION_STATUS `bson:"status" json:"status"`                                       // Current status
+	IPAddress      string            `bson:"ip_address,omitempty" json:"ip_address,omitempty"`           // IP address of the checkout
+}
+
+// SubscriptionDiscount is the coupon applied to a subscription
+type SubscriptionDiscount struct {
+	CouponKey      string          `bson:"coupon_key" json:"coupon"`
+	Code           string          `bson:"code" json:"code"`
+	Name           string          `bson:"name" json:"name"`
+	PercentOff     int             `bson:"percent_off,omitempty" json:"percent_off,omitempty"`
+	AmountOff      int64           `bson:"amount_off,omitempty" json:"amount_off,omitempty"`
+	Currency       string          `bson:"currency,omitempty" json:"currency,omitempty"`
+	Duration       COUPON_DURATION `bson:"duration" json:"duration"`
+	DurationMonths int             `bson:"duration_months,omitempty" json:"duration_months,omitempty"`
+	Start          time.Time       `bson:"start" json:"start"`
+}
+
+// ReferralCode is the code a user shares to refer others
+type ReferralCode struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	UserID bson.ObjectID `bson:"user_id" json:"-"` // Reference to User model
+	Code   string        `bson:"code" json:"code"` // Uppercase code
+}
+
+// Referral links a referred user to their referrer. Both are credited to
+// their customer balance when the referee's first payment is collected.
+type Referral struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	ReferrerID       bson.ObjectID   `bson:"referrer_id" json:"-"`                                           // User who shared the code
+	RefereeID        bson.ObjectID   `bson:"referee_id" json:"-"`                                            // User who used the code
+	Code             string          `bson:"code" json:"code"`                                               // Referral code used
+	Status           REFERRAL_STATUS `bson:"status" json:"status"`                                           // Current status
+	Currency         string          `bson:"currency,omitempty" json:"currency,omitempty"`                   // Currency of the referee's first payment
+	ReferrerCredit   int64           `bson:"referrer_credit,omitempty" json:"referrer_credit,omitempty"`     // Credit granted to the referrer
+	RefereeCredit    int64           `bson:"referee_credit,omitempty" json:"referee_credit,omitempty"`       // Credit granted to the referee
+	ReferrerCredited *time.Time      `bson:"referrer_credited,omitempty" json:"referrer_credited,omitempty"` // When the referrer was credited
+	RefereeCredited  *time.Time      `bson:"referee_credited,omitempty" json:"referee_credited,omitempty"`   // When the referee was credited
+	LastError        string          `bson:"last_error,omitempty" json:"-"`                                  // Last error crediting a party
+}
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const COLLECTION_CRYPTO_SUBSCRIPTIONS = "crypto_subscriptions"
+
+type CRYPTO_INVOICE_STATUS string
+
+// Crypto invoice statuses, as reported by the payment processor
+const (
+	CRYPTO_INVOICE_STATUS_NEW        CRYPTO_INVOICE_STATUS = "new"        // Waiting for payment at the quoted rate
+	CRYPTO_INVOICE_STATUS_PROCESSING CRYPTO_INVOICE_STATUS = "processing" // Paid, waiting for confirmations
+	CRYPTO_INVOICE_STATUS_SETTLED    CRYPTO_INVOICE_STATUS = "settled"    // Paid and confirmed
+	CRYPTO_INVOICE_STATUS_EXPIRED    CRYPTO_INVOICE_STATUS = "expired"    // Quote expired before it was (fully) paid
+	CRYPTO_INVOICE_STATUS_INVALID    CRYPTO_INVOICE_STATUS = "invalid"    // Payment failed to confirm, or invoice withdrawn
+)
+
+// CryptoSubscription is a subscription paid in cryptocurrency. Crypto
+// processors cannot charge a customer, so brain keeps the subscription
+// itself and asks the customer to pay an invoice for each period.
+type CryptoSubscription struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+	Revision  int           `bson:"revision" json:"-"` // Incremented on every write, for optimistic locking
+
+	UserID             bson.ObjectID       `bson:"user_id" json:"user_id"`                             // Reference to User model
+	CustomerID         string              `bson:"customer_id" json:"-"`                               // BillingCustomer.ExternalID
+	IdempotencyKey     string              `bson:"idempotency_key" json:"-"`                           // Makes a retried creation return the same subscription
+	PriceID            string              `bson:"price_id" json:"-"`                                  // Catalog price of the current period
+	NextPriceID        string              `bson:"next_price_id,omitempty" json:"-"`                   // Catalog price charged from the next period on, if changed
+	TaxCountry         string              `bson:"tax_country,omitempty" json:"tax_country,omitempty"` // Customer country determined at checkout
+	Status             SUBSCRIPTION_STATUS `bson:"status" json:"status"`                               // Current status
+	Period             int                 `bson:"period" json:"period"`                               // Number of paid periods
+	CurrentPeriodStart *time.Time          `bson:"current_period_start,omitempty" json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time          `bson:"current_period_end,omitempty" json:"current_period_end,omitempty"`
+	TrialEnd           *time.Time          `bson:"trial_end,omitempty" json:"trial_end,omitempty"`
+	CancelAtPeriodEnd  bool                `bson:"cancel_at_period_end" json:"cancel_at_period_end"`
+	CanceledAt         *time.Time          `bson:"canceled_at,omitempty" json:"canceled_at,omitempty"`
+	RenewalNoticeFor   int                 `bson:"renewal_notice_for" json:"-"` // Period whose payment reminder was emailed
+	Invoices           []CryptoInvoice     `bson:"invoices" json:"invoices"`    // Invoices at the processor, oldest first
+}
+
+// CryptoInvoice is an invoice at the crypto payment processor. Each pays one
+// period; when a quote expires partly paid, the amount received is credited
+// to the next invoice for the same period.
+type CryptoInvoice struct {
+	ExternalID   string                `bson:"external_id" json:"id"`                            // Invoice ID at the processor
+	Period       int                   `bson:"period" json:"period"`                             // Period the invoice pays, CryptoSubscription.Period + 1 when created
+	PriceID      string                `bson:"price_id" json:"-"`                                // Catalog price the period is paid with
+	Interval     PLAN_INTERVAL         `bson:"interval" json:"interval"`                         // Length of the period
+	Amount       int64                 `bson:"amount" json:"amount"`                             // Amount due in minor units
+	Credit       int64                 `bson:"credit" json:"credit"`                             // Received on earlier invoices for the period
+	Currency     string                `bson:"currency" json:"currency"`                         // ISO 4217 code, lowercase
+	Paid         int64                 `bson:"paid" json:"paid"`                                 // Received in minor units, at the invoice's rate
+	Status       CRYPTO_INVOICE_STATUS `bson:"status" json:"status"`                             // Current status
+	Overpaid     bool                  `bson:"overpaid" json:"overpaid"`                         // More than Amount was received
+	CheckoutLink string                `bson:"checkout_link" json:"checkout_link"`               // Payment page at the processor
+	ExpiresAt    time.Time             `bson:"expires_at" json:"expires_at"`                     // End of the quote
+	SettledAt    *time.Time            `bson:"settled_at,omitempty" json:"settled_at,omitempty"` // When the payment was confirmed
+	Notified     bool                  `bson:"notified" json:"-"`                                // Partial payment or refund emailed to the customer
+	Refund       string                `bson:"refund,omitempty" json:"refund,omitempty"`         // Overpayment refund state, see CRYPTO_REFUND_*
+	RefundLink   string                `bson:"refund_link,omitempty" json:"refund_link,omitempty"`
+	Unapplied    bool                  `bson:"unapplied,omitempty" json:"unapplied,omitempty"` // Settled for a period that was already paid or canceled, to be refunded by hand
+}
+
+// Overpayment refund states
+const (
+	CRYPTO_REFUND_PENDING = "pending" // Being created at the processor
+	CRYPTO_REFUND_CREATED = "created" // Claimable at RefundLink
+)
+
+// Open reports whether the invoice can still be paid or is being confirmed
+func (i *CryptoInvoice) Open() bool {
+	return i.Status == CRYPTO_INVOICE_STATUS_NEW || i.Status == CRYPTO_INVOICE_STATUS_PROCESSING
+}
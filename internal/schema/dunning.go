@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const COLLECTION_DUNNING_CASES = "dunning_cases"
+
+type DUNNING_STATUS string
+
+const (
+	DUNNING_STATUS_OPEN      DUNNING_STATUS = "open"      // Retrying the payment, subscription in grace
+	DUNNING_STATUS_RECOVERED DUNNING_STATUS = "recovered" // The outstanding payment was collected
+	DUNNING_STATUS_EXHAUSTED DUNNING_STATUS = "exhausted" // Grace ended, the final action was taken
+	DUNNING_STATUS_CLOSED    DUNNING_STATUS = "closed"    // The subscription ended otherwise
+)
+
+type DUNNING_STEP string
+
+// Dunning steps, recorded in AccountHistory as they happen
+const (
+	DUNNING_STEP_PAYMENT_FAILED DUNNING_STEP = "payment_failed" // Renewal payment failed, grace started
+	DUNNING_STEP_RETRY_FAILED   DUNNING_STEP = "retry_failed"   // A scheduled retry failed
+	DUNNING_STEP_RECOVERED      DUNNING_STEP = "recovered"      // Payment collected, grace ended
+	DUNNING_STEP_DOWNGRADED     DUNNING_STEP = "downgraded"     // Subscription canceled after grace
+	DUNNING_STEP_SUSPENDED      DUNNING_STEP = "suspended"      // Subscription canceled and account suspended after grace
+	DUNNING_STEP_CLOSED         DUNNING_STEP = "closed"         // Subscription ended during grace
+)
+
+// DunningCase tracks the recovery of a failed subscription payment, one open
+// case per subscription
+type DunningCase struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID         bson.ObjectID  `bson:"user_id" json:"user_id"`                                 // Reference to User model
+	SubscriptionID bson.ObjectID  `bson:"subscription_id" json:"subscription_id"`                 // Reference to Subscription model
+	Gateway        string         `bson:"gateway" json:"gateway"`                                 // Payment gateway of the subscription
+	ExternalID     string         `bson:"external_id" json:"-"`                                   // Subscription ID at the gateway
+	InvoiceID      string         `bson:"invoice_id,omitempty" json:"-"`                          // Gateway invoice whose payment failed
+	Status         DUNNING_STATUS `bson:"status" json:"status"`                                   // Current status
+	Step           DUNNING_STEP   `bson:"step" json:"step"`                                       // Last step taken
+	FailedAt       time.Time      `bson:"failed_at" json:"failed_at"`                             // First failed payment
+	Attempts       int            `bson:"attempts" json:"attempts"`                               // Retries made
+	NextRetryAt    *time.Time     `bson:"next_retry_at,omitempty" json:"next_retry_at,omitempty"` // Unset when no retries are left
+	GraceEndsAt    time.Time      `bson:"grace_ends_at" json:"grace_ends_at"`                     // When the final action is taken
+	LastError      string         `bson:"last_error,omitempty" json:"last_error,omitempty"`       // Error of the last failed retry
+	ResolvedAt     *time.Time     `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
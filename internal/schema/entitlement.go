@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_ENTITLEMENT_OVERRIDES = "entitlement_overrides"
+)
+
+type ENTITLEMENT_KIND string
+
+const (
+	ENTITLEMENT_KIND_FEATURE ENTITLEMENT_KIND = "feature" // Grants or revokes a plan feature
+	ENTITLEMENT_KIND_LIMIT   ENTITLEMENT_KIND = "limit"   // Replaces a plan quota
+)
+
+// UNLIMITED_QUOTA is the limit of an override lifting a quota
+const UNLIMITED_QUOTA int64 = -1
+
+// EntitlementOverride changes one feature or limit of a user's plan, e.g. a
+// beta feature for a single customer or a raised quota negotiated by sales.
+// There is at most one override per user, kind and key.
+type EntitlementOverride struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID    bson.ObjectID    `bson:"user_id" json:"user_id"`                           // Reference to User model
+	Kind      ENTITLEMENT_KIND `bson:"kind" json:"kind"`                                 // Feature or limit
+	Key       string           `bson:"key" json:"key"`                                   // Feature or quota name
+	Enabled   bool             `bson:"enabled" json:"enabled"`                           // Features: granted when true, revoked when false
+	Limit     int64            `bson:"limit" json:"limit"`                               // Limits: the quota, UNLIMITED_QUOTA lifts it
+	ExpiresAt *time.Time       `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // The plan applies again afterwards, removed by a TTL index
+	Reason    string           `bson:"reason" json:"reason"`                             // Why the override was made
+	CreatedBy string           `bson:"created_by" json:"created_by"`                     // Who made the override (user_id or system)
+}
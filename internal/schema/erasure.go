@@ -0,0 +1,60 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_ERASURE_REPORTS = "erasure_reports"
+)
+
+type ERASURE_ACTION string
+
+const (
+	ERASURE_ACTION_KEEP         ERASURE_ACTION = "keep"         // Leave the records untouched
+	ERASURE_ACTION_DELETE       ERASURE_ACTION = "delete"       // Delete the records
+	ERASURE_ACTION_PSEUDONYMIZE ERASURE_ACTION = "pseudonymize" // Truncate IPs to /24 (/48 for IPv6), coarsen user agents, hash other identifiers
+	ERASURE_ACTION_HASH         ERASURE_ACTION = "hash"         // Replace every personal field with a keyed hash
+)
+
+type ERASURE_STATUS string
+
+const (
+	ERASURE_STATUS_COMPLETE ERASURE_STATUS = "complete" // Every collection and processor succeeded
+	ERASURE_STATUS_PENDING  ERASURE_STATUS = "pending"  // A step failed, the erasure is retried
+	ERASURE_STATUS_RETRIED  ERASURE_STATUS = "retried"  // Superseded by the report of the retry
+	ERASURE_STATUS_FAILED   ERASURE_STATUS = "failed"   // Still failing after the last attempt, needs an operator
+)
+
+// ErasureReport records what a right-to-erasure run did for one user
+type ErasureReport struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	UserID      bson.ObjectID             `bson:"user_id" json:"user_id"`                   // Reference to the erased User model
+	DryRun      bool                      `bson:"dry_run" json:"dry_run"`                   // Whether changes were only simulated
+	Status      ERASURE_STATUS            `bson:"status,omitempty" json:"status,omitempty"` // Outcome, empty for dry runs
+	Attempt     int                       `bson:"attempt" json:"attempt"`                   // 1 for the first run, counting retries
+	Error       string                    `bson:"error,omitempty" json:"error,omitempty"`   // Error that stopped the run before any step
+	Collections []ErasureCollectionResult `bson:"collections" json:"collections"`           // Outcome per history collection
+	Processors  []ErasureProcessorResult  `bson:"processors" json:"processors"`             // Outcome per external processor
+}
+
+// ErasureCollectionResult is the outcome of the policy for one collection
+type ErasureCollectionResult struct {
+	Collection string         `bson:"collection" json:"collection"`           // Collection name
+	Action     ERASURE_ACTION `bson:"action" json:"action"`                   // Configured action
+	Matched    int64          `bson:"matched" json:"matched"`                 // Records linked to the user
+	Modified   int64          `bson:"modified" json:"modified"`               // Records deleted or rewritten
+	Error      string         `bson:"error,omitempty" json:"error,omitempty"` // Error message if failed
+}
+
+// ErasureProcessorResult is the outcome of one external processor hook
+type ErasureProcessorResult struct {
+	Name    string `bson:"name" json:"name"`                         // Processor name (e.g. "stripe")
+	Success bool   `bson:"success" json:"success"`                   // Whether the processor erased its data
+	Detail  string `bson:"detail,omitempty" json:"detail,omitempty"` // What the processor did
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`   // Error message if failed
+}
@@ -39,6 +39,20 @@ const (
 	LOGIN_EVENT_REVOKED LoginEventType = "revoked" // Session revoked
 )
 
+// Email event types
+const (
+	EMAIL_EVENT_SUSPENSION_NOTICE EmailEventType = "suspension_notice" // Account suspended notice
+	EMAIL_EVENT_ACCOUNT_RECOVERY  EmailEventType = "account_recovery"  // Deleted account recovery link
+	EMAIL_EVENT_DATA_EXPORT       EmailEventType = "data_export"       // Personal data export download link
+	EMAIL_EVENT_INACTIVITY        EmailEventType = "inactivity"        // Inactive account deletion warning
+	EMAIL_EVENT_INVOICE           EmailEventType = "invoice"           // Invoice or credit note document
+	EMAIL_EVENT_CRYPTO_PAYMENT    EmailEventType = "crypto_payment"    // Crypto renewal or remaining payment link
+	EMAIL_EVENT_CRYPTO_REFUND     EmailEventType = "crypto_refund"     // Crypto overpayment refund claim link
+	EMAIL_EVENT_DUNNING           EmailEventType = "dunning"           // Failed payment reminder or downgrade notice
+	EMAIL_EVENT_ORGANIZATION      EmailEventType = "organization"      // Organization invitation
+	EMAIL_EVENT_ACCOUNT_SETUP     EmailEventType = "account_setup"     // Set-password link for an admin-created account
+)
+
 // Account event types
 const (
 	ACCOUNT_EVENT_EMAIL_CHANGE    AccountEventType = "email_change"    // Email address change (e.g. old: "user@old.com" -> new: "user@new.com")
@@ -46,6 +60,13 @@ const (
 	ACCOUNT_EVENT_PASSWORD_CHANGE AccountEventType = "password_change" // Password change (e.g. field: "password", old: "[REDACTED]", new: "[REDACTED]")
 	ACCOUNT_EVENT_PROFILE_UPDATE  AccountEventType = "profile_update"  // Profile information update (e.g. field: "name", old: "John" -> new: "John Doe")
 	ACCOUNT_EVENT_ACCOUNT_TYPE    AccountEventType = "account_type"    // Account type change (e.g. old: "free" -> new: "premium")
+	ACCOUNT_EVENT_STATUS_CHANGE   AccountEventType = "status_change"   // Account status change (e.g. old: "pending" -> new: "active")
+	ACCOUNT_EVENT_DATA_EXPORT     AccountEventType = "data_export"     // Personal data export requested (e.g. field: "export", new: "<export id>")
+	ACCOUNT_EVENT_DUNNING         AccountEventType = "dunning"         // Failed payment recovery step (e.g. field: "dunning", old: "payment_failed" -> new: "retry_failed")
+	ACCOUNT_EVENT_COUPON          AccountEventType = "coupon"          // Promotion code redeemed (e.g. field: "coupon", new: "<coupon id>:<code>")
+	ACCOUNT_EVENT_REFERRAL        AccountEventType = "referral"        // Referral claimed or credited (e.g. field: "referral_credit", new: "500 eur")
+	ACCOUNT_EVENT_ENTITLEMENT     AccountEventType = "entitlement"     // Entitlement override set or removed (e.g. field: "limit:projects", old: "10" -> new: "50")
+	ACCOUNT_EVENT_CREATED         AccountEventType = "created"         // Account created for the user (e.g. field: "account", new: "admin" or "import:auth0")
 )
 
 // Security event types
@@ -55,15 +76,24 @@ const (
 	SECURITY_EVENT_OAUTH_LINK     SecurityEventType = "oauth_link"     // OAuth account linked
 	SECURITY_EVENT_OAUTH_UNLINK   SecurityEventType = "oauth_unlink"   // OAuth account unlinked
 	SECURITY_EVENT_PASSWORD_RESET SecurityEventType = "password_reset" // Password reset
+	SECURITY_EVENT_ACTIVITY_FLAG  SecurityEventType = "activity_flag"  // User reported an event as "this wasn't me"
 )
 
 // Admin event types
 const (
-	ADMIN_EVENT_SUSPEND     AdminEventType = "suspend"     // Account suspension
-	ADMIN_EVENT_UNSUSPEND   AdminEventType = "unsuspend"   // Account unsuspension
-	ADMIN_EVENT_DELETE      AdminEventType = "delete"      // Account deletion
-	ADMIN_EVENT_ANONYMIZE   AdminEventType = "anonymize"   // Account anonymization
-	ADMIN_EVENT_ROLE_CHANGE AdminEventType = "role_change" // Role/permission change
+	ADMIN_EVENT_SUSPEND        AdminEventType = "suspend"        // Account suspension
+	ADMIN_EVENT_UNSUSPEND      AdminEventType = "unsuspend"      // Account unsuspension
+	ADMIN_EVENT_DELETE         AdminEventType = "delete"         // Account deletion
+	ADMIN_EVENT_ANONYMIZE      AdminEventType = "anonymize"      // Account anonymization
+	ADMIN_EVENT_ROLE_CHANGE    AdminEventType = "role_change"    // Role granted or revoked, or custom role defined or deleted
+	ADMIN_EVENT_LEGAL_HOLD     AdminEventType = "legal_hold"     // Legal retention hold placed or lifted
+	ADMIN_EVENT_PASSWORD_RESET AdminEventType = "password_reset" // Password reset forced, sessions revoked
+	ADMIN_EVENT_2FA_DISABLE    AdminEventType = "2fa_disable"    // Two-factor authentication turned off
+	ADMIN_EVENT_IMPERSONATE    AdminEventType = "impersonate"    // Impersonation session started (expires_at is its end)
+	ADMIN_EVENT_CREATE         AdminEventType = "create"         // Account created, set-password link sent, or setup completed
+	ADMIN_EVENT_IMPORT         AdminEventType = "import"         // User import uploaded, run or cancelled (no user_id)
+	ADMIN_EVENT_EXPORT         AdminEventType = "export"         // Users exported as JSONL (no user_id)
+	ADMIN_EVENT_ORGANIZATION   AdminEventType = "organization"   // Member removed from an organization (details: organization ID)
 )
 
 // LoginHistory model to track user login activity
@@ -0,0 +1,38 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_DATA_EXPORTS = "data_exports"
+
+	// GridFS bucket of the encrypted archives, stored under the export ID
+	BUCKET_EXPORT_ARCHIVES = "export_archives"
+)
+
+type EXPORT_STATUS string
+
+const (
+	EXPORT_STATUS_PENDING    EXPORT_STATUS = "pending"    // Waiting for the export worker
+	EXPORT_STATUS_PROCESSING EXPORT_STATUS = "processing" // Archive is being generated
+	EXPORT_STATUS_READY      EXPORT_STATUS = "ready"      // Archive can be downloaded
+	EXPORT_STATUS_FAILED     EXPORT_STATUS = "failed"     // Generation failed
+	EXPORT_STATUS_EXPIRED    EXPORT_STATUS = "expired"    // Archive was removed
+)
+
+// DataExport model for a GDPR data subject access export (Article 15/20)
+type DataExport struct {
+	ID          bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt   time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time     `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time    `bson:"completed_at,omitempty" json:"completed_at,omitempty"` // When the archive was ready
+	ExpiresAt   *time.Time    `bson:"expires_at,omitempty" json:"expires_at,omitempty"`     // When the archive will be removed
+
+	UserID bson.ObjectID `bson:"user_id" json:"user_id"`                 // Reference to User model
+	Status EXPORT_STATUS `bson:"status" json:"status"`                   // Current export status
+	Size   int64         `bson:"size,omitempty" json:"size,omitempty"`   // Encrypted archive size in bytes
+	Error  string        `bson:"error,omitempty" json:"error,omitempty"` // Error message if failed
+}
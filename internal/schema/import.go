@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_USER_IMPORTS = "user_imports"
+
+	// GridFS bucket of the uploaded files until they are imported, stored
+	// under the import ID
+	BUCKET_IMPORT_FILES = "import_files"
+)
+
+type IMPORT_SOURCE string
+type IMPORT_STATUS string
+
+const (
+	// Import sources, the export format of each identity provider
+	IMPORT_SOURCE_AUTH0    IMPORT_SOURCE = "auth0"    // Auth0 user export job (NDJSON)
+	IMPORT_SOURCE_FIREBASE IMPORT_SOURCE = "firebase" // firebase auth:export (JSON)
+	IMPORT_SOURCE_KEYCLOAK IMPORT_SOURCE = "keycloak" // Realm export with users (JSON)
+	IMPORT_SOURCE_JSONL    IMPORT_SOURCE = "jsonl"    // Export of another brain instance
+
+	// Import statuses
+	IMPORT_STATUS_PENDING    IMPORT_STATUS = "pending"    // Waiting for the import worker
+	IMPORT_STATUS_PROCESSING IMPORT_STATUS = "processing" // Records are being imported
+	IMPORT_STATUS_REVIEWED   IMPORT_STATUS = "reviewed"   // Dry run finished, can be run for real
+	IMPORT_STATUS_COMPLETED  IMPORT_STATUS = "completed"  // All records were processed
+	IMPORT_STATUS_FAILED     IMPORT_STATUS = "failed"     // The file could not be read
+	IMPORT_STATUS_CANCELLED  IMPORT_STATUS = "cancelled"  // Cancelled by an admin
+)
+
+// UserImport model for a bulk import of users exported from another identity
+// provider. The worker checkpoints its progress so that an interrupted import
+// resumes where it stopped.
+type UserImport struct {
+	ID          bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt   time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time     `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time    `bson:"completed_at,omitempty" json:"completed_at,omitempty"` // When the last record was processed
+	CreatedBy   bson.ObjectID `bson:"created_by" json:"created_by"`                         // Admin who uploaded the file
+
+	Source     IMPORT_SOURCE `bson:"source" json:"source"`                   // Format of the uploaded file
+	DryRun     bool          `bson:"dry_run" json:"dry_run"`                 // Report only, nothing is written
+	Status     IMPORT_STATUS `bson:"status" json:"status"`                   // Current import status
+	Size       int64         `bson:"size" json:"size"`                       // Uploaded file size in bytes
+	Checkpoint int           `bson:"checkpoint" json:"checkpoint"`           // Records processed so far
+	Report     ImportReport  `bson:"report" json:"report"`                   // Outcome of the processed records
+	Error      string        `bson:"error,omitempty" json:"error,omitempty"` // Error message if failed
+}
+
+// ImportReport counts the outcome of the records of an import. On a dry run
+// Created counts the users that would be created.
+type ImportReport struct {
+	Total      int            `bson:"total" json:"total"`           // Records processed
+	Created    int            `bson:"created" json:"created"`       // Users created
+	Skipped    int            `bson:"skipped" json:"skipped"`       // Email or external ID already present
+	Failed     int            `bson:"failed" json:"failed"`         // Invalid records
+	Hashes     map[string]int `bson:"hashes" json:"hashes"`         // Password hash schemes found, "none" for users without one
+	Identities int            `bson:"identities" json:"identities"` // Linked social identities imported
+	Issues     []ImportIssue  `bson:"issues" json:"issues"`         // First problems found, see MAX_IMPORT_ISSUES
+}
+
+// MAX_IMPORT_ISSUES caps ImportReport.Issues, the counters keep counting
+const MAX_IMPORT_ISSUES = 100
+
+// ImportIssue is a record that was skipped, failed, or imported without part
+// of its data
+type ImportIssue struct {
+	Record     int    `bson:"record" json:"record"`                               // Position in the file, starting at 1
+	ExternalID string `bson:"external_id,omitempty" json:"external_id,omitempty"` // User ID at the source
+	Email      string `bson:"email,omitempty" json:"email,omitempty"`
+	Message    string `bson:"message" json:"message"`
+}
+
+// ImportInfo records where an imported user came from
+type ImportInfo struct {
+	Source     IMPORT_SOURCE `bson:"source" json:"source"`           // Identity provider the user was exported from
+	ExternalID string        `bson:"external_id" json:"external_id"` // User ID at the source
+	JobID      bson.ObjectID `bson:"job_id" json:"job_id"`           // Reference to UserImport model
+	ImportedAt time.Time     `bson:"imported_at" json:"imported_at"`
+}
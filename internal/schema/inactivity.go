@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_INACTIVITY_REPORTS = "inactivity_reports"
+)
+
+type INACTIVITY_ACTION string
+
+const (
+	INACTIVITY_ACTION_WARN   INACTIVITY_ACTION = "warn"   // Warning email sent
+	INACTIVITY_ACTION_DELETE INACTIVITY_ACTION = "delete" // Account marked for deletion
+	INACTIVITY_ACTION_SKIP   INACTIVITY_ACTION = "skip"   // Account is on hold
+)
+
+// InactivityReport records one run of the inactive account policy
+type InactivityReport struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	DryRun  bool              `bson:"dry_run" json:"dry_run"` // Whether actions were only simulated
+	Entries []InactivityEntry `bson:"entries" json:"entries"` // One entry per account acted upon
+}
+
+// InactivityEntry is the action taken (or planned) for one account
+type InactivityEntry struct {
+	UserID      bson.ObjectID     `bson:"user_id" json:"user_id"`                             // Reference to User model
+	Rule        string            `bson:"rule" json:"rule"`                                   // Name of the matching rule
+	AccountType string            `bson:"account_type" json:"account_type"`                   // Account type at evaluation time
+	LastSeenAt  time.Time         `bson:"last_seen_at" json:"last_seen_at"`                   // Latest of last activity, last login and creation
+	Action      INACTIVITY_ACTION `bson:"action" json:"action"`                               // Action taken
+	SkipReason  string            `bson:"skip_reason,omitempty" json:"skip_reason,omitempty"` // Hold that prevented deletion
+	Error       string            `bson:"error,omitempty" json:"error,omitempty"`             // Error message if failed
+}
@@ -0,0 +1,147 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_INVOICES          = "invoices"
+	COLLECTION_INVOICE_NUMBERS   = "invoice_numbers"
+	COLLECTION_INVOICE_DOCUMENTS = "invoice_documents"
+)
+
+type INVOICE_KIND string
+
+const (
+	INVOICE_KIND_INVOICE     INVOICE_KIND = "invoice"     // Amount owed by the customer
+	INVOICE_KIND_CREDIT_NOTE INVOICE_KIND = "credit_note" // Reduces the amount of a finalized invoice
+)
+
+type INVOICE_STATUS string
+
+const (
+	INVOICE_STATUS_DRAFT      INVOICE_STATUS = "draft"      // Editable, has no number
+	INVOICE_STATUS_FINALIZING INVOICE_STATUS = "finalizing" // Being numbered and rendered
+	INVOICE_STATUS_OPEN       INVOICE_STATUS = "open"       // Finalized invoice awaiting payment
+	INVOICE_STATUS_PAID       INVOICE_STATUS = "paid"       // Finalized invoice paid
+	INVOICE_STATUS_ISSUED     INVOICE_STATUS = "issued"     // Finalized credit note
+)
+
+type INVOICE_FORMAT string
+
+const (
+	INVOICE_FORMAT_PDF  INVOICE_FORMAT = "pdf"
+	INVOICE_FORMAT_HTML INVOICE_FORMAT = "html"
+)
+
+// Invoice is an invoice or credit note. Everything except the payment and
+// delivery fields (Status open to paid, PaidAt, SentAt, CreditedTotal,
+// CreditNotes) is immutable once finalized.
+type Invoice struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID   bson.ObjectID  `bson:"user_id" json:"user_id"`                   // Reference to User model
+	Entity   string         `bson:"entity" json:"entity"`                     // Issuing legal entity
+	Kind     INVOICE_KIND   `bson:"kind" json:"kind"`                         // Invoice or credit note
+	Status   INVOICE_STATUS `bson:"status" json:"status"`                     // Current status
+	Number   string         `bson:"number,omitempty" json:"number,omitempty"` // Assigned on finalization
+	Year     int            `bson:"year,omitempty" json:"-"`                  // Year of the number sequence
+	Sequence int64          `bson:"sequence,omitempty" json:"-"`              // Position in the number sequence
+	Currency string         `bson:"currency" json:"currency"`                 // ISO 4217 code, lowercase
+	Locale   string         `bson:"locale" json:"locale"`                     // Locale the documents are rendered in
+	Note     string         `bson:"note,omitempty" json:"note,omitempty"`     // Free text printed on the invoice
+
+	Seller   InvoiceParty `bson:"seller" json:"seller"`     // Issuer snapshot, taken on finalization
+	Customer InvoiceParty `bson:"customer" json:"customer"` // Customer snapshot, taken on finalization
+
+	Lines     []InvoiceLine     `bson:"lines" json:"lines"`
+	Discounts []InvoiceDiscount `bson:"discounts" json:"discounts"` // Invoice-level discounts
+	Taxes     []InvoiceTax      `bson:"taxes" json:"taxes"`         // Tax per rate
+
+	TaxCountry      string `bson:"tax_country,omitempty" json:"tax_country,omitempty"`     // Country whose VAT rules applied
+	TaxTreatment    string `bson:"tax_treatment,omitempty" json:"tax_treatment,omitempty"` // Applied VAT rule, e.g. reverse_charge
+	TaxRatesVersion string `bson:"tax_rates_version,omitempty" json:"-"`                   // Rate table the rates came from
+
+	Subtotal      int64 `bson:"subtotal" json:"subtotal"`             // Sum of line amounts
+	DiscountTotal int64 `bson:"discount_total" json:"discount_total"` // Line and invoice discounts
+	TaxTotal      int64 `bson:"tax_total" json:"tax_total"`           // Sum of taxes
+	Total         int64 `bson:"total" json:"total"`                   // Subtotal - DiscountTotal + TaxTotal
+
+	IssuedAt *time.Time `bson:"issued_at,omitempty" json:"issued_at,omitempty"` // Invoice date
+	DueAt    *time.Time `bson:"due_at,omitempty" json:"due_at,omitempty"`       // Payment due date
+	PaidAt   *time.Time `bson:"paid_at,omitempty" json:"paid_at,omitempty"`     // When payment was received
+	SentAt   *time.Time `bson:"sent_at,omitempty" json:"sent_at,omitempty"`     // When it was last emailed
+
+	CreditedInvoiceID *bson.ObjectID  `bson:"credited_invoice_id,omitempty" json:"credited_invoice_id,omitempty"` // Credit note: the invoice it credits
+	CreditNotes       []bson.ObjectID `bson:"credit_notes,omitempty" json:"credit_notes,omitempty"`               // Invoice: its finalized credit notes
+	CreditedTotal     int64           `bson:"credited_total" json:"credited_total"`                               // Invoice: total of its credit notes
+
+	SubscriptionID *bson.ObjectID `bson:"subscription_id,omitempty" json:"subscription_id,omitempty"` // Subscription billed by the invoice
+	Gateway        string         `bson:"gateway,omitempty" json:"-"`                                 // Gateway that collected the payment
+	PaymentID      string         `bson:"payment_id,omitempty" json:"-"`                              // Payment or gateway invoice ID
+	DocumentHash   string         `bson:"document_hash,omitempty" json:"document_hash,omitempty"`     // SHA-256 of the PDF
+}
+
+// InvoiceParty is the name and address of the seller or customer
+type InvoiceParty struct {
+	Name    string   `bson:"name" json:"name"`
+	Email   string   `bson:"email,omitempty" json:"email,omitempty"`
+	Address []string `bson:"address,omitempty" json:"address,omitempty"`
+	Country string   `bson:"country,omitempty" json:"country,omitempty"` // ISO 3166-1 alpha-2
+	VATID   string   `bson:"vat_id,omitempty" json:"vat_id,omitempty"`
+}
+
+// InvoiceLine is one billed item. Amounts are in the currency's minor unit.
+type InvoiceLine struct {
+	Description string     `bson:"description" json:"description"`
+	Quantity    int64      `bson:"quantity" json:"quantity"`
+	UnitAmount  int64      `bson:"unit_amount" json:"unit_amount"`                       // Net price per unit
+	Amount      int64      `bson:"amount" json:"amount"`                                 // Quantity * UnitAmount
+	Discount    int64      `bson:"discount" json:"discount"`                             // Discount on this line
+	TaxRate     int64      `bson:"tax_rate" json:"tax_rate"`                             // In basis points (1900 = 19%)
+	PeriodStart *time.Time `bson:"period_start,omitempty" json:"period_start,omitempty"` // Service period
+	PeriodEnd   *time.Time `bson:"period_end,omitempty" json:"period_end,omitempty"`
+}
+
+// InvoiceDiscount reduces the whole invoice, either by a fixed amount or by
+// a percentage in basis points. It is spread over the lines before tax.
+type InvoiceDiscount struct {
+	Description string `bson:"description" json:"description"`
+	Amount      int64  `bson:"amount,omitempty" json:"amount,omitempty"`   // Fixed amount
+	Percent     int64  `bson:"percent,omitempty" json:"percent,omitempty"` // Basis points (1000 = 10%)
+	Applied     int64  `bson:"applied" json:"applied"`                     // Resulting reduction
+}
+
+// InvoiceTax is the tax of all lines sharing a rate
+type InvoiceTax struct {
+	Rate   int64 `bson:"rate" json:"rate"`     // In basis points
+	Base   int64 `bson:"base" json:"base"`     // Net amount after discounts
+	Amount int64 `bson:"amount" json:"amount"` // Tax amount
+}
+
+// InvoiceNumber reserves a number of a sequence for one invoice. Numbers are
+// reserved before they are written to the invoice, so an interrupted
+// finalization resumes with the same number and no number is skipped.
+type InvoiceNumber struct {
+	ID        bson.ObjectID `bson:"_id,omitempty"`
+	CreatedAt time.Time     `bson:"created_at"`
+	Entity    string        `bson:"entity"`
+	Kind      INVOICE_KIND  `bson:"kind"`
+	Year      int           `bson:"year"`
+	Sequence  int64         `bson:"sequence"`
+	InvoiceID bson.ObjectID `bson:"invoice_id"`
+}
+
+// InvoiceDocument is a rendered invoice, stored on finalization
+type InvoiceDocument struct {
+	ID        bson.ObjectID  `bson:"_id,omitempty"`
+	CreatedAt time.Time      `bson:"created_at"`
+	InvoiceID bson.ObjectID  `bson:"invoice_id"`
+	Format    INVOICE_FORMAT `bson:"format"`
+	Data      []byte         `bson:"data"`
+	SHA256    string         `bson:"sha256"`
+}
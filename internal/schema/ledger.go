@@ -0,0 +1,137 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_LEDGER_ACCOUNTS       = "ledger_accounts"
+	COLLECTION_LEDGER_ENTRIES        = "ledger_entries"
+	COLLECTION_LEDGER_TRIAL_BALANCES = "ledger_trial_balances"
+)
+
+type LEDGER_OWNER string
+
+const (
+	LEDGER_OWNER_USER         LEDGER_OWNER = "user"         // Reference to User model
+	LEDGER_OWNER_ORGANIZATION LEDGER_OWNER = "organization" // Reference to an organization
+)
+
+type LEDGER_ACCOUNT_TYPE string
+
+// Account types decide the normal balance: debits increase assets and
+// expenses, credits increase liabilities and revenue
+const (
+	LEDGER_ACCOUNT_TYPE_ASSET     LEDGER_ACCOUNT_TYPE = "asset"
+	LEDGER_ACCOUNT_TYPE_LIABILITY LEDGER_ACCOUNT_TYPE = "liability"
+	LEDGER_ACCOUNT_TYPE_REVENUE   LEDGER_ACCOUNT_TYPE = "revenue"
+	LEDGER_ACCOUNT_TYPE_EXPENSE   LEDGER_ACCOUNT_TYPE = "expense"
+)
+
+type LEDGER_ACCOUNT_KIND string
+
+const (
+	LEDGER_ACCOUNT_CREDIT        LEDGER_ACCOUNT_KIND = "credit"        // Liability: one lot of prepaid credits of an owner
+	LEDGER_ACCOUNT_CASH          LEDGER_ACCOUNT_KIND = "cash"          // Asset: payments collected for top-ups, less refunds
+	LEDGER_ACCOUNT_USAGE_REVENUE LEDGER_ACCOUNT_KIND = "usage_revenue" // Revenue: credits burned by metered usage
+	LEDGER_ACCOUNT_BREAKAGE      LEDGER_ACCOUNT_KIND = "breakage"      // Revenue: paid credits that expired unused
+	LEDGER_ACCOUNT_PROMOTIONS    LEDGER_ACCOUNT_KIND = "promotions"    // Expense: credits granted free of charge
+)
+
+type LEDGER_ENTRY_TYPE string
+
+const (
+	LEDGER_ENTRY_TOP_UP          LEDGER_ENTRY_TYPE = "top_up"          // Credits bought by the owner
+	LEDGER_ENTRY_GRANT           LEDGER_ENTRY_TYPE = "grant"           // Credits granted free of charge
+	LEDGER_ENTRY_USAGE           LEDGER_ENTRY_TYPE = "usage"           // Credits burned by metered usage
+	LEDGER_ENTRY_REFUND          LEDGER_ENTRY_TYPE = "refund"          // Unused paid credits refunded
+	LEDGER_ENTRY_REFUND_REVERSAL LEDGER_ENTRY_TYPE = "refund_reversal" // Refund failed at the gateway, credits restored
+	LEDGER_ENTRY_EXPIRATION      LEDGER_ENTRY_TYPE = "expiration"      // Unused credits of a lot expired
+)
+
+// LedgerAccount is an account of the credit ledger. Owners hold one credit
+// account per top-up or grant (a lot), so every lot expires on its own; the
+// other kinds are system accounts, one per currency.
+type LedgerAccount struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"-"`
+
+	Key             string              `bson:"key" json:"-"`                                                 // Unique key: "<kind>:<currency>" for system accounts, "lot:<entry key>" for credit accounts
+	Kind            LEDGER_ACCOUNT_KIND `bson:"kind" json:"kind"`                                             // What the account holds
+	Type            LEDGER_ACCOUNT_TYPE `bson:"type" json:"-"`                                                // Normal balance of the kind
+	Currency        string              `bson:"currency" json:"currency"`                                     // ISO 4217 code, lowercase
+	OwnerType       LEDGER_OWNER        `bson:"owner_type,omitempty" json:"-"`                                // Owner of a credit account
+	OwnerID         *bson.ObjectID      `bson:"owner_id,omitempty" json:"-"`                                  // Owner of a credit account
+	Source          LEDGER_ENTRY_TYPE   `bson:"source,omitempty" json:"source,omitempty"`                     // Entry that opened a credit account (top_up or grant)
+	Amount          int64               `bson:"amount,omitempty" json:"amount,omitempty"`                     // Credits the lot was opened with, in minor units
+	ExpiresAt       *time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`             // When unused credits of the lot expire
+	Gateway         string              `bson:"gateway,omitempty" json:"-"`                                   // Gateway that collected a top-up
+	ChargeID        string              `bson:"charge_id,omitempty" json:"-"`                                 // Gateway charge of a top-up, refunded from
+	Balance         int64               `bson:"balance" json:"balance"`                                       // Balance of a credit account after its last posting
+	Seq             int64               `bson:"seq" json:"-"`                                                 // Sequence of the last posting to a credit account
+	Description     string              `bson:"description,omitempty" json:"description,omitempty"`           // Shown to the owner
+	RefundableUntil *time.Time          `bson:"refundable_until,omitempty" json:"refundable_until,omitempty"` // Last moment the owner can refund unused credits of a top-up
+}
+
+// LedgerEntry is a journal entry: postings that sum to zero, written in a
+// single insert and never changed afterwards. Corrections are new entries.
+type LedgerEntry struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"` // When the entry was posted, the point in time balances are queried by
+
+	Key         string            `bson:"key" json:"-"`                                   // Idempotency key, unique
+	Type        LEDGER_ENTRY_TYPE `bson:"type" json:"type"`                               // What happened
+	Currency    string            `bson:"currency" json:"currency"`                       // Currency of every posting
+	Amount      int64             `bson:"amount" json:"amount"`                           // Credits moved, in minor units
+	OwnerType   LEDGER_OWNER      `bson:"owner_type" json:"-"`                            // Owner of the credit accounts posted to
+	OwnerID     bson.ObjectID     `bson:"owner_id" json:"-"`                              // Owner of the credit accounts posted to
+	Description string            `bson:"description,omitempty" json:"description"`       // Shown to the owner
+	Reference   string            `bson:"reference,omitempty" json:"reference,omitempty"` // Gateway charge or refund, usage bucket, ...
+	Postings    []LedgerPosting   `bson:"postings" json:"postings"`                       // Debits and credits
+	Locks       []string          `bson:"locks,omitempty" json:"-"`                       // "<account id>:<seq>" of each credit account posting, unique
+	ChangedBy   string            `bson:"changed_by,omitempty" json:"-"`                  // User ID or "system"
+}
+
+// LedgerPosting moves an amount into or out of one account. Amounts are
+// signed minor units: positive debits, negative credits.
+type LedgerPosting struct {
+	AccountID    bson.ObjectID       `bson:"account_id" json:"account_id"`
+	Kind         LEDGER_ACCOUNT_KIND `bson:"kind" json:"kind"`                                       // Kind of the account
+	Amount       int64               `bson:"amount" json:"amount"`                                   // Debit (positive) or credit (negative)
+	Seq          int64               `bson:"seq,omitempty" json:"-"`                                 // Sequence within a credit account
+	BalanceAfter *int64              `bson:"balance_after,omitempty" json:"balance_after,omitempty"` // Credit account balance after the posting
+}
+
+// LedgerTrialBalance is the result of one consistency check of the ledger
+type LedgerTrialBalance struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	Balanced          bool                   `bson:"balanced" json:"balanced"`                                         // No check failed
+	Currencies        []LedgerCurrencyTotals `bson:"currencies" json:"currencies"`                                     // Totals per currency
+	UnbalancedEntries []bson.ObjectID        `bson:"unbalanced_entries,omitempty" json:"unbalanced_entries,omitempty"` // Entries whose postings do not sum to zero
+	Mismatches        []LedgerMismatch       `bson:"mismatches,omitempty" json:"mismatches,omitempty"`                 // Credit accounts whose cached balance is wrong
+}
+
+// LedgerCurrencyTotals are the debits, credits and balances by account kind
+// of one currency. Balances are in the normal direction of the kind.
+type LedgerCurrencyTotals struct {
+	Currency string                        `bson:"currency" json:"currency"`
+	Debits   int64                         `bson:"debits" json:"debits"`
+	Credits  int64                         `bson:"credits" json:"credits"`
+	Balances map[LEDGER_ACCOUNT_KIND]int64 `bson:"balances" json:"balances"`
+}
+
+// LedgerMismatch is a credit account whose cached balance or sequence does
+// not match its postings
+type LedgerMismatch struct {
+	AccountID bson.ObjectID `bson:"account_id" json:"account_id"`
+	Balance   int64         `bson:"balance" json:"balance"`   // Cached balance
+	Seq       int64         `bson:"seq" json:"seq"`           // Cached sequence
+	Postings  int64         `bson:"postings" json:"postings"` // Number of postings
+	Computed  int64         `bson:"computed" json:"computed"` // Balance summed from the postings
+	Repaired  bool          `bson:"repaired" json:"repaired"` // The cache was behind and was rolled forward
+}
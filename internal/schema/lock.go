@@ -0,0 +1,16 @@
+package schema
+
+import (
+	"time"
+)
+
+const (
+	COLLECTION_LOCKS = "locks"
+)
+
+// Lock model for leader election between brain instances
+type Lock struct {
+	ID        string    `bson:"_id" json:"id"`                // Lock name
+	Owner     string    `bson:"owner" json:"owner"`           // Instance currently holding the lock
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"` // When the lease runs out
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_ORGANIZATIONS        = "organizations"
+	COLLECTION_ORGANIZATION_MEMBERS = "organization_members"
+	COLLECTION_ORGANIZATION_INVITES = "organization_invitations"
+	COLLECTION_ORGANIZATION_DOMAINS = "organization_domains"
+	COLLECTION_MEMBERSHIP_HISTORY   = "membership_history"
+)
+
+type ORGANIZATION_ROLE string
+
+const (
+	ORGANIZATION_ROLE_OWNER  ORGANIZATION_ROLE = "owner"  // Exactly one per organization, pays for the seats
+	ORGANIZATION_ROLE_ADMIN  ORGANIZATION_ROLE = "admin"  // Manages members, invitations and domains
+	ORGANIZATION_ROLE_MEMBER ORGANIZATION_ROLE = "member" // Uses the organization
+)
+
+type INVITATION_STATUS string
+
+const (
+	INVITATION_STATUS_PENDING  INVITATION_STATUS = "pending"  // Sent, can be accepted until ExpiresAt
+	INVITATION_STATUS_ACCEPTED INVITATION_STATUS = "accepted" // The invitee joined
+	INVITATION_STATUS_REVOKED  INVITATION_STATUS = "revoked"  // Withdrawn by an admin
+)
+
+type MEMBERSHIP_JOIN string
+
+const (
+	MEMBERSHIP_JOIN_CREATED    MEMBERSHIP_JOIN = "created"    // Created the organization
+	MEMBERSHIP_JOIN_INVITATION MEMBERSHIP_JOIN = "invitation" // Accepted an invitation
+	MEMBERSHIP_JOIN_DOMAIN     MEMBERSHIP_JOIN = "domain"     // Joined through a verified email domain
+)
+
+type MembershipEventType string
+
+// Membership event types
+const (
+	MEMBERSHIP_EVENT_CREATED            MembershipEventType = "created"               // Organization created by its owner
+	MEMBERSHIP_EVENT_INVITED            MembershipEventType = "invited"               // Invitation sent (e.g. email: "jane@example.com", new_role: "member")
+	MEMBERSHIP_EVENT_INVITATION_REVOKED MembershipEventType = "invitation_revoked"    // Pending invitation withdrawn
+	MEMBERSHIP_EVENT_JOINED             MembershipEventType = "joined"                // Member joined (e.g. detail: "invitation" or "domain")
+	MEMBERSHIP_EVENT_ROLE_CHANGED       MembershipEventType = "role_changed"          // Role changed (e.g. old_role: "member" -> new_role: "admin")
+	MEMBERSHIP_EVENT_REMOVED            MembershipEventType = "removed"               // Member removed by an admin
+	MEMBERSHIP_EVENT_LEFT               MembershipEventType = "left"                  // Member left on their own
+	MEMBERSHIP_EVENT_OWNER_TRANSFERRED  MembershipEventType = "ownership_transferred" // Ownership moved to another member
+	MEMBERSHIP_EVENT_SEATS_CHANGED      MembershipEventType = "seats_changed"         // Seats billed changed (e.g. detail: "5 -> 8")
+	MEMBERSHIP_EVENT_DOMAIN             MembershipEventType = "domain"                // Email domain added, verified, changed or removed (e.g. detail: "verified example.com")
+	MEMBERSHIP_EVENT_DELETED            MembershipEventType = "deleted"               // Organization deleted
+)
+
+// Organization is a team account. The subscription of its owner pays for
+// its seats.
+type Organization struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	Name    string        `bson:"name" json:"name"`         // Display name
+	OwnerID bson.ObjectID `bson:"owner_id" json:"owner_id"` // User with ORGANIZATION_ROLE_OWNER
+}
+
+// OrganizationMember is the membership of a User in an Organization
+type OrganizationMember struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"` // When the user joined
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	OrganizationID bson.ObjectID     `bson:"organization_id" json:"organization_id"`           // Reference to Organization model
+	UserID         bson.ObjectID     `bson:"user_id" json:"user_id"`                           // Reference to User model
+	Role           ORGANIZATION_ROLE `bson:"role" json:"role"`                                 // Role in the organization
+	JoinedVia      MEMBERSHIP_JOIN   `bson:"joined_via" json:"joined_via"`                     // How the user joined
+	InvitedBy      *bson.ObjectID    `bson:"invited_by,omitempty" json:"invited_by,omitempty"` // Inviting member, for invitations
+}
+
+// OrganizationInvitation is an emailed invitation to join an Organization.
+// Pending invitations that have not expired take a seat.
+type OrganizationInvitation struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	OrganizationID bson.ObjectID     `bson:"organization_id" json:"organization_id"`             // Reference to Organization model
+	Email          string            `bson:"email" json:"email"`                                 // Invitee, lowercase
+	Role           ORGANIZATION_ROLE `bson:"role" json:"role"`                                   // Role granted on acceptance, admin or member
+	TokenHash      string            `bson:"token_hash" json:"-"`                                // SHA-256 of the emailed token
+	ExpiresAt      time.Time         `bson:"expires_at" json:"expires_at"`                       // Cannot be accepted afterwards
+	InvitedBy      bson.ObjectID     `bson:"invited_by" json:"invited_by"`                       // Inviting member
+	Status         INVITATION_STATUS `bson:"status" json:"status"`                               // Pending, accepted or revoked
+	AcceptedBy     *bson.ObjectID    `bson:"accepted_by,omitempty" json:"accepted_by,omitempty"` // User who accepted
+	ResolvedAt     *time.Time        `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"` // When it was accepted or revoked
+}
+
+// OrganizationDomain is an email domain claimed by an Organization. Once its
+// DNS TXT record is verified, users with a verified email address at the
+// domain may join without an invitation if AutoJoin is set. A domain can be
+// verified by one organization only.
+type OrganizationDomain struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	OrganizationID    bson.ObjectID `bson:"organization_id" json:"organization_id"`             // Reference to Organization model
+	Domain            string        `bson:"domain" json:"domain"`                               // Lowercase domain name
+	VerificationToken string        `bson:"verification_token" json:"verification_token"`       // Expected in the TXT record
+	Verified          bool          `bson:"verified" json:"verified"`                           // The TXT record was found
+	VerifiedAt        *time.Time    `bson:"verified_at,omitempty" json:"verified_at,omitempty"` // When the TXT record was found
+	AutoJoin          bool          `bson:"auto_join" json:"auto_join"`                         // Users at the domain may join without an invitation
+}
+
+// MembershipHistory records every change to the members of an Organization
+type MembershipHistory struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	OrganizationID bson.ObjectID       `bson:"organization_id" json:"organization_id"`           // Reference to Organization model
+	EventType      MembershipEventType `bson:"event_type" json:"event_type"`                     // Type of membership event
+	UserID         *bson.ObjectID      `bson:"user_id,omitempty" json:"user_id,omitempty"`       // Member affected, if any
+	Email          string              `bson:"email,omitempty" json:"email,omitempty"`           // Invitee, for invitations
+	OldRole        ORGANIZATION_ROLE   `bson:"old_role,omitempty" json:"old_role,omitempty"`     // Role before the change
+	NewRole        ORGANIZATION_ROLE   `bson:"new_role,omitempty" json:"new_role,omitempty"`     // Role after the change
+	Detail         string              `bson:"detail,omitempty" json:"detail,omitempty"`         // Event specific details
+	ChangedBy      string              `bson:"changed_by" json:"changed_by"`                     // Who made the change (user_id or system)
+	IPAddress      string              `bson:"ip_address,omitempty" json:"ip_address,omitempty"` // IP address of the change
+	Country        string              `bson:"country,omitempty" json:"country,omitempty"`       // Country code (e.g. "US", "GB")
+	UserAgent      string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"` // User agent of the change
+}
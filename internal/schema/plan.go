@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_PLANS = "plans"
+)
+
+type PLAN_INTERVAL string
+
+const (
+	PLAN_INTERVAL_MONTH PLAN_INTERVAL = "month" // Billed monthly
+	PLAN_INTERVAL_YEAR  PLAN_INTERVAL = "year"  // Billed yearly
+)
+
+// Plan is one version of a catalog plan. Each plan grants one User.AccountType.
+// Changing a plan in the config stores a new version; subscribers stay on the
+// version and price they subscribed to.
+type Plan struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	AccountType string           `bson:"account_type" json:"account_type"` // User.AccountType granted by the plan
+	Version     int              `bson:"version" json:"version"`           // Increases with every change, starting at 1
+	Current     bool             `bson:"current" json:"-"`                 // Latest version of the plan
+	Available   bool             `bson:"available" json:"-"`               // Offered to new subscribers (still in the config)
+	Name        string           `bson:"name" json:"name"`                 // Display name
+	TrialDays   int              `bson:"trial_days" json:"trial_days"`     // Free trial length
+	Prices      []PlanPrice      `bson:"prices" json:"prices"`             // Empty for free plans
+	Features    []string         `bson:"features" json:"features"`         // Feature entitlements
+	Quotas      map[string]int64 `bson:"quotas" json:"quotas"`             // Usage limits by name
+	Fingerprint string           `bson:"fingerprint" json:"-"`             // Hash of the plan definition, detects changes
+	RetiredAt   *time.Time       `bson:"retired_at,omitempty" json:"-"`    // When the version stopped being current
+}
+
+// PlanPrice is the price of a plan in one currency and billing interval
+type PlanPrice struct {
+	Currency string        `bson:"currency" json:"currency"` // ISO 4217 code, lowercase
+	Interval PLAN_INTERVAL `bson:"interval" json:"interval"` // Billing interval
+	Amount   int64         `bson:"amount" json:"amount"`     // Price in the currency's minor unit
+	Gateway  string        `bson:"gateway" json:"gateway"`   // Payment gateway charging the price
+	PriceID  string        `bson:"price_id" json:"-"`        // Price or plan ID at the gateway
+}
+
+// Free reports whether the plan has no prices
+func (p *Plan) Free() bool {
+	return len(p.Prices) == 0
+}
+
+// HasFeature reports whether the plan grants a feature
+func (p *Plan) HasFeature(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Price returns the plan's price with a gateway price ID
+func (p *Plan) Price(gateway, priceID string) *PlanPrice {
+	for i, pr := range p.Prices {
+		if pr.Gateway == gateway && pr.PriceID == priceID {
+			return &p.Prices[i]
+		}
+	}
+	return nil
+}
+
+// PriceFor returns the plan's price in a currency and interval. Empty
+// arguments match the first price.
+func (p *Plan) PriceFor(currency string, interval PLAN_INTERVAL) *PlanPrice {
+	for i, pr := range p.Prices {
+		if (currency == "" || pr.Currency == currency) && (interval == "" || pr.Interval == interval) {
+			return &p.Prices[i]
+		}
+	}
+	return nil
+}
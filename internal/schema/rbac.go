@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_ROLES       = "roles"
+	COLLECTION_ROLE_GRANTS = "role_grants"
+)
+
+type PERMISSION string
+
+const (
+	PERMISSION_ALL                 PERMISSION = "*"                   // Every permission, including future ones
+	PERMISSION_USERS_READ          PERMISSION = "users:read"          // Search users, view their status and history
+	PERMISSION_USERS_WRITE         PERMISSION = "users:write"         // Create, invite, import and export users
+	PERMISSION_USERS_SUSPEND       PERMISSION = "users:suspend"       // Suspend and unsuspend accounts
+	PERMISSION_USERS_DELETE        PERMISSION = "users:delete"        // Delete accounts
+	PERMISSION_USERS_CREDENTIALS   PERMISSION = "users:credentials"   // Force password resets, disable 2FA
+	PERMISSION_USERS_IMPERSONATE   PERMISSION = "users:impersonate"   // Sign in as another user
+	PERMISSION_BILLING_READ        PERMISSION = "billing:read"        // View subscriptions, invoices and credits
+	PERMISSION_BILLING_WRITE       PERMISSION = "billing:write"       // Change subscriptions, credits and entitlements
+	PERMISSION_ORGANIZATIONS_READ  PERMISSION = "organizations:read"  // View organizations and their members
+	PERMISSION_ORGANIZATIONS_WRITE PERMISSION = "organizations:write" // Change organizations and their members
+	PERMISSION_ROLES_READ          PERMISSION = "roles:read"          // View roles and grants
+	PERMISSION_ROLES_WRITE         PERMISSION = "roles:write"         // Define roles, grant and revoke them
+)
+
+// Permissions lists every permission that can be given to a role
+var Permissions = []PERMISSION{
+	PERMISSION_ALL,
+	PERMISSION_USERS_READ,
+	PERMISSION_USERS_WRITE,
+	PERMISSION_USERS_SUSPEND,
+	PERMISSION_USERS_DELETE,
+	PERMISSION_USERS_CREDENTIALS,
+	PERMISSION_USERS_IMPERSONATE,
+	PERMISSION_BILLING_READ,
+	PERMISSION_BILLING_WRITE,
+	PERMISSION_ORGANIZATIONS_READ,
+	PERMISSION_ORGANIZATIONS_WRITE,
+	PERMISSION_ROLES_READ,
+	PERMISSION_ROLES_WRITE,
+}
+
+// Role is a named set of permissions. Built-in roles are defined in code and
+// cannot be changed; custom roles are stored in the roles collection.
+type Role struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	Name        string       `bson:"name" json:"name"`               // Unique key, lowercase letters, digits and dashes
+	Description string       `bson:"description" json:"description"` // What the role is for
+	Permissions []PERMISSION `bson:"permissions" json:"permissions"` // Permissions granted by the role
+	BuiltIn     bool         `bson:"-" json:"built_in"`              // Defined in code
+}
+
+// RoleGrant gives a Role to a User, either globally or within one
+// Organization
+type RoleGrant struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+
+	UserID         bson.ObjectID  `bson:"user_id" json:"user_id"`                                     // Reference to User model
+	Role           string         `bson:"role" json:"role"`                                           // Role name
+	OrganizationID *bson.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"` // Scope, global when unset
+	GrantedBy      bson.ObjectID  `bson:"granted_by" json:"granted_by"`                               // Admin who granted it, zero for the system
+	Reason         string         `bson:"reason,omitempty" json:"reason,omitempty"`                   // Why it was granted
+}
@@ -0,0 +1,30 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_SESSIONS = "sessions"
+)
+
+// Session model for an authenticated user session
+type Session struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time     `bson:"expires_at" json:"expires_at"` // Used for TTL index
+
+	UserID        bson.ObjectID `bson:"user_id" json:"user_id"`                                   // Reference to User model
+	TokenHash     string        `bson:"token_hash" json:"-"`                                      // SHA-256 hash of the session token
+	IPAddress     string        `bson:"ip_address" json:"ip_address"`                             // IP address the session was created from
+	Country       string        `bson:"country" json:"country"`                                   // Country code (e.g. "US", "GB")
+	UserAgent     string        `bson:"user_agent" json:"user_agent"`                             // User agent string
+	LastSeenAt    time.Time     `bson:"last_seen_at" json:"last_seen_at"`                         // Last time the session was used
+	RevokedAt     *time.Time    `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`         // When the session was revoked
+	RevokedReason string        `bson:"revoked_reason,omitempty" json:"revoked_reason,omitempty"` // Why the session was revoked
+
+	// Impersonation
+	ImpersonatorID *bson.ObjectID `bson:"impersonator_id,omitempty" json:"impersonator_id,omitempty"` // Admin signed in as the user, unset for the user's own sessions
+}
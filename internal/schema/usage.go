@@ -0,0 +1,88 @@
+package schema
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLECTION_USAGE_EVENTS          = "usage_events"
+	COLLECTION_USAGE_BUCKETS         = "usage_buckets"
+	COLLECTION_USAGE_RECONCILIATIONS = "usage_reconciliations"
+
+	// Usage events are kept long enough to recompute and reconcile the previous month
+	TTL_USAGE_EVENTS = 100 * 24 * 60 * 60 // 100 days
+)
+
+type USAGE_AGGREGATION string
+
+const (
+	USAGE_AGGREGATION_SUM    USAGE_AGGREGATION = "sum"    // Sum of quantities
+	USAGE_AGGREGATION_MAX    USAGE_AGGREGATION = "max"    // Highest quantity
+	USAGE_AGGREGATION_UNIQUE USAGE_AGGREGATION = "unique" // Number of distinct values
+)
+
+type USAGE_GRANULARITY string
+
+const (
+	USAGE_GRANULARITY_HOUR  USAGE_GRANULARITY = "hour"  // One bucket per UTC hour
+	USAGE_GRANULARITY_MONTH USAGE_GRANULARITY = "month" // One bucket per UTC calendar month
+)
+
+// UsageEvent is one ingested usage record. The ID combines the user and the
+// reporter's idempotency key, so a retried report is stored once.
+type UsageEvent struct {
+	ID         string        `bson:"_id" json:"-"`
+	CreatedAt  time.Time     `bson:"created_at" json:"created_at"`           // When the event was stored, drives the TTL
+	UserID     bson.ObjectID `bson:"user_id" json:"user_id"`                 // Reference to User model (the billed customer)
+	Meter      string        `bson:"meter" json:"meter"`                     // Configured meter name
+	Quantity   int64         `bson:"quantity" json:"quantity"`               // Quantity for sum and max meters
+	Value      string        `bson:"value,omitempty" json:"value,omitempty"` // Counted value for unique meters
+	Timestamp  time.Time     `bson:"timestamp" json:"timestamp"`             // When the usage happened
+	Aggregated bool          `bson:"aggregated" json:"-"`                    // Included in the buckets
+}
+
+// UsageBucket is the aggregated usage of one user and meter in one period
+type UsageBucket struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+
+	UserID      bson.ObjectID     `bson:"user_id" json:"-"`                 // Reference to User model
+	Meter       string            `bson:"meter" json:"meter"`               // Configured meter name
+	Aggregation USAGE_AGGREGATION `bson:"aggregation" json:"aggregation"`   // How Value is computed
+	Granularity USAGE_GRANULARITY `bson:"granularity" json:"granularity"`   // Bucket size
+	PeriodStart time.Time         `bson:"period_start" json:"period_start"` // Inclusive
+	PeriodEnd   time.Time         `bson:"period_end" json:"period_end"`     // Exclusive
+	Value       int64             `bson:"value" json:"value"`               // Aggregated usage
+	Events      int64             `bson:"events" json:"events"`             // Number of events in the bucket
+
+	ReportedValue  int64      `bson:"reported_value" json:"-"`            // Value sent to Stripe so far
+	ReportedAt     *time.Time `bson:"reported_at,omitempty" json:"-"`     // Last report to Stripe
+	ReportError    string     `bson:"report_error,omitempty" json:"-"`    // Last report error
+	ReportAttempts int        `bson:"report_attempts,omitempty" json:"-"` // Failed reports since the last success
+	NextAttemptAt  *time.Time `bson:"next_attempt_at,omitempty" json:"-"` // Not reported again before, set after a failed report
+	NotBillable    bool       `bson:"not_billable,omitempty" json:"-"`    // The user had no Stripe customer, the bucket is never reported
+
+	CreditBurned int64 `bson:"credit_burned" json:"-"` // Prepaid credits burned for the bucket so far, in minor units
+}
+
+// UsageReconciliation compares local usage with Stripe for one meter and month
+type UsageReconciliation struct {
+	ID          bson.ObjectID              `bson:"_id,omitempty" json:"id"`
+	CreatedAt   time.Time                  `bson:"created_at" json:"created_at"`
+	Meter       string                     `bson:"meter" json:"meter"`
+	PeriodStart time.Time                  `bson:"period_start" json:"period_start"`
+	PeriodEnd   time.Time                  `bson:"period_end" json:"period_end"`
+	Mismatches  int                        `bson:"mismatches" json:"mismatches"` // Entries where the values differ
+	Entries     []UsageReconciliationEntry `bson:"entries" json:"entries"`
+}
+
+type UsageReconciliationEntry struct {
+	UserID   bson.ObjectID `bson:"user_id" json:"user_id"`
+	Local    int64         `bson:"local" json:"local"`       // Value of the monthly bucket
+	Reported int64         `bson:"reported" json:"reported"` // Value sent to Stripe
+	Remote   int64         `bson:"remote" json:"remote"`     // Value aggregated by Stripe
+	Match    bool          `bson:"match" json:"match"`       // Local, reported and remote agree
+	Error    string        `bson:"error,omitempty" json:"error,omitempty"`
+}
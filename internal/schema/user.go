@@ -45,7 +45,7 @@ type User struct {
 	AvatarURL   string `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`     // Profile picture URL
 	Locale      string `bson:"locale" json:"locale"`                                 // User's locale (e.g., en-US, fr-CA)
 	TimeZone    string `bson:"timezone,omitempty" json:"timezone,omitempty"`         // User's timezone (e.g., America/New_York, Europe/Paris)
-	AccountType string `bson:"account_type" json:"account_type"`                     // Type of account (e.g., "free", "premium", "business", "enterprise", etc.)
+	AccountType string `bson:"account_type" json:"account_type"`                     // Type of account, granted by a catalog plan (e.g., "free", "premium", "business", "enterprise", etc.)
 
 	// Authentication and verification information
 	AuthInfo AuthInfo `bson:"auth_info" json:"auth_info"`
@@ -56,6 +56,9 @@ type User struct {
 	LastEmailChange *time.Time `bson:"last_email_change,omitempty" json:"last_email_change,omitempty"` // Last email change
 	LastPhoneChange *time.Time `bson:"last_phone_change,omitempty" json:"last_phone_change,omitempty"` // Last phone change
 
+	// Inactive account policy
+	InactivityWarnedAt *time.Time `bson:"inactivity_warned_at,omitempty" json:"-"` // Last inactivity warning email
+
 	// User status
 	Status USER_STATUS `bson:"status" json:"status"` // Current account status
 
@@ -64,6 +67,9 @@ type User struct {
 
 	// GDPR Deletion tracking
 	DeletionInfo *DeletionInfo `bson:"deletion_info,omitempty" json:"deletion_info,omitempty"` // Account deletion information
+
+	// Migration from another identity provider
+	Import *ImportInfo `bson:"import,omitempty" json:"import,omitempty"` // Set on users created by an import
 }
 
 // DeletionInfo tracks GDPR-compliant account deletion
@@ -74,6 +80,13 @@ type DeletionInfo struct {
 	RequestedBy    string          `bson:"requested_by" json:"requested_by"`                           // Who requested the deletion (user_id or system)
 	AnonymizedBy   string          `bson:"anonymized_by,omitempty" json:"anonymized_by,omitempty"`     // Who performed the anonymization
 	RetentionUntil *time.Time      `bson:"retention_until,omitempty" json:"retention_until,omitempty"` // Legal retention period if applicable
+	PreviousStatus USER_STATUS     `bson:"previous_status,omitempty" json:"-"`                         // Status before the deletion request
+
+	// Account recovery during the grace period
+	RecoveryTokenHash string     `bson:"recovery_token_hash,omitempty" json:"-"`               // SHA-256 hash of the emailed recovery token
+	RecoverySentAt    *time.Time `bson:"recovery_sent_at,omitempty" json:"-"`                  // When the recovery email was sent
+	RecoveredAt       *time.Time `bson:"recovered_at,omitempty" json:"recovered_at,omitempty"` // When the account was restored
+	RecoveredBy       string     `bson:"recovered_by,omitempty" json:"recovered_by,omitempty"` // Who restored the account
 }
 
 // AuthInfo groups fields for password, token, and OTP management
@@ -83,6 +96,7 @@ type AuthInfo struct {
 	PasswordResetSentAt *time.Time `bson:"password_reset_sent_at,omitempty" json:"-"`
 	LastPasswordChange  time.Time  `bson:"last_password_change" json:"-"`
 	LastPasswordReset   *time.Time `bson:"last_password_reset,omitempty" json:"-"`
+	PasswordResetForced bool       `bson:"password_reset_forced" json:"password_reset_forced"` // Password must be reset before the next login
 
 	// Verification status
 	EmailVerified           bool       `bson:"email_verified" json:"email_verified"`
@@ -100,6 +114,10 @@ type AuthInfo struct {
 
 	// OAuth providers
 	OAuthProviders map[string]OAuthProvider `bson:"oauth_providers,omitempty" json:"oauth_providers,omitempty"`
+
+	// Account setup for admin-created users
+	SetupTokenHash string     `bson:"setup_token_hash,omitempty" json:"-"`                          // SHA-256 hash of the emailed set-password token
+	SetupExpiresAt *time.Time `bson:"setup_expires_at,omitempty" json:"setup_expires_at,omitempty"` // When the set-password link expires
 }
 
 // OAuthProvider represents a connected OAuth account
@@ -114,9 +132,10 @@ type OAuthProvider struct {
 
 // SuspensionInfo handles account suspension status
 type SuspensionInfo struct {
-	IsSuspended     bool       `bson:"is_suspended" json:"is_suspended"`
-	SuspendedAt     *time.Time `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
-	SuspendedUntil  *time.Time `bson:"suspended_until,omitempty" json:"suspended_until,omitempty"`
-	SuspendedReason string     `bson:"suspended_reason,omitempty" json:"suspended_reason,omitempty"`
-	SuspendedBy     string     `bson:"suspended_by,omitempty" json:"suspended_by,omitempty"`
+	IsSuspended     bool        `bson:"is_suspended" json:"is_suspended"`
+	SuspendedAt     *time.Time  `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
+	SuspendedUntil  *time.Time  `bson:"suspended_until,omitempty" json:"suspended_until,omitempty"`
+	SuspendedReason string      `bson:"suspended_reason,omitempty" json:"suspended_reason,omitempty"`
+	SuspendedBy     string      `bson:"suspended_by,omitempty" json:"suspended_by,omitempty"`
+	PreviousStatus  USER_STATUS `bson:"previous_status,omitempty" json:"-"` // Status restored on unsuspension
 }
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// DEFAULT_TTL is how long a new session stays valid
+const DEFAULT_TTL = 30 * 24 * time.Hour
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Create starts a new session for the user and returns the plain session token
+func Create(ctx context.Context, userID bson.ObjectID, meta history.Meta) (string, *schema.Session, error) {
+	return create(ctx, userID, nil, DEFAULT_TTL, meta)
+}
+
+// CreateImpersonation starts a session for the user on behalf of an admin. It
+// is marked with the admin's ID and expires after ttl.
+func CreateImpersonation(ctx context.Context, userID, adminID bson.ObjectID, ttl time.Duration, meta history.Meta) (string, *schema.Session, error) {
+	return create(ctx, userID, &adminID, ttl, meta)
+}
+
+func create(ctx context.Context, userID bson.ObjectID, impersonatorID *bson.ObjectID, ttl time.Duration, meta history.Meta) (string, *schema.Session, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	s := &schema.Session{
+		ID:             bson.NewObjectID(),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+		UserID:         userID,
+		TokenHash:      hashToken(token),
+		IPAddress:      meta.IPAddress,
+		Country:        meta.Country,
+		UserAgent:      meta.UserAgent,
+		LastSeenAt:     now,
+		ImpersonatorID: impersonatorID,
+	}
+	if _, err := database.Collection(schema.COLLECTION_SESSIONS).InsertOne(ctx, s); err != nil {
+		return "", nil, err
+	}
+	return token, s, nil
+}
+
+// Lookup returns the active session for a plain session token
+func Lookup(ctx context.Context, token string) (*schema.Session, error) {
+	now := time.Now().UTC()
+	var s schema.Session
+	err := database.Collection(schema.COLLECTION_SESSIONS).FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hashToken(token),
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"last_seen_at": now}},
+	).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// RevokeAllForUser revokes every active session of the user and records a
+// LOGIN_EVENT_REVOKED event for each of them
+func RevokeAllForUser(ctx context.Context, userID bson.ObjectID, reason string, meta history.Meta) (int64, error) {
+	coll := database.Collection(schema.COLLECTION_SESSIONS)
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}
+
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var sessions []schema.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return 0, err
+	}
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]bson.ObjectID, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID
+	}
+	now := time.Now().UTC()
+	res, err := coll.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoked_reason": reason}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range sessions {
+		if err := history.RecordLogin(ctx, schema.LoginHistory{
+			UserID:    userID,
+			EventType: schema.LOGIN_EVENT_REVOKED,
+			IPAddress: meta.IPAddress,
+			Country:   meta.Country,
+			UserAgent: meta.UserAgent,
+			Success:   true,
+		}); err != nil {
+			log.Error().Err(err).Str("session_id", s.ID.Hex()).Msg("Error recording session revocation")
+		}
+	}
+	return res.ModifiedCount, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,56 @@
+package suspension
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	EXPIRY_JOB      = "suspension_expiry"
+	EXPIRY_INTERVAL = time.Minute
+	EXPIRY_BATCH    = 100
+)
+
+// ExpireSuspensions unsuspends every account whose timed suspension has ended.
+// It is run by the scheduler under a lease, so only one instance acts at a time.
+func ExpireSuspensions(ctx context.Context) error {
+	cursor, err := database.Collection(schema.COLLECTION_USERS).Find(ctx,
+		bson.M{
+			"status":                     schema.USER_STATUS_SUSPENDED,
+			"suspension.suspended_until": bson.M{"$lte": time.Now().UTC()},
+		},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(EXPIRY_BATCH),
+	)
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		ID bson.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		_, err := Unsuspend(ctx, r.ID, users.SystemActor(), REASON_EXPIRED, history.Meta{})
+		// Another writer got there first, the next run will pick it up if still needed
+		if errors.Is(err, users.ErrConcurrentUpdate) || errors.Is(err, ErrNotSuspended) {
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("user_id", r.ID.Hex()).Msg("Error lifting expired suspension")
+			continue
+		}
+		log.Info().Str("user_id", r.ID.Hex()).Msg("Lifted expired suspension")
+	}
+	return nil
+}
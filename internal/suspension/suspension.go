@@ -0,0 +1,112 @@
+package suspension
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/mailer"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/Auth5/brain/internal/session"
+	"github.com/Auth5/brain/internal/users"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	REVOKE_REASON_SUSPENDED = "suspended"
+	REASON_EXPIRED          = "suspension expired"
+)
+
+var (
+	ErrUntilInPast  = errors.New("suspension end must be in the future")
+	ErrNotSuspended = errors.New("user is not suspended")
+)
+
+// Request describes a suspension
+type Request struct {
+	UserID bson.ObjectID
+	Actor  users.Actor
+	Until  *time.Time // Nil suspends indefinitely
+	Reason string
+	Meta   history.Meta
+}
+
+// Suspend suspends the user, revokes all of their sessions and emails them a notice
+func Suspend(ctx context.Context, req Request) (*schema.User, error) {
+	now := time.Now().UTC()
+	if req.Until != nil && !req.Until.After(now) {
+		return nil, ErrUntilInPast
+	}
+
+	current, err := users.FindByID(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := users.Apply(ctx, users.Transition{
+		UserID: req.UserID,
+		To:     schema.USER_STATUS_SUSPENDED,
+		Actor:  req.Actor,
+		Reason: req.Reason,
+		Set: bson.M{"suspension": schema.SuspensionInfo{
+			IsSuspended:     true,
+			SuspendedAt:     &now,
+			SuspendedUntil:  req.Until,
+			SuspendedReason: req.Reason,
+			SuspendedBy:     req.Actor.String(),
+			PreviousStatus:  current.Status,
+		}},
+		ExpiresAt: req.Until,
+		Meta:      req.Meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := session.RevokeAllForUser(ctx, u.ID, REVOKE_REASON_SUSPENDED, req.Meta); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error revoking sessions of suspended user")
+	}
+
+	if err := mailer.Send(ctx, mailer.Message{
+		Profile:  mailer.PROFILE_NOREPLY,
+		To:       u.Email,
+		UserID:   u.ID,
+		Type:     schema.EMAIL_EVENT_SUSPENSION_NOTICE,
+		Template: "suspension_notice",
+		Data: map[string]any{
+			"DisplayName": u.DisplayName,
+			"Until":       req.Until,
+			"Reason":      req.Reason,
+		},
+	}); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error sending suspension notice")
+	}
+	return u, nil
+}
+
+// Unsuspend lifts a suspension and restores the status the user had before it
+func Unsuspend(ctx context.Context, userID bson.ObjectID, actor users.Actor, reason string, meta history.Meta) (*schema.User, error) {
+	current, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != schema.USER_STATUS_SUSPENDED {
+		return nil, ErrNotSuspended
+	}
+
+	to := current.Suspension.PreviousStatus
+	if to == "" || !users.CanTransition(schema.USER_STATUS_SUSPENDED, to) {
+		to = schema.USER_STATUS_ACTIVE
+	}
+
+	return users.Apply(ctx, users.Transition{
+		UserID: userID,
+		To:     to,
+		Actor:  actor,
+		Reason: reason,
+		Set:    bson.M{"suspension": schema.SuspensionInfo{}},
+		Meta:   meta,
+	})
+}
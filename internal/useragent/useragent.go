@@ -0,0 +1,110 @@
+package useragent
+
+import (
+	"strings"
+)
+
+// Device classes reported by Parse
+const (
+	DEVICE_DESKTOP = "desktop"
+	DEVICE_MOBILE  = "mobile"
+	DEVICE_TABLET  = "tablet"
+	DEVICE_BOT     = "bot"
+	DEVICE_UNKNOWN = "unknown"
+)
+
+// Info is a coarse, human-readable description of a user agent
+type Info struct {
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+// Summary returns a short description such as "Chrome on Windows"
+func (i Info) Summary() string {
+	switch {
+	case i.Browser == "" && i.OS == "":
+		return "Unknown device"
+	case i.OS == "":
+		return i.Browser
+	case i.Browser == "":
+		return i.OS
+	}
+	return i.Browser + " on " + i.OS
+}
+
+// Order matters: more specific tokens must come before the tokens they contain
+// (e.g. Edge and Opera user agents also contain "Chrome" and "Safari").
+var browsers = []struct{ token, name string }{
+	{"edg/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"safari/", "Safari"},
+	{"msie ", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"okhttp/", "OkHttp"},
+}
+
+var systems = []struct{ token, name string }{
+	{"iphone", "iOS"},
+	{"ipad", "iPadOS"},
+	{"android", "Android"},
+	{"cros", "ChromeOS"},
+	{"windows", "Windows"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+var bots = []string{"bot", "crawler", "spider", "slurp"}
+
+// Parse extracts the browser, operating system and device class from a user agent string
+func Parse(ua string) Info {
+	s := strings.ToLower(ua)
+	info := Info{Device: DEVICE_UNKNOWN}
+	if s == "" {
+		return info
+	}
+
+	for _, b := range browsers {
+		if strings.Contains(s, b.token) {
+			info.Browser = b.name
+			break
+		}
+	}
+	for _, o := range systems {
+		if strings.Contains(s, o.token) {
+			info.OS = o.name
+			break
+		}
+	}
+
+	switch {
+	case containsAny(s, bots):
+		info.Device = DEVICE_BOT
+	case strings.Contains(s, "ipad") || strings.Contains(s, "tablet") ||
+		(strings.Contains(s, "android") && !strings.Contains(s, "mobile")):
+		info.Device = DEVICE_TABLET
+	case strings.Contains(s, "mobile") || strings.Contains(s, "iphone"):
+		info.Device = DEVICE_MOBILE
+	case info.OS != "":
+		info.Device = DEVICE_DESKTOP
+	}
+	return info
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
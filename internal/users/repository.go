@@ -0,0 +1,125 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/password"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrNotFound       = errors.New("user not found")
+	ErrProtectedField = errors.New("field can only be changed through a status transition")
+	ErrAlreadyExists  = errors.New("email or username already in use")
+)
+
+// protectedFields may only be written by Apply so that every change goes
+// through the state machine and is audited
+var protectedFields = []string{"status"}
+
+func collection() *mongo.Collection {
+	return database.Collection(schema.COLLECTION_USERS)
+}
+
+// Create inserts a new user. Its status is written as given, so callers
+// create users in their initial status only.
+func Create(ctx context.Context, u *schema.User) error {
+	now := time.Now().UTC()
+	if u.ID.IsZero() {
+		u.ID = bson.NewObjectID()
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	u.UpdatedAt = now
+	_, err := collection().InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// FindByID returns the user with the given ID
+func FindByID(ctx context.Context, id bson.ObjectID) (*schema.User, error) {
+	return findOne(ctx, bson.M{"_id": id})
+}
+
+// FindByEmail returns the user with the given primary email
+func FindByEmail(ctx context.Context, email string) (*schema.User, error) {
+	return findOne(ctx, bson.M{"email": email})
+}
+
+// FindOne returns the first user matching the filter
+func FindOne(ctx context.Context, filter bson.M) (*schema.User, error) {
+	return findOne(ctx, filter)
+}
+
+// Update sets the given fields on the user and bumps UpdatedAt. The account
+// status cannot be changed here, use Apply instead.
+func Update(ctx context.Context, id bson.ObjectID, set bson.M) error {
+	fields := bson.M{"updated_at": time.Now().UTC()}
+	for k, v := range set {
+		if isProtected(k) {
+			return fmt.Errorf("%w: %s", ErrProtectedField, k)
+		}
+		fields[k] = v
+	}
+	res, err := collection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func isProtected(field string) bool {
+	for _, p := range protectedFields {
+		if field == p || strings.HasPrefix(field, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func findOne(ctx context.Context, filter bson.M) (*schema.User, error) {
+	var u schema.User
+	err := collection().FindOne(ctx, filter).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CheckPassword reports whether plain is the user's password. Hashes imported
+// from other identity providers or below the current bcrypt cost are replaced
+// on the first match, so migrated accounts converge on the native format.
+func CheckPassword(ctx context.Context, u *schema.User, plain string) bool {
+	if u.Password == "" || !password.Verify(u.Password, plain) {
+		return false
+	}
+	if password.NeedsRehash(u.Password) {
+		hash, err := password.Hash(plain)
+		if err == nil {
+			err = Update(ctx, u.ID, bson.M{"password": hash})
+		}
+		if err != nil {
+			log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error rehashing password")
+		} else {
+			u.Password = hash
+		}
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Auth5/brain/internal/history"
+	"github.com/Auth5/brain/internal/schema"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type ACTOR_KIND string
+
+const (
+	ACTOR_KIND_USER   ACTOR_KIND = "user"   // The user changed their own account
+	ACTOR_KIND_ADMIN  ACTOR_KIND = "admin"  // An administrator changed the account
+	ACTOR_KIND_SYSTEM ACTOR_KIND = "system" // Brain changed the account on its own
+)
+
+var (
+	ErrInvalidTransition = errors.New("status transition not allowed")
+	ErrGuardFailed       = errors.New("status transition precondition not met")
+	ErrConcurrentUpdate  = errors.New("user was modified concurrently")
+)
+
+// Actor identifies who requested a status transition
+type Actor struct {
+	Kind ACTOR_KIND
+	ID   bson.ObjectID // Unset for ACTOR_KIND_SYSTEM
+}
+
+// SystemActor returns the actor used for automated changes
+func SystemActor() Actor {
+	return Actor{Kind: ACTOR_KIND_SYSTEM}
+}
+
+// String returns the value recorded as ChangedBy in AccountHistory
+func (a Actor) String() string {
+	if a.Kind == ACTOR_KIND_SYSTEM {
+		return history.SYSTEM_ACTOR
+	}
+	return a.ID.Hex()
+}
+
+// Transition describes a requested change of USER_STATUS
+type Transition struct {
+	UserID    bson.ObjectID
+	To        schema.USER_STATUS
+	Actor     Actor
+	Reason    string     // Recorded in AdminHistory
+	Details   string     // Recorded in AdminHistory
+	ExpiresAt *time.Time // Recorded in AdminHistory for time-limited actions
+	Set       bson.M     // Extra fields written atomically with the status
+	Unset     []string   // Fields removed atomically with the status
+	Meta      history.Meta
+}
+
+// guard checks a precondition of a transition against the current user
+type guard func(u *schema.User, t Transition) error
+
+// transitions lists every allowed from -> to status change and its guard
+var transitions = map[schema.USER_STATUS]map[schema.USER_STATUS]guard{
+	schema.USER_STATUS_PENDING: {
+		schema.USER_STATUS_ACTIVE:    requireEmailVerified,
+		schema.USER_STATUS_SUSPENDED: nil,
+		schema.USER_STATUS_DELETED:   nil,
+	},
+	schema.USER_STATUS_ACTIVE: {
+		schema.USER_STATUS_INACTIVE:  nil,
+		schema.USER_STATUS_SUSPENDED: nil,
+		schema.USER_STATUS_DELETED:   nil,
+	},
+	schema.USER_STATUS_INACTIVE: {
+		schema.USER_STATUS_ACTIVE:    nil,
+		schema.USER_STATUS_SUSPENDED: nil,
+		schema.USER_STATUS_DELETED:   nil,
+	},
+	schema.USER_STATUS_SUSPENDED: {
+		schema.USER_STATUS_ACTIVE:   nil,
+		schema.USER_STATUS_INACTIVE: nil,
+		schema.USER_STATUS_PENDING:  nil,
+		schema.USER_STATUS_DELETED:  nil,
+	},
+	schema.USER_STATUS_DELETED: {
+		schema.USER_STATUS_ANONYMIZED: requireRetentionEnded,
+		// Recovery during the grace period
+		schema.USER_STATUS_ACTIVE:    requireNotAnonymized,
+		schema.USER_STATUS_INACTIVE:  requireNotAnonymized,
+		schema.USER_STATUS_PENDING:   requireNotAnonymized,
+		schema.USER_STATUS_SUSPENDED: requireNotAnonymized,
+	},
+	// Anonymization is irreversible
+	schema.USER_STATUS_ANONYMIZED: {},
+}
+
+// adminEvents maps transitions to the AdminHistory event they produce
+var adminEvents = map[schema.USER_STATUS]schema.AdminEventType{
+	schema.USER_STATUS_SUSPENDED:  schema.ADMIN_EVENT_SUSPEND,
+	schema.USER_STATUS_DELETED:    schema.ADMIN_EVENT_DELETE,
+	schema.USER_STATUS_ANONYMIZED: schema.ADMIN_EVENT_ANONYMIZE,
+}
+
+func requireEmailVerified(u *schema.User, _ Transition) error {
+	if !u.AuthInfo.EmailVerified {
+		return fmt.Errorf("%w: email is not verified", ErrGuardFailed)
+	}
+	return nil
+}
+
+// requireRetentionEnded treats accounts deleted before deletion details were
+// kept as not under retention
+func requireRetentionEnded(u *schema.User, _ Transition) error {
+	if u.DeletionInfo == nil {
+		return nil
+	}
+	if r := u.DeletionInfo.RetentionUntil; r != nil && r.After(time.Now()) {
+		return fmt.Errorf("%w: under legal retention until %s", ErrGuardFailed, r.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func requireNotAnonymized(u *schema.User, _ Transition) error {
+	if u.DeletionInfo != nil && u.DeletionInfo.AnonymizedAt != nil {
+		return fmt.Errorf("%w: account data was anonymized", ErrGuardFailed)
+	}
+	return nil
+}
+
+// CanTransition reports whether the state machine allows from -> to
+func CanTransition(from, to schema.USER_STATUS) bool {
+	_, ok := transitions[from][to]
+	return ok
+}
+
+// Apply moves the user to a new status. The write only succeeds if neither
+// the status nor UpdatedAt changed since the user was loaded, and the
+// matching AccountHistory or AdminHistory event is recorded afterwards.
+func Apply(ctx context.Context, t Transition) (*schema.User, error) {
+	u, err := FindByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	g, ok := transitions[u.Status][t.To]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, u.Status, t.To)
+	}
+	if g != nil {
+		if err := g(u, t); err != nil {
+			return nil, err
+		}
+	}
+
+	set := bson.M{}
+	for k, v := range t.Set {
+		if isProtected(k) {
+			return nil, fmt.Errorf("%w: %s", ErrProtectedField, k)
+		}
+		set[k] = v
+	}
+	set["status"] = t.To
+	set["updated_at"] = time.Now().UTC()
+	update := bson.M{"$set": set}
+	if len(t.Unset) > 0 {
+		unset := bson.M{}
+		for _, k := range t.Unset {
+			if isProtected(k) {
+				return nil, fmt.Errorf("%w: %s", ErrProtectedField, k)
+			}
+			unset[k] = ""
+		}
+		update["$unset"] = unset
+	}
+
+	var updated schema.User
+	err = collection().FindOneAndUpdate(ctx,
+		bson.M{"_id": u.ID, "status": u.Status, "updated_at": u.UpdatedAt},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrConcurrentUpdate
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := record(ctx, u.Status, t); err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.Hex()).Msg("Error recording status transition")
+	}
+	return &updated, nil
+}
+
+// complianceEvents are always written to AdminHistory, whoever requested
+// them, because the deletion audit trail has to outlive the account history
+var complianceEvents = map[schema.AdminEventType]bool{
+	schema.ADMIN_EVENT_DELETE:    true,
+	schema.ADMIN_EVENT_ANONYMIZE: true,
+}
+
+// record writes AdminHistory for administrative and automated actions that
+// have an admin event type, and AccountHistory for everything else
+func record(ctx context.Context, from schema.USER_STATUS, t Transition) error {
+	event, ok := adminEvents[t.To]
+	if from == schema.USER_STATUS_SUSPENDED && t.To != schema.USER_STATUS_DELETED {
+		event, ok = schema.ADMIN_EVENT_UNSUSPEND, true
+	}
+
+	if ok && (t.Actor.Kind != ACTOR_KIND_USER || complianceEvents[event]) {
+		var adminID bson.ObjectID
+		if t.Actor.Kind == ACTOR_KIND_ADMIN {
+			adminID = t.Actor.ID
+		}
+		if err := history.RecordAdmin(ctx, schema.AdminHistory{
+			AdminID:   adminID,
+			UserID:    t.UserID,
+			EventType: event,
+			Action:    fmt.Sprintf("status %s -> %s by %s", from, t.To, t.Actor.Kind),
+			Reason:    t.Reason,
+			Details:   t.Details,
+			IPAddress: t.Meta.IPAddress,
+			Country:   t.Meta.Country,
+			UserAgent: t.Meta.UserAgent,
+			ExpiresAt: t.ExpiresAt,
+		}); err != nil {
+			return err
+		}
+		if t.Actor.Kind != ACTOR_KIND_USER {
+			return nil
+		}
+	}
+
+	return history.RecordAccount(ctx, schema.AccountHistory{
+		UserID:    t.UserID,
+		EventType: schema.ACCOUNT_EVENT_STATUS_CHANGE,
+		Field:     "status",
+		OldValue:  string(from),
+		NewValue:  string(t.To),
+		ChangedBy: t.Actor.String(),
+		IPAddress: t.Meta.IPAddress,
+		Country:   t.Meta.Country,
+		UserAgent: t.Meta.UserAgent,
+	})
+}
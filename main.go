@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os/signal"
+	"syscall"
+
+	"github.com/Auth5/brain/internal/api"
+	"github.com/Auth5/brain/internal/billing"
+	"github.com/Auth5/brain/internal/billing/invoice"
+	"github.com/Auth5/brain/internal/billing/ledger"
+	"github.com/Auth5/brain/internal/billing/metering"
+	"github.com/Auth5/brain/internal/config"
+	"github.com/Auth5/brain/internal/database"
+	"github.com/Auth5/brain/internal/entitlements"
+	"github.com/Auth5/brain/internal/gdpr"
+	"github.com/Auth5/brain/internal/geoip"
+	"github.com/Auth5/brain/internal/migration"
+	"github.com/Auth5/brain/internal/organizations"
+	"github.com/Auth5/brain/internal/rbac"
+	"github.com/Auth5/brain/internal/scheduler"
+	"github.com/Auth5/brain/internal/suspension"
+)
+
+func main() {
+	config.InitConfig()
+
+	database.InitMongo()
+	defer database.CloseMongo()
+	database.InitBadger()
+	defer database.CloseBadger()
+
+	geoip.InitGeoIP()
+	billing.InitBilling()
+	entitlements.InitEntitlements()
+	organizations.InitOrganizations()
+	rbac.InitRBAC()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Background jobs
+	geoip.StartUpdater(ctx)
+	scheduler.Every(ctx, suspension.EXPIRY_JOB, suspension.EXPIRY_INTERVAL, suspension.ExpireSuspensions)
+	scheduler.Every(ctx, gdpr.ANONYMIZER_JOB, gdpr.ANONYMIZER_INTERVAL, gdpr.RunAnonymizer)
+	scheduler.Every(ctx, gdpr.ERASURE_RETRY_JOB, gdpr.ERASURE_RETRY_INTERVAL, gdpr.RetryErasures)
+	scheduler.Every(ctx, gdpr.EXPORT_JOB, gdpr.EXPORT_INTERVAL, gdpr.ProcessExports)
+	scheduler.Every(ctx, gdpr.INACTIVITY_JOB, gdpr.INACTIVITY_INTERVAL, gdpr.RunInactivityPolicy)
+	scheduler.Every(ctx, migration.IMPORT_JOB, migration.IMPORT_INTERVAL, migration.ProcessImports)
+	metering.StartFlusher(ctx)
+	scheduler.Every(ctx, metering.AGGREGATE_JOB, metering.AGGREGATE_INTERVAL, metering.Aggregate)
+	scheduler.Every(ctx, metering.REPORT_JOB, metering.REPORT_INTERVAL, metering.ReportUsage)
+	scheduler.Every(ctx, metering.RECONCILE_JOB, metering.RECONCILE_INTERVAL, metering.ReconcileUsage)
+	scheduler.Every(ctx, metering.BURN_JOB, metering.BURN_INTERVAL, metering.BurnCredits)
+	scheduler.Every(ctx, ledger.EXPIRY_JOB, ledger.EXPIRY_INTERVAL, ledger.ExpireCredits)
+	scheduler.Every(ctx, ledger.TRIAL_BALANCE_JOB, ledger.TRIAL_BALANCE_INTERVAL, ledger.CheckTrialBalance)
+	scheduler.Every(ctx, invoice.RESUME_JOB, invoice.RESUME_INTERVAL, invoice.ResumeFinalizations)
+	scheduler.Every(ctx, billing.DUNNING_JOB, billing.DUNNING_INTERVAL, billing.RunDunning)
+	scheduler.Every(ctx, billing.CRYPTO_RENEWAL_JOB, billing.CRYPTO_RENEWAL_INTERVAL, billing.RenewCryptoSubscriptions)
+
+	api.Start()
+}